@@ -0,0 +1,295 @@
+package p2p
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/binary"
+	"github.com/DSiSc/p2p/common"
+	"github.com/DSiSc/p2p/config"
+	mrand "math/rand"
+	"strconv"
+	"time"
+)
+
+const (
+	newBucketCount          = 1024 // num of buckets in new table
+	triedBucketCount        = 256  // num of buckets in tried table
+	bucketSize              = 64   // max num of addresses in a bucket
+	newBucketsPerGroup      = 64   // num of new buckets the addresses told by a source group can be put in
+	triedBucketsPerGroup    = 8    // num of tried buckets the addresses in a group can be put in
+	maxNewBucketsPerAddress = 8    // max num of new buckets an address can be put in
+	addrBookKeyLen          = 32   // length of the secret key
+)
+
+// knownAddress is an address recorded in address book
+type knownAddress struct {
+	addr          *common.NetAddress
+	src           *common.NetAddress // the peer who told us this address
+	tried         bool               // whether we have connected To this address successfully
+	refs          int                // num of new buckets referencing this address
+	addedTime     time.Time          // time when the address was added To its current table
+	lastSeen      time.Time          // last time we heard the address is active
+	services      config.ServiceFlag // services supported by the address, valid only if servicesKnown is true
+	servicesKnown bool
+	disconnect    *DisconnectInfo // last disconnection, nil if never disconnected. It's replaced but never modified
+}
+
+// get a copy of the services supported by the address, return nil if unknown.
+func (ka *knownAddress) knownServices() *config.ServiceFlag {
+	if !ka.servicesKnown {
+		return nil
+	}
+	services := ka.services
+	return &services
+}
+
+// addrBook records the known addresses in two tables. Addresses we have never connected To are put in
+// the "new" table, and promoted To the "tried" table after a successful connection. Both tables are divided
+// into buckets, the bucket of a new address is decided by the network group of the address and the source
+// who told us the address, and the bucket of a tried address is decided by its network group, both keyed
+// by a secret key. So an attacker can only occupy a limited num of buckets no matter how many addresses
+// it gossips. addrBook is not thread safe, it is protected by AddressManager's lock.
+type addrBook struct {
+	key        [addrBookKeyLen]byte
+	index      map[string]*knownAddress
+	newTable   []map[string]*knownAddress
+	triedTable []map[string]*knownAddress
+	newCount   int
+	triedCount int
+}
+
+// newAddrBook create an empty address book with a random secret key
+func newAddrBook() *addrBook {
+	book := &addrBook{
+		index:      make(map[string]*knownAddress),
+		newTable:   make([]map[string]*knownAddress, newBucketCount),
+		triedTable: make([]map[string]*knownAddress, triedBucketCount),
+	}
+	if _, err := rand.Read(book.key[:]); err != nil {
+		mrand.Read(book.key[:])
+	}
+	return book
+}
+
+// keyed hash of the parts
+func (book *addrBook) hash(parts ...string) uint64 {
+	hasher := sha256.New()
+	hasher.Write(book.key[:])
+	for _, part := range parts {
+		hasher.Write([]byte(part))
+		hasher.Write([]byte{0})
+	}
+	return binary.BigEndian.Uint64(hasher.Sum(nil)[:8])
+}
+
+// the bucket in new table for an address told by the source
+func (book *addrBook) newBucket(addr, src *common.NetAddress) int {
+	srcGroup := src.GroupKey()
+	slot := book.hash(addr.GroupKey(), srcGroup) % newBucketsPerGroup
+	return int(book.hash(srcGroup, strconv.FormatUint(slot, 10)) % newBucketCount)
+}
+
+// the bucket in tried table for an address
+func (book *addrBook) triedBucket(addr *common.NetAddress) int {
+	slot := book.hash(addr.ToString()) % triedBucketsPerGroup
+	return int(book.hash(addr.GroupKey(), strconv.FormatUint(slot, 10)) % triedBucketCount)
+}
+
+// get the known address, return nil if not exist
+func (book *addrBook) get(addr *common.NetAddress) *knownAddress {
+	return book.index[addr.ToString()]
+}
+
+// count of the known addresses
+func (book *addrBook) count() int {
+	return len(book.index)
+}
+
+// all the known addresses
+func (book *addrBook) all() []*knownAddress {
+	kas := make([]*knownAddress, 0, len(book.index))
+	for _, ka := range book.index {
+		kas = append(kas, ka)
+	}
+	return kas
+}
+
+// add an address told by the source To new table, the last seen time of a known address will be updated if
+// it's newer. return true if the book is changed.
+func (book *addrBook) add(addr, src *common.NetAddress, lastSeen time.Time) bool {
+	if src == nil {
+		src = addr
+	}
+	key := addr.ToString()
+	changed := false
+	ka, ok := book.index[key]
+	if ok {
+		if lastSeen.After(ka.lastSeen) {
+			ka.lastSeen = lastSeen
+			changed = true
+		}
+		if ka.tried || ka.refs >= maxNewBucketsPerAddress {
+			return changed
+		}
+		// the more buckets the address is in, the less chance it will be put in another one
+		if mrand.Intn(1<<uint(ka.refs)) != 0 {
+			return changed
+		}
+	} else {
+		ka = &knownAddress{
+			addr:      addr,
+			src:       src,
+			addedTime: time.Now(),
+			lastSeen:  lastSeen,
+		}
+	}
+
+	bucket := book.newBucket(addr, src)
+	if _, exist := book.newTable[bucket][key]; exist {
+		return changed
+	}
+	book.insertNew(bucket, ka)
+	return true
+}
+
+// insert the address into a bucket of new table, the oldest address will be evicted if the bucket is full.
+func (book *addrBook) insertNew(bucket int, ka *knownAddress) {
+	if book.newTable[bucket] == nil {
+		book.newTable[bucket] = make(map[string]*knownAddress)
+	}
+	if len(book.newTable[bucket]) >= bucketSize {
+		book.expireNew(bucket)
+	}
+	key := ka.addr.ToString()
+	book.newTable[bucket][key] = ka
+	ka.refs++
+	if ka.refs == 1 {
+		book.index[key] = ka
+		book.newCount++
+	}
+}
+
+// evict the oldest address in a new bucket
+func (book *addrBook) expireNew(bucket int) {
+	var oldest *knownAddress
+	for _, ka := range book.newTable[bucket] {
+		if oldest == nil || ka.addedTime.Before(oldest.addedTime) {
+			oldest = ka
+		}
+	}
+	if oldest == nil {
+		return
+	}
+	key := oldest.addr.ToString()
+	delete(book.newTable[bucket], key)
+	oldest.refs--
+	if oldest.refs == 0 {
+		delete(book.index, key)
+		book.newCount--
+	}
+}
+
+// remove the address From all new buckets
+func (book *addrBook) removeFromNew(ka *knownAddress) {
+	key := ka.addr.ToString()
+	for _, bucket := range book.newTable {
+		if _, ok := bucket[key]; ok {
+			delete(bucket, key)
+		}
+	}
+	ka.refs = 0
+	book.newCount--
+}
+
+// good promote the address To tried table after a successful connection, the oldest address in the tried
+// bucket will be moved back To new table if the bucket is full. return true if the book is changed.
+func (book *addrBook) good(addr *common.NetAddress) bool {
+	ka := book.get(addr)
+	if ka == nil || ka.tried {
+		return false
+	}
+	book.removeFromNew(ka)
+
+	bucket := book.triedBucket(addr)
+	if book.triedTable[bucket] == nil {
+		book.triedTable[bucket] = make(map[string]*knownAddress)
+	}
+	if len(book.triedTable[bucket]) >= bucketSize {
+		book.demoteTried(bucket)
+	}
+	ka.tried = true
+	ka.addedTime = time.Now()
+	book.triedTable[bucket][addr.ToString()] = ka
+	book.triedCount++
+	return true
+}
+
+// move the oldest address in a tried bucket back To new table
+func (book *addrBook) demoteTried(bucket int) {
+	var oldest *knownAddress
+	for _, ka := range book.triedTable[bucket] {
+		if oldest == nil || ka.addedTime.Before(oldest.addedTime) {
+			oldest = ka
+		}
+	}
+	if oldest == nil {
+		return
+	}
+	delete(book.triedTable[bucket], oldest.addr.ToString())
+	book.triedCount--
+	oldest.tried = false
+	oldest.addedTime = time.Now()
+	book.insertNew(book.newBucket(oldest.addr, oldest.src), oldest)
+}
+
+// remove the address From book, return true if the book is changed.
+func (book *addrBook) remove(addr *common.NetAddress) bool {
+	ka := book.get(addr)
+	if ka == nil {
+		return false
+	}
+	if ka.tried {
+		delete(book.triedTable[book.triedBucket(addr)], addr.ToString())
+		book.triedCount--
+	} else {
+		book.removeFromNew(ka)
+	}
+	delete(book.index, addr.ToString())
+	return true
+}
+
+// pick a random address satisfy the filter. The table is chosen with equal chance, as the tried table is
+// usually much smaller than the new table, selection is biased toward tried addresses. In the chosen table,
+// a random bucket is chosen before the address, so the addresses From a few sources can't dominate it.
+func (book *addrBook) pick(filter func(ka *knownAddress) bool) *knownAddress {
+	useTried := book.triedCount > 0 && (book.newCount == 0 || mrand.Intn(2) == 0)
+	if ka := book.pickFrom(useTried, filter); ka != nil {
+		return ka
+	}
+	return book.pickFrom(!useTried, filter)
+}
+
+// pick a random address satisfy the filter From a table
+func (book *addrBook) pickFrom(tried bool, filter func(ka *knownAddress) bool) *knownAddress {
+	table := book.newTable
+	if tried {
+		table = book.triedTable
+	}
+	candidates := make([][]*knownAddress, 0)
+	for _, bucket := range table {
+		kas := make([]*knownAddress, 0, len(bucket))
+		for _, ka := range bucket {
+			if filter == nil || filter(ka) {
+				kas = append(kas, ka)
+			}
+		}
+		if len(kas) > 0 {
+			candidates = append(candidates, kas)
+		}
+	}
+	if len(candidates) == 0 {
+		return nil
+	}
+	kas := candidates[mrand.Intn(len(candidates))]
+	return kas[mrand.Intn(len(kas))]
+}
@@ -0,0 +1,132 @@
+package p2p
+
+import (
+	"github.com/DSiSc/p2p/common"
+	"github.com/stretchr/testify/assert"
+	"strconv"
+	"testing"
+	"time"
+)
+
+// mock addresses in the same network group
+func mockGroupAddresses(group string, num int) []*common.NetAddress {
+	addrs := make([]*common.NetAddress, 0, num)
+	for i := 0; i < num; i++ {
+		addrs = append(addrs, common.NewNetAddress("tcp", group+"."+strconv.Itoa(i/250)+"."+strconv.Itoa(i%250+1), 8080))
+	}
+	return addrs
+}
+
+func TestAddrBook_Add(t *testing.T) {
+	assert := assert.New(t)
+	book := newAddrBook()
+	addr := mockAddress()
+	now := time.Now()
+	assert.True(book.add(addr, nil, now))
+	assert.False(book.add(addr, nil, now))
+	assert.Equal(1, book.count())
+	assert.Equal(1, book.newCount)
+	ka := book.get(addr)
+	assert.NotNil(ka)
+	assert.Equal(1, ka.refs)
+	assert.False(ka.tried)
+}
+
+func TestAddrBook_AddFromSameSource(t *testing.T) {
+	assert := assert.New(t)
+	book := newAddrBook()
+	src := common.NewNetAddress("tcp", "10.0.0.1", 8080)
+	for _, addr := range mockGroupAddresses("20.0", 10000) {
+		book.add(addr, src, time.Now())
+	}
+	// a single source can only occupy limited buckets
+	assert.True(book.count() <= newBucketsPerGroup*bucketSize)
+	occupied := 0
+	for _, bucket := range book.newTable {
+		if len(bucket) > 0 {
+			occupied++
+		}
+	}
+	assert.True(occupied <= newBucketsPerGroup)
+}
+
+func TestAddrBook_Good(t *testing.T) {
+	assert := assert.New(t)
+	book := newAddrBook()
+	addr := mockAddress()
+	assert.False(book.good(addr))
+	book.add(addr, nil, time.Now())
+	book.add(addr, common.NewNetAddress("tcp", "10.0.0.1", 8080), time.Now())
+	assert.True(book.good(addr))
+	assert.False(book.good(addr))
+	assert.Equal(0, book.newCount)
+	assert.Equal(1, book.triedCount)
+	assert.True(book.get(addr).tried)
+	for _, bucket := range book.newTable {
+		_, ok := bucket[addr.ToString()]
+		assert.False(ok)
+	}
+
+	// tried address will not be added To new table again, only its last seen time is updated
+	lastSeen := time.Now().Add(time.Minute)
+	assert.True(book.add(addr, nil, lastSeen))
+	assert.Equal(0, book.newCount)
+	assert.Equal(lastSeen, book.get(addr).lastSeen)
+	assert.False(book.add(addr, nil, lastSeen))
+}
+
+func TestAddrBook_DemoteTried(t *testing.T) {
+	assert := assert.New(t)
+	book := newAddrBook()
+	addrs := mockGroupAddresses("30.0", 2*triedBucketsPerGroup*bucketSize)
+	for _, addr := range addrs {
+		book.add(addr, nil, time.Now())
+		book.good(addr)
+	}
+	// addresses in the same group can only occupy limited tried buckets, the rest are moved back To new table
+	assert.True(book.triedCount <= triedBucketsPerGroup*bucketSize)
+	assert.True(book.newCount > 0)
+	assert.Equal(book.count(), book.triedCount+book.newCount)
+}
+
+func TestAddrBook_Remove(t *testing.T) {
+	assert := assert.New(t)
+	book := newAddrBook()
+	addrs := mockGroupAddresses("40.0", 2)
+	book.add(addrs[0], nil, time.Now())
+	book.add(addrs[1], nil, time.Now())
+	book.good(addrs[1])
+	assert.True(book.remove(addrs[0]))
+	assert.True(book.remove(addrs[1]))
+	assert.False(book.remove(addrs[1]))
+	assert.Equal(0, book.count())
+	assert.Equal(0, book.newCount)
+	assert.Equal(0, book.triedCount)
+}
+
+func TestAddrBook_Pick(t *testing.T) {
+	assert := assert.New(t)
+	book := newAddrBook()
+	assert.Nil(book.pick(nil))
+
+	tried := common.NewNetAddress("tcp", "50.0.0.1", 8080)
+	book.add(tried, nil, time.Now())
+	book.good(tried)
+	for _, addr := range mockGroupAddresses("60.0", 1000) {
+		book.add(addr, common.NewNetAddress("tcp", "10.0.0.1", 8080), time.Now())
+	}
+
+	triedNum := 0
+	for i := 0; i < 1000; i++ {
+		if book.pick(nil).tried {
+			triedNum++
+		}
+	}
+	assert.True(triedNum > 300)
+
+	// fall back To the other table if no address satisfy the filter
+	ka := book.pick(func(ka *knownAddress) bool {
+		return !ka.tried
+	})
+	assert.False(ka.tried)
+}
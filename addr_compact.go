@@ -0,0 +1,162 @@
+package p2p
+
+import (
+	"github.com/DSiSc/craft/log"
+	"sync/atomic"
+	"time"
+)
+
+const (
+	defaultMaxAddrBookSize = 20000            // default max num of addresses in address book
+	compactInterval        = 10 * time.Minute // interval of removing terrible addresses From address book
+	recentAttemptInterval  = time.Minute      // addresses attempted in it are never terrible
+	maxNeverSucceededTries = 3                // max num of failed attempts of an address never connected
+	maxFailures            = 10               // max num of failed attempts since the last success
+	minFailureInterval     = 7 * 24 * time.Hour
+	evictSampleSize        = 64 // num of new addresses sampled To find the one To evict
+)
+
+// AddrBookStats is the composition of the address book
+type AddrBookStats struct {
+	Total          int    `json:"total"`           // num of addresses
+	New            int    `json:"new"`             // num of addresses in new table
+	Tried          int    `json:"tried"`           // num of addresses in tried table
+	Terrible       int    `json:"terrible"`        // num of addresses to be removed in next compaction
+	NeverSucceeded int    `json:"never_succeeded"` // num of addresses never connected successfully
+	MaxSize        int    `json:"max_size"`        // max num of addresses
+	Evicted        uint64 `json:"evicted"`         // num of addresses evicted for the room of new ones since start
+	Compacted      uint64 `json:"compacted"`       // num of addresses removed by compaction since start
+}
+
+// SetMaxSize set the max num of addresses in address book, zero value means default.
+func (addrManager *AddressManager) SetMaxSize(maxSize int) {
+	if maxSize <= 0 {
+		maxSize = defaultMaxAddrBookSize
+	}
+	addrManager.lock.Lock()
+	defer addrManager.lock.Unlock()
+	addrManager.maxSize = maxSize
+}
+
+// check whether the address is not worth keeping: not seen within the horizon, failed too many times without
+// a success, or failed too many times since a success long ago.
+func (addrManager *AddressManager) isTerrible(ka *knownAddress, now time.Time) bool {
+	var attempts uint32
+	var lastAttempt, lastSuccess time.Time
+	if v, ok := addrManager.addressAttemptInfo.Load(ka.addr.ToString()); ok {
+		attemptInfo := v.(*AttemptInfo)
+		attempts = atomic.LoadUint32(&attemptInfo.AttemptNum)
+		lastAttempt = attemptInfo.LastAttemptTime.Load().(time.Time)
+		lastSuccess = attemptInfo.LastSuccessTime.Load().(time.Time)
+	}
+	// don't remove the address we are trying
+	if now.Sub(lastAttempt) < recentAttemptInterval {
+		return false
+	}
+	if now.Sub(ka.lastSeen) > addrHorizon && now.Sub(lastSuccess) > addrHorizon {
+		return true
+	}
+	if lastSuccess.IsZero() && attempts >= maxNeverSucceededTries {
+		return true
+	}
+	return now.Sub(lastSuccess) > minFailureInterval && attempts >= maxFailures
+}
+
+// remove the address and its attempt info, caller must hold the lock.
+func (addrManager *AddressManager) removeKnown(ka *knownAddress) {
+	addrManager.book.remove(ka.addr)
+	addrManager.addressAttemptInfo.Delete(ka.addr.ToString())
+	addrManager.changed = true
+}
+
+// evict a new address To make room for another one, terrible addresses are evicted first, otherwise the
+// least recently seen one in a random sample. Tried addresses are never evicted for new ones, return false
+// if there's no new address. caller must hold the lock.
+func (addrManager *AddressManager) evictOne() bool {
+	now := time.Now()
+	var victim *knownAddress
+	sampled := 0
+	for _, ka := range addrManager.book.index {
+		if ka.tried {
+			continue
+		}
+		if addrManager.isTerrible(ka, now) {
+			victim = ka
+			break
+		}
+		if victim == nil || ka.lastSeen.Before(victim.lastSeen) {
+			victim = ka
+		}
+		if sampled++; sampled >= evictSampleSize {
+			break
+		}
+	}
+	if victim == nil {
+		return false
+	}
+	log.Debug("evict address %s From full address book", victim.addr.ToString())
+	addrManager.removeKnown(victim)
+	addrManager.evicted++
+	return true
+}
+
+// compact remove the terrible addresses, and evict addresses until the book is within max size.
+func (addrManager *AddressManager) compact() {
+	addrManager.lock.Lock()
+	defer addrManager.lock.Unlock()
+	now := time.Now()
+	removed := 0
+	for _, ka := range addrManager.book.all() {
+		if addrManager.isTerrible(ka, now) {
+			addrManager.removeKnown(ka)
+			removed++
+		}
+	}
+	for addrManager.book.count() > addrManager.maxSize && addrManager.evictOne() {
+	}
+
+	// attempt info of the addresses no longer in book is useless, unless the address is still being retried,
+	// e.g. a persistent peer.
+	addrManager.addressAttemptInfo.Range(func(key, value interface{}) bool {
+		if _, ok := addrManager.book.index[key.(string)]; !ok && now.After(value.(*AttemptInfo).NextAttemptTime.Load().(time.Time)) {
+			addrManager.addressAttemptInfo.Delete(key)
+		}
+		return true
+	})
+
+	addrManager.compacted += uint64(removed)
+	if removed > 0 {
+		log.Info("remove %d terrible addresses From address book", removed)
+	}
+	stats := addrManager.stats(now)
+	log.Info("address book: total %d, new %d, tried %d, never succeeded %d, evicted %d, compacted %d",
+		stats.Total, stats.New, stats.Tried, stats.NeverSucceeded, stats.Evicted, stats.Compacted)
+}
+
+// Stats get the composition of the address book
+func (addrManager *AddressManager) Stats() *AddrBookStats {
+	addrManager.lock.RLock()
+	defer addrManager.lock.RUnlock()
+	return addrManager.stats(time.Now())
+}
+
+// caller must hold the lock.
+func (addrManager *AddressManager) stats(now time.Time) *AddrBookStats {
+	stats := &AddrBookStats{
+		Total:     addrManager.book.count(),
+		New:       addrManager.book.newCount,
+		Tried:     addrManager.book.triedCount,
+		MaxSize:   addrManager.maxSize,
+		Evicted:   addrManager.evicted,
+		Compacted: addrManager.compacted,
+	}
+	for _, ka := range addrManager.book.all() {
+		if addrManager.isTerrible(ka, now) {
+			stats.Terrible++
+		}
+		if addrManager.LastSuccessTime(ka.addr).IsZero() {
+			stats.NeverSucceeded++
+		}
+	}
+	return stats
+}
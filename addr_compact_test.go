@@ -0,0 +1,90 @@
+package p2p
+
+import (
+	"github.com/stretchr/testify/assert"
+	"os"
+	"testing"
+	"time"
+)
+
+func TestAddressManager_IsTerrible(t *testing.T) {
+	assert := assert.New(t)
+	os.RemoveAll(addressFile)
+	addrManger := NewAddressManager(addressFile)
+	addrs := mockNetAddresses(4)
+	addrManger.AddAddresses(addrs)
+	now := time.Now()
+
+	// fresh address
+	assert.False(addrManger.isTerrible(addrManger.book.get(addrs[0]), now))
+
+	// never succeeded
+	for i := 0; i < maxNeverSucceededTries; i++ {
+		addrManger.UpdateAddressAttemptInfo(addrs[1])
+	}
+	assert.False(addrManger.isTerrible(addrManger.book.get(addrs[1]), now))
+	assert.True(addrManger.isTerrible(addrManger.book.get(addrs[1]), now.Add(2*recentAttemptInterval)))
+
+	// succeeded recently
+	addrManger.ResetAddressAttemptInfo(addrs[2])
+	for i := 0; i < maxFailures; i++ {
+		addrManger.UpdateAddressAttemptInfo(addrs[2])
+	}
+	assert.False(addrManger.isTerrible(addrManger.book.get(addrs[2]), now.Add(2*recentAttemptInterval)))
+	assert.True(addrManger.isTerrible(addrManger.book.get(addrs[2]), now.Add(minFailureInterval+time.Hour)))
+
+	// stale
+	addrManger.book.get(addrs[3]).lastSeen = now.Add(-addrHorizon - time.Hour)
+	assert.True(addrManger.isTerrible(addrManger.book.get(addrs[3]), now))
+}
+
+func TestAddressManager_MaxSize(t *testing.T) {
+	assert := assert.New(t)
+	os.RemoveAll(addressFile)
+	addrManger := NewAddressManager(addressFile)
+	addrManger.SetMaxSize(10)
+	addrs := mockNetAddresses(20)
+	addrManger.AddAddresses(addrs[:10])
+	addrManger.ResetAddressAttemptInfo(addrs[0])
+	addrManger.Good(addrs[0])
+
+	addrManger.AddAddresses(addrs[10:])
+	assert.Equal(10, addrManger.GetAddressCount())
+	assert.True(addrManger.IsTried(addrs[0]))
+	stats := addrManger.Stats()
+	assert.Equal(10, stats.Total)
+	assert.Equal(1, stats.Tried)
+	assert.Equal(9, stats.New)
+	assert.Equal(9, stats.NeverSucceeded)
+	assert.Equal(uint64(10), stats.Evicted)
+
+	// compaction shrinks the book To new max size
+	addrManger.SetMaxSize(5)
+	addrManger.compact()
+	assert.Equal(5, addrManger.GetAddressCount())
+	assert.True(addrManger.IsTried(addrs[0]))
+}
+
+func TestAddressManager_Compact(t *testing.T) {
+	assert := assert.New(t)
+	os.RemoveAll(addressFile)
+	addrManger := NewAddressManager(addressFile)
+	addrs := mockNetAddresses(2)
+	addrManger.AddAddresses(addrs)
+	for i := 0; i < maxNeverSucceededTries; i++ {
+		addrManger.UpdateAddressAttemptInfo(addrs[0])
+	}
+	v, _ := addrManger.addressAttemptInfo.Load(addrs[0].ToString())
+	v.(*AttemptInfo).LastAttemptTime.Store(time.Now().Add(-time.Hour))
+	v.(*AttemptInfo).NextAttemptTime.Store(time.Now().Add(-time.Hour))
+	assert.Equal(1, addrManger.Stats().Terrible)
+
+	addrManger.compact()
+	assert.Equal(1, addrManger.GetAddressCount())
+	assert.Nil(addrManger.book.get(addrs[0]))
+	_, ok := addrManger.addressAttemptInfo.Load(addrs[0].ToString())
+	assert.False(ok)
+	stats := addrManger.Stats()
+	assert.Equal(0, stats.Terrible)
+	assert.Equal(uint64(1), stats.Compacted)
+}
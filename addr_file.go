@@ -0,0 +1,183 @@
+package p2p
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/hex"
+	"encoding/json"
+	"github.com/DSiSc/craft/log"
+	"github.com/DSiSc/p2p/config"
+	"io/ioutil"
+	"os"
+	"time"
+)
+
+const (
+	// addrBookFileVersion is the version of current address book file format
+	addrBookFileVersion = 1
+	// permission of the address book file
+	addrBookFilePerm = 0600
+	// max length of a line in address book file, longer lines are skipped as corrupted
+	maxAddrBookLineLen = 64 * 1024
+)
+
+// The address book file begins with a header line, followed by one address record per line, so a corrupted
+// record only loses itself. The legacy format, a JSON array of address strings, is still accepted on load.
+
+// addrBookHeader is the first line of the address book file
+type addrBookHeader struct {
+	Version int    `json:"version"`
+	Key     string `json:"key"` // hex encoded secret key of the buckets
+}
+
+// addrRecord is the persisted metadata of an address
+type addrRecord struct {
+	Addr        string              `json:"addr"`
+	Src         string              `json:"src,omitempty"`
+	Tried       bool                `json:"tried,omitempty"`
+	Services    *config.ServiceFlag `json:"services,omitempty"`
+	Attempts    uint32              `json:"attempts,omitempty"`
+	LastAttempt time.Time           `json:"last_attempt"`
+	NextAttempt time.Time           `json:"next_attempt"`
+	LastSuccess time.Time           `json:"last_success"`
+	LastSeen    time.Time           `json:"last_seen"`
+	Added       time.Time           `json:"added"`
+	Disconnect  *DisconnectInfo     `json:"disconnect,omitempty"`
+}
+
+// encode the address book To file content
+func encodeAddrBook(key []byte, records []*addrRecord) ([]byte, error) {
+	var buf bytes.Buffer
+	encoder := json.NewEncoder(&buf)
+	header := &addrBookHeader{
+		Version: addrBookFileVersion,
+		Key:     hex.EncodeToString(key),
+	}
+	if err := encoder.Encode(header); err != nil {
+		return nil, err
+	}
+	for _, record := range records {
+		if err := encoder.Encode(record); err != nil {
+			return nil, err
+		}
+	}
+	return buf.Bytes(), nil
+}
+
+// decode the address book From file content, corrupted records are skipped. The returned key is nil if the
+// file is in legacy format or the key is invalid.
+func decodeAddrBook(buf []byte) (key []byte, records []*addrRecord) {
+	records = make([]*addrRecord, 0)
+	buf = bytes.TrimSpace(buf)
+	if len(buf) == 0 {
+		return nil, records
+	}
+	if buf[0] == '[' {
+		return nil, decodeLegacyAddrBook(buf)
+	}
+
+	reader := bufio.NewReader(bytes.NewReader(buf))
+	line, oversized, err := readAddrBookLine(reader)
+	if err != nil {
+		return nil, records
+	}
+	header := &addrBookHeader{}
+	if oversized {
+		log.Warn("address book file have an oversized header")
+	} else if err := json.Unmarshal(line, header); err != nil {
+		log.Warn("address book file have a corrupted header, as: %v", err)
+	} else {
+		if header.Version > addrBookFileVersion {
+			log.Warn("address book file version %d is newer than %d, try To load it anyway", header.Version, addrBookFileVersion)
+		}
+		if k, err := hex.DecodeString(header.Key); err == nil && len(k) == addrBookKeyLen {
+			key = k
+		}
+	}
+
+	corrupted, skipped := 0, 0
+	for {
+		line, oversized, err := readAddrBookLine(reader)
+		if err != nil {
+			break
+		}
+		if oversized {
+			skipped++
+			continue
+		}
+		record := &addrRecord{}
+		if err := json.Unmarshal(line, record); err != nil || record.Addr == "" {
+			corrupted++
+			continue
+		}
+		records = append(records, record)
+	}
+	if skipped > 0 {
+		log.Warn("skip %d records longer than %d bytes in address book file", skipped, maxAddrBookLineLen)
+	}
+	if corrupted > 0 {
+		log.Warn("skip %d corrupted records in address book file", corrupted)
+	}
+	return key, records
+}
+
+// read a line of address book file, the content of a line longer than maxAddrBookLineLen is dropped and
+// oversized is true. err is io.EOF if there is no more line.
+func readAddrBookLine(reader *bufio.Reader) (line []byte, oversized bool, err error) {
+	for {
+		chunk, isPrefix, err := reader.ReadLine()
+		if err != nil {
+			return nil, false, err
+		}
+		if !oversized {
+			if len(line)+len(chunk) > maxAddrBookLineLen {
+				oversized, line = true, nil
+			} else {
+				line = append(line, chunk...)
+			}
+		}
+		if !isPrefix {
+			return line, oversized, nil
+		}
+	}
+}
+
+// decode the legacy address book, which is a JSON array of address strings
+func decodeLegacyAddrBook(buf []byte) []*addrRecord {
+	records := make([]*addrRecord, 0)
+	decoder := json.NewDecoder(bytes.NewReader(buf))
+	if _, err := decoder.Token(); err != nil {
+		log.Error("failed To parse legacy address book file, as %v", err)
+		return records
+	}
+	now := time.Now()
+	for decoder.More() {
+		var addrStr string
+		if err := decoder.Decode(&addrStr); err != nil {
+			log.Warn("legacy address book file is corrupted, as: %v", err)
+			break
+		}
+		records = append(records, &addrRecord{
+			Addr:     addrStr,
+			LastSeen: now,
+			Added:    now,
+		})
+	}
+	log.Info("migrate %d addresses From legacy address book file", len(records))
+	return records
+}
+
+// loadAddressBook load the address book file
+func loadAddressBook(filePath string) (key []byte, records []*addrRecord) {
+	if _, err := os.Stat(filePath); err != nil {
+		return nil, make([]*addrRecord, 0)
+	}
+	buf, err := ioutil.ReadFile(filePath)
+	if err != nil {
+		log.Error("failed To read address book file, as: %v", err)
+		return nil, make([]*addrRecord, 0)
+	}
+	key, records = decodeAddrBook(buf)
+	log.Debug("load %d addresses From file %s", len(records), filePath)
+	return key, records
+}
@@ -0,0 +1,141 @@
+package p2p
+
+import (
+	"github.com/DSiSc/p2p/config"
+	"github.com/DSiSc/p2p/message"
+	"github.com/stretchr/testify/assert"
+	"io/ioutil"
+	"os"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestEncodeDecodeAddrBook(t *testing.T) {
+	assert := assert.New(t)
+	key := make([]byte, addrBookKeyLen)
+	key[0] = 1
+	services := config.SFNodeBlockSyncer
+	records := []*addrRecord{
+		{Addr: "tcp://192.168.1.101:8080", Tried: true, Services: &services, Attempts: 2, LastSeen: time.Now().Round(0)},
+		{Addr: "tcp://192.168.1.102:8080", Src: "tcp://192.168.1.101:8080"},
+	}
+	buf, err := encodeAddrBook(key, records)
+	assert.Nil(err)
+
+	key1, records1 := decodeAddrBook(buf)
+	assert.Equal(key, key1)
+	assert.Equal(2, len(records1))
+	assert.Equal(records[0].Addr, records1[0].Addr)
+	assert.True(records1[0].Tried)
+	assert.Equal(services, *records1[0].Services)
+	assert.Equal(uint32(2), records1[0].Attempts)
+	assert.True(records[0].LastSeen.Equal(records1[0].LastSeen))
+	assert.Equal(records[1].Src, records1[1].Src)
+	assert.Nil(records1[1].Services)
+}
+
+func TestDecodeAddrBook_Corrupted(t *testing.T) {
+	assert := assert.New(t)
+	buf, err := encodeAddrBook(make([]byte, addrBookKeyLen), []*addrRecord{
+		{Addr: "tcp://192.168.1.101:8080"},
+		{Addr: "tcp://192.168.1.102:8080"},
+		{Addr: "tcp://192.168.1.103:8080"},
+	})
+	assert.Nil(err)
+	lines := strings.Split(string(buf), "\n")
+	lines[2] = lines[2][:len(lines[2])/2]
+	_, records := decodeAddrBook([]byte(strings.Join(lines, "\n")))
+	assert.Equal(2, len(records))
+	assert.Equal("tcp://192.168.1.101:8080", records[0].Addr)
+	assert.Equal("tcp://192.168.1.103:8080", records[1].Addr)
+
+	// corrupted header
+	_, records = decodeAddrBook([]byte("{\"version\n" + lines[1]))
+	assert.Equal(1, len(records))
+}
+
+func TestDecodeAddrBook_Oversized(t *testing.T) {
+	assert := assert.New(t)
+	buf, err := encodeAddrBook(make([]byte, addrBookKeyLen), []*addrRecord{
+		{Addr: "tcp://192.168.1.101:8080"},
+		{Addr: "tcp://192.168.1.102:8080"},
+		{Addr: "tcp://192.168.1.103:8080"},
+	})
+	assert.Nil(err)
+	lines := strings.Split(string(buf), "\n")
+	lines[2] = strings.Repeat("x", 2*maxAddrBookLineLen)
+	key, records := decodeAddrBook([]byte(strings.Join(lines, "\n")))
+	assert.NotNil(key)
+	assert.Equal(2, len(records))
+	assert.Equal("tcp://192.168.1.101:8080", records[0].Addr)
+	assert.Equal("tcp://192.168.1.103:8080", records[1].Addr)
+
+	// oversized header
+	lines[0] = lines[2]
+	key, records = decodeAddrBook([]byte(strings.Join(lines, "\n")))
+	assert.Nil(key)
+	assert.Equal(2, len(records))
+}
+
+func TestDecodeAddrBook_Legacy(t *testing.T) {
+	assert := assert.New(t)
+	key, records := decodeAddrBook([]byte(`["tcp://192.168.1.101:8080","tcp://192.168.1.102:8080"]`))
+	assert.Nil(key)
+	assert.Equal(2, len(records))
+	assert.Equal("tcp://192.168.1.102:8080", records[1].Addr)
+	assert.False(records[1].LastSeen.IsZero())
+
+	// truncated legacy file
+	_, records = decodeAddrBook([]byte(`["tcp://192.168.1.101:8080","tcp://192.1`))
+	assert.Equal(1, len(records))
+}
+
+func TestAddressManager_SaveRestore(t *testing.T) {
+	assert := assert.New(t)
+	os.RemoveAll(addressFile)
+	defer os.RemoveAll(addressFile)
+	addrManger := NewAddressManager(addressFile)
+	addrs := mockNetAddresses(3)
+	addrManger.AddTimedAddresses(mockTimedAddresses(addrs, time.Now()), mockAddress())
+	addrManger.ResetAddressAttemptInfo(addrs[0])
+	addrManger.Good(addrs[0])
+	addrManger.Connected(addrs[0], config.SFNodeBlockSyncer)
+	addrManger.UpdateAddressAttemptInfo(addrs[1])
+	addrManger.RecordDisconnect(addrs[2], message.ReasonTooManyPeers)
+	addrManger.Save()
+	info, err := os.Stat(addressFile)
+	assert.Nil(err)
+	assert.Equal(os.FileMode(addrBookFilePerm), info.Mode().Perm())
+
+	addrManger1 := NewAddressManager(addressFile)
+	assert.Equal(addrManger.book.key, addrManger1.book.key)
+	assert.Equal(3, addrManger1.GetAddressCount())
+	assert.True(addrManger1.IsTried(addrs[0]))
+	ka := addrManger1.book.get(addrs[0])
+	assert.True(ka.servicesKnown)
+	assert.Equal(config.SFNodeBlockSyncer, ka.services)
+	assert.False(addrManger1.LastSuccessTime(addrs[0]).IsZero())
+	assert.Equal(mockAddress(), addrManger1.book.get(addrs[1]).src)
+	attemptNum, _ := addrManger1.GetAddressAttemptInfo(addrs[1])
+	assert.Equal(uint32(1), attemptNum)
+	disconnect := addrManger1.GetDisconnectInfo(addrs[2])
+	assert.Equal(message.ReasonTooManyPeers, disconnect.Reason)
+	assert.True(disconnect.RetryTime.Equal(addrManger.GetDisconnectInfo(addrs[2]).RetryTime))
+	assert.Nil(addrManger1.GetDisconnectInfo(addrs[0]))
+	assert.False(addrManger1.changed)
+}
+
+func TestAddressManager_MigrateLegacy(t *testing.T) {
+	assert := assert.New(t)
+	os.RemoveAll(addressFile)
+	defer os.RemoveAll(addressFile)
+	assert.Nil(ioutil.WriteFile(addressFile, []byte(`["tcp://192.168.1.101:8080"]`), 0600))
+	addrManger := NewAddressManager(addressFile)
+	assert.Equal(1, addrManger.GetAddressCount())
+	assert.True(addrManger.changed)
+	addrManger.Save()
+	key, records := loadAddressBook(addressFile)
+	assert.NotNil(key)
+	assert.Equal(1, len(records))
+}
@@ -0,0 +1,72 @@
+package p2p
+
+import (
+	"errors"
+	"fmt"
+	"github.com/DSiSc/p2p/common"
+	"sync/atomic"
+)
+
+// policies of accepting the addresses relayed by peers
+const (
+	// AddrPolicyAuto accept non-routable addresses only From the peers in non-routable network, so a node in
+	// a private network can still learn its neighbors, but a public node won't be polluted by private addresses.
+	AddrPolicyAuto = "auto"
+	// AddrPolicyPublic accept routable addresses only
+	AddrPolicyPublic = "public"
+	// AddrPolicyPrivate accept all valid addresses, used in private/LAN deployments.
+	AddrPolicyPrivate = "private"
+)
+
+// max num of Addr messages containing junk addresses a peer can relay before it is penalized
+const maxJunkRelays = 3
+
+var (
+	errInvalidAddr     = errors.New("invalid address")
+	errNonRoutableAddr = errors.New("non-routable address")
+)
+
+// checkRelayedAddr check whether the address relayed by the source is acceptable under the policy. Addresses
+// observed by ourselves(src is nil) are trusted To be reachable, only the sanity is checked. A DNS seed(src is
+// a host name) is regarded as a public source.
+func checkRelayedAddr(policy string, addr, src *common.NetAddress) error {
+	if !addr.IsValid() {
+		return errInvalidAddr
+	}
+	if src == nil || addr.IsRoutable() {
+		return nil
+	}
+	switch policy {
+	case AddrPolicyPrivate:
+		return nil
+	case AddrPolicyPublic:
+		return errNonRoutableAddr
+	default:
+		// a loopback address is meaningful only if the source is on the same host
+		if addr.IsLoopback() {
+			if src.IsLoopback() {
+				return nil
+			}
+			return errNonRoutableAddr
+		}
+		if src.IsRoutable() || src.IsHostname() {
+			return errNonRoutableAddr
+		}
+		return nil
+	}
+}
+
+// validAddrPolicy check whether the policy is supported, empty policy means AddrPolicyAuto.
+func validAddrPolicy(policy string) error {
+	switch policy {
+	case "", AddrPolicyAuto, AddrPolicyPublic, AddrPolicyPrivate:
+		return nil
+	default:
+		return fmt.Errorf("unsupported address policy %s", policy)
+	}
+}
+
+// record the peer relayed junk addresses, return true if it have relayed junk too many times.
+func (peer *Peer) recordJunkRelay() bool {
+	return atomic.AddInt32(&peer.junkRelays, 1) > maxJunkRelays
+}
@@ -0,0 +1,78 @@
+package p2p
+
+import (
+	"github.com/DSiSc/p2p/common"
+	"github.com/stretchr/testify/assert"
+	"os"
+	"testing"
+	"time"
+)
+
+func TestCheckRelayedAddr(t *testing.T) {
+	assert := assert.New(t)
+	public := common.NewNetAddress("tcp", "8.8.8.8", 8080)
+	private := common.NewNetAddress("tcp", "192.168.1.1", 8080)
+	loopback := common.NewNetAddress("tcp", "127.0.0.1", 8080)
+	publicSrc := common.NewNetAddress("tcp", "9.9.9.9", 8080)
+	privateSrc := common.NewNetAddress("tcp", "10.0.0.1", 8080)
+
+	// insane addresses are always rejected
+	for _, policy := range []string{AddrPolicyAuto, AddrPolicyPublic, AddrPolicyPrivate} {
+		assert.Equal(errInvalidAddr, checkRelayedAddr(policy, common.NewNetAddress("tcp", "0.0.0.0", 8080), nil))
+		assert.Equal(errInvalidAddr, checkRelayedAddr(policy, common.NewNetAddress("tcp", "224.0.0.1", 8080), privateSrc))
+		assert.Equal(errInvalidAddr, checkRelayedAddr(policy, common.NewNetAddress("tcp", "8.8.8.8", 0), publicSrc))
+		assert.Nil(checkRelayedAddr(policy, public, publicSrc))
+		assert.Nil(checkRelayedAddr(policy, private, nil))
+	}
+
+	assert.Equal(errNonRoutableAddr, checkRelayedAddr(AddrPolicyAuto, private, publicSrc))
+	assert.Nil(checkRelayedAddr(AddrPolicyAuto, private, privateSrc))
+	assert.Equal(errNonRoutableAddr, checkRelayedAddr(AddrPolicyAuto, loopback, privateSrc))
+	assert.Nil(checkRelayedAddr(AddrPolicyAuto, loopback, common.NewNetAddress("tcp", "127.0.0.1", 8081)))
+	seedSrc := common.NewNetAddress("tcp", "seed.example.com", 8080)
+	assert.Nil(checkRelayedAddr(AddrPolicyAuto, public, seedSrc))
+	assert.Equal(errNonRoutableAddr, checkRelayedAddr(AddrPolicyAuto, private, seedSrc))
+	assert.Nil(checkRelayedAddr(AddrPolicyPrivate, private, seedSrc))
+
+	assert.Equal(errNonRoutableAddr, checkRelayedAddr(AddrPolicyPublic, private, privateSrc))
+	assert.Nil(checkRelayedAddr(AddrPolicyPrivate, private, publicSrc))
+	assert.Nil(checkRelayedAddr(AddrPolicyPrivate, loopback, publicSrc))
+}
+
+func TestAddressManager_SetAddrPolicy(t *testing.T) {
+	assert := assert.New(t)
+	addrManger := NewAddressManager(addressFile)
+	assert.Nil(addrManger.SetAddrPolicy(""))
+	assert.Nil(addrManger.SetAddrPolicy(AddrPolicyPublic))
+	assert.Equal(AddrPolicyPublic, addrManger.policy)
+	assert.NotNil(addrManger.SetAddrPolicy("unknown"))
+	assert.Equal(AddrPolicyPublic, addrManger.policy)
+}
+
+func TestAddressManager_JunkRelay(t *testing.T) {
+	assert := assert.New(t)
+	os.RemoveAll(addressFile)
+	addrManger := NewAddressManager(addressFile)
+	src := common.NewNetAddress("tcp", "9.9.9.9", 8080)
+	now := time.Now()
+	junk := mockTimedAddresses([]*common.NetAddress{
+		common.NewNetAddress("tcp", "0.0.0.0", 8080),
+		common.NewNetAddress("tcp", "192.168.1.1", 8080),
+	}, now)
+	good := mockTimedAddresses(mockNetAddresses(2), now)
+
+	assert.Equal(0, addrManger.AddTimedAddresses(good, src))
+	assert.Equal(2, addrManger.GetAddressCount())
+	assert.Equal(2, addrManger.AddTimedAddresses(append(junk, good...), src))
+	assert.Equal(2, addrManger.GetAddressCount())
+
+	peer := NewOutboundPeer(nil, src, false, nil)
+	for i := 0; i < maxJunkRelays; i++ {
+		assert.False(peer.recordJunkRelay())
+	}
+	assert.True(peer.recordJunkRelay())
+
+	// the counter is kept by the connection, a new one starts From zero
+	peer = NewOutboundPeer(nil, src, false, nil)
+	assert.False(peer.recordJunkRelay())
+}
@@ -1,15 +1,15 @@
 package p2p
 
 import (
-	"encoding/json"
 	"errors"
 	"fmt"
 	"github.com/DSiSc/craft/log"
 	"github.com/DSiSc/p2p/common"
-	"io/ioutil"
+	"github.com/DSiSc/p2p/config"
+	"github.com/DSiSc/p2p/message"
 	"math/rand"
 	"net"
-	"os"
+	"sort"
 	"strconv"
 	"sync"
 	"sync/atomic"
@@ -23,36 +23,84 @@ const (
 	// getAddrMax is the most addresses that we will send in response
 	// To a getAddr (in practise the most addresses we will return From a
 	// call To AddressCache()).
-	getAddrMax             = 2500
-	maxAttemptNum          = 100
-	minimalAttemptInterval = time.Minute
+	getAddrMax    = 2500
+	maxAttemptNum = 100
+
+	addrHorizon        = 30 * 24 * time.Hour // addresses not seen in it are stale
+	legacyAddrAge      = 5 * 24 * time.Hour  // assumed age of the relayed address without a valid timestamp
+	relayPenalty       = 2 * time.Hour       // relayed address is assumed not seen as recently as the relayer claimed
+	maxClockSkew       = 10 * time.Minute    // max tolerance of the timestamp in future
+	seenUpdateInterval = 20 * time.Minute    // min interval of updating the last seen time of an active address
 )
 
 //AttemptInfo represent the address attempt info
 type AttemptInfo struct {
-	AttemptNum      uint32       // number of attempt
+	AttemptNum      uint32       // number of attempt since last success
 	LastAttemptTime atomic.Value // unix time of last attempt
+	NextAttemptTime atomic.Value // unix time before which the address should not be attempted
+	LastSuccessTime atomic.Value // unix time of last successful connection
+}
+
+// create an attempt info with no attempts
+func newAttemptInfo() *AttemptInfo {
+	attemptInfo := &AttemptInfo{}
+	attemptInfo.LastAttemptTime.Store(time.Time{})
+	attemptInfo.NextAttemptTime.Store(time.Time{})
+	attemptInfo.LastSuccessTime.Store(time.Time{})
+	return attemptInfo
+}
+
+// DisconnectInfo represent the last disconnection of an address
+type DisconnectInfo struct {
+	Reason    message.DisconnectReason `json:"reason"`     // reason code of the disconnection
+	Time      time.Time                `json:"time"`       // time of the disconnection
+	RetryTime time.Time                `json:"retry_time"` // time after which the address can be connected again
+}
+
+// disconnectBackoff is the interval we back off From an address after disconnected with the reason,
+// reasons not in it have no back off.
+var disconnectBackoff = map[message.DisconnectReason]time.Duration{
+	message.ReasonTooManyPeers:        10 * time.Minute,
+	message.ReasonBanned:              24 * time.Hour,
+	message.ReasonIncompatibleVersion: 24 * time.Hour,
+	message.ReasonProtocolViolation:   time.Hour,
+	message.ReasonShuttingDown:        5 * time.Minute,
+	message.ReasonTimeout:             5 * time.Minute,
 }
 
 // AddressManager is used To manage neighbor's address
 type AddressManager struct {
 	filePath           string
 	ourAddrs           sync.Map
-	addresses          sync.Map
+	book               *addrBook
 	addressAttemptInfo sync.Map
+	backoff            *backoff
+	policy             string // policy of accepting relayed addresses
+	maxSize            int    // max num of addresses in book
+	evicted            uint64 // num of addresses evicted for the room of new ones
+	compacted          uint64 // num of addresses removed by compaction
 	lock               sync.RWMutex
+	saveLock           sync.Mutex // serialize the saving, so older records never overwrite the newer ones
 	changed            bool
 	quitChan           chan interface{}
 }
 
 // NewAddressManager create an address manager instance
 func NewAddressManager(filePath string) *AddressManager {
-	addresses := loadAddress(filePath)
+	key, records := loadAddressBook(filePath)
 	addrManager := &AddressManager{
 		filePath: filePath,
+		book:     newAddrBook(),
+		backoff:  newBackoff(0, 0, 0),
+		maxSize:  defaultMaxAddrBookSize,
 		quitChan: make(chan interface{}),
 	}
-	addrManager.AddAddresses(addresses)
+	if key != nil {
+		copy(addrManager.book.key[:], key)
+	}
+	addrManager.restore(records)
+	// rewrite the legacy file in current format
+	addrManager.changed = key == nil && len(records) > 0
 	return addrManager
 }
 
@@ -63,6 +111,19 @@ func (addrManager *AddressManager) AddOurAddress(addr *common.NetAddress) {
 	}
 }
 
+// RemoveOurAddress remove our address which is no longer valid, e.g. the outdated external address. The address
+// of local interfaces is never removed.
+func (addrManager *AddressManager) RemoveOurAddress(addr *common.NetAddress) {
+	if localIps, err := getLocalAddresses(); err == nil {
+		for _, localIp := range localIps {
+			if localIp == addr.IP {
+				return
+			}
+		}
+	}
+	addrManager.ourAddrs.Delete(addr.ToString())
+}
+
 // AddOurAddress add our local address.
 func (addrManager *AddressManager) AddLocalAddress(port int32) error {
 	localIps, err := getLocalAddresses()
@@ -70,7 +131,7 @@ func (addrManager *AddressManager) AddLocalAddress(port int32) error {
 		return fmt.Errorf("failed To add our local address To address manager as:%v", err)
 	}
 	for _, localIp := range localIps {
-		netAddr, err := common.ParseNetAddress(localIp + ":" + strconv.Itoa(int(port)))
+		netAddr, err := common.ParseNetAddress(net.JoinHostPort(localIp, strconv.Itoa(int(port))))
 		if err != nil {
 			continue
 		}
@@ -106,77 +167,323 @@ func (addrManager *AddressManager) AddAddresses(addrs []*common.NetAddress) {
 	}
 }
 
-// AddAddress add a new address
+// AddTimedAddresses add the addresses relayed by the source peer. Stale addresses are ignored, and the
+// timestamps are penalized as we don't see them ourselves. Addresses rejected by the policy are junk, return
+// the num of junk addresses.
+func (addrManager *AddressManager) AddTimedAddresses(addrs []*message.TimedAddress, src *common.NetAddress) int {
+	log.Debug("add %d relayed addresses To book", len(addrs))
+	now := time.Now()
+	junk := 0
+	for _, taddr := range addrs {
+		if taddr == nil || taddr.NetAddress == nil {
+			junk++
+			continue
+		}
+		lastSeen := relayedLastSeen(taddr, src, now)
+		if now.Sub(lastSeen) > addrHorizon {
+			log.Debug("ignore stale address %s", taddr.ToString())
+			continue
+		}
+		if addrManager.addAddress(taddr.NetAddress, src, lastSeen, taddr.Services) != nil {
+			junk++
+		}
+	}
+	if junk > 0 && src != nil {
+		log.Warn("peer %s relayed %d junk addresses", src.ToString(), junk)
+	}
+	return junk
+}
+
+// the last seen time we believe of a relayed address
+func relayedLastSeen(taddr *message.TimedAddress, src *common.NetAddress, now time.Time) time.Time {
+	lastSeen := time.Unix(taddr.Timestamp, 0)
+	if taddr.Timestamp <= 0 || lastSeen.After(now.Add(maxClockSkew)) {
+		lastSeen = now.Add(-legacyAddrAge)
+	}
+	if src != nil && !src.Equal(taddr.NetAddress) {
+		lastSeen = lastSeen.Add(-relayPenalty)
+	}
+	return lastSeen
+}
+
+// AddAddress add a new address seen by ourselves
 func (addrManager *AddressManager) AddAddress(addr *common.NetAddress) {
+	addrManager.addAddress(addr, nil, time.Now(), nil)
+}
+
+// SetAddrPolicy set the policy of accepting relayed addresses
+func (addrManager *AddressManager) SetAddrPolicy(policy string) error {
+	if err := validAddrPolicy(policy); err != nil {
+		return err
+	}
+	addrManager.policy = policy
+	return nil
+}
+
+// add a new address told by the source, the address itself is the source if src is nil. return error if the
+// address is rejected by the policy, our own address is ignored without error.
+func (addrManager *AddressManager) addAddress(addr, src *common.NetAddress, lastSeen time.Time, services *config.ServiceFlag) error {
+	if err := checkRelayedAddr(addrManager.policy, addr, src); err != nil {
+		log.Debug("ignore address %s, as: %v", addr.ToString(), err)
+		return err
+	}
 	log.Debug("add new address %s To book", addr.ToString())
 	addrManager.lock.Lock()
 	defer addrManager.lock.Unlock()
 	if _, ok := addrManager.ourAddrs.Load(addr.ToString()); ok {
-		return
+		return nil
+	}
+	if addrManager.book.get(addr) == nil && addrManager.book.count() >= addrManager.maxSize && !addrManager.evictOne() {
+		log.Debug("address book is full of tried addresses, ignore address %s", addr.ToString())
+		return nil
+	}
+
+	if addrManager.book.add(addr, src, lastSeen) {
+		addrManager.changed = true
+	}
+	// services learned From hand shake are more trustworthy than the relayed ones
+	if ka := addrManager.book.get(addr); ka != nil && services != nil && !ka.servicesKnown {
+		ka.services = *services
+		ka.servicesKnown = true
+		addrManager.changed = true
 	}
+	return nil
+}
 
-	if _, ok := addrManager.addresses.LoadOrStore(addr.ToString(), addr); !ok {
+// Seen update the last seen time of an address when observed activity From it.
+func (addrManager *AddressManager) Seen(addr *common.NetAddress) {
+	addrManager.lock.Lock()
+	defer addrManager.lock.Unlock()
+	now := time.Now()
+	if ka := addrManager.book.get(addr); ka != nil && now.Sub(ka.lastSeen) > seenUpdateInterval {
+		ka.lastSeen = now
 		addrManager.changed = true
 	}
 }
 
+// Good mark the address as tried after a successful connection.
+func (addrManager *AddressManager) Good(addr *common.NetAddress) {
+	addrManager.lock.Lock()
+	defer addrManager.lock.Unlock()
+	if addrManager.book.good(addr) {
+		addrManager.changed = true
+	}
+}
+
+// Connected update the last seen time and services of the address after connected with it.
+func (addrManager *AddressManager) Connected(addr *common.NetAddress, services config.ServiceFlag) {
+	addrManager.lock.Lock()
+	defer addrManager.lock.Unlock()
+	if ka := addrManager.book.get(addr); ka != nil {
+		ka.lastSeen = time.Now()
+		ka.services = services
+		ka.servicesKnown = true
+		addrManager.changed = true
+	}
+}
+
+// IsTried check whether the address have been connected successfully.
+func (addrManager *AddressManager) IsTried(addr *common.NetAddress) bool {
+	addrManager.lock.RLock()
+	defer addrManager.lock.RUnlock()
+	ka := addrManager.book.get(addr)
+	return ka != nil && ka.tried
+}
+
 // RemoveAddress remove an address
 func (addrManager *AddressManager) RemoveAddress(addr *common.NetAddress) {
-	addrManager.addresses.Delete(addr.ToString())
-	addrManager.changed = true
+	addrManager.lock.Lock()
+	defer addrManager.lock.Unlock()
+	if addrManager.book.remove(addr) {
+		addrManager.changed = true
+	}
 }
 
-// GetAddress get a random address
+// GetAddress get a random address To connect, tried addresses are preferred.
 func (addrManager *AddressManager) GetAddress() (*common.NetAddress, error) {
-	addrs := addrManager.GetAllAddress()
-	if len(addrs) > 0 {
-		index := rand.Intn(len(addrs))
-		return addrs[index], nil
+	return addrManager.GetAddressWithFilter(nil)
+}
+
+// GetAddressWithFilter get a random address To connect satisfying the filter, tried addresses are preferred. The
+// services passed To filter is nil if the services of the address is unknown.
+func (addrManager *AddressManager) GetAddressWithFilter(filter func(addr *common.NetAddress, services *config.ServiceFlag) bool) (*common.NetAddress, error) {
+	addrManager.lock.RLock()
+	defer addrManager.lock.RUnlock()
+	ka := addrManager.book.pick(func(ka *knownAddress) bool {
+		if !addrManager.connectable(ka) {
+			return false
+		}
+		return filter == nil || filter(ka.addr, ka.knownServices())
+	})
+	if ka == nil {
+		return nil, errors.New("no address in address book")
 	}
-	return nil, errors.New("no address in address book")
+	return ka.addr, nil
 }
 
-// GetAddresses get a random address list To send To peer
-func (addrManager *AddressManager) GetAddresses() []*common.NetAddress {
-	addrs := addrManager.GetAllAddress()
-	if addrManager.GetAddressCount() <= getAddrMax {
-		return addrs
-	} else {
-		for i := 0; i < getAddrMax; i++ {
-			j := rand.Intn(getAddrMax-i) + i
-			addrs[i], addrs[j] = addrs[j], addrs[i]
+// GetUntriedAddress get a random address we have never connected successfully, used To test the liveness.
+func (addrManager *AddressManager) GetUntriedAddress() (*common.NetAddress, error) {
+	addrManager.lock.RLock()
+	defer addrManager.lock.RUnlock()
+	ka := addrManager.book.pickFrom(false, func(ka *knownAddress) bool {
+		return addrManager.connectable(ka)
+	})
+	if ka == nil {
+		return nil, errors.New("no untried address in address book")
+	}
+	return ka.addr, nil
+}
+
+// GetHealthyAddresses get a random sample of at most num healthy addresses satisfying the filter. An address
+// is healthy if we have connected To it successfully, and it's neither terrible nor in back off. The services
+// passed To filter is nil if the services of the address is unknown.
+func (addrManager *AddressManager) GetHealthyAddresses(num int, filter func(addr *common.NetAddress, services *config.ServiceFlag) bool) []*common.NetAddress {
+	now := time.Now()
+	addrManager.lock.RLock()
+	addrs := make([]*common.NetAddress, 0)
+	for _, ka := range addrManager.book.all() {
+		if !ka.tried || addrManager.isTerrible(ka, now) || inBackoff(ka, now) {
+			continue
 		}
-		return addrs[:getAddrMax]
+		if filter == nil || filter(ka.addr, ka.knownServices()) {
+			addrs = append(addrs, ka.addr)
+		}
+	}
+	addrManager.lock.RUnlock()
+	return sampleAddresses(addrs, num)
+}
+
+// shuffle the addresses and get at most num of them
+func sampleAddresses(addrs []*common.NetAddress, num int) []*common.NetAddress {
+	for i := 0; i < len(addrs) && i < num; i++ {
+		j := rand.Intn(len(addrs)-i) + i
+		addrs[i], addrs[j] = addrs[j], addrs[i]
+	}
+	if len(addrs) > num {
+		addrs = addrs[:num]
+	}
+	return addrs
+}
+
+// GetServices get the services supported by the address, return nil if the address or its services is unknown.
+func (addrManager *AddressManager) GetServices(addr *common.NetAddress) *config.ServiceFlag {
+	addrManager.lock.RLock()
+	defer addrManager.lock.RUnlock()
+	if ka := addrManager.book.get(addr); ka != nil {
+		return ka.knownServices()
+	}
+	return nil
+}
+
+// GetAddresses get a random address list To send To peer, stale addresses are excluded and fresh addresses
+// are preferred.
+func (addrManager *AddressManager) GetAddresses() []*message.TimedAddress {
+	return addrManager.getAddresses(nil)
+}
+
+// GetServiceAddresses get a random list of the addresses known To support the service To send To peer.
+func (addrManager *AddressManager) GetServiceAddresses(services config.ServiceFlag) []*message.TimedAddress {
+	return addrManager.getAddresses(func(ka *knownAddress) bool {
+		return ka.servicesKnown && ka.services == services
+	})
+}
+
+// get a random list of the fresh addresses satisfying the filter To send To peer
+func (addrManager *AddressManager) getAddresses(filter func(ka *knownAddress) bool) []*message.TimedAddress {
+	now := time.Now()
+	addrManager.lock.RLock()
+	addrs := make([]*message.TimedAddress, 0, addrManager.book.count())
+	for _, ka := range addrManager.book.all() {
+		if now.Sub(ka.lastSeen) > addrHorizon || (filter != nil && !filter(ka)) {
+			continue
+		}
+		taddr := message.NewTimedAddress(ka.addr, ka.lastSeen)
+		taddr.Services = ka.knownServices()
+		addrs = append(addrs, taddr)
+	}
+	addrManager.lock.RUnlock()
+
+	for i := range addrs {
+		j := rand.Intn(len(addrs)-i) + i
+		addrs[i], addrs[j] = addrs[j], addrs[i]
+	}
+	if len(addrs) <= getAddrMax {
+		return addrs
 	}
+	// addresses seen within the same hour are in random order
+	sort.SliceStable(addrs, func(i, j int) bool {
+		return addrs[i].Timestamp/3600 > addrs[j].Timestamp/3600
+	})
+	return addrs[:getAddrMax]
+}
+
+// AddressInfo is a snapshot of a known address
+type AddressInfo struct {
+	Addr        string              `json:"addr"`
+	Src         string              `json:"src,omitempty"` // the peer who told us the address, empty if observed by ourselves
+	Tried       bool                `json:"tried"`
+	Services    *config.ServiceFlag `json:"services,omitempty"`
+	Attempts    uint32              `json:"attempts"`
+	LastAttempt time.Time           `json:"last_attempt"`
+	LastSuccess time.Time           `json:"last_success"`
+	LastSeen    time.Time           `json:"last_seen"`
+	Added       time.Time           `json:"added"`
+}
+
+// GetAddressInfos get the snapshots of all known addresses, the most recently seen first.
+func (addrManager *AddressManager) GetAddressInfos() []*AddressInfo {
+	addrManager.lock.RLock()
+	records := addrManager.records()
+	addrManager.lock.RUnlock()
+	infos := make([]*AddressInfo, 0, len(records))
+	for _, record := range records {
+		infos = append(infos, &AddressInfo{
+			Addr:        record.Addr,
+			Src:         record.Src,
+			Tried:       record.Tried,
+			Services:    record.Services,
+			Attempts:    record.Attempts,
+			LastAttempt: record.LastAttempt,
+			LastSuccess: record.LastSuccess,
+			LastSeen:    record.LastSeen,
+			Added:       record.Added,
+		})
+	}
+	sort.SliceStable(infos, func(i, j int) bool {
+		return infos[i].LastSeen.After(infos[j].LastSeen)
+	})
+	return infos
 }
 
 // GetAddressCount get address count
 func (addrManager *AddressManager) GetAddressCount() int {
-	count := 0
-	addrManager.addresses.Range(
-		func(key, value interface{}) bool {
-			count++
-			return true
-		},
-	)
-	return count
+	addrManager.lock.RLock()
+	defer addrManager.lock.RUnlock()
+	return addrManager.book.count()
 }
 
-// GetAllAddress get all address
+// GetAllAddress get all address can be connected now
 func (addrManager *AddressManager) GetAllAddress() []*common.NetAddress {
+	addrManager.lock.RLock()
+	defer addrManager.lock.RUnlock()
 	addresses := make([]*common.NetAddress, 0)
-	addrManager.addresses.Range(
-		func(key, value interface{}) bool {
-			addr := value.(*common.NetAddress)
-			if n, t := addrManager.GetAddressAttemptInfo(addr); n < maxAttemptNum && time.Now().Sub(t) > (time.Duration(n)*minimalAttemptInterval) {
-				addresses = append(addresses, addr)
-			}
-			return true
-		},
-	)
+	for _, ka := range addrManager.book.all() {
+		if addrManager.connectable(ka) {
+			addresses = append(addresses, ka.addr)
+		}
+	}
 	return addresses
 }
 
+// check whether the address can be connected now according To its attempt and disconnect info
+func (addrManager *AddressManager) connectable(ka *knownAddress) bool {
+	if inBackoff(ka, time.Now()) {
+		return false
+	}
+	n, _ := addrManager.GetAddressAttemptInfo(ka.addr)
+	return n < maxAttemptNum && !time.Now().Before(addrManager.NextAttemptTime(ka.addr))
+}
+
 // NeedMoreAddrs check whether need more address.
 func (addrManager *AddressManager) NeedMoreAddrs() bool {
 	return addrManager.GetAddressCount() < needAddressThreshold
@@ -184,34 +491,113 @@ func (addrManager *AddressManager) NeedMoreAddrs() bool {
 
 // Save save addresses To file
 func (addrManager *AddressManager) Save() {
+	addrManager.saveLock.Lock()
+	defer addrManager.saveLock.Unlock()
 	addrManager.lock.Lock()
 	if !addrManager.changed {
 		addrManager.lock.Unlock()
 		return
 	}
-	addrManager.lock.Unlock()
 
-	addrStrs := make([]string, 0)
-	addrManager.addresses.Range(
-		func(key, value interface{}) bool {
-			addrStr := key.(string)
-			addrStrs = append(addrStrs, addrStr)
-			return true
-		},
-	)
+	records := addrManager.records()
+	addrManager.changed = false
+	addrManager.lock.Unlock()
 
-	buf, err := json.Marshal(addrStrs)
-	fmt.Println(string(buf))
+	buf, err := encodeAddrBook(addrManager.book.key[:], records)
 	if err != nil {
-		log.Warn("failed To marshal recent addresses, as: %v", err)
+		log.Warn("failed To encode recent addresses, as: %v", err)
+		return
 	}
 
-	err = ioutil.WriteFile(addrManager.filePath, buf, os.ModePerm)
+	err = common.WriteFileAtomic(addrManager.filePath, buf, addrBookFilePerm)
 	if err != nil {
 		log.Warn("failed To write recent addresses To file, as: %v", err)
+		addrManager.markChanged()
 	}
+}
 
-	addrManager.changed = false
+// mark the address book changed, so it will be saved on next sync.
+func (addrManager *AddressManager) markChanged() {
+	addrManager.lock.Lock()
+	addrManager.changed = true
+	addrManager.lock.Unlock()
+}
+
+// records get the persisted records of all addresses, must be called with lock held.
+func (addrManager *AddressManager) records() []*addrRecord {
+	records := make([]*addrRecord, 0, addrManager.book.count())
+	for _, ka := range addrManager.book.all() {
+		record := &addrRecord{
+			Addr:     ka.addr.ToString(),
+			Tried:    ka.tried,
+			LastSeen: ka.lastSeen,
+			Added:    ka.addedTime,
+		}
+		if ka.src != nil && !ka.src.Equal(ka.addr) {
+			record.Src = ka.src.ToString()
+		}
+		if ka.servicesKnown {
+			services := ka.services
+			record.Services = &services
+		}
+		record.Disconnect = ka.disconnect
+		if v, ok := addrManager.addressAttemptInfo.Load(record.Addr); ok {
+			attemptInfo := v.(*AttemptInfo)
+			record.Attempts = atomic.LoadUint32(&attemptInfo.AttemptNum)
+			record.LastAttempt = attemptInfo.LastAttemptTime.Load().(time.Time)
+			record.NextAttempt = attemptInfo.NextAttemptTime.Load().(time.Time)
+			record.LastSuccess = attemptInfo.LastSuccessTime.Load().(time.Time)
+		}
+		records = append(records, record)
+	}
+	return records
+}
+
+// restore the addresses From persisted records
+func (addrManager *AddressManager) restore(records []*addrRecord) {
+	addrManager.lock.Lock()
+	defer addrManager.lock.Unlock()
+	for _, record := range records {
+		addr, err := common.ParseNetAddress(record.Addr)
+		if err != nil {
+			log.Warn("encounter an invalid address %s", record.Addr)
+			continue
+		}
+		var src *common.NetAddress
+		if record.Src != "" {
+			if src, err = common.ParseNetAddress(record.Src); err != nil {
+				src = nil
+			}
+		}
+		lastSeen := record.LastSeen
+		if lastSeen.IsZero() {
+			lastSeen = time.Now()
+		}
+		addrManager.book.add(addr, src, lastSeen)
+		if record.Tried {
+			addrManager.book.good(addr)
+		}
+		ka := addrManager.book.get(addr)
+		if ka == nil {
+			continue
+		}
+		if !record.Added.IsZero() {
+			ka.addedTime = record.Added
+		}
+		if record.Services != nil {
+			ka.services = *record.Services
+			ka.servicesKnown = true
+		}
+		ka.disconnect = record.Disconnect
+		if record.Attempts > 0 || !record.LastSuccess.IsZero() {
+			attemptInfo := newAttemptInfo()
+			attemptInfo.AttemptNum = record.Attempts
+			attemptInfo.LastAttemptTime.Store(record.LastAttempt)
+			attemptInfo.NextAttemptTime.Store(record.NextAttempt)
+			attemptInfo.LastSuccessTime.Store(record.LastSuccess)
+			addrManager.addressAttemptInfo.Store(record.Addr, attemptInfo)
+		}
+	}
 }
 
 // Start start address manager
@@ -219,9 +605,10 @@ func (addrManager *AddressManager) Start() {
 	go addrManager.saveHandler()
 }
 
-// Stop stop address manager
+// Stop stop address manager, the changes since last sync are saved To file.
 func (addrManager *AddressManager) Stop() {
 	close(addrManager.quitChan)
+	addrManager.Save()
 }
 
 // GetAddressAttemptInfo get address attempt info
@@ -234,29 +621,84 @@ func (addrManager *AddressManager) GetAddressAttemptInfo(addr *common.NetAddress
 	}
 }
 
-// UpdateAddressAttemptInfo update address attempt info
-func (addrManager *AddressManager) UpdateAddressAttemptInfo(addr *common.NetAddress) {
-	attemptInfo := &AttemptInfo{
-		AttemptNum: 1,
+// NextAttemptTime get the time before which the address should not be attempted again.
+func (addrManager *AddressManager) NextAttemptTime(addr *common.NetAddress) time.Time {
+	if v, ok := addrManager.addressAttemptInfo.Load(addr.ToString()); ok {
+		return v.(*AttemptInfo).NextAttemptTime.Load().(time.Time)
 	}
-	attemptInfo.LastAttemptTime.Store(time.Now())
-	if v, loaded := addrManager.addressAttemptInfo.LoadOrStore(addr.ToString(), attemptInfo); loaded {
-		attemptInfo := v.(*AttemptInfo)
-		atomic.AddUint32(&attemptInfo.AttemptNum, 1)
-		attemptInfo.LastAttemptTime.Store(time.Now())
+	return time.Time{}
+}
+
+// LastSuccessTime get the time of last successful connection with the address, return zero time if never succeeded.
+func (addrManager *AddressManager) LastSuccessTime(addr *common.NetAddress) time.Time {
+	if v, ok := addrManager.addressAttemptInfo.Load(addr.ToString()); ok {
+		return v.(*AttemptInfo).LastSuccessTime.Load().(time.Time)
 	}
+	return time.Time{}
+}
+
+// UpdateAddressAttemptInfo update address attempt info, the next attempt will be delayed exponentially.
+func (addrManager *AddressManager) UpdateAddressAttemptInfo(addr *common.NetAddress) {
+	v, _ := addrManager.addressAttemptInfo.LoadOrStore(addr.ToString(), newAttemptInfo())
+	attemptInfo := v.(*AttemptInfo)
+	attemptNum := atomic.AddUint32(&attemptInfo.AttemptNum, 1)
+	now := time.Now()
+	attemptInfo.LastAttemptTime.Store(now)
+	attemptInfo.NextAttemptTime.Store(now.Add(addrManager.backoff.delay(attemptNum)))
+	addrManager.markChanged()
 }
 
-// ResetAddressAttemptInfo reset address attempt info
+// ResetAddressAttemptInfo reset address attempt info after a successful connection, and remember the success time.
 func (addrManager *AddressManager) ResetAddressAttemptInfo(addr *common.NetAddress) {
-	addrManager.addressAttemptInfo.Delete(addr.ToString())
+	attemptInfo := newAttemptInfo()
+	attemptInfo.LastSuccessTime.Store(time.Now())
+	addrManager.addressAttemptInfo.Store(addr.ToString(), attemptInfo)
+	addrManager.markChanged()
+}
+
+// RecordDisconnect record the disconnect reason of the address in address book, and back off From it according
+// To the reason. Nothing is recorded if the address is not in address book.
+func (addrManager *AddressManager) RecordDisconnect(addr *common.NetAddress, reason message.DisconnectReason) {
+	addrManager.lock.Lock()
+	defer addrManager.lock.Unlock()
+	ka := addrManager.book.get(addr)
+	if ka == nil {
+		return
+	}
+	now := time.Now()
+	ka.disconnect = &DisconnectInfo{
+		Reason:    reason,
+		Time:      now,
+		RetryTime: now.Add(disconnectBackoff[reason]),
+	}
+	addrManager.changed = true
+}
+
+// GetDisconnectInfo get the last disconnection info of the address, return nil if not exist.
+func (addrManager *AddressManager) GetDisconnectInfo(addr *common.NetAddress) *DisconnectInfo {
+	addrManager.lock.RLock()
+	defer addrManager.lock.RUnlock()
+	if ka := addrManager.book.get(addr); ka != nil {
+		return ka.disconnect
+	}
+	return nil
+}
+
+// check whether we are backing off From the address
+func inBackoff(ka *knownAddress, now time.Time) bool {
+	return ka.disconnect != nil && now.Before(ka.disconnect.RetryTime)
 }
 
 // saveHandler save addresses To file periodically
 func (addrManager *AddressManager) saveHandler() {
 	saveFileTicker := time.NewTicker(syncInterval)
+	compactTicker := time.NewTicker(compactInterval)
+	defer saveFileTicker.Stop()
+	defer compactTicker.Stop()
 	for {
 		select {
+		case <-compactTicker.C:
+			addrManager.compact()
 		case <-saveFileTicker.C:
 			addrManager.Save()
 		case <-addrManager.quitChan:
@@ -265,37 +707,6 @@ func (addrManager *AddressManager) saveHandler() {
 	}
 }
 
-// loadAddress load addresses From file.
-func loadAddress(filePath string) []*common.NetAddress {
-	addrStrs := make([]string, 0)
-	addresses := make([]*common.NetAddress, 0)
-	if _, err := os.Stat(filePath); err != nil {
-		return addresses
-	}
-	buf, err := ioutil.ReadFile(filePath)
-	if err != nil {
-		log.Error("failed To read address book file, as: %v", err)
-		return addresses
-	}
-
-	err = json.Unmarshal(buf, &addrStrs)
-	if err != nil {
-		log.Error("failed To parse address book file, as %v", err)
-		return addresses
-	}
-
-	for _, addrStr := range addrStrs {
-		addr, err := common.ParseNetAddress(addrStr)
-		if err != nil {
-			log.Warn("encounter an invalid address %s", addrStr)
-			continue
-		}
-		addresses = append(addresses, addr)
-	}
-	log.Debug("load %d addresses From file %s", len(addresses), filePath)
-	return addresses
-}
-
 // get all address of our server
 func getLocalAddresses() ([]string, error) {
 	ips := make([]string, 0)
@@ -323,7 +734,7 @@ func getLocalAddresses() ([]string, error) {
 			case *net.IPAddr:
 				ip = v.IP
 			}
-			if ip.To4() == nil || ip.IsLoopback() {
+			if ip == nil || ip.IsLoopback() || ip.IsLinkLocalUnicast() {
 				log.Warn("skip invalid address %s", ip)
 				continue
 			}
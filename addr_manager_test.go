@@ -3,36 +3,39 @@ package p2p
 import (
 	"errors"
 	"github.com/DSiSc/p2p/common"
+	"github.com/DSiSc/p2p/config"
+	"github.com/DSiSc/p2p/message"
 	"github.com/stretchr/testify/assert"
 	"os"
 	"strconv"
+	"sync"
 	"testing"
 	"time"
 )
 
+// mock addresses in different network groups, the first octet avoids private, loopback and multicast ranges.
 func mockNetAddresses(num int) []*common.NetAddress {
-	addrs := make([]*common.NetAddress, 0)
-	for i := 1; i < 255; i++ {
-		for j := 1; j < 255; j++ {
-			for k := 0; k < 255; k++ {
-				for l := 0; l < 255; l++ {
-					addrs = append(addrs,
-						&common.NetAddress{
-							Protocol: "tcp",
-							IP:       strconv.Itoa(i) + "," + strconv.Itoa(j) + "," + strconv.Itoa(k) + "," + strconv.Itoa(l),
-							Port:     8080,
-						})
-					num--
-					if num <= 0 {
-						return addrs
-					}
-				}
-			}
+	addrs := make([]*common.NetAddress, 0, num)
+	for i := 0; i < num; i++ {
+		first := i%98 + 1
+		if first >= 10 {
+			first++
 		}
+		ip := strconv.Itoa(first) + "." + strconv.Itoa(i/98%254+1) + "." + strconv.Itoa(i/98/254%254+1) + ".1"
+		addrs = append(addrs, common.NewNetAddress("tcp", ip, 8080))
 	}
 	return addrs
 }
 
+// mock timed addresses last seen at the time
+func mockTimedAddresses(addrs []*common.NetAddress, lastSeen time.Time) []*message.TimedAddress {
+	taddrs := make([]*message.TimedAddress, 0, len(addrs))
+	for _, addr := range addrs {
+		taddrs = append(taddrs, message.NewTimedAddress(addr, lastSeen))
+	}
+	return taddrs
+}
+
 const addressFile = "address.json"
 
 func TestMain(m *testing.M) {
@@ -93,6 +96,14 @@ func TestAddressManager_AddAddresses(t *testing.T) {
 	assert.Equal(3, addrManger.GetAddressCount())
 }
 
+func TestAddressManager_AddUnresolvedAddress(t *testing.T) {
+	assert := assert.New(t)
+	os.RemoveAll(addressFile)
+	addrManger := NewAddressManager(addressFile)
+	addrManger.AddAddress(common.NewNetAddress("tcp", "seed.example.com", 8080))
+	assert.Equal(0, addrManger.GetAddressCount())
+}
+
 func TestAddressManager_GetAddress(t *testing.T) {
 	assert := assert.New(t)
 	addrManger := NewAddressManager(addressFile)
@@ -116,13 +127,13 @@ func TestAddressManager_GetAddresses(t *testing.T) {
 	addrManger.AddAddresses(addrs)
 	assert.Equal(3, addrManger.GetAddressCount())
 
-	addrs = addrManger.GetAddresses()
-	assert.Equal(3, len(addrs))
+	taddrs := addrManger.GetAddresses()
+	assert.Equal(3, len(taddrs))
 
 	addrs = mockNetAddresses(getAddrMax + 1)
 	addrManger.AddAddresses(addrs)
-	addrs = addrManger.GetAddresses()
-	assert.Equal(getAddrMax, len(addrs))
+	taddrs = addrManger.GetAddresses()
+	assert.Equal(getAddrMax, len(taddrs))
 }
 
 func TestAddressManager_GetAllAddress(t *testing.T) {
@@ -186,6 +197,44 @@ func TestAddressManager_Stop(t *testing.T) {
 	}
 }
 
+func TestAddressManager_StopSave(t *testing.T) {
+	assert := assert.New(t)
+	os.RemoveAll(addressFile)
+	defer os.RemoveAll(addressFile)
+	addrManger := NewAddressManager(addressFile)
+	addrManger.Start()
+	addrs := mockNetAddresses(2)
+	addrManger.AddAddresses(addrs)
+	addrManger.Stop()
+
+	// changes since last sync are saved on stop
+	_, records := loadAddressBook(addressFile)
+	assert.Equal(2, len(records))
+	assert.False(addrManger.changed)
+}
+
+func TestAddressManager_ConcurrentSave(t *testing.T) {
+	assert := assert.New(t)
+	os.RemoveAll(addressFile)
+	defer os.RemoveAll(addressFile)
+	addrManger := NewAddressManager(addressFile)
+	addrs := mockNetAddresses(10)
+	var wg sync.WaitGroup
+	for _, addr := range addrs {
+		wg.Add(1)
+		go func(addr *common.NetAddress) {
+			defer wg.Done()
+			addrManger.AddAddress(addr)
+			addrManger.Save()
+		}(addr)
+	}
+	wg.Wait()
+
+	// the last saving always contains all the addresses
+	_, records := loadAddressBook(addressFile)
+	assert.Equal(len(addrs), len(records))
+}
+
 func TestAddressManager_Save(t *testing.T) {
 	assert := assert.New(t)
 	addrManger := NewAddressManager(addressFile)
@@ -199,9 +248,9 @@ func TestAddressManager_Save(t *testing.T) {
 	addrManger.Save()
 
 	exist := false
-	addrs := loadAddress(addressFile)
-	for _, addr := range addrs {
-		if addr.Equal(address) {
+	_, records := loadAddressBook(addressFile)
+	for _, record := range records {
+		if record.Addr == address.ToString() {
 			exist = true
 			break
 		}
@@ -228,7 +277,232 @@ func TestAddressManager_AddressAttemptInfo(t *testing.T) {
 	assert.NotNil(timeBeforeUpdate.Before(lastAttemptTime))
 	assert.NotNil(time.Now().After(lastAttemptTime))
 
+	assert.True(addrManger.NextAttemptTime(address).After(lastAttemptTime))
+	assert.True(addrManger.LastSuccessTime(address).IsZero())
+
 	addrManger.ResetAddressAttemptInfo(address)
 	attemptNum, _ = addrManger.GetAddressAttemptInfo(address)
 	assert.Equal(uint32(0), attemptNum)
+	assert.False(addrManger.LastSuccessTime(address).IsZero())
+	assert.True(addrManger.NextAttemptTime(address).IsZero())
+}
+
+func TestAddressManager_AttemptBackoff(t *testing.T) {
+	assert := assert.New(t)
+	os.RemoveAll(addressFile)
+	addrManger := NewAddressManager(addressFile)
+	addrManger.backoff = newBackoff(time.Minute, time.Hour, 0.5)
+	addrs := mockNetAddresses(2)
+	addrManger.AddAddresses(addrs)
+	assert.Equal(2, len(addrManger.GetAllAddress()))
+
+	addrManger.UpdateAddressAttemptInfo(addrs[0])
+	assert.Equal(1, len(addrManger.GetAllAddress()))
+	first := addrManger.NextAttemptTime(addrs[0])
+	addrManger.UpdateAddressAttemptInfo(addrs[0])
+	assert.True(addrManger.NextAttemptTime(addrs[0]).Sub(first) > 0)
+
+	addrManger.ResetAddressAttemptInfo(addrs[0])
+	assert.Equal(2, len(addrManger.GetAllAddress()))
+}
+
+func TestAddressManager_RecordDisconnect(t *testing.T) {
+	assert := assert.New(t)
+	os.RemoveAll(addressFile)
+	addrManger := NewAddressManager(addressFile)
+	assert.NotNil(addrManger)
+	addrs := mockNetAddresses(2)
+	addrManger.AddAddresses(addrs)
+	assert.Nil(addrManger.GetDisconnectInfo(addrs[0]))
+
+	// no back off for duplicate connection
+	addrManger.RecordDisconnect(addrs[0], message.ReasonDuplicate)
+	assert.Equal(message.ReasonDuplicate, addrManger.GetDisconnectInfo(addrs[0]).Reason)
+	assert.Equal(2, len(addrManger.GetAllAddress()))
+
+	addrManger.RecordDisconnect(addrs[0], message.ReasonTooManyPeers)
+	info := addrManger.GetDisconnectInfo(addrs[0])
+	assert.Equal(message.ReasonTooManyPeers, info.Reason)
+	assert.Equal(disconnectBackoff[message.ReasonTooManyPeers], info.RetryTime.Sub(info.Time))
+	allAddrs := addrManger.GetAllAddress()
+	assert.Equal(1, len(allAddrs))
+	assert.Equal(addrs[1], allAddrs[0])
+
+	// addresses not in address book are not recorded
+	unknown := common.NewNetAddress("tcp", "9.9.9.9", 8080)
+	addrManger.RecordDisconnect(unknown, message.ReasonTooManyPeers)
+	assert.Nil(addrManger.GetDisconnectInfo(unknown))
+}
+
+func TestAddressManager_Good(t *testing.T) {
+	assert := assert.New(t)
+	os.RemoveAll(addressFile)
+	addrManger := NewAddressManager(addressFile)
+	addrs := mockNetAddresses(2)
+	addrManger.AddTimedAddresses(mockTimedAddresses(addrs, time.Now()), mockAddress())
+	assert.False(addrManger.IsTried(addrs[0]))
+	addrManger.Good(addrs[0])
+	assert.True(addrManger.IsTried(addrs[0]))
+	assert.False(addrManger.IsTried(addrs[1]))
+	assert.Equal(2, addrManger.GetAddressCount())
+}
+
+func TestAddressManager_AddTimedAddresses(t *testing.T) {
+	assert := assert.New(t)
+	os.RemoveAll(addressFile)
+	addrManger := NewAddressManager(addressFile)
+	addrs := mockNetAddresses(4)
+	now := time.Now()
+	taddrs := mockTimedAddresses(addrs, now)
+	taddrs[1].Timestamp = now.Add(-addrHorizon - time.Hour).Unix() // stale
+	taddrs[2].Timestamp = 0                                        // unknown
+	taddrs[3].Timestamp = now.Add(time.Hour).Unix()                // in future
+	services := config.SFNodeBlockSyncer
+	taddrs[0].Services = &services
+	addrManger.AddTimedAddresses(taddrs, mockAddress())
+
+	assert.Equal(3, addrManger.GetAddressCount())
+	assert.Nil(addrManger.book.get(addrs[1]))
+	ka := addrManger.book.get(addrs[0])
+	assert.Equal(now.Add(-relayPenalty).Unix(), ka.lastSeen.Unix())
+	assert.True(ka.servicesKnown)
+	assert.Equal(services, ka.services)
+	assert.True(addrManger.book.get(addrs[2]).lastSeen.Before(now.Add(-legacyAddrAge)))
+	assert.True(addrManger.book.get(addrs[3]).lastSeen.Before(now.Add(-legacyAddrAge)))
+
+	// address told by itself is not penalized
+	addrManger.AddTimedAddresses(mockTimedAddresses(addrs[3:], now), addrs[3])
+	assert.Equal(now.Unix(), addrManger.book.get(addrs[3]).lastSeen.Unix())
+}
+
+func TestAddressManager_Seen(t *testing.T) {
+	assert := assert.New(t)
+	os.RemoveAll(addressFile)
+	addrManger := NewAddressManager(addressFile)
+	addrs := mockNetAddresses(1)
+	lastSeen := time.Now().Add(-time.Hour)
+	addrManger.AddTimedAddresses(mockTimedAddresses(addrs, lastSeen), addrs[0])
+	addrManger.Seen(addrs[0])
+	assert.True(addrManger.book.get(addrs[0]).lastSeen.After(lastSeen))
+}
+
+func TestAddressManager_CompactStale(t *testing.T) {
+	assert := assert.New(t)
+	os.RemoveAll(addressFile)
+	addrManger := NewAddressManager(addressFile)
+	addrs := mockNetAddresses(3)
+	addrManger.AddAddresses(addrs)
+	addrManger.book.get(addrs[0]).lastSeen = time.Now().Add(-addrHorizon - time.Hour)
+	addrManger.book.get(addrs[1]).lastSeen = time.Now().Add(-addrHorizon - time.Hour)
+	addrManger.ResetAddressAttemptInfo(addrs[1])
+
+	// stale address is not relayed
+	assert.Equal(1, len(addrManger.GetAddresses()))
+	addrManger.compact()
+	assert.Equal(2, addrManger.GetAddressCount())
+	assert.Nil(addrManger.book.get(addrs[0]))
+}
+
+func TestAddressManager_GetAddressesPreferFresh(t *testing.T) {
+	assert := assert.New(t)
+	os.RemoveAll(addressFile)
+	addrManger := NewAddressManager(addressFile)
+	addrs := mockNetAddresses(getAddrMax + 10)
+	now := time.Now()
+	addrManger.AddTimedAddresses(mockTimedAddresses(addrs[:10], now.Add(-24*time.Hour)), nil)
+	addrManger.AddAddresses(addrs[10:])
+	for _, taddr := range addrManger.GetAddresses() {
+		assert.True(taddr.Timestamp > now.Add(-time.Hour).Unix())
+	}
+}
+
+func TestAddressManager_GetHealthyAddresses(t *testing.T) {
+	assert := assert.New(t)
+	os.RemoveAll(addressFile)
+	addrManger := NewAddressManager(addressFile)
+	addrs := mockNetAddresses(10)
+	addrManger.AddAddresses(addrs)
+	assert.Equal(0, len(addrManger.GetHealthyAddresses(10, nil)))
+
+	for _, addr := range addrs[:6] {
+		addrManger.ResetAddressAttemptInfo(addr)
+		addrManger.Good(addr)
+	}
+	addrManger.RecordDisconnect(addrs[0], message.ReasonBanned)
+	assert.Equal(5, len(addrManger.GetHealthyAddresses(10, nil)))
+	assert.Equal(3, len(addrManger.GetHealthyAddresses(3, nil)))
+	healthy := addrManger.GetHealthyAddresses(10, func(addr *common.NetAddress, services *config.ServiceFlag) bool {
+		return addr.Equal(addrs[1])
+	})
+	assert.Equal([]*common.NetAddress{addrs[1]}, healthy)
+
+	// services are passed To filter if known
+	addrManger.Connected(addrs[2], config.SFNodeBlockSyncer)
+	healthy = addrManger.GetHealthyAddresses(10, func(addr *common.NetAddress, services *config.ServiceFlag) bool {
+		return services != nil && *services == config.SFNodeBlockSyncer
+	})
+	assert.Equal([]*common.NetAddress{addrs[2]}, healthy)
+}
+
+func TestAddressManager_GetServiceAddresses(t *testing.T) {
+	assert := assert.New(t)
+	os.RemoveAll(addressFile)
+	addrManger := NewAddressManager(addressFile)
+	addrs := mockNetAddresses(3)
+	addrManger.AddAddresses(addrs)
+	addrManger.Connected(addrs[0], config.SFNodeBlockSyncer)
+	addrManger.Connected(addrs[1], config.SFNodeTX)
+
+	syncers := addrManger.GetServiceAddresses(config.SFNodeBlockSyncer)
+	assert.Equal(1, len(syncers))
+	assert.Equal(addrs[0], syncers[0].NetAddress)
+	assert.Equal(config.SFNodeBlockSyncer, *syncers[0].Services)
+	assert.Equal(0, len(addrManger.GetServiceAddresses(config.SFNodeBlockBroadCast)))
+	assert.Equal(3, len(addrManger.GetAddresses()))
+
+	assert.Equal(config.SFNodeTX, *addrManger.GetServices(addrs[1]))
+	assert.Nil(addrManger.GetServices(addrs[2]))
+	assert.Nil(addrManger.GetServices(common.NewNetAddress("tcp", "192.168.2.1", 8080)))
+}
+
+func TestAddressManager_GetAddressWithFilter(t *testing.T) {
+	assert := assert.New(t)
+	os.RemoveAll(addressFile)
+	addrManger := NewAddressManager(addressFile)
+	addrs := mockNetAddresses(3)
+	addrManger.AddAddresses(addrs)
+	addrManger.Connected(addrs[1], config.SFNodeBlockSyncer)
+
+	for i := 0; i < 10; i++ {
+		addr, err := addrManger.GetAddressWithFilter(func(addr *common.NetAddress, services *config.ServiceFlag) bool {
+			return services != nil && *services == config.SFNodeBlockSyncer
+		})
+		assert.Nil(err)
+		assert.Equal(addrs[1], addr)
+	}
+	_, err := addrManger.GetAddressWithFilter(func(addr *common.NetAddress, services *config.ServiceFlag) bool {
+		return false
+	})
+	assert.NotNil(err)
+}
+
+func TestAddressManager_GetAddressInfos(t *testing.T) {
+	assert := assert.New(t)
+	os.RemoveAll(addressFile)
+	addrManger := NewAddressManager(addressFile)
+	addrs := mockNetAddresses(2)
+	addrManger.AddAddresses(addrs)
+	addrManger.UpdateAddressAttemptInfo(addrs[1])
+	addrManger.Good(addrs[1])
+	addrManger.Connected(addrs[1], config.SFNodeBlockSyncer)
+
+	infos := addrManger.GetAddressInfos()
+	assert.Equal(2, len(infos))
+	assert.Equal(addrs[1].ToString(), infos[0].Addr)
+	assert.True(infos[0].Tried)
+	assert.Equal(uint32(1), infos[0].Attempts)
+	assert.Equal(config.SFNodeBlockSyncer, *infos[0].Services)
+	assert.Equal(addrs[0].ToString(), infos[1].Addr)
+	assert.False(infos[1].Tried)
+	assert.Nil(infos[1].Services)
 }
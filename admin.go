@@ -0,0 +1,440 @@
+package p2p
+
+import (
+	"context"
+	"crypto/subtle"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/DSiSc/craft/log"
+	"github.com/DSiSc/p2p/common"
+	"github.com/DSiSc/p2p/config"
+	stCommon "github.com/DSiSc/p2p/tools/common"
+	"github.com/gorilla/mux"
+	"mime"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	adminShutdownTimeout = 5 * time.Second
+	defaultAdminAddrNum  = 100 // default num of addresses returned by address book query
+	adminTrafficBuffer   = 256 // buffer size of the traffic stream, records are dropped when it's full
+	adminSuccess         = "SUCCESS"
+)
+
+// The admin server exposes the status of p2p layer and the runtime peer management actions as JSON over http:
+//
+//	GET    /node                              node info
+//	GET    /peers                             peer list
+//	POST   /peers                             connect To a peer, body: {"addr": "tcp://ip:port", "persistent": false}
+//	DELETE /peers?addr=tcp://ip:port          disconnect a peer
+//	GET    /persistent                        persistent peers
+//	DELETE /persistent?addr=tcp://ip:port     remove a persistent peer
+//	GET    /addresses?tried=&service=&limit=  address book query
+//	GET    /bans                              ban list
+//	POST   /bans                              ban an ip, body: {"addr": "ip", "duration": "24h", "reason": ""}
+//	DELETE /bans?addr=ip                      lift a ban
+//	GET    /traffic?peer=&type=               stream the live messages as JSON lines
+//
+// The requests must carry header "Authorization: Bearer <token>" if a token is configured, otherwise the server
+// refuses To listen on non-loopback addresses.
+
+// AdminServer is the embedded admin http server of p2p
+type AdminServer struct {
+	p2p      *P2P
+	addr     string
+	token    string
+	server   *http.Server
+	listener net.Listener
+	quitChan chan struct{} // closed when stopping, so the streams are ended
+}
+
+// body of connect request
+type adminConnectRequest struct {
+	Addr       string `json:"addr"`
+	Persistent bool   `json:"persistent"`
+}
+
+// body of ban request
+type adminBanRequest struct {
+	Addr     string `json:"addr"`
+	Duration string `json:"duration,omitempty"` // ban duration, e.g. "1h30m"(default 24h)
+	Reason   string `json:"reason,omitempty"`
+}
+
+// NewAdminServer create an admin server instance, return error if no token is given for a non-loopback address.
+func NewAdminServer(p2p *P2P, addr, token string) (*AdminServer, error) {
+	if token == "" && !isLoopbackListenAddr(addr) {
+		return nil, fmt.Errorf("admin server must listen on loopback address without token, got %s", addr)
+	}
+	server := &AdminServer{
+		p2p:      p2p,
+		addr:     addr,
+		token:    token,
+		quitChan: make(chan struct{}),
+	}
+	server.server = &http.Server{Handler: server.handler()}
+	return server, nil
+}
+
+// start the admin server configured
+func (service *P2P) startAdmin() error {
+	admin, err := NewAdminServer(service, service.config.AdminAddr, service.config.AdminToken)
+	if err != nil {
+		return err
+	}
+	if err := admin.Start(); err != nil {
+		return err
+	}
+	service.admin = admin
+	return nil
+}
+
+// check whether the listen address only accepts local connections
+func isLoopbackListenAddr(addr string) bool {
+	host, _, err := net.SplitHostPort(addr)
+	if err != nil {
+		return false
+	}
+	return isLoopbackHost(host)
+}
+
+// check whether the host is localhost or a loopback ip
+func isLoopbackHost(host string) bool {
+	if host == "localhost" {
+		return true
+	}
+	ip := net.ParseIP(strings.Trim(host, "[]"))
+	return ip != nil && ip.IsLoopback()
+}
+
+// Start start listening and serving
+func (server *AdminServer) Start() error {
+	listener, err := net.Listen("tcp", server.addr)
+	if err != nil {
+		return err
+	}
+	server.listener = listener
+	log.Info("admin server listening on %s", listener.Addr().String())
+	go func() {
+		if err := server.server.Serve(listener); err != nil && err != http.ErrServerClosed {
+			log.Error("admin server stopped, as: %v", err)
+		}
+	}()
+	return nil
+}
+
+// Stop stop the server, the requests being served are waited for a while.
+func (server *AdminServer) Stop() {
+	close(server.quitChan)
+	ctx, cancel := context.WithTimeout(context.Background(), adminShutdownTimeout)
+	defer cancel()
+	if err := server.server.Shutdown(ctx); err != nil {
+		log.Warn("failed To shut down admin server gracefully, as: %v", err)
+	}
+}
+
+// Addr get the address the server is listening on, return nil if not started.
+func (server *AdminServer) Addr() net.Addr {
+	if server.listener == nil {
+		return nil
+	}
+	return server.listener.Addr()
+}
+
+// the routes of the server
+func (server *AdminServer) handler() http.Handler {
+	router := mux.NewRouter()
+	router.Use(server.rejectCrossSite)
+	router.Use(server.authenticate)
+	router.HandleFunc("/node", server.getNode).Methods("GET")
+	router.HandleFunc("/peers", server.getPeers).Methods("GET")
+	router.HandleFunc("/peers", server.connectPeer).Methods("POST")
+	router.HandleFunc("/peers", server.disconnectPeer).Methods("DELETE")
+	router.HandleFunc("/persistent", server.getPersistentPeers).Methods("GET")
+	router.HandleFunc("/persistent", server.removePersistentPeer).Methods("DELETE")
+	router.HandleFunc("/addresses", server.getAddresses).Methods("GET")
+	router.HandleFunc("/bans", server.getBans).Methods("GET")
+	router.HandleFunc("/bans", server.banPeer).Methods("POST")
+	router.HandleFunc("/bans", server.unbanPeer).Methods("DELETE")
+	router.HandleFunc("/traffic", server.streamTraffic).Methods("GET")
+	return router
+}
+
+// reject the requests may be sent by web pages in operator's browser, as the admin server without token is
+// protected by nothing but the loopback address. Browsers always send Origin header with cross-site requests
+// except simple GET, the POST requests must be JSON which can't be sent by a simple form, and the Host header
+// must be loopback without token, so that a DNS rebinding page can't reach us by its own domain.
+func (server *AdminServer) rejectCrossSite(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Origin") != "" {
+			adminError(w, http.StatusForbidden, errors.New("cross-site request is not allowed"))
+			return
+		}
+		if r.Method == "POST" {
+			if mediaType, _, err := mime.ParseMediaType(r.Header.Get("Content-Type")); err != nil || mediaType != "application/json" {
+				adminError(w, http.StatusUnsupportedMediaType, errors.New("content type must be application/json"))
+				return
+			}
+		}
+		if server.token == "" {
+			host, _, err := net.SplitHostPort(r.Host)
+			if err != nil {
+				host = r.Host
+			}
+			if !isLoopbackHost(host) {
+				adminError(w, http.StatusForbidden, fmt.Errorf("host %s is not allowed without token", r.Host))
+				return
+			}
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// check the bearer token of the request
+func (server *AdminServer) authenticate(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if server.token != "" {
+			token := strings.TrimPrefix(r.Header.Get("Authorization"), "Bearer ")
+			if subtle.ConstantTimeCompare([]byte(token), []byte(server.token)) != 1 {
+				adminError(w, http.StatusUnauthorized, errors.New("invalid admin token"))
+				return
+			}
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// getNode response the node info
+func (server *AdminServer) getNode(w http.ResponseWriter, r *http.Request) {
+	adminResponse(w, server.p2p.GetNodeInfo())
+}
+
+// getPeers response the peer list, including the pending ones
+func (server *AdminServer) getPeers(w http.ResponseWriter, r *http.Request) {
+	adminResponse(w, server.p2p.GetPeerInfo())
+}
+
+// connectPeer connect To a peer once, or add it as persistent peer
+func (server *AdminServer) connectPeer(w http.ResponseWriter, r *http.Request) {
+	req := &adminConnectRequest{}
+	if err := json.NewDecoder(r.Body).Decode(req); err != nil {
+		adminError(w, http.StatusBadRequest, err)
+		return
+	}
+	addr, err := parseAdminAddr(req.Addr)
+	if err != nil {
+		adminError(w, http.StatusBadRequest, err)
+		return
+	}
+	if req.Persistent {
+		err = server.p2p.AddPersistentPeer(addr)
+	} else {
+		err = server.p2p.DialPeer(addr)
+	}
+	if err != nil {
+		adminError(w, http.StatusBadRequest, err)
+		return
+	}
+	adminResponse(w, adminSuccess)
+}
+
+// disconnectPeer disconnect the peer in query
+func (server *AdminServer) disconnectPeer(w http.ResponseWriter, r *http.Request) {
+	addr, err := parseAdminAddr(r.URL.Query().Get("addr"))
+	if err == nil {
+		err = server.p2p.DisconnectPeer(addr)
+	}
+	if err != nil {
+		adminError(w, http.StatusBadRequest, err)
+		return
+	}
+	adminResponse(w, adminSuccess)
+}
+
+// getPersistentPeers response the persistent peers
+func (server *AdminServer) getPersistentPeers(w http.ResponseWriter, r *http.Request) {
+	addrs := make([]string, 0)
+	for _, addr := range server.p2p.PersistentPeers() {
+		addrs = append(addrs, addr.ToString())
+	}
+	adminResponse(w, addrs)
+}
+
+// removePersistentPeer remove the persistent peer in query
+func (server *AdminServer) removePersistentPeer(w http.ResponseWriter, r *http.Request) {
+	addr, err := parseAdminAddr(r.URL.Query().Get("addr"))
+	if err == nil {
+		err = server.p2p.RemovePersistentPeer(addr)
+	}
+	if err != nil {
+		adminError(w, http.StatusBadRequest, err)
+		return
+	}
+	adminResponse(w, adminSuccess)
+}
+
+// getAddresses response the addresses in book, filtered by query "tried"(true|false), "service" and "limit".
+func (server *AdminServer) getAddresses(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+	limit := defaultAdminAddrNum
+	if v := query.Get("limit"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n <= 0 {
+			adminError(w, http.StatusBadRequest, fmt.Errorf("invalid limit %s", v))
+			return
+		}
+		limit = n
+	}
+	filters := make([]func(info *AddressInfo) bool, 0)
+	if v := query.Get("tried"); v != "" {
+		tried, err := strconv.ParseBool(v)
+		if err != nil {
+			adminError(w, http.StatusBadRequest, fmt.Errorf("invalid tried %s", v))
+			return
+		}
+		filters = append(filters, func(info *AddressInfo) bool { return info.Tried == tried })
+	}
+	if v := query.Get("service"); v != "" {
+		service, err := strconv.ParseUint(v, 10, 64)
+		if err != nil {
+			adminError(w, http.StatusBadRequest, fmt.Errorf("invalid service %s", v))
+			return
+		}
+		filters = append(filters, func(info *AddressInfo) bool {
+			return info.Services != nil && *info.Services == config.ServiceFlag(service)
+		})
+	}
+
+	infos := make([]*AddressInfo, 0)
+	for _, info := range server.p2p.addrManager.GetAddressInfos() {
+		if len(infos) >= limit {
+			break
+		}
+		matched := true
+		for _, filter := range filters {
+			matched = matched && filter(info)
+		}
+		if matched {
+			infos = append(infos, info)
+		}
+	}
+	adminResponse(w, infos)
+}
+
+// getBans response the ban list
+func (server *AdminServer) getBans(w http.ResponseWriter, r *http.Request) {
+	adminResponse(w, server.p2p.BannedPeers())
+}
+
+// banPeer ban the ip in request
+func (server *AdminServer) banPeer(w http.ResponseWriter, r *http.Request) {
+	req := &adminBanRequest{}
+	if err := json.NewDecoder(r.Body).Decode(req); err != nil {
+		adminError(w, http.StatusBadRequest, err)
+		return
+	}
+	addr, err := parseAdminAddr(req.Addr)
+	if err != nil {
+		adminError(w, http.StatusBadRequest, err)
+		return
+	}
+	var duration time.Duration
+	if req.Duration != "" {
+		if duration, err = time.ParseDuration(req.Duration); err != nil {
+			adminError(w, http.StatusBadRequest, err)
+			return
+		}
+	}
+	if err := server.p2p.BanPeer(addr, duration, req.Reason); err != nil {
+		adminError(w, http.StatusBadRequest, err)
+		return
+	}
+	adminResponse(w, adminSuccess)
+}
+
+// unbanPeer lift the ban of the ip in query
+func (server *AdminServer) unbanPeer(w http.ResponseWriter, r *http.Request) {
+	addr, err := parseAdminAddr(r.URL.Query().Get("addr"))
+	if err == nil {
+		err = server.p2p.UnbanPeer(addr)
+	}
+	if err != nil {
+		adminError(w, http.StatusBadRequest, err)
+		return
+	}
+	adminResponse(w, adminSuccess)
+}
+
+// streamTraffic stream the live messages as JSON lines until the client goes away, filtered by query "peer"
+// and "type"(message type name).
+func (server *AdminServer) streamTraffic(w http.ResponseWriter, r *http.Request) {
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		adminError(w, http.StatusInternalServerError, errors.New("streaming is not supported"))
+		return
+	}
+	var peerFilter string
+	if v := r.URL.Query().Get("peer"); v != "" {
+		addr, err := parseAdminAddr(v)
+		if err != nil {
+			adminError(w, http.StatusBadRequest, err)
+			return
+		}
+		peerFilter = addr.ToString()
+	}
+	typeFilter := r.URL.Query().Get("type")
+
+	records, cancel := server.p2p.SubscribeTraffic(adminTrafficBuffer)
+	defer cancel()
+	w.Header().Set("Content-Type", "application/x-ndjson")
+	w.WriteHeader(http.StatusOK)
+	flusher.Flush()
+	encoder := json.NewEncoder(w)
+	for {
+		select {
+		case record := <-records:
+			if (peerFilter != "" && record.Peer != peerFilter) || (typeFilter != "" && record.TypeName != typeFilter) {
+				continue
+			}
+			if err := encoder.Encode(record); err != nil {
+				return
+			}
+			flusher.Flush()
+		case <-r.Context().Done():
+			return
+		case <-server.quitChan:
+			return
+		}
+	}
+}
+
+// parse the address in admin request, which is a net address or a bare ip.
+func parseAdminAddr(addrStr string) (*common.NetAddress, error) {
+	if addrStr == "" {
+		return nil, errors.New("address is not specified")
+	}
+	if ip := net.ParseIP(addrStr); ip != nil {
+		return common.NewNetAddress("tcp", ip.String(), 0), nil
+	}
+	return common.ParseNetAddress(addrStr)
+}
+
+// write the value as JSON response
+func adminResponse(w http.ResponseWriter, value interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(value); err != nil {
+		log.Debug("failed To write admin response, as: %v", err)
+	}
+}
+
+// write the error as JSON response
+func adminError(w http.ResponseWriter, status int, err error) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(stCommon.NewResponse(err))
+}
@@ -0,0 +1,259 @@
+package p2p
+
+import (
+	"encoding/json"
+	"github.com/DSiSc/p2p/common"
+	"github.com/DSiSc/p2p/config"
+	"github.com/DSiSc/p2p/message"
+	"github.com/stretchr/testify/assert"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+// serve the admin request and return the response
+func adminRequest(handler http.Handler, method, url, body, token string) *httptest.ResponseRecorder {
+	req := httptest.NewRequest(method, url, strings.NewReader(body))
+	req.Host = "127.0.0.1:8081"
+	if body != "" {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	if token != "" {
+		req.Header.Set("Authorization", "Bearer "+token)
+	}
+	recorder := httptest.NewRecorder()
+	handler.ServeHTTP(recorder, req)
+	return recorder
+}
+
+func TestIsLoopbackListenAddr(t *testing.T) {
+	assert := assert.New(t)
+	assert.True(isLoopbackListenAddr("127.0.0.1:8081"))
+	assert.True(isLoopbackListenAddr("[::1]:8081"))
+	assert.True(isLoopbackListenAddr("localhost:8081"))
+	assert.False(isLoopbackListenAddr(":8081"))
+	assert.False(isLoopbackListenAddr("0.0.0.0:8081"))
+	assert.False(isLoopbackListenAddr("192.168.1.1:8081"))
+	assert.False(isLoopbackListenAddr("invalid"))
+}
+
+func TestParseAdminAddr(t *testing.T) {
+	assert := assert.New(t)
+	addr, err := parseAdminAddr("192.168.1.1")
+	assert.Nil(err)
+	assert.Equal(common.NewNetAddress("tcp", "192.168.1.1", 0), addr)
+	addr, err = parseAdminAddr("tcp://192.168.1.1:8080")
+	assert.Nil(err)
+	assert.Equal(common.NewNetAddress("tcp", "192.168.1.1", 8080), addr)
+	_, err = parseAdminAddr("")
+	assert.NotNil(err)
+	_, err = parseAdminAddr("192.168.1.1:port")
+	assert.NotNil(err)
+}
+
+func TestNewAdminServer(t *testing.T) {
+	assert := assert.New(t)
+	p2p, err := NewP2P(mockConfig(), nil)
+	assert.Nil(err)
+	_, err = NewAdminServer(p2p, "0.0.0.0:0", "")
+	assert.NotNil(err)
+	_, err = NewAdminServer(p2p, "0.0.0.0:0", "secret")
+	assert.Nil(err)
+
+	server, err := NewAdminServer(p2p, "127.0.0.1:0", "")
+	assert.Nil(err)
+	assert.Nil(server.Addr())
+	assert.Nil(server.Start())
+	defer server.Stop()
+	resp, err := http.Get("http://" + server.Addr().String() + "/node")
+	assert.Nil(err)
+	defer resp.Body.Close()
+	info := &NodeInfo{}
+	assert.Nil(json.NewDecoder(resp.Body).Decode(info))
+	assert.Equal(p2p.addr.ToString(), info.ListenAddr)
+}
+
+func TestAdminServer_Authenticate(t *testing.T) {
+	assert := assert.New(t)
+	p2p, err := NewP2P(mockConfig(), nil)
+	assert.Nil(err)
+	server, err := NewAdminServer(p2p, "0.0.0.0:0", "secret")
+	assert.Nil(err)
+	handler := server.handler()
+	assert.Equal(http.StatusUnauthorized, adminRequest(handler, "GET", "/node", "", "").Code)
+	assert.Equal(http.StatusUnauthorized, adminRequest(handler, "GET", "/node", "", "wrong").Code)
+	assert.Equal(http.StatusOK, adminRequest(handler, "GET", "/node", "", "secret").Code)
+}
+
+func TestAdminServer_Peers(t *testing.T) {
+	assert := assert.New(t)
+	p2p, err := NewP2P(mockConfig(), nil)
+	assert.Nil(err)
+	server, err := NewAdminServer(p2p, "127.0.0.1:0", "")
+	assert.Nil(err)
+	handler := server.handler()
+
+	addr := common.NewNetAddress("tcp", "192.168.1.1", 8080)
+	peer := NewOutboundPeer(mockServerInfo(), addr, false, make(chan *InternalMsg))
+	assert.Nil(p2p.peers.add(addr, peer))
+	resp := adminRequest(handler, "GET", "/peers", "", "")
+	assert.Equal(http.StatusOK, resp.Code)
+	peers := make([]*PeerInfo, 0)
+	assert.Nil(json.NewDecoder(resp.Body).Decode(&peers))
+	assert.Equal(1, len(peers))
+	assert.Equal(addr.ToString(), peers[0].Addr)
+	assert.Equal(PeerDialing.String(), peers[0].Status)
+	assert.True(peers[0].OutBound)
+
+	// disconnect
+	assert.Equal(http.StatusOK, adminRequest(handler, "DELETE", "/peers?addr=tcp://192.168.1.1:8080", "", "").Code)
+	assert.False(p2p.containsPeer(addr))
+	assert.Equal(http.StatusBadRequest, adminRequest(handler, "DELETE", "/peers?addr=tcp://192.168.1.1:8080", "", "").Code)
+
+	// connect
+	assert.Equal(http.StatusBadRequest, adminRequest(handler, "POST", "/peers", `{"addr": "tcp://192.168.1.2:8080"}`, "").Code)
+	assert.Equal(http.StatusBadRequest, adminRequest(handler, "POST", "/peers", `{"addr": `, "").Code)
+	assert.Equal(http.StatusOK, adminRequest(handler, "POST", "/peers", `{"addr": "tcp://192.168.1.2:8080", "persistent": true}`, "").Code)
+	assert.Equal([]*common.NetAddress{common.NewNetAddress("tcp", "192.168.1.2", 8080)}, p2p.PersistentPeers())
+
+	// persistent peers
+	resp = adminRequest(handler, "GET", "/persistent", "", "")
+	assert.Equal("[\"tcp://192.168.1.2:8080\"]\n", resp.Body.String())
+	assert.Equal(http.StatusOK, adminRequest(handler, "DELETE", "/persistent?addr=tcp://192.168.1.2:8080", "", "").Code)
+	assert.Equal(0, len(p2p.PersistentPeers()))
+}
+
+func TestAdminServer_Addresses(t *testing.T) {
+	assert := assert.New(t)
+	p2p, err := NewP2P(mockConfig(), nil)
+	assert.Nil(err)
+	server, err := NewAdminServer(p2p, "127.0.0.1:0", "")
+	assert.Nil(err)
+	handler := server.handler()
+
+	addrs := mockNetAddresses(3)
+	p2p.addrManager.AddAddresses(addrs)
+	p2p.addrManager.Good(addrs[0])
+	p2p.addrManager.Connected(addrs[0], config.SFNodeBlockSyncer)
+
+	query := func(url string) []*AddressInfo {
+		resp := adminRequest(handler, "GET", url, "", "")
+		assert.Equal(http.StatusOK, resp.Code)
+		infos := make([]*AddressInfo, 0)
+		assert.Nil(json.NewDecoder(resp.Body).Decode(&infos))
+		return infos
+	}
+	assert.Equal(3, len(query("/addresses")))
+	assert.Equal(2, len(query("/addresses?limit=2")))
+	assert.Equal(2, len(query("/addresses?tried=false")))
+	infos := query("/addresses?tried=true&service=2")
+	assert.Equal(1, len(infos))
+	assert.Equal(addrs[0].ToString(), infos[0].Addr)
+	assert.Equal(0, len(query("/addresses?service=1")))
+	assert.Equal(http.StatusBadRequest, adminRequest(handler, "GET", "/addresses?limit=0", "", "").Code)
+	assert.Equal(http.StatusBadRequest, adminRequest(handler, "GET", "/addresses?tried=maybe", "", "").Code)
+	assert.Equal(http.StatusBadRequest, adminRequest(handler, "GET", "/addresses?service=x", "", "").Code)
+}
+
+func TestAdminServer_Bans(t *testing.T) {
+	assert := assert.New(t)
+	p2p, err := NewP2P(mockConfig(), nil)
+	assert.Nil(err)
+	server, err := NewAdminServer(p2p, "127.0.0.1:0", "")
+	assert.Nil(err)
+	handler := server.handler()
+
+	assert.Equal(http.StatusBadRequest, adminRequest(handler, "POST", "/bans", `{"addr": "192.168.1.1", "duration": "1y"}`, "").Code)
+	assert.Equal(http.StatusBadRequest, adminRequest(handler, "POST", "/bans", `{"addr": "example.com:8080"}`, "").Code)
+	assert.Equal(http.StatusOK, adminRequest(handler, "POST", "/bans", `{"addr": "192.168.1.1", "duration": "1h", "reason": "spam"}`, "").Code)
+	resp := adminRequest(handler, "GET", "/bans", "", "")
+	bans := make([]*BanInfo, 0)
+	assert.Nil(json.NewDecoder(resp.Body).Decode(&bans))
+	assert.Equal(1, len(bans))
+	assert.Equal("192.168.1.1", bans[0].IP)
+	assert.Equal("spam", bans[0].Reason)
+
+	assert.Equal(http.StatusOK, adminRequest(handler, "DELETE", "/bans?addr=192.168.1.1", "", "").Code)
+	assert.Equal(http.StatusBadRequest, adminRequest(handler, "DELETE", "/bans?addr=192.168.1.1", "", "").Code)
+	assert.Equal(0, len(p2p.BannedPeers()))
+}
+
+func TestAdminServer_Traffic(t *testing.T) {
+	assert := assert.New(t)
+	p2p, err := NewP2P(mockConfig(), nil)
+	assert.Nil(err)
+	server, err := NewAdminServer(p2p, "127.0.0.1:0", "")
+	assert.Nil(err)
+	assert.Nil(server.Start())
+	defer server.Stop()
+
+	resp, err := http.Get("http://" + server.Addr().String() + "/traffic?type=pong&peer=tcp://192.168.1.1:8080")
+	assert.Nil(err)
+	defer resp.Body.Close()
+	assert.Equal(http.StatusOK, resp.StatusCode)
+	addr := common.NewNetAddress("tcp", "192.168.1.1", 8080)
+	p2p.traffic.publish(addr, TrafficOut, &message.PingMsg{})
+	p2p.traffic.publish(common.NewNetAddress("tcp", "192.168.1.2", 8080), TrafficIn, &message.PongMsg{})
+	p2p.traffic.publish(addr, TrafficIn, &message.PongMsg{})
+	record := &TrafficRecord{}
+	assert.Nil(json.NewDecoder(resp.Body).Decode(record))
+	assert.Equal(addr.ToString(), record.Peer)
+	assert.Equal(TrafficIn, record.Direction)
+	assert.Equal("pong", record.TypeName)
+
+	assert.Equal(http.StatusBadRequest, adminRequest(server.handler(), "GET", "/traffic?peer=invalid", "", "").Code)
+}
+
+func TestAdminServer_RejectCrossSite(t *testing.T) {
+	assert := assert.New(t)
+	p2p, err := NewP2P(mockConfig(), nil)
+	assert.Nil(err)
+	server, err := NewAdminServer(p2p, "127.0.0.1:0", "")
+	assert.Nil(err)
+	handler := server.handler()
+	body := `{"addr": "tcp://192.168.1.1:8080", "persistent": true}`
+
+	// simple form post of a web page
+	req := httptest.NewRequest("POST", "/peers", strings.NewReader(body))
+	req.Host = "127.0.0.1:8081"
+	req.Header.Set("Content-Type", "text/plain")
+	recorder := httptest.NewRecorder()
+	handler.ServeHTTP(recorder, req)
+	assert.Equal(http.StatusUnsupportedMediaType, recorder.Code)
+
+	// cross-site request with origin
+	req = httptest.NewRequest("POST", "/bans", strings.NewReader(`{"addr": "192.168.1.1"}`))
+	req.Host = "127.0.0.1:8081"
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("Origin", "http://example.com")
+	recorder = httptest.NewRecorder()
+	handler.ServeHTTP(recorder, req)
+	assert.Equal(http.StatusForbidden, recorder.Code)
+
+	// dns rebinding
+	req = httptest.NewRequest("GET", "/peers", nil)
+	req.Host = "attacker.example.com:8081"
+	recorder = httptest.NewRecorder()
+	handler.ServeHTTP(recorder, req)
+	assert.Equal(http.StatusForbidden, recorder.Code)
+
+	assert.Equal(0, len(p2p.PersistentPeers()))
+	assert.Equal(0, len(p2p.BannedPeers()))
+	for _, host := range []string{"localhost:8081", "[::1]:8081", "127.0.0.1"} {
+		req = httptest.NewRequest("GET", "/peers", nil)
+		req.Host = host
+		recorder = httptest.NewRecorder()
+		handler.ServeHTTP(recorder, req)
+		assert.Equal(http.StatusOK, recorder.Code, host)
+	}
+
+	// any host is allowed with token
+	server, err = NewAdminServer(p2p, "0.0.0.0:0", "secret")
+	assert.Nil(err)
+	req = httptest.NewRequest("GET", "/peers", nil)
+	req.Header.Set("Authorization", "Bearer secret")
+	recorder = httptest.NewRecorder()
+	server.handler().ServeHTTP(recorder, req)
+	assert.Equal(http.StatusOK, recorder.Code)
+}
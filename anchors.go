@@ -0,0 +1,151 @@
+package p2p
+
+import (
+	"encoding/json"
+	"github.com/DSiSc/craft/log"
+	"github.com/DSiSc/p2p/common"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"time"
+)
+
+const (
+	// max num of anchors, a few anchors are enough To keep us in the honest network, more anchors only
+	// make it harder To recover if some of them are malicious.
+	maxAnchors      = 2
+	anchorsFileName = "anchors.json" // anchors file is in the same directory as address book file
+	// max time waiting for the anchors To be connected before the other peers are selected, long enough for
+	// dialing and handshaking
+	anchorsConnectTimeout = 4 * HANDSHAKE_TIMEOUT * time.Second
+)
+
+// Anchors are the healthy outbound peers we had at shutdown. They are reconnected first on next start, so
+// an attacker can't eclipse us simply by making us restart and pick all the peers From a polluted book.
+
+// get the anchors file path, return empty string if address book is not persisted.
+func anchorsFilePath(addrBookPath string) string {
+	if addrBookPath == "" {
+		return ""
+	}
+	return filepath.Join(filepath.Dir(addrBookPath), anchorsFileName)
+}
+
+// save the anchors To file
+func saveAnchors(filePath string, anchors []*common.NetAddress) error {
+	addrStrs := make([]string, 0, len(anchors))
+	for _, anchor := range anchors {
+		addrStrs = append(addrStrs, anchor.ToString())
+	}
+	buf, err := json.Marshal(addrStrs)
+	if err != nil {
+		return err
+	}
+	return common.WriteFileAtomic(filePath, buf, addrBookFilePerm)
+}
+
+// load the anchors From file, the file is removed after loading, so we won't be stuck with bad anchors if
+// we crash before saving new ones.
+func loadAnchors(filePath string) []*common.NetAddress {
+	anchors := make([]*common.NetAddress, 0)
+	buf, err := ioutil.ReadFile(filePath)
+	if err != nil {
+		if !os.IsNotExist(err) {
+			log.Warn("failed To read anchors file, as: %v", err)
+		}
+		return anchors
+	}
+	if err := os.Remove(filePath); err != nil {
+		log.Warn("failed To remove anchors file, as: %v", err)
+	}
+
+	addrStrs := make([]string, 0)
+	if err := json.Unmarshal(buf, &addrStrs); err != nil {
+		log.Warn("anchors file is corrupted, as: %v", err)
+		return anchors
+	}
+	for _, addrStr := range addrStrs {
+		addr, err := common.ParseNetAddress(addrStr)
+		if err != nil || !addr.IsValid() {
+			continue
+		}
+		anchors = append(anchors, addr)
+		if len(anchors) >= maxAnchors {
+			break
+		}
+	}
+	return anchors
+}
+
+// select the anchors From current peers: active non-persistent outbound peers, the longest-lived first.
+// Persistent peers are excluded as they will be reconnected anyway.
+func (service *P2P) selectAnchors() []*common.NetAddress {
+	candidates := service.peers.list(func(peer *Peer) bool {
+		return activeFilter(peer) && peer.IsOutBound() && !peer.IsPersistent()
+	})
+	sort.Slice(candidates, func(i, j int) bool {
+		return candidates[i].ActiveTime().Before(candidates[j].ActiveTime())
+	})
+	anchors := make([]*common.NetAddress, 0, maxAnchors)
+	for _, peer := range candidates {
+		if len(anchors) >= maxAnchors {
+			break
+		}
+		anchors = append(anchors, peer.GetAddr())
+	}
+	return anchors
+}
+
+// persist current anchors before shutdown
+func (service *P2P) persistAnchors() {
+	if service.anchorsPath == "" {
+		return
+	}
+	anchors := service.selectAnchors()
+	if len(anchors) == 0 {
+		return
+	}
+	if err := saveAnchors(service.anchorsPath, anchors); err != nil {
+		log.Warn("failed To save anchors, as: %v", err)
+		return
+	}
+	log.Info("save %d anchors", len(anchors))
+}
+
+// connect To the anchors saved in last run, and wait until they are connected or failed(at most
+// anchorsConnectTimeout), so they take the outbound slots before the other peers.
+func (service *P2P) connectAnchors() {
+	if service.anchorsPath == "" {
+		return
+	}
+	var wg sync.WaitGroup
+	for _, anchor := range loadAnchors(service.anchorsPath) {
+		if service.addrManager.IsOurAddress(anchor) || service.containsPeer(anchor) {
+			continue
+		}
+		log.Info("start connecting To anchor %s", anchor.ToString())
+		service.addrManager.AddAddress(anchor)
+		peer := NewOutboundPeer(&service.PeerCom, anchor, false, service.internalChan)
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			service.connectPeer(peer)
+		}()
+	}
+
+	done := make(chan struct{})
+	go func() {
+		wg.Wait()
+		close(done)
+	}()
+	timer := time.NewTimer(anchorsConnectTimeout)
+	defer timer.Stop()
+	select {
+	case <-done:
+	case <-timer.C:
+		log.Warn("anchors are not connected in %v, continue To connect To other peers", anchorsConnectTimeout)
+	case <-service.quitChan:
+	}
+}
@@ -0,0 +1,105 @@
+package p2p
+
+import (
+	"github.com/DSiSc/p2p/common"
+	"github.com/stretchr/testify/assert"
+	"io/ioutil"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestAnchorsFilePath(t *testing.T) {
+	assert := assert.New(t)
+	assert.Equal("", anchorsFilePath(""))
+	assert.Equal(filepath.Join("data", anchorsFileName), anchorsFilePath(filepath.Join("data", "address.json")))
+}
+
+func TestSaveLoadAnchors(t *testing.T) {
+	assert := assert.New(t)
+	dir, err := ioutil.TempDir("", "anchors")
+	assert.Nil(err)
+	defer os.RemoveAll(dir)
+	filePath := filepath.Join(dir, anchorsFileName)
+
+	assert.Equal(0, len(loadAnchors(filePath)))
+	anchors := []*common.NetAddress{
+		common.NewNetAddress("tcp", "192.168.1.1", 8080),
+		common.NewNetAddress("tcp", "2001:db8::1", 8080),
+	}
+	assert.Nil(saveAnchors(filePath, anchors))
+	assert.Equal(anchors, loadAnchors(filePath))
+
+	// anchors file is removed after loading
+	_, err = os.Stat(filePath)
+	assert.True(os.IsNotExist(err))
+
+	// corrupted file
+	assert.Nil(ioutil.WriteFile(filePath, []byte(`["tcp://192.168.1.1:8080",`), 0600))
+	assert.Equal(0, len(loadAnchors(filePath)))
+}
+
+func TestP2P_SelectAnchors(t *testing.T) {
+	assert := assert.New(t)
+	p2p, err := NewP2P(mockConfig(), nil)
+	assert.Nil(err)
+
+	now := time.Now()
+	addPeer := func(ip string, outBound, persistent bool, activeTime time.Time) {
+		addr := common.NewNetAddress("tcp", ip, 8080)
+		var peer *Peer
+		if outBound {
+			peer = NewOutboundPeer(mockServerInfo(), addr, persistent, make(chan *InternalMsg))
+			peer.transit(PeerHandshaking)
+		} else {
+			peer = NewInboundPeer(mockServerInfo(), addr, make(chan *InternalMsg), newTestConn())
+		}
+		assert.Nil(p2p.peers.add(addr, peer))
+		assert.Nil(p2p.peers.activate(peer))
+		peer.activeTime.Store(activeTime)
+	}
+	addPeer("192.168.1.1", true, false, now)
+	addPeer("192.168.1.2", true, false, now.Add(-2*time.Hour))
+	addPeer("192.168.1.3", true, false, now.Add(-time.Hour))
+	addPeer("192.168.1.4", true, true, now.Add(-3*time.Hour))
+	addPeer("192.168.1.5", false, false, now.Add(-3*time.Hour))
+	pending := NewOutboundPeer(mockServerInfo(), common.NewNetAddress("tcp", "192.168.1.6", 8080), false, make(chan *InternalMsg))
+	assert.Nil(p2p.peers.add(pending.GetAddr(), pending))
+
+	assert.Equal([]*common.NetAddress{
+		common.NewNetAddress("tcp", "192.168.1.2", 8080),
+		common.NewNetAddress("tcp", "192.168.1.3", 8080),
+	}, p2p.selectAnchors())
+}
+
+func TestP2P_ConnectAnchors(t *testing.T) {
+	assert := assert.New(t)
+	dir, err := ioutil.TempDir("", "anchors")
+	assert.Nil(err)
+	defer os.RemoveAll(dir)
+	p2p, err := NewP2P(mockConfig(), nil)
+	assert.Nil(err)
+	p2p.anchorsPath = filepath.Join(dir, anchorsFileName)
+
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	assert.Nil(err)
+	defer listener.Close()
+	go func() {
+		conn, err := listener.Accept()
+		if err != nil {
+			return
+		}
+		connAddr, _ := common.ParseNetAddress(conn.RemoteAddr().String())
+		peer := NewInboundPeer(mockServerInfo(), connAddr, make(chan *InternalMsg, 10), conn)
+		peer.Start()
+	}()
+	anchor, _ := common.ParseNetAddress(listener.Addr().String())
+	assert.Nil(saveAnchors(p2p.anchorsPath, []*common.NetAddress{anchor}))
+
+	// the anchor is connected before returning, so it takes an outbound slot before the other peers
+	p2p.connectAnchors()
+	assert.Equal(1, p2p.GetOutBountPeersCount())
+	assert.NotNil(p2p.peers.getActive(anchor))
+}
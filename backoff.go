@@ -0,0 +1,57 @@
+package p2p
+
+import (
+	"math/rand"
+	"time"
+)
+
+const (
+	defaultBackoffBase   = 5 * time.Second
+	defaultBackoffMax    = 30 * time.Minute
+	defaultBackoffJitter = 0.5
+)
+
+// backoff compute the exponential reconnect interval with jitter, the interval of n-th retry is
+// base * 2^(n-1) capped by max, and reduced by a random fraction(at most jitter) of it, so peers
+// reconnecting at the same time won't retry synchronously.
+type backoff struct {
+	base   time.Duration
+	max    time.Duration
+	jitter float64
+}
+
+// newBackoff create a backoff instance, zero value parameters will be set To default.
+func newBackoff(base, max time.Duration, jitter float64) *backoff {
+	if base <= 0 {
+		base = defaultBackoffBase
+	}
+	if max <= 0 {
+		max = defaultBackoffMax
+	}
+	if max < base {
+		max = base
+	}
+	if jitter <= 0 || jitter > 1 {
+		jitter = defaultBackoffJitter
+	}
+	return &backoff{
+		base:   base,
+		max:    max,
+		jitter: jitter,
+	}
+}
+
+// delay get the interval before the n-th retry, the first retry is 1.
+func (b *backoff) delay(n uint32) time.Duration {
+	if n == 0 {
+		return 0
+	}
+	interval := b.base
+	for i := uint32(1); i < n && interval < b.max; i++ {
+		interval *= 2
+	}
+	if interval > b.max {
+		interval = b.max
+	}
+	return interval - time.Duration(rand.Float64()*b.jitter*float64(interval))
+}
@@ -0,0 +1,32 @@
+package p2p
+
+import (
+	"github.com/stretchr/testify/assert"
+	"testing"
+	"time"
+)
+
+func TestNewBackoff(t *testing.T) {
+	assert := assert.New(t)
+	b := newBackoff(0, 0, 0)
+	assert.Equal(defaultBackoffBase, b.base)
+	assert.Equal(defaultBackoffMax, b.max)
+	assert.Equal(defaultBackoffJitter, b.jitter)
+
+	b = newBackoff(time.Minute, time.Second, 0.1)
+	assert.Equal(time.Minute, b.max)
+}
+
+func TestBackoff_Delay(t *testing.T) {
+	assert := assert.New(t)
+	b := newBackoff(time.Second, time.Minute, 0.5)
+	assert.Equal(time.Duration(0), b.delay(0))
+	for i := 0; i < 100; i++ {
+		delay := b.delay(1)
+		assert.True(delay > 500*time.Millisecond && delay <= time.Second)
+		delay = b.delay(4)
+		assert.True(delay > 4*time.Second && delay <= 8*time.Second)
+		delay = b.delay(1000)
+		assert.True(delay > 30*time.Second && delay <= time.Minute)
+	}
+}
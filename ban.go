@@ -0,0 +1,88 @@
+package p2p
+
+import (
+	"net"
+	"sort"
+	"sync"
+	"time"
+)
+
+const defaultBanDuration = 24 * time.Hour
+
+// BanInfo is a banned ip, neither inbound nor outbound connections with it are allowed until the ban expires.
+type BanInfo struct {
+	IP      string    `json:"ip"`
+	Reason  string    `json:"reason,omitempty"`
+	Created time.Time `json:"created"`
+	Until   time.Time `json:"until"`
+}
+
+// banList records the banned ips in memory
+type banList struct {
+	bans sync.Map // ip string -> *BanInfo
+}
+
+// create an empty ban list
+func newBanList() *banList {
+	return &banList{}
+}
+
+// ban the ip for the duration(default 24h)
+func (list *banList) ban(ip net.IP, duration time.Duration, reason string) *BanInfo {
+	if duration <= 0 {
+		duration = defaultBanDuration
+	}
+	now := time.Now()
+	info := &BanInfo{
+		IP:      ip.String(),
+		Reason:  reason,
+		Created: now,
+		Until:   now.Add(duration),
+	}
+	list.bans.Store(info.IP, info)
+	return info
+}
+
+// lift the ban of the ip, return false if it's not banned.
+func (list *banList) unban(ip net.IP) bool {
+	if !list.isBanned(ip) {
+		return false
+	}
+	list.bans.Delete(ip.String())
+	return true
+}
+
+// check whether the ip is banned, the expired ban is removed.
+func (list *banList) isBanned(ip net.IP) bool {
+	if ip == nil {
+		return false
+	}
+	v, ok := list.bans.Load(ip.String())
+	if !ok {
+		return false
+	}
+	if time.Now().After(v.(*BanInfo).Until) {
+		list.bans.Delete(ip.String())
+		return false
+	}
+	return true
+}
+
+// get the bans not expired, the earliest first.
+func (list *banList) list() []*BanInfo {
+	now := time.Now()
+	bans := make([]*BanInfo, 0)
+	list.bans.Range(func(key, value interface{}) bool {
+		info := value.(*BanInfo)
+		if now.After(info.Until) {
+			list.bans.Delete(key)
+		} else {
+			bans = append(bans, info)
+		}
+		return true
+	})
+	sort.Slice(bans, func(i, j int) bool {
+		return bans[i].Created.Before(bans[j].Created)
+	})
+	return bans
+}
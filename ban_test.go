@@ -0,0 +1,35 @@
+package p2p
+
+import (
+	"github.com/stretchr/testify/assert"
+	"net"
+	"testing"
+	"time"
+)
+
+func TestBanList(t *testing.T) {
+	assert := assert.New(t)
+	list := newBanList()
+	ip := net.ParseIP("192.168.1.1")
+	assert.False(list.isBanned(ip))
+	assert.False(list.isBanned(nil))
+	assert.False(list.unban(ip))
+
+	info := list.ban(ip, 0, "spam")
+	assert.Equal("192.168.1.1", info.IP)
+	assert.Equal("spam", info.Reason)
+	assert.Equal(defaultBanDuration, info.Until.Sub(info.Created))
+	assert.True(list.isBanned(ip))
+	assert.True(list.isBanned(net.ParseIP("::ffff:192.168.1.1")))
+	assert.Equal([]*BanInfo{info}, list.list())
+
+	assert.True(list.unban(ip))
+	assert.False(list.isBanned(ip))
+	assert.Equal(0, len(list.list()))
+
+	// expired ban
+	list.ban(ip, time.Millisecond, "")
+	time.Sleep(5 * time.Millisecond)
+	assert.False(list.isBanned(ip))
+	assert.Equal(0, len(list.list()))
+}
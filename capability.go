@@ -0,0 +1,151 @@
+package p2p
+
+import (
+	"fmt"
+	"github.com/DSiSc/craft/log"
+	"github.com/DSiSc/p2p/common"
+	"github.com/DSiSc/p2p/config"
+	"github.com/DSiSc/p2p/message"
+	"math/rand"
+	"sort"
+)
+
+const (
+	// num of address picks per missing peer when filling a service quota
+	quotaAttemptsPerPeer = 10
+	// max share of the inbound slots for the peers of other services, which are accepted only as they claim
+	// To need ours
+	servingInboundRatio = 4
+)
+
+// Service quotas keep a minimum num of outbound peers supporting the needed services, e.g. at least two block
+// syncers. The quota peers are dialed before the others in each round of outbound selection, even if max
+// outbound is reached, so the outbound peers may exceed MaxConnOutBound by at most the sum of the quotas.
+
+// get the services accepted besides ours, i.e. the services having quota
+func acceptedServices(quotas map[config.ServiceFlag]int) map[config.ServiceFlag]bool {
+	accepted := make(map[config.ServiceFlag]bool)
+	for service, quota := range quotas {
+		if quota > 0 {
+			accepted[service] = true
+		}
+	}
+	return accepted
+}
+
+// check whether the address may support a compatible service, the address with unknown services is compatible.
+func (service *P2P) compatibleAddr(addr *common.NetAddress, services *config.ServiceFlag) bool {
+	return services == nil || service.compatible(*services)
+}
+
+// get the num of outbound peers supporting the service, the services of pending peers are what we heard From
+// address book.
+func (service *P2P) outboundServiceCount(flag config.ServiceFlag) int {
+	peers := service.peers.list(func(peer *Peer) bool {
+		return peer.IsOutBound()
+	})
+	count := 0
+	for _, peer := range peers {
+		if peer.Status() == PeerActive {
+			if peer.GetService() == flag {
+				count++
+			}
+		} else if services := service.addrManager.GetServices(peer.GetAddr()); services != nil && *services == flag {
+			count++
+		}
+	}
+	return count
+}
+
+// get the num of outbound peers still needed for each service short of peers
+func (service *P2P) unmetQuotas() map[config.ServiceFlag]int {
+	unmet := make(map[config.ServiceFlag]int)
+	for flag, quota := range service.config.ServiceQuotas {
+		if missing := quota - service.outboundServiceCount(flag); missing > 0 {
+			unmet[flag] = missing
+		}
+	}
+	return unmet
+}
+
+// max num of inbound peers of other services accepted as they need ours
+func (service *P2P) maxServingInbound() int {
+	if max := service.config.MaxConnInBound / servingInboundRatio; max > 0 {
+		return max
+	}
+	return 1
+}
+
+// check whether the peer can be activated by its service. An inbound peer whose service is not compatible with
+// us is accepted by its claim of needing ours, which can't be verified, so only a few inbound slots are given
+// To such peers.
+func (service *P2P) admitService(peer *Peer) error {
+	if peer.IsOutBound() || service.compatible(peer.GetService()) {
+		return nil
+	}
+	serving := service.peers.count(func(p *Peer) bool {
+		return p != peer && p.Status() == PeerActive && !p.IsOutBound() && !service.compatible(p.GetService())
+	})
+	if serving >= service.maxServingInbound() {
+		return newDisconnectError(message.ReasonTooManyPeers, fmt.Errorf("too many inbound peers of other services(%d)", serving))
+	}
+	return nil
+}
+
+// select the addresses known To support the services short of peers, at most the num still needed per service.
+func (service *P2P) quotaAddrs() []*common.NetAddress {
+	addrs := make([]*common.NetAddress, 0)
+	selected := make(map[string]bool)
+	for flag, missing := range service.unmetQuotas() {
+		log.Debug("need %d more outbound peers of service %d", missing, flag)
+		filter := func(addr *common.NetAddress, services *config.ServiceFlag) bool {
+			return services != nil && *services == flag
+		}
+		for i := 0; i < missing*quotaAttemptsPerPeer && missing > 0; i++ {
+			addr, err := service.addrManager.GetAddressWithFilter(filter)
+			if err != nil {
+				break
+			}
+			if selected[addr.ToString()] || service.containsPeer(addr) || service.checkOutbound(addr) != nil {
+				continue
+			}
+			selected[addr.ToString()] = true
+			addrs = append(addrs, addr)
+			missing--
+		}
+	}
+	return addrs
+}
+
+// connect To the addresses known To support the services short of peers, return the num of peers dialed.
+func (service *P2P) connectQuotaPeers() int {
+	addrs := service.quotaAddrs()
+	for _, addr := range addrs {
+		log.Info("start connecting To quota peer %s", addr.ToString())
+		service.addrManager.UpdateAddressAttemptInfo(addr)
+		peer := NewOutboundPeer(&service.PeerCom, addr, false, service.internalChan)
+		go service.connectPeer(peer)
+	}
+	return len(addrs)
+}
+
+// ask a random peer for the addresses of each service short of peers
+func (service *P2P) requestServiceAddrs() {
+	unmet := service.unmetQuotas()
+	if len(unmet) == 0 {
+		return
+	}
+	peers := service.GetPeers()
+	if len(peers) == 0 {
+		return
+	}
+	flags := make([]config.ServiceFlag, 0, len(unmet))
+	for flag := range unmet {
+		flags = append(flags, flag)
+	}
+	sort.Slice(flags, func(i, j int) bool { return flags[i] < flags[j] })
+	for _, flag := range flags {
+		services := flag
+		service.sendMsgAsync(peers[rand.Intn(len(peers))], &message.AddrReq{Services: &services})
+	}
+}
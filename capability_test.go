@@ -0,0 +1,208 @@
+package p2p
+
+import (
+	"github.com/DSiSc/p2p/common"
+	"github.com/DSiSc/p2p/config"
+	"github.com/DSiSc/p2p/message"
+	"github.com/DSiSc/p2p/version"
+	"github.com/stretchr/testify/assert"
+	"net"
+	"testing"
+)
+
+func TestAcceptedServices(t *testing.T) {
+	assert := assert.New(t)
+	accepted := acceptedServices(map[config.ServiceFlag]int{
+		config.SFNodeBlockSyncer:    2,
+		config.SFNodeBlockBroadCast: 0,
+	})
+	assert.Equal(map[config.ServiceFlag]bool{config.SFNodeBlockSyncer: true}, accepted)
+}
+
+func TestPeerCom_Compatible(t *testing.T) {
+	assert := assert.New(t)
+	com := &PeerCom{
+		service:  config.SFNodeTX,
+		accepted: map[config.ServiceFlag]bool{config.SFNodeBlockSyncer: true},
+	}
+	assert.True(com.compatible(config.SFNodeTX))
+	assert.True(com.compatible(config.SFNodeBlockSyncer))
+	assert.False(com.compatible(config.SFNodeBlockBroadCast))
+	assert.True(mockServerInfo().compatible(config.SFNodeTX))
+	assert.False(mockServerInfo().compatible(config.SFNodeBlockSyncer))
+}
+
+func TestPeerCom_CompatibleVersion(t *testing.T) {
+	assert := assert.New(t)
+	syncer := &PeerCom{service: config.SFNodeBlockSyncer}
+	assert.True(syncer.compatibleVersion(&message.Version{Service: config.SFNodeBlockSyncer}))
+	assert.False(syncer.compatibleVersion(&message.Version{Service: config.SFNodeTX}))
+	assert.True(syncer.compatibleVersion(&message.Version{
+		Service: config.SFNodeTX,
+		Accepts: []config.ServiceFlag{config.SFNodeBlockBroadCast, config.SFNodeBlockSyncer},
+	}))
+
+	com := &PeerCom{accepted: acceptedServices(map[config.ServiceFlag]int{
+		config.SFNodeBlockSyncer:    2,
+		config.SFNodeBlockBroadCast: 1,
+	})}
+	assert.Equal([]config.ServiceFlag{config.SFNodeBlockBroadCast, config.SFNodeBlockSyncer}, com.acceptedList())
+	assert.Equal(0, len(syncer.acceptedList()))
+}
+
+func TestPeer_HandshakeWithQuotaService(t *testing.T) {
+	assert := assert.New(t)
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	assert.Nil(err)
+	defer listener.Close()
+	syncerInfo := mockServerInfo()
+	syncerInfo.service = config.SFNodeBlockSyncer
+	started := make(chan error, 1)
+	go func() {
+		conn, err := listener.Accept()
+		if err != nil {
+			started <- err
+			return
+		}
+		connAddr, _ := common.ParseNetAddress(conn.RemoteAddr().String())
+		peer := NewInboundPeer(syncerInfo, connAddr, make(chan *InternalMsg, 10), conn)
+		started <- peer.Start()
+	}()
+
+	// a tx node needing block syncers is accepted by a stock block syncer
+	txInfo := mockServerInfo()
+	txInfo.accepted = acceptedServices(map[config.ServiceFlag]int{config.SFNodeBlockSyncer: 2})
+	addr, _ := common.ParseNetAddress(listener.Addr().String())
+	peer := NewOutboundPeer(txInfo, addr, false, make(chan *InternalMsg, 10))
+	assert.Nil(peer.Start())
+	defer peer.Stop()
+	assert.Nil(<-started)
+	assert.Equal(config.SFNodeBlockSyncer, peer.GetService())
+}
+
+func TestP2P_AdmitService(t *testing.T) {
+	assert := assert.New(t)
+	conf := mockConfig()
+	conf.MaxConnInBound = 8
+	p2p, err := NewP2P(conf, nil)
+	assert.Nil(err)
+	assert.Equal(2, p2p.maxServingInbound())
+
+	newInbound := func(ip string, service config.ServiceFlag) *Peer {
+		peer := NewInboundPeer(mockServerInfo(), common.NewNetAddress("tcp", ip, 8080), make(chan *InternalMsg), newTestConn())
+		peer.service = service
+		assert.Nil(p2p.peers.add(peer.GetAddr(), peer))
+		return peer
+	}
+	// inbound peers of our service are not limited
+	for _, ip := range []string{"192.168.1.1", "192.168.1.2", "192.168.1.3"} {
+		peer := newInbound(ip, config.SFNodeTX)
+		assert.Nil(p2p.admitService(peer))
+		assert.Nil(p2p.peers.activate(peer))
+	}
+	// inbound peers of other services take at most the serving slots
+	for _, ip := range []string{"192.168.1.4", "192.168.1.5"} {
+		peer := newInbound(ip, config.SFNodeBlockSyncer)
+		assert.Nil(p2p.admitService(peer))
+		assert.Nil(p2p.peers.activate(peer))
+	}
+	peer := newInbound("192.168.1.6", config.SFNodeBlockSyncer)
+	assert.Equal(message.ReasonTooManyPeers, disconnectReason(p2p.admitService(peer)))
+
+	// outbound peer is not limited
+	outbound := NewOutboundPeer(mockServerInfo(), common.NewNetAddress("tcp", "192.168.1.7", 8080), false, make(chan *InternalMsg))
+	outbound.service = config.SFNodeBlockSyncer
+	assert.Nil(p2p.admitService(outbound))
+}
+
+func TestP2P_OnVersionWithQuota(t *testing.T) {
+	assert := assert.New(t)
+	conf := mockConfig()
+	conf.ServiceQuotas = map[config.ServiceFlag]int{config.SFNodeBlockSyncer: 2}
+	p2p, err := NewP2P(conf, nil)
+	assert.Nil(err)
+	assert.Nil(p2p.onVersion(&message.Version{Version: version.Version, Service: config.SFNodeBlockSyncer}))
+	assert.NotNil(p2p.onVersion(&message.Version{Version: version.Version, Service: config.SFNodeBlockBroadCast}))
+}
+
+func TestP2P_CompatibleAddr(t *testing.T) {
+	assert := assert.New(t)
+	p2p, err := NewP2P(mockConfig(), nil)
+	assert.Nil(err)
+	addr := common.NewNetAddress("tcp", "192.168.1.1", 8080)
+	tx, syncer := config.SFNodeTX, config.SFNodeBlockSyncer
+	assert.True(p2p.compatibleAddr(addr, nil))
+	assert.True(p2p.compatibleAddr(addr, &tx))
+	assert.False(p2p.compatibleAddr(addr, &syncer))
+}
+
+func TestP2P_OutboundServiceCount(t *testing.T) {
+	assert := assert.New(t)
+	conf := mockConfig()
+	conf.ServiceQuotas = map[config.ServiceFlag]int{config.SFNodeBlockSyncer: 2}
+	p2p, err := NewP2P(conf, nil)
+	assert.Nil(err)
+
+	// an active syncer
+	active := NewOutboundPeer(mockServerInfo(), common.NewNetAddress("tcp", "192.168.1.1", 8080), false, make(chan *InternalMsg))
+	active.transit(PeerHandshaking)
+	active.service = config.SFNodeBlockSyncer
+	assert.Nil(p2p.peers.add(active.GetAddr(), active))
+	assert.Nil(p2p.peers.activate(active))
+	// a pending peer known as syncer in address book
+	pending := NewOutboundPeer(mockServerInfo(), common.NewNetAddress("tcp", "192.168.1.2", 8080), false, make(chan *InternalMsg))
+	p2p.addrManager.AddAddress(pending.GetAddr())
+	p2p.addrManager.Connected(pending.GetAddr(), config.SFNodeBlockSyncer)
+	assert.Nil(p2p.peers.add(pending.GetAddr(), pending))
+	// an inbound syncer is not counted
+	inbound := NewInboundPeer(mockServerInfo(), common.NewNetAddress("tcp", "192.168.1.3", 8080), make(chan *InternalMsg), newTestConn())
+	inbound.service = config.SFNodeBlockSyncer
+	assert.Nil(p2p.peers.add(inbound.GetAddr(), inbound))
+	assert.Nil(p2p.peers.activate(inbound))
+
+	assert.Equal(2, p2p.outboundServiceCount(config.SFNodeBlockSyncer))
+	assert.Equal(0, p2p.outboundServiceCount(config.SFNodeTX))
+	assert.Equal(0, len(p2p.unmetQuotas()))
+
+	p2p.peers.remove(active)
+	assert.Equal(map[config.ServiceFlag]int{config.SFNodeBlockSyncer: 1}, p2p.unmetQuotas())
+}
+
+func TestP2P_ConnectQuotaPeers(t *testing.T) {
+	assert := assert.New(t)
+	conf := mockConfig()
+	conf.ServiceQuotas = map[config.ServiceFlag]int{config.SFNodeBlockSyncer: 2}
+	p2p, err := NewP2P(conf, nil)
+	assert.Nil(err)
+	assert.Equal(0, p2p.connectQuotaPeers())
+
+	addrs := make([]*common.NetAddress, 0)
+	for _, ip := range []string{"192.168.1.1", "192.168.1.2", "192.168.1.3", "192.168.1.4"} {
+		addr := common.NewNetAddress("tcp", ip, 8080)
+		p2p.addrManager.AddAddress(addr)
+		addrs = append(addrs, addr)
+	}
+	p2p.addrManager.Connected(addrs[0], config.SFNodeBlockSyncer)
+	p2p.addrManager.Connected(addrs[1], config.SFNodeBlockSyncer)
+	p2p.addrManager.Connected(addrs[2], config.SFNodeBlockSyncer)
+	p2p.addrManager.Connected(addrs[3], config.SFNodeTX)
+
+	// distinct block syncers are selected
+	selected := p2p.quotaAddrs()
+	assert.Equal(2, len(selected))
+	assert.NotEqual(selected[0].ToString(), selected[1].ToString())
+	for _, addr := range selected {
+		assert.NotEqual(addrs[3].ToString(), addr.ToString())
+	}
+
+	// the addresses already connecting are skipped
+	assert.Nil(p2p.peers.add(addrs[0], NewOutboundPeer(mockServerInfo(), addrs[0], false, make(chan *InternalMsg))))
+	selected = p2p.quotaAddrs()
+	assert.Equal(1, len(selected))
+	assert.NotEqual(addrs[0].ToString(), selected[0].ToString())
+	assert.NotEqual(addrs[3].ToString(), selected[0].ToString())
+
+	assert.Nil(p2p.peers.add(addrs[1], NewOutboundPeer(mockServerInfo(), addrs[1], false, make(chan *InternalMsg))))
+	assert.Equal(0, len(p2p.quotaAddrs()))
+	assert.Equal(0, p2p.connectQuotaPeers())
+}
@@ -0,0 +1,36 @@
+package common
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+)
+
+// WriteFileAtomic write data To a temp file in the same directory and rename it To the target file, so the
+// target file will never be left partially written.
+func WriteFileAtomic(filename string, data []byte, perm os.FileMode) error {
+	dir, name := filepath.Split(filename)
+	if dir == "" {
+		dir = "."
+	}
+	tmpFile, err := ioutil.TempFile(dir, "."+name+".tmp")
+	if err != nil {
+		return err
+	}
+	tmpName := tmpFile.Name()
+	defer os.Remove(tmpName)
+
+	if _, err = tmpFile.Write(data); err == nil {
+		err = tmpFile.Sync()
+	}
+	if closeErr := tmpFile.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return err
+	}
+	if err = os.Chmod(tmpName, perm); err != nil {
+		return err
+	}
+	return os.Rename(tmpName, filename)
+}
@@ -0,0 +1,31 @@
+package common
+
+import (
+	"github.com/stretchr/testify/assert"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestWriteFileAtomic(t *testing.T) {
+	assert := assert.New(t)
+	dir, err := ioutil.TempDir("", "p2p")
+	assert.Nil(err)
+	defer os.RemoveAll(dir)
+
+	filename := filepath.Join(dir, "address.json")
+	assert.Nil(WriteFileAtomic(filename, []byte("hello"), 0600))
+	assert.Nil(WriteFileAtomic(filename, []byte("world"), 0600))
+	data, err := ioutil.ReadFile(filename)
+	assert.Nil(err)
+	assert.Equal("world", string(data))
+	info, err := os.Stat(filename)
+	assert.Nil(err)
+	assert.Equal(os.FileMode(0600), info.Mode().Perm())
+
+	// no temp file left
+	files, err := ioutil.ReadDir(dir)
+	assert.Nil(err)
+	assert.Equal(1, len(files))
+}
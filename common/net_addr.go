@@ -1,17 +1,64 @@
 package common
 
 import (
+	"fmt"
 	"github.com/DSiSc/craft/log"
 	"net"
-	"regexp"
 	"strconv"
 	"strings"
 )
 
-const (
-	loopBackAddressPattern = "^tcp://127(\\.[0-9]){3}:[0-9]{1,}$"
+// private network blocks(RFC1918 and RFC4193)
+var privateBlocks = parseCIDRs(
+	"10.0.0.0/8",
+	"172.16.0.0/12",
+	"192.168.0.0/16",
+	"100.64.0.0/10", // carrier-grade NAT
+	"fc00::/7",
 )
 
+// blocks which are never an address of peer: "this network"(RFC1122) and reserved for future use(RFC1112)
+var invalidBlocks = parseCIDRs(
+	"0.0.0.0/8",
+	"240.0.0.0/4",
+)
+
+// special purpose blocks which are not routed in public network: documentation(RFC5737 and RFC3849) and
+// benchmarking(RFC2544)
+var reservedBlocks = parseCIDRs(
+	"192.0.2.0/24",
+	"198.51.100.0/24",
+	"203.0.113.0/24",
+	"198.18.0.0/15",
+	"2001:db8::/32",
+)
+
+// lookupIP look up the ips of a host, it's a variable so that it can be replaced in test.
+var lookupIP = net.LookupIP
+
+// parse the CIDR blocks
+func parseCIDRs(cidrs ...string) []*net.IPNet {
+	blocks := make([]*net.IPNet, 0, len(cidrs))
+	for _, cidr := range cidrs {
+		_, block, err := net.ParseCIDR(cidr)
+		if err != nil {
+			panic(err)
+		}
+		blocks = append(blocks, block)
+	}
+	return blocks
+}
+
+// check whether the ip is in any of the blocks
+func inBlocks(ip net.IP, blocks []*net.IPNet) bool {
+	for _, block := range blocks {
+		if block.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
 // NetAddress network address
 type NetAddress struct {
 	Protocol string
@@ -55,19 +102,108 @@ func ParseNetAddress(addrStr string) (*NetAddress, error) {
 		return nil, err
 	}
 
+	// use the canonical form of ip, so the same ip always has the same string
+	if ip := net.ParseIP(host); ip != nil {
+		host = ip.String()
+	}
 	return NewNetAddress(proto, host, int32(port)), nil
 }
 
 //ToString encode netaddress to string
 func (addr *NetAddress) ToString() string {
-	return addr.Protocol + "://" + addr.IP + ":" + strconv.Itoa(int(addr.Port))
+	return addr.Protocol + "://" + addr.HostPort()
+}
+
+// HostPort get the "host:port" form of the address, ipv6 address is enclosed in square brackets.
+func (addr *NetAddress) HostPort() string {
+	return net.JoinHostPort(addr.IP, strconv.Itoa(int(addr.Port)))
+}
+
+// ParsedIP get the ip of the address, return nil if the address is not an ip address.
+func (addr *NetAddress) ParsedIP() net.IP {
+	return net.ParseIP(addr.IP)
+}
+
+// IsHostname reports whether the address is a host name which need To be resolved before dialing.
+func (addr *NetAddress) IsHostname() bool {
+	return addr.IP != "" && addr.ParsedIP() == nil
+}
+
+// Resolve resolve the address To ip addresses. A host name may have multiple A/AAAA records, all of them
+// are returned in the order given by resolver. An ip address is returned as it is.
+func (addr *NetAddress) Resolve() ([]*NetAddress, error) {
+	if !addr.IsHostname() {
+		return []*NetAddress{addr}, nil
+	}
+	ips, err := lookupIP(addr.IP)
+	if err != nil {
+		return nil, err
+	}
+	addrs := make([]*NetAddress, 0, len(ips))
+	seen := make(map[string]bool)
+	for _, ip := range ips {
+		if seen[ip.String()] {
+			continue
+		}
+		seen[ip.String()] = true
+		addrs = append(addrs, NewNetAddress(addr.Protocol, ip.String(), addr.Port))
+	}
+	if len(addrs) == 0 {
+		return nil, fmt.Errorf("no ip address found for host %s", addr.IP)
+	}
+	return addrs, nil
+}
+
+// IsIPv6 reports whether the address is an ipv6 address.
+func (addr *NetAddress) IsIPv6() bool {
+	ip := addr.ParsedIP()
+	return ip != nil && ip.To4() == nil
+}
+
+// IsValid reports whether the address is a sane peer address: a specified unicast ip with a valid port.
+func (addr *NetAddress) IsValid() bool {
+	ip := addr.ParsedIP()
+	if ip == nil || addr.Port <= 0 || addr.Port > 65535 {
+		return false
+	}
+	return !ip.IsUnspecified() && !ip.IsMulticast() && !inBlocks(ip, invalidBlocks)
+}
+
+// IsLinkLocal reports whether ip is a link local address.
+func (addr *NetAddress) IsLinkLocal() bool {
+	ip := addr.ParsedIP()
+	return ip != nil && (ip.IsLinkLocalUnicast() || ip.IsLinkLocalMulticast())
 }
 
 // IsLoopback reports whether ip is a loopback address.
 func (addr *NetAddress) IsLoopback() bool {
-	matched, err := regexp.Match(loopBackAddressPattern, []byte(addr.ToString()))
-	if err != nil {
-		log.Warn("address %s match local address error %v", err)
+	ip := addr.ParsedIP()
+	return ip != nil && ip.IsLoopback()
+}
+
+// IsPrivate reports whether ip is in private network.
+func (addr *NetAddress) IsPrivate() bool {
+	ip := addr.ParsedIP()
+	return ip != nil && inBlocks(ip, privateBlocks)
+}
+
+// IsRoutable reports whether ip is reachable From public network.
+func (addr *NetAddress) IsRoutable() bool {
+	if !addr.IsValid() || addr.IsLoopback() || addr.IsLinkLocal() {
+		return false
+	}
+	return !addr.IsPrivate() && !inBlocks(addr.ParsedIP(), reservedBlocks)
+}
+
+// GroupKey get the network group of the address, which is the /16 prefix of an ipv4 address and the /32 prefix
+// of an ipv6 address. Addresses in the same group are likely controlled by the same operator.
+func (addr *NetAddress) GroupKey() string {
+	ip := net.ParseIP(addr.IP)
+	if ip == nil {
+		return addr.IP
+	}
+	if ip4 := ip.To4(); ip4 != nil {
+		return ip4.Mask(net.CIDRMask(16, 32)).String()
 	}
-	return matched
+	return ip.Mask(net.CIDRMask(32, 128)).String()
 }
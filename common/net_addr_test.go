@@ -1,7 +1,9 @@
 package common
 
 import (
+	"errors"
 	"github.com/stretchr/testify/assert"
+	"net"
 	"testing"
 )
 
@@ -45,3 +47,137 @@ func TestParseNetAddress1(t *testing.T) {
 	assert.Equal("127.0.0.1", addr.IP)
 	assert.Equal(int32(8080), addr.Port)
 }
+
+func TestNetAddress_GroupKey(t *testing.T) {
+	assert := assert.New(t)
+	assert.Equal("192.168.0.0", NewNetAddress("tcp", "192.168.1.101", 8080).GroupKey())
+	assert.Equal(NewNetAddress("tcp", "192.168.1.101", 8080).GroupKey(), NewNetAddress("tcp", "192.168.2.1", 8081).GroupKey())
+	assert.NotEqual(NewNetAddress("tcp", "192.168.1.101", 8080).GroupKey(), NewNetAddress("tcp", "192.169.1.101", 8080).GroupKey())
+	assert.Equal("2001:db8::", NewNetAddress("tcp", "2001:db8:1::1", 8080).GroupKey())
+	assert.Equal("localhost", NewNetAddress("tcp", "localhost", 8080).GroupKey())
+}
+
+func TestNetAddress_IPv6(t *testing.T) {
+	assert := assert.New(t)
+	addr := NewNetAddress("tcp", "::1", 8080)
+	assert.Equal("tcp://[::1]:8080", addr.ToString())
+	assert.Equal("[::1]:8080", addr.HostPort())
+	assert.True(addr.IsIPv6())
+
+	addr1, err := ParseNetAddress(addr.ToString())
+	assert.Nil(err)
+	assert.Equal(addr, addr1)
+
+	// canonical ip form
+	addr1, err = ParseNetAddress("tcp://[2001:DB8:0::1]:8080")
+	assert.Nil(err)
+	assert.Equal("2001:db8::1", addr1.IP)
+	addr1, err = ParseNetAddress("[::ffff:192.168.1.1]:8080")
+	assert.Nil(err)
+	assert.Equal("192.168.1.1", addr1.IP)
+	assert.False(addr1.IsIPv6())
+}
+
+func TestNetAddress_Classify(t *testing.T) {
+	assert := assert.New(t)
+	assert.True(NewNetAddress("tcp", "127.0.0.1", 8080).IsLoopback())
+	assert.True(NewNetAddress("tcp", "127.10.0.1", 8080).IsLoopback())
+	assert.True(NewNetAddress("tcp", "::1", 8080).IsLoopback())
+	assert.False(NewNetAddress("tcp", "192.168.1.1", 8080).IsLoopback())
+	assert.False(NewNetAddress("tcp", "localhost", 8080).IsLoopback())
+
+	assert.True(NewNetAddress("tcp", "10.1.1.1", 8080).IsPrivate())
+	assert.True(NewNetAddress("tcp", "172.20.1.1", 8080).IsPrivate())
+	assert.True(NewNetAddress("tcp", "fd00::1", 8080).IsPrivate())
+	assert.False(NewNetAddress("tcp", "172.32.1.1", 8080).IsPrivate())
+	assert.True(NewNetAddress("tcp", "100.64.1.1", 8080).IsPrivate())
+
+	assert.True(NewNetAddress("tcp", "8.8.8.8", 8080).IsRoutable())
+	assert.True(NewNetAddress("tcp", "2001:4860::8888", 8080).IsRoutable())
+	assert.False(NewNetAddress("tcp", "192.168.1.1", 8080).IsRoutable())
+	assert.False(NewNetAddress("tcp", "fe80::1", 8080).IsRoutable())
+	assert.False(NewNetAddress("tcp", "0.0.0.0", 8080).IsRoutable())
+	assert.False(NewNetAddress("tcp", "::", 8080).IsRoutable())
+	assert.False(NewNetAddress("tcp", "224.0.0.1", 8080).IsRoutable())
+}
+
+func TestNetAddress_IsRoutable(t *testing.T) {
+	assert := assert.New(t)
+	tests := []struct {
+		ip       string
+		valid    bool
+		routable bool
+	}{
+		{"8.8.8.8", true, true},
+		{"1.2.3.4", true, true},
+		{"2001:4860::8888", true, true},
+		{"0.1.2.3", false, false},
+		{"240.0.0.1", false, false},
+		{"255.255.255.254", false, false},
+		{"192.0.2.1", true, false},
+		{"198.51.100.1", true, false},
+		{"203.0.113.1", true, false},
+		{"198.18.0.1", true, false},
+		{"198.19.255.1", true, false},
+		{"198.20.0.1", true, true},
+		{"100.64.0.1", true, false},
+		{"100.127.255.1", true, false},
+		{"100.128.0.1", true, true},
+		{"2001:db8::1", true, false},
+		{"2001:db9::1", true, true},
+	}
+	for _, test := range tests {
+		addr := NewNetAddress("tcp", test.ip, 8080)
+		assert.Equal(test.valid, addr.IsValid(), test.ip)
+		assert.Equal(test.routable, addr.IsRoutable(), test.ip)
+	}
+}
+
+func TestNetAddress_Resolve(t *testing.T) {
+	assert := assert.New(t)
+	defer func(origin func(host string) ([]net.IP, error)) { lookupIP = origin }(lookupIP)
+	lookupIP = func(host string) ([]net.IP, error) {
+		if host != "seed.example.com" {
+			return nil, errors.New("no such host")
+		}
+		return []net.IP{net.ParseIP("192.168.1.1"), net.ParseIP("2001:db8::1"), net.ParseIP("192.168.1.1")}, nil
+	}
+
+	addr, err := ParseNetAddress("tcp://seed.example.com:8080")
+	assert.Nil(err)
+	assert.True(addr.IsHostname())
+	addrs, err := addr.Resolve()
+	assert.Nil(err)
+	assert.Equal([]*NetAddress{
+		NewNetAddress("tcp", "192.168.1.1", 8080),
+		NewNetAddress("tcp", "2001:db8::1", 8080),
+	}, addrs)
+
+	_, err = NewNetAddress("tcp", "unknown.example.com", 8080).Resolve()
+	assert.NotNil(err)
+
+	// ip address need no resolving
+	addr = NewNetAddress("tcp", "192.168.1.2", 8080)
+	assert.False(addr.IsHostname())
+	addrs, err = addr.Resolve()
+	assert.Nil(err)
+	assert.Equal([]*NetAddress{addr}, addrs)
+}
+
+func TestNetAddress_IsValid(t *testing.T) {
+	assert := assert.New(t)
+	assert.True(NewNetAddress("tcp", "192.168.1.1", 8080).IsValid())
+	assert.True(NewNetAddress("tcp", "2001:db8::1", 8080).IsValid())
+	assert.False(NewNetAddress("tcp", "192.168.1.1", 0).IsValid())
+	assert.False(NewNetAddress("tcp", "192.168.1.1", 65536).IsValid())
+	assert.False(NewNetAddress("tcp", "0.0.0.0", 8080).IsValid())
+	assert.False(NewNetAddress("tcp", "::", 8080).IsValid())
+	assert.False(NewNetAddress("tcp", "239.1.1.1", 8080).IsValid())
+	assert.False(NewNetAddress("tcp", "ff02::1", 8080).IsValid())
+	assert.False(NewNetAddress("tcp", "255.255.255.255", 8080).IsValid())
+	assert.False(NewNetAddress("tcp", "seed.example.com", 8080).IsValid())
+
+	assert.True(NewNetAddress("tcp", "169.254.1.1", 8080).IsLinkLocal())
+	assert.True(NewNetAddress("tcp", "fe80::1", 8080).IsLinkLocal())
+	assert.False(NewNetAddress("tcp", "192.168.1.1", 8080).IsLinkLocal())
+}
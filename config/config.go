@@ -1,5 +1,7 @@
 package config
 
+import "time"
+
 // ServiceFlag identifies services supported by a bitcoin peer.
 type ServiceFlag uint64
 
@@ -17,19 +19,43 @@ const (
 	SFNodeBroadCastTest
 )
 
+// AllServices is all the services a peer may support
+var AllServices = []ServiceFlag{SFNodeTX, SFNodeBlockBroadCast, SFNodeBlockSyncer, SFNodeBroadCastTest}
+
 // P2PConfig configuration of the p2p network.
 type P2PConfig struct {
-	AddrBookFilePath string      // address book file path
-	ListenAddress    string      // server listen address
-	MaxConnOutBound  int         // max connection out bound
-	MaxConnInBound   int         // max connection in bound
-	PersistentPeers  string      // persistent peers
-	DebugServer      string      // p2p test debug server address
-	DebugP2P         bool        // p2p debug flag
-	DebugAddr        string      //debug address
-	NAT              string      //NAT port mapping mechanism(none|upnp)
-	SeedMode         bool        // whether run as dns seed(default false)
-	DisableDNSSeed   bool        //Disable DNS seeding for peers
-	DNSSeeds         string      //list of DNS seeds for the network that are used as one method to discover peers
-	Service          ServiceFlag // service supported by this peer.
+	AddrBookFilePath  string        // address book file path
+	ListenAddress     string        // server listen address
+	MaxConnOutBound   int           // max connection out bound
+	MaxConnInBound    int           // max connection in bound
+	MaxPendingInBound int           // max num of concurrent pending inbound handshakes(default 16)
+	InBoundRatePerIP  int           // max num of inbound connections accepted From an ip per minute(default 10)
+	ReconnectBase     time.Duration // base interval of the exponential reconnect backoff(default 5s)
+	ReconnectMax      time.Duration // max interval of the exponential reconnect backoff(default 30m)
+	ReconnectJitter   float64       // max fraction of the reconnect interval randomly reduced, in (0, 1](default 0.5)
+	PersistentPeers   string        // persistent peers, comma separated, host names are resolved at dial time
+	DebugServer       string        // p2p test debug server address
+	DebugP2P          bool          // p2p debug flag
+	DebugAddr         string        //debug address
+	NAT               string        //NAT port mapping mechanism(none|upnp)
+	SeedMode          bool          // whether run as dns seed(default false)
+	DisableDNSSeed    bool          //Disable DNS seeding for peers
+	DNSSeeds          string        //list of DNS seeds for the network that are used as one method to discover peers
+	DNSBootstrap      bool          // resolve DNSSeeds as DNS names To get peer addresses listening on our port(default false)
+	DNSResolver       string        // ip:port of the resolver used in DNS bootstrap, system resolver is used if empty
+	DiscoveryAddr     string        // udp listen address of node discovery, discovery is disabled if empty
+	Bootnodes         string        // boot nodes of discovery, comma separated "dnode://<node id>@<ip>:<port>?discport=<udp port>"
+	MDNS              bool          // whether discover the peers in LAN with mDNS(default false)
+	MDNSInterface     string        // name of the interface used by mDNS, system default if empty
+	FeelerInterval    time.Duration // interval of feeler connections testing untried addresses(default 2m)
+	MaxAddrBookSize   int           // max num of addresses in address book(default 20000)
+	AddrPolicy        string        // policy of accepting relayed addresses(auto|public|private, default auto)
+	Crawl             bool          // whether crawl the known addresses To serve only the reachable ones, used by DNS seed(default false)
+	CrawlInterval     time.Duration // interval of crawling an address(default 15m)
+	AdminAddr         string        // listen address of the admin http server, disabled if empty
+	AdminToken        string        // bearer token required by admin server, admin server must listen on loopback if empty
+	Service           ServiceFlag   // service supported by this peer.
+	// min num of outbound peers per service, e.g. {SFNodeBlockSyncer: 2}. Peers supporting these services are
+	// accepted besides the ones supporting ours.
+	ServiceQuotas map[ServiceFlag]int
 }
@@ -0,0 +1,106 @@
+package p2p
+
+import (
+	"sync"
+	"time"
+)
+
+const (
+	defaultMaxPendingInBound = 16               // default max num of concurrent pending inbound handshakes
+	defaultInBoundRatePerIP  = 10               // default max num of inbound connections accepted From an ip per rateInterval
+	rateInterval             = time.Minute      // interval in which an ip's accept tokens are refilled
+	inboundHeaderTimeout     = 2 * time.Second  // deadline for inbound peer To send the first message
+	limiterPruneInterval     = 10 * time.Minute // interval To prune the idle rate buckets
+)
+
+// acceptBucket is the token bucket of an ip
+type acceptBucket struct {
+	tokens   float64
+	lastTime time.Time
+}
+
+// connLimiter protects the listener From connection-exhaustion attacks. It bounds the number of
+// concurrent pending handshakes and the rate of accepted connections per ip.
+type connLimiter struct {
+	slots     chan struct{}
+	ratePerIP int
+	buckets   map[string]*acceptBucket
+	lastPrune time.Time
+	lock      sync.Mutex
+}
+
+// newConnLimiter create a connection limiter instance
+func newConnLimiter(maxPending, ratePerIP int) *connLimiter {
+	if maxPending <= 0 {
+		maxPending = defaultMaxPendingInBound
+	}
+	if ratePerIP <= 0 {
+		ratePerIP = defaultInBoundRatePerIP
+	}
+	return &connLimiter{
+		slots:     make(chan struct{}, maxPending),
+		ratePerIP: ratePerIP,
+		buckets:   make(map[string]*acceptBucket),
+		lastPrune: time.Now(),
+	}
+}
+
+// allow check whether a new connection From ip can be accepted.
+func (limiter *connLimiter) allow(ip string) bool {
+	limiter.lock.Lock()
+	defer limiter.lock.Unlock()
+
+	now := time.Now()
+	if now.Sub(limiter.lastPrune) > limiterPruneInterval {
+		limiter.prune(now)
+	}
+
+	bucket, ok := limiter.buckets[ip]
+	if !ok {
+		bucket = &acceptBucket{
+			tokens:   float64(limiter.ratePerIP),
+			lastTime: now,
+		}
+		limiter.buckets[ip] = bucket
+	} else {
+		bucket.tokens += now.Sub(bucket.lastTime).Seconds() / rateInterval.Seconds() * float64(limiter.ratePerIP)
+		if bucket.tokens > float64(limiter.ratePerIP) {
+			bucket.tokens = float64(limiter.ratePerIP)
+		}
+		bucket.lastTime = now
+	}
+
+	if bucket.tokens < 1 {
+		return false
+	}
+	bucket.tokens--
+	return true
+}
+
+// remove the buckets which have been refilled, as they are the same as a new one.
+func (limiter *connLimiter) prune(now time.Time) {
+	for ip, bucket := range limiter.buckets {
+		if now.Sub(bucket.lastTime) >= rateInterval {
+			delete(limiter.buckets, ip)
+		}
+	}
+	limiter.lastPrune = now
+}
+
+// acquire try To take a pending handshake slot, return false if all slots are in use.
+func (limiter *connLimiter) acquire() bool {
+	select {
+	case limiter.slots <- struct{}{}:
+		return true
+	default:
+		return false
+	}
+}
+
+// release give back a pending handshake slot
+func (limiter *connLimiter) release() {
+	select {
+	case <-limiter.slots:
+	default:
+	}
+}
@@ -0,0 +1,97 @@
+package p2p
+
+import (
+	"github.com/DSiSc/p2p/message"
+	"github.com/stretchr/testify/assert"
+	"io"
+	"net"
+	"testing"
+	"time"
+)
+
+func TestNewConnLimiter(t *testing.T) {
+	assert := assert.New(t)
+	limiter := newConnLimiter(0, 0)
+	assert.NotNil(limiter)
+	assert.Equal(defaultMaxPendingInBound, cap(limiter.slots))
+	assert.Equal(defaultInBoundRatePerIP, limiter.ratePerIP)
+}
+
+func TestConnLimiter_Allow(t *testing.T) {
+	assert := assert.New(t)
+	limiter := newConnLimiter(1, 2)
+	assert.True(limiter.allow("192.168.1.1"))
+	assert.True(limiter.allow("192.168.1.1"))
+	assert.False(limiter.allow("192.168.1.1"))
+	assert.True(limiter.allow("192.168.1.2"))
+
+	// refill the bucket
+	limiter.buckets["192.168.1.1"].lastTime = time.Now().Add(-rateInterval)
+	assert.True(limiter.allow("192.168.1.1"))
+}
+
+func TestConnLimiter_Prune(t *testing.T) {
+	assert := assert.New(t)
+	limiter := newConnLimiter(1, 2)
+	assert.True(limiter.allow("192.168.1.1"))
+	limiter.buckets["192.168.1.1"].lastTime = time.Now().Add(-rateInterval)
+	limiter.lastPrune = time.Now().Add(-limiterPruneInterval - time.Second)
+	assert.True(limiter.allow("192.168.1.2"))
+	_, ok := limiter.buckets["192.168.1.1"]
+	assert.False(ok)
+}
+
+func TestConnLimiter_AcquireRelease(t *testing.T) {
+	assert := assert.New(t)
+	limiter := newConnLimiter(2, 1)
+	assert.True(limiter.acquire())
+	assert.True(limiter.acquire())
+	assert.False(limiter.acquire())
+	limiter.release()
+	assert.True(limiter.acquire())
+}
+
+// connection with a mocked remote address
+type remoteAddrConn struct {
+	net.Conn
+	remote net.Addr
+}
+
+func (conn *remoteAddrConn) RemoteAddr() net.Addr {
+	return conn.remote
+}
+
+// accept a connection From the ip by the listener, return the remote side of the connection
+func acceptFrom(listener *testListener, ip string) net.Conn {
+	local, remote := net.Pipe()
+	listener.connChan <- &remoteAddrConn{Conn: local, remote: &net.TCPAddr{IP: net.ParseIP(ip), Port: 50001}}
+	return remote
+}
+
+func TestP2P_StartListenFull(t *testing.T) {
+	assert := assert.New(t)
+	p2p, err := NewP2P(mockConfig(), nil)
+	assert.Nil(err)
+	p2p.config.MaxConnInBound = -1
+	listener := newTestListener()
+	defer listener.Close()
+	go p2p.startListen(listener)
+
+	// banned ip is dropped without goodbye
+	p2p.bans.ban(net.ParseIP("10.0.0.1"), time.Hour, "test")
+	remote := acceptFrom(listener, "10.0.0.1")
+	_, err = remote.Read(make([]byte, 1))
+	assert.Equal(io.EOF, err)
+
+	// others are told the inbound peers are full
+	remote = acceptFrom(listener, "10.0.0.2")
+	msg, err := message.ReadMessage(remote)
+	assert.Nil(err)
+	assert.Equal(message.ReasonTooManyPeers, msg.(*message.GoodbyeMsg).Reason)
+	_, err = remote.Read(make([]byte, 1))
+	assert.Equal(io.EOF, err)
+	for i := 0; i < 100 && len(p2p.connLimiter.slots) > 0; i++ {
+		time.Sleep(10 * time.Millisecond)
+	}
+	assert.Equal(0, len(p2p.connLimiter.slots))
+}
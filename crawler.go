@@ -0,0 +1,212 @@
+package p2p
+
+import (
+	"errors"
+	"github.com/DSiSc/craft/log"
+	"github.com/DSiSc/p2p/common"
+	"github.com/DSiSc/p2p/config"
+	"github.com/DSiSc/p2p/message"
+	"math"
+	"sync"
+	"time"
+)
+
+const (
+	defaultCrawlInterval = 15 * time.Minute
+	crawlTickInterval    = 10 * time.Second
+	maxCrawlConcurrency  = 16
+	crawlUptimeWindow    = 8 * time.Hour  // time window of uptime, older crawls weigh less
+	minCrawlUptime       = 0.8            // min uptime of the addresses served
+	minGoodCrawls        = 3              // min num of crawls before an address is served
+	crawlUptimePrior     = 0.5            // uptime assumed before the first crawl, the first crawl weighs as much
+	crawlRecordExpiry    = 24 * time.Hour // records not crawled for so long are removed
+)
+
+// The crawler of a DNS seed keeps handshaking with the known addresses, and records the reachability, latency,
+// version and service of them. Only the addresses reachable at the last crawl with good recent uptime are served,
+// so the nodes bootstrapping From the seed won't get the long-dead addresses in address book.
+
+// CrawlResult is the crawling result of an address
+type CrawlResult struct {
+	Addr        string             `json:"addr"`
+	Reachable   bool               `json:"reachable"`   // whether the last crawl succeeded
+	Latency     time.Duration      `json:"latency"`     // handshake latency of the last successful crawl
+	Version     string             `json:"version"`     // version of the last successful crawl
+	Service     config.ServiceFlag `json:"service"`     // service of the last successful crawl
+	Uptime      float64            `json:"uptime"`      // time weighted ratio of successful crawls, in [0, 1]
+	Crawls      int                `json:"crawls"`      // num of crawls
+	LastCrawl   time.Time          `json:"lastCrawl"`   // time of the last crawl
+	LastSuccess time.Time          `json:"lastSuccess"` // time of the last successful crawl
+	addr        *common.NetAddress
+}
+
+// crawler records the crawling results
+type crawler struct {
+	interval time.Duration
+	records  sync.Map // address string -> *CrawlResult, a record is never modified after stored
+	crawling sync.Map // address string -> struct{}, addresses being crawled
+	sem      chan struct{}
+}
+
+// create a crawler instance
+func newCrawler(interval time.Duration) *crawler {
+	if interval <= 0 {
+		interval = defaultCrawlInterval
+	}
+	return &crawler{
+		interval: interval,
+		sem:      make(chan struct{}, maxCrawlConcurrency),
+	}
+}
+
+// record the result of a crawl, the uptime decays exponentially with the time elapsed since last crawl. A new
+// address starts From a neutral uptime, so it must stay reachable for a while before it's served.
+func (c *crawler) record(addr *common.NetAddress, reachable bool, latency time.Duration, version string, service config.ServiceFlag, now time.Time) *CrawlResult {
+	success := 0.0
+	if reachable {
+		success = 1.0
+	}
+	result := &CrawlResult{Addr: addr.ToString(), Uptime: (crawlUptimePrior + success) / 2, addr: addr}
+	if v, ok := c.records.Load(result.Addr); ok {
+		prev := v.(*CrawlResult)
+		*result = *prev
+		weight := 1 - math.Exp(-float64(now.Sub(prev.LastCrawl))/float64(crawlUptimeWindow))
+		result.Uptime = prev.Uptime*(1-weight) + success*weight
+	}
+	result.Reachable = reachable
+	result.Crawls++
+	result.LastCrawl = now
+	if reachable {
+		result.Latency = latency
+		result.Version = version
+		result.Service = service
+		result.LastSuccess = now
+	}
+	c.records.Store(result.Addr, result)
+	return result
+}
+
+// whether the address need To be crawled now
+func (c *crawler) due(addr *common.NetAddress, now time.Time) bool {
+	v, ok := c.records.Load(addr.ToString())
+	return !ok || now.Sub(v.(*CrawlResult).LastCrawl) >= c.interval
+}
+
+// whether the address is good enough To be served
+func (c *crawler) good(result *CrawlResult, now time.Time) bool {
+	return result.Reachable && result.Crawls >= minGoodCrawls && result.Uptime >= minCrawlUptime &&
+		now.Sub(result.LastCrawl) < 2*c.interval
+}
+
+// remove the expired records
+func (c *crawler) prune(now time.Time) {
+	c.records.Range(func(key, value interface{}) bool {
+		if now.Sub(value.(*CrawlResult).LastCrawl) >= crawlRecordExpiry {
+			c.records.Delete(key)
+		}
+		return true
+	})
+}
+
+// get all crawling results
+func (c *crawler) results() []*CrawlResult {
+	results := make([]*CrawlResult, 0)
+	c.records.Range(func(key, value interface{}) bool {
+		result := *value.(*CrawlResult)
+		results = append(results, &result)
+		return true
+	})
+	return results
+}
+
+// get a random sample of at most num good addresses satisfying the filter
+func (c *crawler) goodAddresses(num int, filter func(addr *common.NetAddress, services *config.ServiceFlag) bool) []*common.NetAddress {
+	now := time.Now()
+	addrs := make([]*common.NetAddress, 0)
+	c.records.Range(func(key, value interface{}) bool {
+		result := value.(*CrawlResult)
+		if c.good(result, now) && (filter == nil || filter(result.addr, &result.Service)) {
+			addrs = append(addrs, result.addr)
+		}
+		return true
+	})
+	return sampleAddresses(addrs, num)
+}
+
+// CrawlResults get the crawling results of the addresses, return nil if crawler is disabled.
+func (service *P2P) CrawlResults() []*CrawlResult {
+	if service.crawler == nil {
+		return nil
+	}
+	return service.crawler.results()
+}
+
+// crawlHandler crawl the due addresses periodically
+func (service *P2P) crawlHandler() {
+	ticker := time.NewTicker(crawlTickInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			service.crawl()
+		case <-service.quitChan:
+			return
+		}
+	}
+}
+
+// start crawling the due addresses, at most maxCrawlConcurrency addresses are crawled at the same time.
+func (service *P2P) crawl() {
+	now := time.Now()
+	service.crawler.prune(now)
+	for _, addr := range service.addrManager.GetAllAddress() {
+		if service.addrManager.IsOurAddress(addr) || !service.crawler.due(addr, now) {
+			continue
+		}
+		if _, loaded := service.crawler.crawling.LoadOrStore(addr.ToString(), struct{}{}); loaded {
+			continue
+		}
+		select {
+		case service.crawler.sem <- struct{}{}:
+		default:
+			service.crawler.crawling.Delete(addr.ToString())
+			return
+		}
+		go func(addr *common.NetAddress) {
+			defer func() {
+				<-service.crawler.sem
+				service.crawler.crawling.Delete(addr.ToString())
+			}()
+			service.crawlAddr(addr)
+		}(addr)
+	}
+}
+
+// handshake with the address and record the result, return nil if the address is banned.
+func (service *P2P) crawlAddr(addr *common.NetAddress) *CrawlResult {
+	if service.checkOutbound(addr) != nil {
+		return nil
+	}
+	log.Debug("start crawling %s", addr.ToString())
+	service.addrManager.UpdateAddressAttemptInfo(addr)
+	peer := NewOutboundPeer(&service.PeerCom, addr, false, service.internalChan)
+	peer.crawling = true
+	start := time.Now()
+	err := peer.Start()
+	latency := time.Since(start)
+	// a remote of other service tells us its version before rejecting us
+	if err != nil && !(disconnectReason(err) == message.ReasonIncompatibleVersion && peer.GetVersion() != "") {
+		log.Debug("failed To crawl %s, as: %v", addr.ToString(), err)
+		service.addrManager.RecordDisconnect(addr, disconnectReason(err))
+		return service.crawler.record(addr, false, 0, "", 0, time.Now())
+	}
+	if err == nil {
+		peer.sayGoodbye(newDisconnectError(message.ReasonNone, errors.New("crawling finished")))
+	}
+	peer.Stop()
+
+	service.addrManager.ResetAddressAttemptInfo(addr)
+	service.addrManager.Good(addr)
+	service.addrManager.Connected(addr, peer.GetService())
+	return service.crawler.record(addr, true, latency, peer.version, peer.GetService(), time.Now())
+}
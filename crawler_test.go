@@ -0,0 +1,170 @@
+package p2p
+
+import (
+	"github.com/DSiSc/p2p/common"
+	"github.com/DSiSc/p2p/config"
+	"github.com/stretchr/testify/assert"
+	"net"
+	"testing"
+	"time"
+)
+
+func TestCrawler_Record(t *testing.T) {
+	assert := assert.New(t)
+	c := newCrawler(0)
+	assert.Equal(defaultCrawlInterval, c.interval)
+	addr := common.NewNetAddress("tcp", "8.8.8.8", 8080)
+	now := time.Now()
+	assert.True(c.due(addr, now))
+
+	// a new address is not served until it have been reachable for a while
+	result := c.record(addr, true, time.Second, "1.0", config.SFNodeTX, now)
+	assert.True(result.Reachable)
+	assert.Equal(0.75, result.Uptime)
+	assert.Equal(1, result.Crawls)
+	assert.Equal(time.Second, result.Latency)
+	assert.False(c.good(result, now))
+	assert.False(c.due(addr, now))
+	assert.True(c.due(addr, now.Add(c.interval)))
+	for !c.good(result, now) {
+		now = now.Add(c.interval)
+		result = c.record(addr, true, time.Second, "1.0", config.SFNodeTX, now)
+	}
+	assert.True(result.Crawls >= minGoodCrawls)
+	assert.True(result.Crawls < 20)
+	assert.False(c.good(result, now.Add(2*c.interval)))
+
+	// unreachable address is not good, and the uptime decays
+	prev := result
+	now = now.Add(c.interval)
+	result = c.record(addr, false, 0, "", 0, now)
+	assert.False(result.Reachable)
+	assert.True(result.Uptime < prev.Uptime)
+	assert.Equal(prev.Crawls+1, result.Crawls)
+	assert.Equal(time.Second, result.Latency)
+	assert.Equal("1.0", result.Version)
+	assert.False(c.good(result, now))
+
+	// long downtime makes the uptime too low even if it's reachable again
+	for i := 0; i < 20; i++ {
+		now = now.Add(c.interval)
+		c.record(addr, false, 0, "", 0, now)
+	}
+	now = now.Add(c.interval)
+	result = c.record(addr, true, time.Second, "1.0", config.SFNodeTX, now)
+	assert.True(result.Reachable)
+	assert.False(c.good(result, now))
+
+	c.prune(now.Add(crawlRecordExpiry))
+	assert.Equal(0, len(c.results()))
+}
+
+func TestP2P_SeedIPsCrawled(t *testing.T) {
+	assert := assert.New(t)
+	conf := mockConfig()
+	conf.Crawl = true
+	p2p, err := NewP2P(conf, nil)
+	assert.Nil(err)
+	now := time.Now()
+	for i := 20; i >= 0; i-- {
+		at := now.Add(-time.Duration(i) * p2p.crawler.interval)
+		p2p.crawler.record(common.NewNetAddress("tcp", "8.8.8.8", 8080), true, time.Second, "1.0", config.SFNodeTX, at)
+		p2p.crawler.record(common.NewNetAddress("tcp", "8.8.4.4", 8080), false, 0, "", 0, at)
+		p2p.crawler.record(common.NewNetAddress("tcp", "1.1.1.1", 8081), true, time.Second, "1.0", config.SFNodeTX, at)
+		p2p.crawler.record(common.NewNetAddress("tcp", "1.0.0.1", 8080), true, time.Second, "1.0", config.SFNodeBlockSyncer, at)
+	}
+	// a new address is not served yet
+	p2p.crawler.record(common.NewNetAddress("tcp", "9.9.9.9", 8080), true, time.Second, "1.0", config.SFNodeTX, now)
+
+	assert.Equal(2, len(p2p.SeedIPs(10, false, nil)))
+	service := config.SFNodeBlockSyncer
+	assert.Equal([]net.IP{net.ParseIP("1.0.0.1")}, p2p.SeedIPs(10, false, &service))
+	assert.Equal(0, len(p2p.SeedIPs(10, true, nil)))
+	assert.Equal(5, len(p2p.CrawlResults()))
+}
+
+func TestP2P_CrawlAddr(t *testing.T) {
+	assert := assert.New(t)
+	conf := mockConfig()
+	conf.Crawl = true
+	p2p, err := NewP2P(conf, nil)
+	assert.Nil(err)
+
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	assert.Nil(err)
+	defer listener.Close()
+	go func() {
+		conn, err := listener.Accept()
+		if err != nil {
+			return
+		}
+		connAddr, _ := common.ParseNetAddress(conn.RemoteAddr().String())
+		peer := NewInboundPeer(mockServerInfo(), connAddr, make(chan *InternalMsg, 10), conn)
+		peer.Start()
+	}()
+	addr, _ := common.ParseNetAddress(listener.Addr().String())
+	p2p.addrManager.AddAddress(addr)
+
+	result := p2p.crawlAddr(addr)
+	assert.True(result.Reachable)
+	assert.Equal(config.SFNodeTX, result.Service)
+	assert.NotEqual("", result.Version)
+	assert.True(result.Latency > 0)
+	assert.True(p2p.addrManager.IsTried(addr))
+	assert.Equal(0, p2p.peers.count(nil))
+
+	// unreachable now
+	listener.Close()
+	result = p2p.crawlAddr(addr)
+	assert.False(result.Reachable)
+	assert.Equal(2, result.Crawls)
+	attemptNum, _ := p2p.addrManager.GetAddressAttemptInfo(addr)
+	assert.Equal(uint32(1), attemptNum)
+}
+
+func TestP2P_CrawlBanned(t *testing.T) {
+	assert := assert.New(t)
+	conf := mockConfig()
+	conf.Crawl = true
+	p2p, err := NewP2P(conf, nil)
+	assert.Nil(err)
+
+	addr := common.NewNetAddress("tcp", "127.0.0.1", 8080)
+	p2p.addrManager.AddAddress(addr)
+	p2p.bans.ban(addr.ParsedIP(), time.Hour, "spam")
+	assert.Nil(p2p.crawlAddr(addr))
+	attemptNum, _ := p2p.addrManager.GetAddressAttemptInfo(addr)
+	assert.Equal(uint32(0), attemptNum)
+}
+
+func TestP2P_CrawlOtherService(t *testing.T) {
+	assert := assert.New(t)
+	conf := mockConfig()
+	conf.Crawl = true
+	p2p, err := NewP2P(conf, nil)
+	assert.Nil(err)
+
+	// a stock block syncer
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	assert.Nil(err)
+	defer listener.Close()
+	syncerInfo := mockServerInfo()
+	syncerInfo.service = config.SFNodeBlockSyncer
+	go func() {
+		conn, err := listener.Accept()
+		if err != nil {
+			return
+		}
+		connAddr, _ := common.ParseNetAddress(conn.RemoteAddr().String())
+		peer := NewInboundPeer(syncerInfo, connAddr, make(chan *InternalMsg, 10), conn)
+		peer.Start()
+	}()
+	addr, _ := common.ParseNetAddress(listener.Addr().String())
+	p2p.addrManager.AddAddress(addr)
+
+	// the service is recorded even if it's incompatible with ours
+	result := p2p.crawlAddr(addr)
+	assert.True(result.Reachable)
+	assert.Equal(config.SFNodeBlockSyncer, result.Service)
+	assert.Equal(config.SFNodeBlockSyncer, *p2p.addrManager.GetServices(addr))
+}
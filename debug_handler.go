@@ -7,6 +7,7 @@ import (
 	"github.com/DSiSc/p2p/message"
 	stCommon "github.com/DSiSc/p2p/tools/common"
 	"github.com/DSiSc/p2p/tools/statistics/client"
+	"net"
 	"strconv"
 	"time"
 )
@@ -58,7 +59,7 @@ func (this *DebugHandler) RecvMsgEventSubscriber(msg interface{}) {
 		return
 	}
 	if this.p2p.addrManager.OurAddresses()[0].Port == imsg.To.Port {
-		this.reportMessage(this.p2p.config.DebugAddr+":"+strconv.Itoa(int(imsg.To.Port)), imsg, false)
+		this.reportMessage(net.JoinHostPort(this.p2p.config.DebugAddr, strconv.Itoa(int(imsg.To.Port))), imsg, false)
 	}
 }
 
@@ -74,7 +75,7 @@ func (this *DebugHandler) BroadCastMsgEventSubscriber(msg interface{}) {
 		return
 	}
 	if this.p2p.addrManager.OurAddresses()[0].Port == imsg.From.Port {
-		this.reportMessage(this.p2p.config.DebugAddr+":"+strconv.Itoa(int(imsg.From.Port)), imsg, true)
+		this.reportMessage(net.JoinHostPort(this.p2p.config.DebugAddr, strconv.Itoa(int(imsg.From.Port))), imsg, true)
 	}
 }
 
@@ -85,7 +86,7 @@ func (this *DebugHandler) ReportNeighborHandler() {
 	for {
 		select {
 		case <-timer.C:
-			this.reportNeighbors(this.p2p.config.DebugAddr+":"+strconv.Itoa(int(this.p2p.addrManager.OurAddresses()[0].Port)), this.p2p.GetPeers())
+			this.reportNeighbors(net.JoinHostPort(this.p2p.config.DebugAddr, strconv.Itoa(int(this.p2p.addrManager.OurAddresses()[0].Port))), this.p2p.GetPeers())
 		case <-this.quitChan:
 			return
 		}
@@ -120,5 +121,5 @@ func (this *DebugHandler) reportNeighbors(localAddr string, peers []*Peer) {
 
 // format NetAddress to string
 func addrString(addr *common.NetAddress) string {
-	return addr.IP + ":" + strconv.Itoa(int(addr.Port))
+	return addr.HostPort()
 }
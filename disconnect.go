@@ -0,0 +1,81 @@
+package p2p
+
+import (
+	"fmt"
+	"github.com/DSiSc/craft/log"
+	"github.com/DSiSc/p2p/message"
+	"net"
+	"time"
+)
+
+// goodbyeWriteTimeout is the deadline of sending goodbye message on a raw connection
+const goodbyeWriteTimeout = time.Second
+
+// disconnectError is the error carrying a disconnect reason code
+type disconnectError struct {
+	reason message.DisconnectReason
+	remote bool // whether the reason is told by remote peer
+	err    error
+}
+
+// newDisconnectError create a disconnect error decided by local
+func newDisconnectError(reason message.DisconnectReason, err error) error {
+	return &disconnectError{
+		reason: reason,
+		err:    err,
+	}
+}
+
+// newRemoteDisconnectError create a disconnect error told by remote peer's goodbye message
+func newRemoteDisconnectError(goodbye *message.GoodbyeMsg) error {
+	return &disconnectError{
+		reason: goodbye.Reason,
+		remote: true,
+		err:    fmt.Errorf("remote said goodbye: %s", goodbye.Message),
+	}
+}
+
+func (derr *disconnectError) Error() string {
+	return fmt.Sprintf("%v(%v)", derr.err, derr.reason)
+}
+
+// disconnectReason get the disconnect reason code of the error
+func disconnectReason(err error) message.DisconnectReason {
+	if derr, ok := err.(*disconnectError); ok {
+		return derr.reason
+	}
+	return message.ReasonNone
+}
+
+// isRemoteDisconnect check whether the disconnection is decided by remote peer
+func isRemoteDisconnect(err error) bool {
+	if derr, ok := err.(*disconnectError); ok {
+		return derr.remote
+	}
+	return false
+}
+
+// newGoodbyeMsg create the goodbye message To tell remote the disconnect reason
+func newGoodbyeMsg(err error) *message.GoodbyeMsg {
+	goodbye := &message.GoodbyeMsg{
+		Reason: disconnectReason(err),
+	}
+	if derr, ok := err.(*disconnectError); ok {
+		goodbye.Message = derr.err.Error()
+	} else if err != nil {
+		goodbye.Message = err.Error()
+	}
+	return goodbye
+}
+
+// sayGoodbye send a goodbye message on a connection which have not been wrapped as a peer, and close it.
+func sayGoodbye(conn net.Conn, err error) {
+	defer conn.Close()
+	buf, encErr := message.EncodeMessage(newGoodbyeMsg(err))
+	if encErr != nil {
+		log.Warn("failed To encode goodbye message, as: %v", encErr)
+		return
+	}
+	conn.SetWriteDeadline(time.Now().Add(goodbyeWriteTimeout))
+	conn.Write(buf)
+}
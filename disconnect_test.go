@@ -0,0 +1,44 @@
+package p2p
+
+import (
+	"errors"
+	"github.com/DSiSc/p2p/message"
+	"github.com/stretchr/testify/assert"
+	"net"
+	"testing"
+)
+
+func TestDisconnectReason(t *testing.T) {
+	assert := assert.New(t)
+	err := newDisconnectError(message.ReasonTooManyPeers, errors.New("too many inbound peers"))
+	assert.Equal(message.ReasonTooManyPeers, disconnectReason(err))
+	assert.False(isRemoteDisconnect(err))
+	assert.Equal(message.ReasonNone, disconnectReason(errors.New("connection reset")))
+	assert.False(isRemoteDisconnect(errors.New("connection reset")))
+
+	remoteErr := newRemoteDisconnectError(&message.GoodbyeMsg{Reason: message.ReasonBanned})
+	assert.Equal(message.ReasonBanned, disconnectReason(remoteErr))
+	assert.True(isRemoteDisconnect(remoteErr))
+}
+
+func TestNewGoodbyeMsg(t *testing.T) {
+	assert := assert.New(t)
+	goodbye := newGoodbyeMsg(newDisconnectError(message.ReasonShuttingDown, errors.New("p2p service stopped")))
+	assert.Equal(message.ReasonShuttingDown, goodbye.Reason)
+	assert.Equal("p2p service stopped", goodbye.Message)
+
+	goodbye = newGoodbyeMsg(errors.New("address exchange finished"))
+	assert.Equal(message.ReasonNone, goodbye.Reason)
+	assert.Equal("address exchange finished", goodbye.Message)
+}
+
+func TestSayGoodbye(t *testing.T) {
+	assert := assert.New(t)
+	local, remote := net.Pipe()
+	go sayGoodbye(local, newDisconnectError(message.ReasonTooManyPeers, errors.New("too many inbound peers")))
+	msg, err := message.ReadMessage(remote)
+	assert.Nil(err)
+	goodbye, ok := msg.(*message.GoodbyeMsg)
+	assert.True(ok)
+	assert.Equal(message.ReasonTooManyPeers, goodbye.Reason)
+}
@@ -0,0 +1,241 @@
+// Package discover implements a Kademlia-like UDP peer discovery protocol. Nodes are identified by the hash of
+// their public keys, every packet is signed by the sender, and a node only answers the queries of the nodes which
+// have proved their endpoints by answering its ping, so it can't be used To amplify traffic To a victim.
+package discover
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"github.com/DSiSc/p2p/common"
+	"io/ioutil"
+	"math/big"
+	"math/bits"
+	"net"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+)
+
+const (
+	nodeIDLen  = 32
+	pubkeyLen  = 65 // uncompressed P-256 public key
+	nodeScheme = "dnode"
+	keyPerm    = 0600
+)
+
+// NodeID is the identity of a node, which is the sha256 hash of its public key
+type NodeID [nodeIDLen]byte
+
+// PubkeyID get the node id of the public key
+func PubkeyID(pub *ecdsa.PublicKey) NodeID {
+	return sha256.Sum256(marshalPubkey(pub))
+}
+
+// HexID parse the node id in hex
+func HexID(in string) (NodeID, error) {
+	var id NodeID
+	buf, err := hex.DecodeString(strings.TrimPrefix(in, "0x"))
+	if err != nil {
+		return id, err
+	}
+	if len(buf) != nodeIDLen {
+		return id, fmt.Errorf("invalid node id length %d, expected %d", len(buf), nodeIDLen)
+	}
+	copy(id[:], buf)
+	return id, nil
+}
+
+// String encode the node id in hex
+func (id NodeID) String() string {
+	return hex.EncodeToString(id[:])
+}
+
+// MarshalText encode the node id in hex
+func (id NodeID) MarshalText() ([]byte, error) {
+	return []byte(id.String()), nil
+}
+
+// UnmarshalText decode the node id in hex
+func (id *NodeID) UnmarshalText(text []byte) error {
+	parsed, err := HexID(string(text))
+	if err != nil {
+		return err
+	}
+	*id = parsed
+	return nil
+}
+
+// Node is a node in discovery network
+type Node struct {
+	ID  NodeID
+	IP  net.IP
+	UDP uint16 // port of discovery protocol
+	TCP uint16 // port of p2p protocol
+}
+
+// NewNode create a node instance
+func NewNode(id NodeID, ip net.IP, udpPort, tcpPort uint16) *Node {
+	if ipv4 := ip.To4(); ipv4 != nil {
+		ip = ipv4
+	}
+	return &Node{ID: id, IP: ip, UDP: udpPort, TCP: tcpPort}
+}
+
+// ParseNode parse the node URL "dnode://<hex id>@<ip>:<tcp port>?discport=<udp port>", the udp port defaults To
+// the tcp port.
+func ParseNode(rawURL string) (*Node, error) {
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return nil, err
+	}
+	if u.Scheme != nodeScheme {
+		return nil, fmt.Errorf("invalid node URL scheme %s, expected %s", u.Scheme, nodeScheme)
+	}
+	if u.User == nil {
+		return nil, errors.New("node id is not specified")
+	}
+	id, err := HexID(u.User.String())
+	if err != nil {
+		return nil, err
+	}
+	ip := net.ParseIP(u.Hostname())
+	if ip == nil {
+		return nil, fmt.Errorf("invalid node ip %s", u.Hostname())
+	}
+	tcpPort, err := strconv.ParseUint(u.Port(), 10, 16)
+	if err != nil {
+		return nil, fmt.Errorf("invalid node port %s", u.Port())
+	}
+	udpPort := tcpPort
+	if discport := u.Query().Get("discport"); discport != "" {
+		if udpPort, err = strconv.ParseUint(discport, 10, 16); err != nil {
+			return nil, fmt.Errorf("invalid node discport %s", discport)
+		}
+	}
+	return NewNode(id, ip, uint16(udpPort), uint16(tcpPort)), nil
+}
+
+// String encode the node To URL
+func (n *Node) String() string {
+	u := url.URL{
+		Scheme: nodeScheme,
+		User:   url.User(n.ID.String()),
+		Host:   net.JoinHostPort(n.IP.String(), strconv.Itoa(int(n.TCP))),
+	}
+	if n.UDP != n.TCP {
+		u.RawQuery = "discport=" + strconv.Itoa(int(n.UDP))
+	}
+	return u.String()
+}
+
+// UDPAddr get the udp address of the node
+func (n *Node) UDPAddr() *net.UDPAddr {
+	return &net.UDPAddr{IP: n.IP, Port: int(n.UDP)}
+}
+
+// NetAddress get the p2p address of the node
+func (n *Node) NetAddress() *common.NetAddress {
+	return common.NewNetAddress("tcp", n.IP.String(), int32(n.TCP))
+}
+
+// logDist get the logarithmic distance between a and b, i.e. the index of the highest different bit plus one.
+func logDist(a, b NodeID) int {
+	for i := range a {
+		if x := a[i] ^ b[i]; x != 0 {
+			return (nodeIDLen-i)*8 - bits.LeadingZeros8(x)
+		}
+	}
+	return 0
+}
+
+// distCmp compare the distances a->target and b->target, return -1 if a is closer, 1 if b is closer, 0 if equal.
+func distCmp(target, a, b NodeID) int {
+	for i := range target {
+		da := a[i] ^ target[i]
+		db := b[i] ^ target[i]
+		if da > db {
+			return 1
+		} else if da < db {
+			return -1
+		}
+	}
+	return 0
+}
+
+// random node id at the log distance To the id
+func randomIDAtDist(id NodeID, dist int) NodeID {
+	var target NodeID
+	rand.Read(target[:])
+	if dist == 0 {
+		return id
+	}
+	// keep the bits above dist, flip the bit at dist, randomize the bits below.
+	byteIdx := nodeIDLen - 1 - (dist-1)/8
+	bit := byte(1) << uint((dist-1)%8)
+	copy(target[:byteIdx], id[:byteIdx])
+	high := (id[byteIdx] ^ bit) &^ (bit - 1)
+	target[byteIdx] = high | target[byteIdx]&(bit-1)
+	return target
+}
+
+// GenerateKey generate a new private key
+func GenerateKey() (*ecdsa.PrivateKey, error) {
+	return ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+}
+
+// LoadOrCreateKey load the private key From the file, a new key is generated and saved if the file doesn't exist.
+// An ephemeral key is returned if the file path is empty.
+func LoadOrCreateKey(filePath string) (*ecdsa.PrivateKey, error) {
+	if filePath == "" {
+		return GenerateKey()
+	}
+	buf, err := ioutil.ReadFile(filePath)
+	if err == nil {
+		return decodeKey(strings.TrimSpace(string(buf)))
+	}
+	if !os.IsNotExist(err) {
+		return nil, err
+	}
+	key, err := GenerateKey()
+	if err != nil {
+		return nil, err
+	}
+	err = common.WriteFileAtomic(filePath, []byte(hex.EncodeToString(key.D.Bytes())), keyPerm)
+	return key, err
+}
+
+// decode the private key in hex
+func decodeKey(in string) (*ecdsa.PrivateKey, error) {
+	buf, err := hex.DecodeString(in)
+	if err != nil {
+		return nil, err
+	}
+	curve := elliptic.P256()
+	key := &ecdsa.PrivateKey{D: new(big.Int).SetBytes(buf)}
+	if key.D.Sign() <= 0 || key.D.Cmp(curve.Params().N) >= 0 {
+		return nil, errors.New("invalid private key")
+	}
+	key.PublicKey.Curve = curve
+	key.PublicKey.X, key.PublicKey.Y = curve.ScalarBaseMult(buf)
+	return key, nil
+}
+
+// encode the public key in uncompressed form
+func marshalPubkey(pub *ecdsa.PublicKey) []byte {
+	return elliptic.Marshal(elliptic.P256(), pub.X, pub.Y)
+}
+
+// decode the public key in uncompressed form
+func unmarshalPubkey(buf []byte) (*ecdsa.PublicKey, error) {
+	x, y := elliptic.Unmarshal(elliptic.P256(), buf)
+	if x == nil {
+		return nil, errors.New("invalid public key")
+	}
+	return &ecdsa.PublicKey{Curve: elliptic.P256(), X: x, Y: y}, nil
+}
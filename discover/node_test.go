@@ -0,0 +1,82 @@
+package discover
+
+import (
+	"github.com/stretchr/testify/assert"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func mockID(b ...byte) NodeID {
+	var id NodeID
+	copy(id[nodeIDLen-len(b):], b)
+	return id
+}
+
+func TestParseNode(t *testing.T) {
+	assert := assert.New(t)
+	key, _ := GenerateKey()
+	id := PubkeyID(&key.PublicKey)
+	node := NewNode(id, net.ParseIP("192.168.1.1"), 30301, 8080)
+	parsed, err := ParseNode(node.String())
+	assert.Nil(err)
+	assert.Equal(node, parsed)
+
+	parsed, err = ParseNode("dnode://" + id.String() + "@[2001:db8::1]:8080")
+	assert.Nil(err)
+	assert.Equal(uint16(8080), parsed.UDP)
+	assert.Equal("2001:db8::1", parsed.IP.String())
+	assert.Equal("tcp://[2001:db8::1]:8080", parsed.NetAddress().ToString())
+
+	_, err = ParseNode("tcp://" + id.String() + "@192.168.1.1:8080")
+	assert.NotNil(err)
+	_, err = ParseNode("dnode://192.168.1.1:8080")
+	assert.NotNil(err)
+	_, err = ParseNode("dnode://1234@192.168.1.1:8080")
+	assert.NotNil(err)
+	_, err = ParseNode("dnode://" + id.String() + "@host:8080")
+	assert.NotNil(err)
+}
+
+func TestLogDist(t *testing.T) {
+	assert := assert.New(t)
+	assert.Equal(0, logDist(mockID(1), mockID(1)))
+	assert.Equal(1, logDist(mockID(0), mockID(1)))
+	assert.Equal(2, logDist(mockID(1), mockID(2)))
+	assert.Equal(9, logDist(mockID(1, 0), mockID(0, 0)))
+	var a, b NodeID
+	b[0] = 0x80
+	assert.Equal(256, logDist(a, b))
+
+	assert.Equal(-1, distCmp(mockID(0), mockID(1), mockID(2)))
+	assert.Equal(1, distCmp(mockID(3), mockID(0), mockID(2)))
+	assert.Equal(0, distCmp(mockID(3), mockID(1), mockID(1)))
+}
+
+func TestRandomIDAtDist(t *testing.T) {
+	assert := assert.New(t)
+	key, _ := GenerateKey()
+	id := PubkeyID(&key.PublicKey)
+	for _, dist := range []int{0, 1, 7, 8, 9, 100, 255, 256} {
+		assert.Equal(dist, logDist(id, randomIDAtDist(id, dist)))
+	}
+}
+
+func TestLoadOrCreateKey(t *testing.T) {
+	assert := assert.New(t)
+	dir := os.TempDir()
+	path := filepath.Join(dir, "discover_node.key")
+	os.Remove(path)
+	defer os.Remove(path)
+
+	key, err := LoadOrCreateKey(path)
+	assert.Nil(err)
+	key1, err := LoadOrCreateKey(path)
+	assert.Nil(err)
+	assert.Equal(PubkeyID(&key.PublicKey), PubkeyID(&key1.PublicKey))
+
+	key2, err := LoadOrCreateKey("")
+	assert.Nil(err)
+	assert.NotEqual(PubkeyID(&key.PublicKey), PubkeyID(&key2.PublicKey))
+}
@@ -0,0 +1,158 @@
+package discover
+
+import (
+	"crypto/ecdsa"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math/big"
+	"net"
+	"time"
+)
+
+// packet types
+const (
+	pingPacket = iota + 1
+	pongPacket
+	findnodePacket
+	neighborsPacket
+)
+
+const (
+	protocolVersion = 1
+	sigLen          = 64 // r || s of the ecdsa signature
+	headSize        = sigLen + pubkeyLen + 1
+	maxPacketSize   = 1280
+	maxNeighbors    = 6 // max num of nodes in a neighbors packet, so it fits in maxPacketSize
+	expiration      = 20 * time.Second
+)
+
+var (
+	errPacketTooSmall = errors.New("discovery packet is too small")
+	errBadSignature   = errors.New("invalid signature of discovery packet")
+	errExpired        = errors.New("discovery packet is expired")
+	errUnknownPacket  = errors.New("unknown discovery packet type")
+)
+
+// endpoint of a node
+type endpoint struct {
+	IP  net.IP
+	UDP uint16
+	TCP uint16
+}
+
+// a node in neighbors packet
+type rpcNode struct {
+	ID  NodeID
+	IP  net.IP
+	UDP uint16
+	TCP uint16
+}
+
+// ping is sent To check the liveness of a node and prove our endpoint
+type ping struct {
+	Version    uint
+	From       endpoint
+	To         endpoint
+	Expiration int64
+}
+
+// pong is the reply To ping
+type pong struct {
+	To         endpoint // the endpoint the ping was sent From, as seen by the receiver
+	ReplyTok   []byte   // hash of the ping packet
+	Expiration int64
+}
+
+// findnode query the nodes closest To the target
+type findnode struct {
+	Target     NodeID
+	Expiration int64
+}
+
+// neighbors is the reply To findnode
+type neighbors struct {
+	Nodes      []rpcNode
+	Expiration int64
+}
+
+// expiration time of a packet sent now
+func expirationTime() int64 {
+	return time.Now().Add(expiration).Unix()
+}
+
+// whether the packet is expired
+func expired(ts int64) bool {
+	return time.Unix(ts, 0).Before(time.Now())
+}
+
+// encode and sign the packet: signature || public key || type || json body. return the packet and its hash.
+func encodePacket(key *ecdsa.PrivateKey, ptype byte, req interface{}) ([]byte, []byte, error) {
+	body, err := json.Marshal(req)
+	if err != nil {
+		return nil, nil, err
+	}
+	packet := make([]byte, headSize, headSize+len(body))
+	copy(packet[sigLen:], marshalPubkey(&key.PublicKey))
+	packet[sigLen+pubkeyLen] = ptype
+	packet = append(packet, body...)
+	if len(packet) > maxPacketSize {
+		return nil, nil, fmt.Errorf("discovery packet too large: %d", len(packet))
+	}
+
+	digest := sha256.Sum256(packet[sigLen:])
+	r, s, err := ecdsa.Sign(rand.Reader, key, digest[:])
+	if err != nil {
+		return nil, nil, err
+	}
+	putBigInt(packet[:sigLen/2], r)
+	putBigInt(packet[sigLen/2:sigLen], s)
+	hash := sha256.Sum256(packet)
+	return packet, hash[:], nil
+}
+
+// verify and decode the packet, return the packet, the sender id and the packet hash.
+func decodePacket(buf []byte) (interface{}, NodeID, []byte, error) {
+	var from NodeID
+	if len(buf) < headSize+1 {
+		return nil, from, nil, errPacketTooSmall
+	}
+	pub, err := unmarshalPubkey(buf[sigLen : sigLen+pubkeyLen])
+	if err != nil {
+		return nil, from, nil, err
+	}
+	digest := sha256.Sum256(buf[sigLen:])
+	r := new(big.Int).SetBytes(buf[:sigLen/2])
+	s := new(big.Int).SetBytes(buf[sigLen/2 : sigLen])
+	if !ecdsa.Verify(pub, digest[:], r, s) {
+		return nil, from, nil, errBadSignature
+	}
+	from = PubkeyID(pub)
+
+	var req interface{}
+	switch ptype := buf[sigLen+pubkeyLen]; ptype {
+	case pingPacket:
+		req = new(ping)
+	case pongPacket:
+		req = new(pong)
+	case findnodePacket:
+		req = new(findnode)
+	case neighborsPacket:
+		req = new(neighbors)
+	default:
+		return nil, from, nil, errUnknownPacket
+	}
+	if err := json.Unmarshal(buf[headSize:], req); err != nil {
+		return nil, from, nil, err
+	}
+	hash := sha256.Sum256(buf)
+	return req, from, hash[:], nil
+}
+
+// write the big int To the buffer as a big-endian number, padded with leading zeros.
+func putBigInt(buf []byte, v *big.Int) {
+	b := v.Bytes()
+	copy(buf[len(buf)-len(b):], b)
+}
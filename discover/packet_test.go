@@ -0,0 +1,49 @@
+package discover
+
+import (
+	"github.com/stretchr/testify/assert"
+	"net"
+	"testing"
+)
+
+func TestEncodeDecodePacket(t *testing.T) {
+	assert := assert.New(t)
+	key, _ := GenerateKey()
+	req := &findnode{Target: mockID(1, 2, 3), Expiration: expirationTime()}
+	packet, hash, err := encodePacket(key, findnodePacket, req)
+	assert.Nil(err)
+	decoded, from, hash1, err := decodePacket(packet)
+	assert.Nil(err)
+	assert.Equal(req, decoded)
+	assert.Equal(PubkeyID(&key.PublicKey), from)
+	assert.Equal(hash, hash1)
+
+	resp := &neighbors{Expiration: expirationTime()}
+	for i := 0; i < maxNeighbors; i++ {
+		resp.Nodes = append(resp.Nodes, rpcNode{ID: mockID(byte(i)), IP: net.ParseIP("2001:db8::1"), UDP: 30301, TCP: 8080})
+	}
+	packet, _, err = encodePacket(key, neighborsPacket, resp)
+	assert.Nil(err)
+	assert.True(len(packet) <= maxPacketSize)
+	decoded, _, _, err = decodePacket(packet)
+	assert.Nil(err)
+	assert.Equal(maxNeighbors, len(decoded.(*neighbors).Nodes))
+}
+
+func TestDecodePacket_Invalid(t *testing.T) {
+	assert := assert.New(t)
+	key, _ := GenerateKey()
+	packet, _, _ := encodePacket(key, pingPacket, &ping{Version: protocolVersion, Expiration: expirationTime()})
+
+	_, _, _, err := decodePacket(packet[:headSize])
+	assert.Equal(errPacketTooSmall, err)
+
+	tampered := append([]byte(nil), packet...)
+	tampered[len(tampered)-2] ^= 0x01
+	_, _, _, err = decodePacket(tampered)
+	assert.Equal(errBadSignature, err)
+
+	unknown, _, _ := encodePacket(key, 9, &ping{})
+	_, _, _, err = decodePacket(unknown)
+	assert.Equal(errUnknownPacket, err)
+}
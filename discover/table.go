@@ -0,0 +1,192 @@
+package discover
+
+import (
+	"math/rand"
+	"sort"
+	"sync"
+	"time"
+)
+
+const (
+	bucketSize      = 16 // max num of nodes in a bucket, i.e. Kademlia k
+	maxReplacements = 10 // max num of replacement nodes of a bucket
+	nBuckets        = nodeIDLen*8 + 1
+	maxNodeFails    = 5 // nodes failing To answer findnode so many times are removed
+)
+
+// entry of the table
+type tableNode struct {
+	*Node
+	addedAt time.Time
+	fails   int
+}
+
+// bucket contains the nodes at the same log distance, the least recently seen node first.
+type bucket struct {
+	entries      []*tableNode
+	replacements []*tableNode // nodes seen when the bucket is full, used To replace the dead entries
+}
+
+// table is a Kademlia routing table
+type table struct {
+	self    NodeID
+	buckets [nBuckets]*bucket
+	lock    sync.RWMutex
+}
+
+// create a table of the node
+func newTable(self NodeID) *table {
+	tab := &table{self: self}
+	for i := range tab.buckets {
+		tab.buckets[i] = &bucket{}
+	}
+	return tab
+}
+
+// get the bucket of the id
+func (tab *table) bucket(id NodeID) *bucket {
+	return tab.buckets[logDist(tab.self, id)]
+}
+
+// add a verified node To the table. A known node is moved To the tail of its bucket as the most recently seen.
+// If the bucket is full, the node is kept as a replacement. return whether the node is in bucket entries.
+func (tab *table) add(n *Node) bool {
+	if n.ID == tab.self {
+		return false
+	}
+	tab.lock.Lock()
+	defer tab.lock.Unlock()
+	b := tab.bucket(n.ID)
+	for i, e := range b.entries {
+		if e.ID == n.ID {
+			e.Node = n
+			e.fails = 0
+			b.entries = append(append(b.entries[:i], b.entries[i+1:]...), e)
+			return true
+		}
+	}
+	if len(b.entries) < bucketSize {
+		b.entries = append(b.entries, &tableNode{Node: n, addedAt: time.Now()})
+		b.replacements = deleteNode(b.replacements, n.ID)
+		return true
+	}
+	b.replacements = append(deleteNode(b.replacements, n.ID), &tableNode{Node: n, addedAt: time.Now()})
+	if len(b.replacements) > maxReplacements {
+		b.replacements = b.replacements[len(b.replacements)-maxReplacements:]
+	}
+	return false
+}
+
+// record a failure of the node, the node is replaced by the most recently seen replacement if it fails too
+// many times.
+func (tab *table) fail(id NodeID) {
+	tab.lock.Lock()
+	defer tab.lock.Unlock()
+	b := tab.bucket(id)
+	for _, e := range b.entries {
+		if e.ID == id {
+			if e.fails++; e.fails >= maxNodeFails {
+				tab.replace(b, id)
+			}
+			return
+		}
+	}
+}
+
+// remove the node From the table, it's replaced by the most recently seen replacement.
+func (tab *table) remove(id NodeID) {
+	tab.lock.Lock()
+	defer tab.lock.Unlock()
+	tab.replace(tab.bucket(id), id)
+}
+
+// replace the node in bucket with the last replacement
+func (tab *table) replace(b *bucket, id NodeID) {
+	b.entries = deleteNode(b.entries, id)
+	if len(b.replacements) > 0 && len(b.entries) < bucketSize {
+		last := b.replacements[len(b.replacements)-1]
+		b.replacements = b.replacements[:len(b.replacements)-1]
+		b.entries = append(b.entries, last)
+	}
+}
+
+// get the least recently seen node of the bucket the id belongs To, return nil if the bucket is not full.
+func (tab *table) oldestIfFull(id NodeID) *Node {
+	tab.lock.RLock()
+	defer tab.lock.RUnlock()
+	b := tab.bucket(id)
+	if len(b.entries) < bucketSize {
+		return nil
+	}
+	return b.entries[0].Node
+}
+
+// get at most num nodes closest To the target
+func (tab *table) closest(target NodeID, num int) []*Node {
+	tab.lock.RLock()
+	nodes := make([]*Node, 0)
+	for _, b := range tab.buckets {
+		for _, e := range b.entries {
+			nodes = append(nodes, e.Node)
+		}
+	}
+	tab.lock.RUnlock()
+	sortByDistance(nodes, target)
+	if len(nodes) > num {
+		nodes = nodes[:num]
+	}
+	return nodes
+}
+
+// get all nodes in the table
+func (tab *table) nodes() []*Node {
+	tab.lock.RLock()
+	defer tab.lock.RUnlock()
+	nodes := make([]*Node, 0)
+	for _, b := range tab.buckets {
+		for _, e := range b.entries {
+			nodes = append(nodes, e.Node)
+		}
+	}
+	return nodes
+}
+
+// get at most num random nodes in the table
+func (tab *table) randomNodes(num int) []*Node {
+	nodes := tab.nodes()
+	rand.Shuffle(len(nodes), func(i, j int) {
+		nodes[i], nodes[j] = nodes[j], nodes[i]
+	})
+	if len(nodes) > num {
+		nodes = nodes[:num]
+	}
+	return nodes
+}
+
+// get the num of nodes in the table
+func (tab *table) len() int {
+	tab.lock.RLock()
+	defer tab.lock.RUnlock()
+	count := 0
+	for _, b := range tab.buckets {
+		count += len(b.entries)
+	}
+	return count
+}
+
+// sort the nodes by the distance To the target, the closest first.
+func sortByDistance(nodes []*Node, target NodeID) {
+	sort.Slice(nodes, func(i, j int) bool {
+		return distCmp(target, nodes[i].ID, nodes[j].ID) < 0
+	})
+}
+
+// delete the node From the list
+func deleteNode(list []*tableNode, id NodeID) []*tableNode {
+	for i, e := range list {
+		if e.ID == id {
+			return append(list[:i], list[i+1:]...)
+		}
+	}
+	return list
+}
@@ -0,0 +1,78 @@
+package discover
+
+import (
+	"github.com/stretchr/testify/assert"
+	"net"
+	"testing"
+)
+
+// mock a node at the log distance To self
+func mockNode(self NodeID, dist int) *Node {
+	return NewNode(randomIDAtDist(self, dist), net.ParseIP("192.168.1.1"), 30301, 8080)
+}
+
+func TestTable_Add(t *testing.T) {
+	assert := assert.New(t)
+	self := mockID(1)
+	tab := newTable(self)
+	assert.False(tab.add(NewNode(self, net.ParseIP("192.168.1.1"), 30301, 8080)))
+
+	nodes := make([]*Node, 0)
+	for i := 0; i < bucketSize; i++ {
+		n := mockNode(self, 200)
+		nodes = append(nodes, n)
+		assert.True(tab.add(n))
+	}
+	assert.Equal(bucketSize, tab.len())
+	assert.Nil(tab.oldestIfFull(mockNode(self, 100).ID))
+	assert.Equal(nodes[0], tab.oldestIfFull(mockNode(self, 200).ID))
+
+	// full bucket, kept as replacement
+	replacement := mockNode(self, 200)
+	assert.False(tab.add(replacement))
+	assert.Equal(bucketSize, tab.len())
+
+	// seen again, moved To the tail
+	assert.True(tab.add(nodes[0]))
+	assert.Equal(nodes[1], tab.oldestIfFull(replacement.ID))
+
+	// replaced after failing too many times
+	for i := 0; i < maxNodeFails; i++ {
+		tab.fail(nodes[1].ID)
+	}
+	assert.Equal(bucketSize, tab.len())
+	assert.Equal(nodes[2], tab.oldestIfFull(replacement.ID))
+	assert.Contains(tab.nodes(), replacement)
+	assert.NotContains(tab.nodes(), nodes[1])
+
+	tab.remove(nodes[2].ID)
+	assert.Equal(bucketSize-1, tab.len())
+}
+
+func TestTable_Closest(t *testing.T) {
+	assert := assert.New(t)
+	self := mockID(1)
+	tab := newTable(self)
+	for dist := 1; dist <= 256; dist += 5 {
+		tab.add(mockNode(self, dist))
+	}
+	target := randomIDAtDist(self, 100)
+	closest := tab.closest(target, 5)
+	assert.Equal(5, len(closest))
+	for i := 1; i < len(closest); i++ {
+		assert.True(distCmp(target, closest[i-1].ID, closest[i].ID) < 0)
+	}
+	for _, n := range tab.nodes() {
+		assert.True(distCmp(target, closest[len(closest)-1].ID, n.ID) <= 0 || containsNode(closest, n.ID))
+	}
+	assert.Equal(3, len(tab.randomNodes(3)))
+}
+
+func containsNode(nodes []*Node, id NodeID) bool {
+	for _, n := range nodes {
+		if n.ID == id {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,608 @@
+package discover
+
+import (
+	"bytes"
+	"crypto/ecdsa"
+	"errors"
+	"github.com/DSiSc/craft/log"
+	"net"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+const (
+	alpha           = 3 // concurrency of lookup
+	respTimeout     = 500 * time.Millisecond
+	bondExpiration  = 24 * time.Hour // endpoint proof is valid for so long
+	maxBonds        = 10000          // max num of endpoint proofs recorded in each direction
+	maxBackPings    = 16             // max num of concurrent pings back To the unproved nodes pinging us
+	refreshInterval = 30 * time.Minute
+	randomLookups   = 3 // num of random target lookups in a refresh
+)
+
+var (
+	errTimeout     = errors.New("discovery request timeout")
+	errClosed      = errors.New("discovery is closed")
+	errUnknownNode = errors.New("node has not proved its endpoint")
+)
+
+// Config is the config of discovery
+type Config struct {
+	PrivateKey *ecdsa.PrivateKey // key of the node, the node id is derived From it
+	ListenAddr string            // udp listen address
+	TCPPort    uint16            // port of p2p protocol advertised To other nodes
+	ExternalIP net.IP            // ip advertised To other nodes, the listen ip is used if nil and it's specified
+	Bootnodes  []*Node           // nodes used To join the network when the table is empty
+	OnNode     func(n *Node)     // called when a node has proved its endpoint
+}
+
+// a reply expected From a node
+type pendingReply struct {
+	from     NodeID
+	ptype    byte
+	callback func(req interface{}) bool // handle a matched reply, return true if no more replies expected
+	errc     chan error
+}
+
+// Discovery is the node discovery service
+type Discovery struct {
+	key          *ecdsa.PrivateKey
+	self         NodeID
+	tcpPort      uint16
+	externalIP   atomic.Value // net.IP advertised To other nodes, nil if unknown
+	conn         *net.UDPConn
+	tab          *table
+	bootnodes    []*Node
+	onNode       func(n *Node)
+	pending      []*pendingReply
+	backPings    map[string]struct{} // endpoints being pinged back, guarded by pendingLock
+	backPingSem  chan struct{}
+	pendingLock  sync.Mutex
+	pingRecvTime *bondTimes // when the node pinged us, i.e. the node has our endpoint proof
+	pongRecvTime *bondTimes // when the node answered our ping, i.e. its endpoint is proved
+	quitChan     chan struct{}
+	wg           sync.WaitGroup
+}
+
+// New create a discovery instance listening on the address
+func New(config *Config) (*Discovery, error) {
+	if config.PrivateKey == nil {
+		return nil, errors.New("private key of discovery is not specified")
+	}
+	addr, err := net.ResolveUDPAddr("udp", config.ListenAddr)
+	if err != nil {
+		return nil, err
+	}
+	conn, err := net.ListenUDP("udp", addr)
+	if err != nil {
+		return nil, err
+	}
+	d := &Discovery{
+		key:          config.PrivateKey,
+		self:         PubkeyID(&config.PrivateKey.PublicKey),
+		tcpPort:      config.TCPPort,
+		conn:         conn,
+		tab:          newTable(PubkeyID(&config.PrivateKey.PublicKey)),
+		bootnodes:    config.Bootnodes,
+		onNode:       config.OnNode,
+		backPings:    make(map[string]struct{}),
+		backPingSem:  make(chan struct{}, maxBackPings),
+		pingRecvTime: newBondTimes(),
+		pongRecvTime: newBondTimes(),
+		quitChan:     make(chan struct{}),
+	}
+	externalIP := config.ExternalIP
+	if externalIP == nil && addr.IP != nil && !addr.IP.IsUnspecified() {
+		externalIP = addr.IP
+	}
+	d.SetExternalIP(externalIP)
+	return d, nil
+}
+
+// Start start serving the packets and refreshing the table
+func (d *Discovery) Start() {
+	log.Info("start node discovery %s on %s", d.self.String(), d.conn.LocalAddr().String())
+	d.wg.Add(2)
+	go d.readLoop()
+	go d.refreshLoop()
+}
+
+// Stop stop the discovery
+func (d *Discovery) Stop() {
+	close(d.quitChan)
+	d.conn.Close()
+	d.wg.Wait()
+}
+
+// Self get our node, the ip is nil if our external ip is unknown, then the receivers of our packets use the
+// source address instead.
+func (d *Discovery) Self() *Node {
+	addr := d.conn.LocalAddr().(*net.UDPAddr)
+	ip, _ := d.externalIP.Load().(net.IP)
+	return NewNode(d.self, ip, uint16(addr.Port), d.tcpPort)
+}
+
+// SetExternalIP set the ip advertised To other nodes, e.g. when our external ip is learned From peers.
+func (d *Discovery) SetExternalIP(ip net.IP) {
+	d.externalIP.Store(ip)
+}
+
+// Nodes get all nodes in table
+func (d *Discovery) Nodes() []*Node {
+	return d.tab.nodes()
+}
+
+// LookupRandom look up the nodes closest To a random target, which fill the table with the nodes discovered.
+func (d *Discovery) LookupRandom() []*Node {
+	return d.Lookup(randomIDAtDist(d.self, nBuckets-1))
+}
+
+// Lookup look up the nodes closest To the target iteratively, by querying the closest nodes known so far
+// until no closer node is found.
+func (d *Discovery) Lookup(target NodeID) []*Node {
+	if d.tab.len() == 0 {
+		d.bootstrap()
+	}
+	asked := map[NodeID]bool{d.self: true}
+	seen := map[NodeID]bool{d.self: true}
+	result := d.tab.closest(target, bucketSize)
+	for _, n := range result {
+		seen[n.ID] = true
+	}
+	reply := make(chan []*Node, alpha)
+	pending := 0
+	for {
+		for i := 0; i < len(result) && pending < alpha; i++ {
+			if n := result[i]; !asked[n.ID] {
+				asked[n.ID] = true
+				pending++
+				go func(n *Node) {
+					reply <- d.query(n, target)
+				}(n)
+			}
+		}
+		if pending == 0 {
+			break
+		}
+		for _, n := range <-reply {
+			if !seen[n.ID] {
+				seen[n.ID] = true
+				result = append(result, n)
+			}
+		}
+		pending--
+		sortByDistance(result, target)
+		if len(result) > bucketSize {
+			result = result[:bucketSize]
+		}
+	}
+	return result
+}
+
+// bond with the boot nodes
+func (d *Discovery) bootstrap() {
+	var wg sync.WaitGroup
+	for _, n := range d.bootnodes {
+		wg.Add(1)
+		go func(n *Node) {
+			defer wg.Done()
+			if err := d.ping(n); err != nil {
+				log.Debug("failed To ping boot node %s, as: %v", n.String(), err)
+			}
+		}(n)
+	}
+	wg.Wait()
+}
+
+// query the nodes closest To the target From the node
+func (d *Discovery) query(n *Node, target NodeID) []*Node {
+	if err := d.ensureBond(n); err != nil {
+		log.Debug("failed To bond with node %s, as: %v", n.String(), err)
+		d.tab.fail(n.ID)
+		return nil
+	}
+	nodes, err := d.findnode(n, target)
+	if err != nil {
+		log.Debug("failed To find node From %s, as: %v", n.String(), err)
+		d.tab.fail(n.ID)
+	}
+	return nodes
+}
+
+// make sure the node has our endpoint proof, so it will answer our findnode. If the node hasn't pinged us
+// recently, we ping it and wait for its ping back.
+func (d *Discovery) ensureBond(n *Node) error {
+	if d.pingRecvTime.recent(n.ID, n.IP) {
+		return nil
+	}
+	waitPing := d.addPending(n.ID, pingPacket, func(req interface{}) bool { return true })
+	defer d.removePending(waitPing)
+	if err := d.ping(n); err != nil {
+		return err
+	}
+	// the node may have our proof already and won't ping back
+	d.wait(waitPing)
+	return nil
+}
+
+// ping the node and wait for its pong, the node is verified if it answered.
+func (d *Discovery) ping(n *Node) error {
+	req := &ping{
+		Version:    protocolVersion,
+		From:       endpoint{IP: d.Self().IP, UDP: d.Self().UDP, TCP: d.tcpPort},
+		To:         endpoint{IP: n.IP, UDP: n.UDP, TCP: n.TCP},
+		Expiration: expirationTime(),
+	}
+	packet, hash, err := encodePacket(d.key, pingPacket, req)
+	if err != nil {
+		return err
+	}
+	p := d.addPending(n.ID, pongPacket, func(req interface{}) bool {
+		if !bytes.Equal(req.(*pong).ReplyTok, hash) {
+			return false
+		}
+		// record the proof before handling the next packet, which may be a findnode From the node
+		d.pongRecvTime.store(n.ID, n.IP, time.Now())
+		return true
+	})
+	defer d.removePending(p)
+	if err := d.write(n.UDPAddr(), packet); err != nil {
+		return err
+	}
+	if err := d.wait(p); err != nil {
+		return err
+	}
+	d.addVerified(n)
+	return nil
+}
+
+// query the nodes closest To the target From the node
+func (d *Discovery) findnode(n *Node, target NodeID) ([]*Node, error) {
+	nodes := make([]*Node, 0, bucketSize)
+	received := 0
+	var lock sync.Mutex
+	p := d.addPending(n.ID, neighborsPacket, func(req interface{}) bool {
+		lock.Lock()
+		defer lock.Unlock()
+		received++
+		for _, rn := range req.(*neighbors).Nodes {
+			if node := d.checkNode(rn); node != nil {
+				nodes = append(nodes, node)
+			}
+		}
+		return len(nodes) >= bucketSize
+	})
+	defer d.removePending(p)
+	err := d.send(n.UDPAddr(), findnodePacket, &findnode{Target: target, Expiration: expirationTime()})
+	if err != nil {
+		return nil, err
+	}
+	err = d.wait(p)
+	lock.Lock()
+	defer lock.Unlock()
+	// the node may have less than bucketSize nodes
+	if err == errTimeout && received > 0 {
+		err = nil
+	}
+	return nodes, err
+}
+
+// check the node in neighbors packet, return nil if it's invalid
+func (d *Discovery) checkNode(rn rpcNode) *Node {
+	if rn.ID == d.self || rn.IP == nil || rn.IP.IsUnspecified() || rn.IP.IsMulticast() || rn.UDP == 0 {
+		return nil
+	}
+	return NewNode(rn.ID, rn.IP, rn.UDP, rn.TCP)
+}
+
+// add the verified node To table. If its bucket is full, the least recently seen node is pinged, and it's
+// replaced if it doesn't answer.
+func (d *Discovery) addVerified(n *Node) {
+	if !d.tab.add(n) {
+		if oldest := d.tab.oldestIfFull(n.ID); oldest != nil {
+			go func() {
+				if err := d.ping(oldest); err != nil {
+					d.tab.remove(oldest.ID)
+				}
+			}()
+		}
+	}
+	if d.onNode != nil && n.TCP != 0 {
+		d.onNode(n)
+	}
+}
+
+// refresh the table and drop the expired endpoint proofs periodically
+func (d *Discovery) refreshLoop() {
+	defer d.wg.Done()
+	ticker := time.NewTicker(refreshInterval)
+	defer ticker.Stop()
+	for {
+		d.pingRecvTime.expire(time.Now())
+		d.pongRecvTime.expire(time.Now())
+		d.refresh()
+		select {
+		case <-ticker.C:
+		case <-d.quitChan:
+			return
+		}
+	}
+}
+
+// look up ourselves and some random targets To fill the table
+func (d *Discovery) refresh() {
+	d.Lookup(d.self)
+	for i := 0; i < randomLookups; i++ {
+		select {
+		case <-d.quitChan:
+			return
+		default:
+		}
+		d.LookupRandom()
+	}
+	log.Debug("discovery table refreshed, %d nodes in table", d.tab.len())
+}
+
+// read and handle the packets
+func (d *Discovery) readLoop() {
+	defer d.wg.Done()
+	buf := make([]byte, maxPacketSize)
+	for {
+		n, from, err := d.conn.ReadFromUDP(buf)
+		if err != nil {
+			select {
+			case <-d.quitChan:
+			default:
+				log.Error("failed To read discovery packet, as: %v", err)
+			}
+			return
+		}
+		if err := d.handlePacket(from, buf[:n]); err != nil {
+			log.Debug("bad discovery packet From %s, as: %v", from.String(), err)
+		}
+	}
+}
+
+// handle a packet
+func (d *Discovery) handlePacket(from *net.UDPAddr, buf []byte) error {
+	req, fromID, hash, err := decodePacket(buf)
+	if err != nil {
+		return err
+	}
+	switch req := req.(type) {
+	case *ping:
+		return d.handlePing(from, fromID, req, hash)
+	case *pong:
+		return d.handleReply(fromID, pongPacket, req, req.Expiration)
+	case *findnode:
+		return d.handleFindnode(from, fromID, req)
+	case *neighbors:
+		return d.handleReply(fromID, neighborsPacket, req, req.Expiration)
+	}
+	return errUnknownPacket
+}
+
+// answer the ping, and ping back if the node hasn't proved its endpoint.
+func (d *Discovery) handlePing(from *net.UDPAddr, fromID NodeID, req *ping, hash []byte) error {
+	if expired(req.Expiration) {
+		return errExpired
+	}
+	resp := &pong{
+		To:         endpoint{IP: from.IP, UDP: uint16(from.Port), TCP: req.From.TCP},
+		ReplyTok:   hash,
+		Expiration: expirationTime(),
+	}
+	if err := d.send(from, pongPacket, resp); err != nil {
+		return err
+	}
+	d.pingRecvTime.store(fromID, from.IP, time.Now())
+	d.handleReply(fromID, pingPacket, req, req.Expiration)
+
+	n := NewNode(fromID, from.IP, uint16(from.Port), req.From.TCP)
+	if d.pongRecvTime.recent(fromID, from.IP) {
+		d.addVerified(n)
+	} else {
+		d.pingBack(n)
+	}
+	return nil
+}
+
+// ping back the node pinging us To prove its endpoint. As a signed ping can be replayed From spoofed sources,
+// the ping is skipped if the node is being pinged, or too many nodes are being pinged back.
+func (d *Discovery) pingBack(n *Node) {
+	key := bondKey(n.ID, n.IP)
+	d.pendingLock.Lock()
+	if _, ok := d.backPings[key]; ok || d.pingPendingLocked(n.ID) {
+		d.pendingLock.Unlock()
+		return
+	}
+	select {
+	case d.backPingSem <- struct{}{}:
+	default:
+		d.pendingLock.Unlock()
+		log.Debug("too many pings back, skip node %s", n.String())
+		return
+	}
+	d.backPings[key] = struct{}{}
+	d.pendingLock.Unlock()
+
+	d.wg.Add(1)
+	go func() {
+		defer func() {
+			d.pendingLock.Lock()
+			delete(d.backPings, key)
+			d.pendingLock.Unlock()
+			<-d.backPingSem
+			d.wg.Done()
+		}()
+		d.ping(n)
+	}()
+}
+
+// check whether we are waiting for a pong From the node
+func (d *Discovery) pingPendingLocked(id NodeID) bool {
+	for _, p := range d.pending {
+		if p.from == id && p.ptype == pongPacket {
+			return true
+		}
+	}
+	return false
+}
+
+// answer the findnode From the node which has proved its endpoint
+func (d *Discovery) handleFindnode(from *net.UDPAddr, fromID NodeID, req *findnode) error {
+	if expired(req.Expiration) {
+		return errExpired
+	}
+	if !d.pongRecvTime.recent(fromID, from.IP) {
+		return errUnknownNode
+	}
+	closest := d.tab.closest(req.Target, bucketSize)
+	resp := &neighbors{Expiration: expirationTime()}
+	for i, n := range closest {
+		resp.Nodes = append(resp.Nodes, rpcNode{ID: n.ID, IP: n.IP, UDP: n.UDP, TCP: n.TCP})
+		if len(resp.Nodes) == maxNeighbors || i == len(closest)-1 {
+			if err := d.send(from, neighborsPacket, resp); err != nil {
+				return err
+			}
+			resp.Nodes = nil
+		}
+	}
+	// tell the node we know nothing, so it won't wait for the timeout
+	if len(closest) == 0 {
+		return d.send(from, neighborsPacket, resp)
+	}
+	return nil
+}
+
+// deliver the reply To the pending request matching it
+func (d *Discovery) handleReply(fromID NodeID, ptype byte, req interface{}, expiration int64) error {
+	if expired(expiration) {
+		return errExpired
+	}
+	d.pendingLock.Lock()
+	defer d.pendingLock.Unlock()
+	for i, p := range d.pending {
+		if p.from == fromID && p.ptype == ptype && p.callback(req) {
+			p.errc <- nil
+			d.pending = append(d.pending[:i], d.pending[i+1:]...)
+			return nil
+		}
+	}
+	return nil
+}
+
+// register a pending reply
+func (d *Discovery) addPending(from NodeID, ptype byte, callback func(req interface{}) bool) *pendingReply {
+	p := &pendingReply{from: from, ptype: ptype, callback: callback, errc: make(chan error, 1)}
+	d.pendingLock.Lock()
+	d.pending = append(d.pending, p)
+	d.pendingLock.Unlock()
+	return p
+}
+
+// remove the pending reply
+func (d *Discovery) removePending(p *pendingReply) {
+	d.pendingLock.Lock()
+	defer d.pendingLock.Unlock()
+	for i, e := range d.pending {
+		if e == p {
+			d.pending = append(d.pending[:i], d.pending[i+1:]...)
+			return
+		}
+	}
+}
+
+// wait for the pending reply
+func (d *Discovery) wait(p *pendingReply) error {
+	timer := time.NewTimer(respTimeout)
+	defer timer.Stop()
+	select {
+	case err := <-p.errc:
+		return err
+	case <-timer.C:
+		return errTimeout
+	case <-d.quitChan:
+		return errClosed
+	}
+}
+
+// encode and send the packet
+func (d *Discovery) send(to *net.UDPAddr, ptype byte, req interface{}) error {
+	packet, _, err := encodePacket(d.key, ptype, req)
+	if err != nil {
+		return err
+	}
+	return d.write(to, packet)
+}
+
+// write the packet To the address
+func (d *Discovery) write(to *net.UDPAddr, packet []byte) error {
+	_, err := d.conn.WriteToUDP(packet, to)
+	return err
+}
+
+// key of endpoint proof
+func bondKey(id NodeID, ip net.IP) string {
+	return id.String() + "@" + ip.String()
+}
+
+// bondTimes records the time of the endpoint proofs, keyed by node id and ip. As anyone can ping us with new
+// keys, the expired proofs are dropped periodically, and at most maxBonds proofs are kept.
+type bondTimes struct {
+	times map[string]time.Time
+	lock  sync.Mutex
+}
+
+// create a bondTimes instance
+func newBondTimes() *bondTimes {
+	return &bondTimes{times: make(map[string]time.Time)}
+}
+
+// record the proof of the node, an arbitrary proof is dropped if it's full even after expiring.
+func (b *bondTimes) store(id NodeID, ip net.IP, t time.Time) {
+	key := bondKey(id, ip)
+	b.lock.Lock()
+	defer b.lock.Unlock()
+	if _, ok := b.times[key]; !ok && len(b.times) >= maxBonds {
+		b.expireLocked(t)
+		for k := range b.times {
+			if len(b.times) < maxBonds {
+				break
+			}
+			delete(b.times, k)
+		}
+	}
+	b.times[key] = t
+}
+
+// whether the proof of the node is within bondExpiration
+func (b *bondTimes) recent(id NodeID, ip net.IP) bool {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+	t, ok := b.times[bondKey(id, ip)]
+	return ok && time.Since(t) < bondExpiration
+}
+
+// drop the proofs expired at the time
+func (b *bondTimes) expire(now time.Time) {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+	b.expireLocked(now)
+}
+
+func (b *bondTimes) expireLocked(now time.Time) {
+	for key, t := range b.times {
+		if now.Sub(t) >= bondExpiration {
+			delete(b.times, key)
+		}
+	}
+}
+
+// num of the proofs recorded
+func (b *bondTimes) len() int {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+	return len(b.times)
+}
@@ -0,0 +1,195 @@
+package discover
+
+import (
+	"github.com/stretchr/testify/assert"
+	"net"
+	"sync"
+	"testing"
+	"time"
+)
+
+// start a discovery listening on local host
+func mockDiscovery(t *testing.T, bootnodes ...*Node) (*Discovery, *sync.Map) {
+	key, _ := GenerateKey()
+	verified := new(sync.Map)
+	d, err := New(&Config{
+		PrivateKey: key,
+		ListenAddr: "127.0.0.1:0",
+		TCPPort:    8080,
+		Bootnodes:  bootnodes,
+		OnNode: func(n *Node) {
+			verified.Store(n.ID, n)
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	// serve packets only, the table is refreshed manually in test
+	d.wg.Add(1)
+	go d.readLoop()
+	return d, verified
+}
+
+func TestDiscovery_Ping(t *testing.T) {
+	assert := assert.New(t)
+	a, verifiedA := mockDiscovery(t)
+	defer a.Stop()
+	b, verifiedB := mockDiscovery(t)
+	defer b.Stop()
+
+	assert.Nil(a.ensureBond(b.Self()))
+	assert.Equal(1, a.tab.len())
+	_, ok := verifiedA.Load(b.self)
+	assert.True(ok)
+	// b pinged a back, so they are verified by each other
+	time.Sleep(100 * time.Millisecond)
+	assert.Equal(1, b.tab.len())
+	n, ok := verifiedB.Load(a.self)
+	assert.True(ok)
+	assert.Equal(uint16(8080), n.(*Node).TCP)
+	assert.True(a.pingRecvTime.recent(b.self, b.Self().IP))
+}
+
+func TestDiscovery_FindnodeWithoutProof(t *testing.T) {
+	assert := assert.New(t)
+	a, _ := mockDiscovery(t)
+	defer a.Stop()
+	b, _ := mockDiscovery(t)
+	defer b.Stop()
+
+	// b doesn't answer as a hasn't proved its endpoint
+	_, err := a.findnode(b.Self(), a.self)
+	assert.Equal(errTimeout, err)
+
+	assert.Nil(a.ensureBond(b.Self()))
+	nodes, err := a.findnode(b.Self(), a.self)
+	assert.Nil(err)
+	assert.Equal(0, len(nodes))
+}
+
+func TestDiscovery_Lookup(t *testing.T) {
+	assert := assert.New(t)
+	boot, _ := mockDiscovery(t)
+	defer boot.Stop()
+	nodes := make([]*Discovery, 0)
+	for i := 0; i < 5; i++ {
+		d, _ := mockDiscovery(t, boot.Self())
+		defer d.Stop()
+		d.Lookup(d.self)
+		nodes = append(nodes, d)
+	}
+	assert.Equal(5, boot.tab.len())
+
+	// a new node learns all the others From the boot node
+	d, verified := mockDiscovery(t, boot.Self())
+	defer d.Stop()
+	result := d.LookupRandom()
+	assert.Equal(6, len(result))
+	for _, n := range nodes {
+		assert.True(containsNode(d.Nodes(), n.self))
+		_, ok := verified.Load(n.self)
+		assert.True(ok)
+	}
+}
+
+func TestBondTimes(t *testing.T) {
+	assert := assert.New(t)
+	b := newBondTimes()
+	now := time.Now()
+	ip := net.ParseIP("192.168.1.1")
+	old, fresh := NodeID{1}, NodeID{2}
+	b.store(old, ip, now.Add(-bondExpiration))
+	b.store(fresh, ip, now)
+	assert.False(b.recent(old, ip))
+	assert.True(b.recent(fresh, ip))
+	assert.False(b.recent(fresh, net.ParseIP("192.168.1.2")))
+
+	// expired proofs are dropped
+	b.expire(now)
+	assert.Equal(1, b.len())
+	b.expire(now.Add(bondExpiration))
+	assert.Equal(0, b.len())
+
+	// the size is bounded
+	for i := 0; i < maxBonds+10; i++ {
+		var id NodeID
+		id[0], id[1], id[2] = byte(i>>8), byte(i), 1
+		b.store(id, ip, now)
+	}
+	assert.Equal(maxBonds, b.len())
+	b.store(fresh, ip, now)
+	assert.True(b.recent(fresh, ip))
+	assert.Equal(maxBonds, b.len())
+}
+
+func TestDiscovery_Self(t *testing.T) {
+	assert := assert.New(t)
+	key, _ := GenerateKey()
+	// the wildcard listen ip is not advertised
+	d, err := New(&Config{PrivateKey: key, ListenAddr: "0.0.0.0:0", TCPPort: 8080})
+	assert.Nil(err)
+	defer d.conn.Close()
+	assert.Nil(d.Self().IP)
+	assert.Equal(uint16(8080), d.Self().TCP)
+	assert.NotEqual(uint16(0), d.Self().UDP)
+	d.SetExternalIP(net.ParseIP("8.8.8.8"))
+	assert.Equal("8.8.8.8", d.Self().IP.String())
+
+	d1, err := New(&Config{PrivateKey: key, ListenAddr: "0.0.0.0:0", ExternalIP: net.ParseIP("1.2.3.4")})
+	assert.Nil(err)
+	defer d1.conn.Close()
+	assert.Equal("1.2.3.4", d1.Self().IP.String())
+
+	d2, err := New(&Config{PrivateKey: key, ListenAddr: "127.0.0.1:0"})
+	assert.Nil(err)
+	defer d2.conn.Close()
+	assert.Equal("127.0.0.1", d2.Self().IP.String())
+}
+
+func TestDiscovery_PingWithoutIP(t *testing.T) {
+	assert := assert.New(t)
+	a, _ := mockDiscovery(t)
+	defer a.Stop()
+	b, verifiedB := mockDiscovery(t)
+	defer b.Stop()
+
+	// b learns a's ip From the packet source
+	a.SetExternalIP(nil)
+	assert.Nil(a.ensureBond(b.Self()))
+	time.Sleep(100 * time.Millisecond)
+	n, ok := verifiedB.Load(a.self)
+	assert.True(ok)
+	assert.Equal("127.0.0.1", n.(*Node).IP.String())
+}
+
+func TestDiscovery_PingBackLimit(t *testing.T) {
+	assert := assert.New(t)
+	d, _ := mockDiscovery(t)
+	defer d.Stop()
+	// a node never answering
+	silent, err := net.ListenUDP("udp", &net.UDPAddr{IP: net.ParseIP("127.0.0.1")})
+	assert.Nil(err)
+	defer silent.Close()
+	port := uint16(silent.LocalAddr().(*net.UDPAddr).Port)
+
+	// the node being pinged is not pinged again
+	n := NewNode(mockID(1), net.ParseIP("127.0.0.1"), port, 8080)
+	d.pingBack(n)
+	d.pingBack(n)
+	assert.Equal(1, len(d.backPingSem))
+
+	for i := 0; i < maxBackPings+5; i++ {
+		d.pingBack(NewNode(mockID(2, byte(i)), net.ParseIP("127.0.0.1"), port, 8080))
+	}
+	assert.Equal(maxBackPings, len(d.backPingSem))
+	d.pendingLock.Lock()
+	assert.Equal(maxBackPings, len(d.backPings))
+	d.pendingLock.Unlock()
+
+	// the slots are freed on timeout
+	time.Sleep(respTimeout + 200*time.Millisecond)
+	assert.Equal(0, len(d.backPingSem))
+	d.pendingLock.Lock()
+	assert.Equal(0, len(d.backPings))
+	d.pendingLock.Unlock()
+}
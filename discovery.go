@@ -0,0 +1,133 @@
+package p2p
+
+import (
+	"github.com/DSiSc/craft/log"
+	"github.com/DSiSc/p2p/common"
+	"github.com/DSiSc/p2p/discover"
+	"github.com/DSiSc/p2p/mdns"
+	"github.com/DSiSc/p2p/version"
+	"net"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+const nodeKeyFileName = "node.key" // node key file is in the same directory as address book file
+
+// get the node key file path, return empty string if address book is not persisted, so an ephemeral key is used.
+func nodeKeyFilePath(addrBookPath string) string {
+	if addrBookPath == "" {
+		return ""
+	}
+	return filepath.Join(filepath.Dir(addrBookPath), nodeKeyFileName)
+}
+
+// parse the boot nodes of discovery, invalid ones are ignored.
+func parseBootnodes(bootnodes string) []*discover.Node {
+	nodes := make([]*discover.Node, 0)
+	for _, url := range strings.Split(bootnodes, ",") {
+		url = strings.TrimSpace(url)
+		if url == "" {
+			continue
+		}
+		node, err := discover.ParseNode(url)
+		if err != nil {
+			log.Warn("invalid boot node %s, as: %v", url, err)
+			continue
+		}
+		nodes = append(nodes, node)
+	}
+	return nodes
+}
+
+// start the node discovery if discovery address is specified, the nodes discovered are added To address book.
+func (service *P2P) startDiscovery() error {
+	if service.config.DiscoveryAddr == "" {
+		return nil
+	}
+	key, err := discover.LoadOrCreateKey(nodeKeyFilePath(service.config.AddrBookFilePath))
+	if err != nil {
+		return err
+	}
+	discovery, err := discover.New(&discover.Config{
+		PrivateKey: key,
+		ListenAddr: service.config.DiscoveryAddr,
+		ExternalIP: service.advertisedIP(),
+		TCPPort:    uint16(service.addr.Port),
+		Bootnodes:  parseBootnodes(service.config.Bootnodes),
+		OnNode:     service.onDiscoveredNode,
+	})
+	if err != nil {
+		return err
+	}
+	log.Info("node discovery started, id: %s, ip: %v", discovery.Self().ID.String(), discovery.Self().IP)
+	discovery.Start()
+	service.discovery = discovery
+	return nil
+}
+
+// get the ip advertised To discovered nodes, which is our external ip if known, or the listen ip if it's
+// specified. return nil if neither is known.
+func (service *P2P) advertisedIP() net.IP {
+	if external := service.ExternalAddress(); external != nil {
+		return external.ParsedIP()
+	}
+	if ip := service.addr.ParsedIP(); ip != nil && !ip.IsUnspecified() {
+		return ip
+	}
+	return nil
+}
+
+// add the node discovered To address book, its endpoint is proved so it's trusted as observed by ourselves.
+func (service *P2P) onDiscoveredNode(node *discover.Node) {
+	addr := node.NetAddress()
+	if service.addrManager.IsOurAddress(addr) {
+		return
+	}
+	service.addrManager.AddAddress(addr)
+}
+
+// start the mDNS responder and browser if enabled, the compatible LAN peers discovered are added To address book.
+func (service *P2P) startMDNS() error {
+	if !service.config.MDNS {
+		return nil
+	}
+	lan, err := mdns.New(service.mdnsConfig())
+	if err != nil {
+		return err
+	}
+	if err := lan.Start(); err != nil {
+		return err
+	}
+	service.mdns = lan
+	return nil
+}
+
+// get the config of mDNS, our listen ip is advertised if it's specified, otherwise all interface ips.
+func (service *P2P) mdnsConfig() mdns.Config {
+	conf := mdns.Config{
+		Port:      uint16(service.addr.Port),
+		Service:   service.service,
+		Version:   service.version,
+		Interface: service.config.MDNSInterface,
+		OnEntry:   service.onLANEntry,
+	}
+	if ip := service.addr.ParsedIP(); ip != nil && !ip.IsUnspecified() {
+		conf.IPs = []net.IP{ip}
+	}
+	return conf
+}
+
+// add the LAN peer discovered To address book if it's compatible with us
+func (service *P2P) onLANEntry(entry *mdns.Entry) {
+	if !service.compatible(entry.Service) || !version.Accept(entry.Version) {
+		log.Debug("ignore incompatible LAN peer %s", entry.Instance)
+		return
+	}
+	addr := common.NewNetAddress("tcp", entry.IP.String(), int32(entry.Port))
+	if service.addrManager.IsOurAddress(addr) {
+		return
+	}
+	services := entry.Service
+	service.addrManager.addAddress(addr, nil, time.Now(), &services)
+}
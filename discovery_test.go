@@ -0,0 +1,86 @@
+package p2p
+
+import (
+	"github.com/DSiSc/p2p/common"
+	"github.com/DSiSc/p2p/config"
+	"github.com/DSiSc/p2p/discover"
+	"github.com/DSiSc/p2p/mdns"
+	"github.com/stretchr/testify/assert"
+	"net"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestNodeKeyFilePath(t *testing.T) {
+	assert := assert.New(t)
+	assert.Equal("", nodeKeyFilePath(""))
+	assert.Equal(filepath.Join("data", nodeKeyFileName), nodeKeyFilePath(filepath.Join("data", "address_book.json")))
+}
+
+func TestParseBootnodes(t *testing.T) {
+	assert := assert.New(t)
+	key, _ := discover.GenerateKey()
+	node := discover.NewNode(discover.PubkeyID(&key.PublicKey), common.NewNetAddress("tcp", "192.168.1.1", 0).ParsedIP(), 30301, 8080)
+	nodes := parseBootnodes(node.String() + ", invalid,")
+	assert.Equal([]*discover.Node{node}, nodes)
+}
+
+func TestP2P_Discovery(t *testing.T) {
+	assert := assert.New(t)
+	conf := mockConfig()
+	conf.DiscoveryAddr = "127.0.0.1:0"
+	p2p, err := NewP2P(conf, nil)
+	assert.Nil(err)
+	assert.Nil(p2p.startDiscovery())
+	defer p2p.discovery.Stop()
+
+	// a node joins the network with us as boot node
+	key, _ := discover.GenerateKey()
+	d, err := discover.New(&discover.Config{
+		PrivateKey: key,
+		ListenAddr: "127.0.0.1:0",
+		TCPPort:    9090,
+		Bootnodes:  []*discover.Node{p2p.discovery.Self()},
+	})
+	assert.Nil(err)
+	d.Start()
+	defer d.Stop()
+
+	for i := 0; i < 20 && p2p.addrManager.GetAddressCount() == 0; i++ {
+		time.Sleep(50 * time.Millisecond)
+	}
+	assert.Equal([]*common.NetAddress{common.NewNetAddress("tcp", "127.0.0.1", 9090)}, p2p.addrManager.GetAllAddress())
+	assert.Equal(1, len(p2p.discovery.Nodes()))
+}
+
+func TestP2P_OnLANEntry(t *testing.T) {
+	assert := assert.New(t)
+	p2p, err := NewP2P(mockConfig(), nil)
+	assert.Nil(err)
+
+	p2p.onLANEntry(&mdns.Entry{Instance: "a", IP: net.ParseIP("192.168.1.2"), Port: 8080, Service: config.SFNodeTX})
+	assert.NotNil(p2p.addrManager.book.get(common.NewNetAddress("tcp", "192.168.1.2", 8080)))
+	assert.Equal(config.SFNodeTX, *p2p.addrManager.GetServices(common.NewNetAddress("tcp", "192.168.1.2", 8080)))
+
+	// incompatible service
+	p2p.onLANEntry(&mdns.Entry{Instance: "b", IP: net.ParseIP("192.168.1.3"), Port: 8080, Service: config.SFNodeBlockSyncer})
+	assert.Nil(p2p.addrManager.book.get(common.NewNetAddress("tcp", "192.168.1.3", 8080)))
+	assert.Equal(1, p2p.addrManager.GetAddressCount())
+}
+
+func TestP2P_MDNSConfig(t *testing.T) {
+	assert := assert.New(t)
+	p2p, err := NewP2P(mockConfig(), nil)
+	assert.Nil(err)
+	conf := p2p.mdnsConfig()
+	assert.Equal(uint16(8080), conf.Port)
+	assert.Nil(conf.IPs)
+
+	// specific listen ip is advertised only
+	conf1 := mockConfig()
+	conf1.ListenAddress = "tcp://192.168.1.100:8080"
+	p2p, err = NewP2P(conf1, nil)
+	assert.Nil(err)
+	assert.Equal([]net.IP{net.ParseIP("192.168.1.100")}, p2p.mdnsConfig().IPs)
+}
@@ -6,8 +6,11 @@ import (
 	"github.com/DSiSc/craft/log"
 	"github.com/DSiSc/p2p"
 	"github.com/DSiSc/p2p/config"
+	"github.com/DSiSc/p2p/dnsserver"
+	"net"
 	"os"
 	"os/signal"
+	"strings"
 	"syscall"
 )
 
@@ -23,21 +26,28 @@ func sysSignalProcess(p *p2p.P2P) {
 // Run as a dns seed.
 // DnsSeed defines the node that are used as a public proxy to discover peers.
 func main() {
-	var addrBookPath, listenAddress, persistentPeers string
+	var addrBookPath, listenAddress, persistentPeers, dnsListen, dnsZone, dnsNS, dnsNSAddrs string
 	var maxConnOutBound, maxConnInBound int
+	var crawl bool
 	flagSet := flag.NewFlagSet("dns-seed", flag.ExitOnError)
 	flagSet.StringVar(&addrBookPath, "path", "./address_book.json", "Address book file path")
 	flagSet.StringVar(&listenAddress, "listen", "tcp://0.0.0.0:8888", "Listen address")
 	flagSet.IntVar(&maxConnOutBound, "out", 4, "Maximum number of connected outbound peers")
 	flagSet.IntVar(&maxConnInBound, "in", 8, "Maximum number of connected inbound peers")
+	flagSet.StringVar(&dnsListen, "dns", "", "DNS server listen address, empty means not serving DNS")
+	flagSet.StringVar(&dnsZone, "zone", "", "Zone served by DNS server")
+	flagSet.StringVar(&dnsNS, "ns", "", "Name server of the zone(default ns.<zone>)")
+	flagSet.StringVar(&dnsNSAddrs, "nsaddr", "", "Comma separated ip addresses of the name server, required if it's in the zone")
+	flagSet.BoolVar(&crawl, "crawl", false, "Crawl the known addresses and serve only the ones with good recent uptime")
 	flagSet.Usage = func() {
 		fmt.Println(`Justitia blockchain dns seed.
 
 Usage:
-	dns-seed [-path ./address_book.json] [-listen tcp://0.0.0.0:8080]
+	dns-seed [-path ./address_book.json] [-listen tcp://0.0.0.0:8080] [-dns 0.0.0.0:53 -zone seed.example.com [-ns ns.seed.example.com] [-nsaddr 1.2.3.4]] [-crawl]
 
 Examples:
-	dns-seed -path ./address_book.json -listen tcp://0.0.0.0:8080`)
+	dns-seed -path ./address_book.json -listen tcp://0.0.0.0:8080
+	dns-seed -path ./address_book.json -listen tcp://0.0.0.0:8080 -dns 0.0.0.0:53 -zone seed.example.com -nsaddr 1.2.3.4 -crawl`)
 		fmt.Println("Flags:")
 		flagSet.PrintDefaults()
 	}
@@ -51,12 +61,30 @@ Examples:
 		MaxConnOutBound:  maxConnOutBound,
 		MaxConnInBound:   maxConnInBound,
 		SeedMode:         true,
+		Crawl:            crawl,
 	}
 	dnsSeed, err := p2p.NewP2P(conf, nil)
 	if err != nil {
 		log.Error("failed to new p2p server, as: %v", err)
 	}
 	dnsSeed.Start()
+	if dnsListen != "" {
+		dnsConf := dnsserver.Config{Zone: dnsZone, NS: dnsNS}
+		for _, addr := range strings.Split(dnsNSAddrs, ",") {
+			if ip := net.ParseIP(strings.TrimSpace(addr)); ip != nil {
+				dnsConf.NSAddrs = append(dnsConf.NSAddrs, ip)
+			}
+		}
+		dnsServer, err := dnsserver.NewServer(dnsConf, dnsSeed.SeedIPs)
+		if err != nil {
+			log.Error("failed to create dns server, as: %v", err)
+			os.Exit(1)
+		}
+		if err := dnsServer.Start(dnsListen); err != nil {
+			log.Error("failed to start dns server, as: %v", err)
+			os.Exit(1)
+		}
+	}
 	// catch system exit signal
 	sysSignalProcess(dnsSeed)
 }
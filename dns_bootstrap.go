@@ -0,0 +1,86 @@
+package p2p
+
+import (
+	"context"
+	"github.com/DSiSc/craft/log"
+	"github.com/DSiSc/p2p/common"
+	"net"
+	"strings"
+	"time"
+)
+
+const dnsLookupTimeout = 10 * time.Second
+
+// In DNS bootstrap mode, every DNS seed is a zone served by a DNS seed server, whose A/AAAA records are the
+// addresses of healthy peers. As DNS answers carry no port, the peers are assumed To listen on the same port
+// as us. Only when DNS yields nothing we fall back To connect To the seeds as seed nodes.
+
+// create the resolver used in DNS bootstrap, system resolver is used if the resolver address is empty.
+func newDNSResolver(resolverAddr string) *net.Resolver {
+	if resolverAddr == "" {
+		return net.DefaultResolver
+	}
+	return &net.Resolver{
+		PreferGo: true,
+		Dial: func(ctx context.Context, network, address string) (net.Conn, error) {
+			var dialer net.Dialer
+			return dialer.DialContext(ctx, network, resolverAddr)
+		},
+	}
+}
+
+// parse the dns seeds, the port of a seed is optional in DNS bootstrap mode and defaults To our port.
+func (service *P2P) parseDnsSeeds() []*common.NetAddress {
+	seeds := make([]*common.NetAddress, 0)
+	for _, dnsSeed := range strings.Split(service.config.DNSSeeds, ",") {
+		dnsSeed = strings.TrimSpace(dnsSeed)
+		if dnsSeed == "" {
+			continue
+		}
+		netAddr, err := common.ParseNetAddress(dnsSeed)
+		if err != nil && service.config.DNSBootstrap && !strings.Contains(dnsSeed, "://") {
+			netAddr, err = common.ParseNetAddress(net.JoinHostPort(dnsSeed, "0"))
+			if err == nil {
+				netAddr.Port = service.addr.Port
+			}
+		}
+		if err != nil {
+			log.Warn("invalid dns seed address %s", dnsSeed)
+			continue
+		}
+		seeds = append(seeds, netAddr)
+	}
+	return seeds
+}
+
+// resolve the dns seeds To peer addresses and add them To address book, return the num of addresses found. The
+// answers are relayed by the seed, so they are checked by the address policy as well.
+func (service *P2P) bootstrapFromDNS(seeds []*common.NetAddress) int {
+	resolver := newDNSResolver(service.config.DNSResolver)
+	found := 0
+	for _, seed := range seeds {
+		if !seed.IsHostname() {
+			continue
+		}
+		ctx, cancel := context.WithTimeout(context.Background(), dnsLookupTimeout)
+		ips, err := resolver.LookupIPAddr(ctx, seed.IP)
+		cancel()
+		if err != nil {
+			log.Warn("failed To look up dns seed %s, as: %v", seed.IP, err)
+			continue
+		}
+		log.Info("got %d addresses From dns seed %s", len(ips), seed.IP)
+		for _, ip := range ips {
+			addr := common.NewNetAddress(seed.Protocol, ip.IP.String(), service.addr.Port)
+			if service.addrManager.IsOurAddress(addr) {
+				continue
+			}
+			if err := service.addrManager.addAddress(addr, seed, time.Now(), nil); err != nil {
+				log.Debug("ignore address %s From dns seed %s, as: %v", addr.ToString(), seed.IP, err)
+				continue
+			}
+			found++
+		}
+	}
+	return found
+}
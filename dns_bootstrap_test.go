@@ -0,0 +1,84 @@
+package p2p
+
+import (
+	"github.com/DSiSc/p2p/common"
+	"github.com/DSiSc/p2p/config"
+	"github.com/DSiSc/p2p/dnsserver"
+	"github.com/stretchr/testify/assert"
+	"net"
+	"testing"
+)
+
+// start a dns seed server of zone seed.example.com, serving the ips.
+func mockDNSServer(ips ...string) (*dnsserver.Server, error) {
+	server, err := dnsserver.NewServer(dnsserver.Config{Zone: "seed.example.com", NS: "ns.example.com"}, func(num int, ipv6 bool, service *config.ServiceFlag) []net.IP {
+		answers := make([]net.IP, 0)
+		for _, ip := range ips {
+			if parsed := net.ParseIP(ip); (parsed.To4() == nil) == ipv6 {
+				answers = append(answers, parsed)
+			}
+		}
+		return answers
+	})
+	if err != nil {
+		return nil, err
+	}
+	return server, server.Start("127.0.0.1:0")
+}
+
+func TestP2P_ParseDnsSeeds(t *testing.T) {
+	assert := assert.New(t)
+	conf := mockConfig()
+	conf.DNSSeeds = "tcp://192.168.1.1:8081,seed.example.com"
+	p2p, err := NewP2P(conf, nil)
+	assert.Nil(err)
+	seeds := p2p.parseDnsSeeds()
+	assert.Equal(1, len(seeds))
+	assert.Equal("192.168.1.1", seeds[0].IP)
+
+	p2p.config.DNSBootstrap = true
+	seeds = p2p.parseDnsSeeds()
+	assert.Equal(2, len(seeds))
+	assert.Equal(common.NewNetAddress("tcp", "seed.example.com", 8080), seeds[1])
+}
+
+func TestP2P_BootstrapFromDNS(t *testing.T) {
+	assert := assert.New(t)
+	server, err := mockDNSServer("8.8.8.8", "1.2.3.4", "10.0.0.1", "2001:4860::8888")
+	assert.Nil(err)
+	defer server.Stop()
+
+	conf := mockConfig()
+	conf.DNSSeeds = "seed.example.com"
+	conf.DNSBootstrap = true
+	conf.DNSResolver = server.UDPAddr().String()
+	p2p, err := NewP2P(conf, nil)
+	assert.Nil(err)
+	assert.Equal(3, p2p.bootstrapFromDNS(p2p.parseDnsSeeds()))
+	assert.Equal(3, p2p.addrManager.GetAddressCount())
+	assert.NotNil(p2p.addrManager.book.get(common.NewNetAddress("tcp", "8.8.8.8", 8080)))
+	assert.NotNil(p2p.addrManager.book.get(common.NewNetAddress("tcp", "2001:4860::8888", 8080)))
+	assert.Nil(p2p.addrManager.book.get(common.NewNetAddress("tcp", "10.0.0.1", 8080)))
+
+	// private answers are accepted in private deployments
+	conf.AddrPolicy = AddrPolicyPrivate
+	p2p, err = NewP2P(conf, nil)
+	assert.Nil(err)
+	assert.Equal(4, p2p.bootstrapFromDNS(p2p.parseDnsSeeds()))
+}
+
+func TestP2P_BootstrapFromDNSNothing(t *testing.T) {
+	assert := assert.New(t)
+	server, err := mockDNSServer()
+	assert.Nil(err)
+	defer server.Stop()
+
+	conf := mockConfig()
+	conf.DNSSeeds = "seed.example.com,tcp://192.168.1.1:8081"
+	conf.DNSBootstrap = true
+	conf.DNSResolver = server.UDPAddr().String()
+	p2p, err := NewP2P(conf, nil)
+	assert.Nil(err)
+	assert.Equal(0, p2p.bootstrapFromDNS(p2p.parseDnsSeeds()))
+	assert.Equal(0, p2p.addrManager.GetAddressCount())
+}
@@ -0,0 +1,465 @@
+// Package dnsserver implements an authoritative DNS server of a seed zone, which answers A/AAAA queries with
+// a sample of healthy peer addresses, so nodes can bootstrap via ordinary resolvers.
+package dnsserver
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"github.com/DSiSc/craft/log"
+	"github.com/DSiSc/p2p/config"
+	"github.com/DSiSc/p2p/dnswire"
+	"io"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	defaultTTL        = 60
+	defaultMaxAnswers = 25
+	tcpIdleTimeout    = 10 * time.Second
+	maxTCPMsgSize     = 65535
+	maxTCPConns       = 64                   // max num of concurrent TCP connections
+	maxAcceptDelay    = time.Second          // max delay before accepting again after a temporary error
+	minAcceptDelay    = 5 * time.Millisecond // delay before accepting again after the first temporary error
+)
+
+// AddressSource provide at most num ips To answer a query, ipv4 or ipv6 ips according To the query type, and
+// only the ips supporting the service if it's not nil. It's called for every query, so a different sample of
+// addresses can be returned each time.
+type AddressSource func(num int, ipv6 bool, service *config.ServiceFlag) []net.IP
+
+// Config is the config of DNS seed server
+type Config struct {
+	Zone       string   // the zone served, e.g. "seed.example.com"
+	NS         string   // name server of the zone(default "ns." + zone)
+	NSAddrs    []net.IP // addresses of the name server, required if it's in the zone
+	TTL        uint32   // ttl of the answers in seconds(default 60)
+	MaxAnswers int      // max num of addresses in a response(default 25)
+}
+
+// Server is a DNS seed server serving over both UDP and TCP. Besides the zone apex, it answers the queries
+// To "x<service in hex>.<zone>" with the addresses supporting the service, e.g. "x1.seed.example.com".
+type Server struct {
+	zone        string
+	ns          string
+	nsAddrs     []net.IP
+	ttl         uint32
+	maxAnswers  int
+	source      AddressSource
+	udpConn     net.PacketConn
+	tcpListener net.Listener
+	tcpSlots    chan struct{}         // slots of concurrent TCP connections
+	tcpConns    map[net.Conn]struct{} // TCP connections being served, closed on stop
+	connLock    sync.Mutex
+	quitChan    chan struct{}
+	wg          sync.WaitGroup
+}
+
+// NewServer create a DNS seed server instance
+func NewServer(config Config, source AddressSource) (*Server, error) {
+	if config.Zone == "" {
+		return nil, errors.New("dns seed zone is not specified")
+	}
+	if source == nil {
+		return nil, errors.New("address source is not specified")
+	}
+	zone := dnswire.CanonicalName(config.Zone)
+	ns := config.NS
+	if ns == "" {
+		ns = "ns." + zone
+	}
+	ns = dnswire.CanonicalName(ns)
+	// resolvers can't reach a name server in the zone unless we answer its address
+	if (ns == zone || strings.HasSuffix(ns, "."+zone)) && len(config.NSAddrs) == 0 {
+		return nil, fmt.Errorf("address of name server %s is not specified", ns)
+	}
+	server := &Server{
+		zone:       zone,
+		ns:         ns,
+		nsAddrs:    config.NSAddrs,
+		ttl:        config.TTL,
+		maxAnswers: config.MaxAnswers,
+		source:     source,
+		tcpSlots:   make(chan struct{}, maxTCPConns),
+		tcpConns:   make(map[net.Conn]struct{}),
+		quitChan:   make(chan struct{}),
+	}
+	if server.ttl == 0 {
+		server.ttl = defaultTTL
+	}
+	if server.maxAnswers <= 0 {
+		server.maxAnswers = defaultMaxAnswers
+	}
+	return server, nil
+}
+
+// Start listen on the address with both UDP and TCP, and serve the queries.
+func (server *Server) Start(listenAddr string) error {
+	udpConn, err := net.ListenPacket("udp", listenAddr)
+	if err != nil {
+		return err
+	}
+	// use the same port as UDP when listen on a random port
+	tcpAddr := listenAddr
+	if host, port, err := net.SplitHostPort(listenAddr); err == nil && port == "0" {
+		tcpAddr = net.JoinHostPort(host, strconv.Itoa(udpConn.LocalAddr().(*net.UDPAddr).Port))
+	}
+	tcpListener, err := net.Listen("tcp", tcpAddr)
+	if err != nil {
+		udpConn.Close()
+		return err
+	}
+	server.udpConn = udpConn
+	server.tcpListener = tcpListener
+	log.Info("dns seed server of zone %s listen on %s", server.zone, udpConn.LocalAddr().String())
+
+	server.wg.Add(2)
+	go server.serveUDP()
+	go server.serveTCP()
+	return nil
+}
+
+// Stop stop the server
+func (server *Server) Stop() {
+	close(server.quitChan)
+	if server.udpConn != nil {
+		server.udpConn.Close()
+	}
+	if server.tcpListener != nil {
+		server.tcpListener.Close()
+	}
+	server.connLock.Lock()
+	for conn := range server.tcpConns {
+		conn.Close()
+	}
+	server.connLock.Unlock()
+	server.wg.Wait()
+}
+
+// UDPAddr get the UDP address the server listening on
+func (server *Server) UDPAddr() net.Addr {
+	return server.udpConn.LocalAddr()
+}
+
+// TCPAddr get the TCP address the server listening on
+func (server *Server) TCPAddr() net.Addr {
+	return server.tcpListener.Addr()
+}
+
+// serve the queries over UDP
+func (server *Server) serveUDP() {
+	defer server.wg.Done()
+	buf := make([]byte, maxTCPMsgSize)
+	for {
+		n, remote, err := server.udpConn.ReadFrom(buf)
+		if err != nil {
+			select {
+			case <-server.quitChan:
+			default:
+				log.Error("failed To read dns query, as: %v", err)
+			}
+			return
+		}
+		resp := server.handle(buf[:n], dnswire.MaxUDPMsgSize)
+		if resp == nil {
+			continue
+		}
+		if _, err := server.udpConn.WriteTo(resp, remote); err != nil {
+			log.Debug("failed To send dns response To %s, as: %v", remote.String(), err)
+		}
+	}
+}
+
+// serve the queries over TCP, accepting is retried on temporary errors(e.g. too many open files), and the
+// connections exceeding maxTCPConns are closed immediately.
+func (server *Server) serveTCP() {
+	defer server.wg.Done()
+	var delay time.Duration
+	for {
+		conn, err := server.tcpListener.Accept()
+		if err != nil {
+			select {
+			case <-server.quitChan:
+				return
+			default:
+			}
+			if ne, ok := err.(net.Error); ok && ne.Temporary() {
+				delay *= 2
+				if delay == 0 {
+					delay = minAcceptDelay
+				}
+				if delay > maxAcceptDelay {
+					delay = maxAcceptDelay
+				}
+				log.Warn("failed To accept dns connection, retry in %v, as: %v", delay, err)
+				select {
+				case <-time.After(delay):
+					continue
+				case <-server.quitChan:
+					return
+				}
+			}
+			log.Error("failed To accept dns connection, as: %v", err)
+			return
+		}
+		delay = 0
+		select {
+		case server.tcpSlots <- struct{}{}:
+		default:
+			log.Debug("too many dns connections, drop connection From %s", conn.RemoteAddr())
+			conn.Close()
+			continue
+		}
+		if !server.trackConn(conn) {
+			conn.Close()
+			<-server.tcpSlots
+			return
+		}
+		server.wg.Add(1)
+		go server.serveConn(conn)
+	}
+}
+
+// record the connection being served, return false if the server have stopped.
+func (server *Server) trackConn(conn net.Conn) bool {
+	server.connLock.Lock()
+	defer server.connLock.Unlock()
+	select {
+	case <-server.quitChan:
+		return false
+	default:
+	}
+	server.tcpConns[conn] = struct{}{}
+	return true
+}
+
+// serve the queries in a TCP connection, every message is prefixed by its length in two bytes.
+func (server *Server) serveConn(conn net.Conn) {
+	defer func() {
+		conn.Close()
+		server.connLock.Lock()
+		delete(server.tcpConns, conn)
+		server.connLock.Unlock()
+		<-server.tcpSlots
+		server.wg.Done()
+	}()
+	lenBuf := make([]byte, 2)
+	for {
+		conn.SetDeadline(time.Now().Add(tcpIdleTimeout))
+		if _, err := io.ReadFull(conn, lenBuf); err != nil {
+			return
+		}
+		query := make([]byte, binary.BigEndian.Uint16(lenBuf))
+		if _, err := io.ReadFull(conn, query); err != nil {
+			return
+		}
+		resp := server.handle(query, maxTCPMsgSize)
+		if resp == nil {
+			return
+		}
+		out := make([]byte, 2, 2+len(resp))
+		binary.BigEndian.PutUint16(out, uint16(len(resp)))
+		if _, err := conn.Write(append(out, resp...)); err != nil {
+			return
+		}
+	}
+}
+
+// handle a query, return the packed response no longer than maxSize, or nil if no response should be sent.
+// Packets without a whole header or with the QR bit set are dropped silently, so spoofed garbage can't make us
+// reflect responses.
+func (server *Server) handle(buf []byte, maxSize int) []byte {
+	if len(buf) < dnswire.HeaderLen || buf[2]&0x80 != 0 {
+		return nil
+	}
+	query, err := dnswire.Unpack(buf)
+	if err != nil {
+		// we can still tell the id of the malformed query
+		return server.pack(&dnswire.Message{
+			Header: dnswire.Header{ID: binary.BigEndian.Uint16(buf), Response: true, RCode: dnswire.RCodeFormatError},
+		}, maxSize)
+	}
+	return server.pack(server.answer(query), maxSize)
+}
+
+// answer the query
+func (server *Server) answer(query *dnswire.Message) *dnswire.Message {
+	resp := &dnswire.Message{
+		Header: dnswire.Header{
+			ID:               query.ID,
+			Response:         true,
+			Opcode:           query.Opcode,
+			RecursionDesired: query.RecursionDesired,
+		},
+		Questions: query.Questions,
+	}
+	if query.Opcode != 0 {
+		resp.RCode = dnswire.RCodeNotImplemented
+		return resp
+	}
+	if len(query.Questions) != 1 {
+		resp.RCode = dnswire.RCodeFormatError
+		return resp
+	}
+	q := query.Questions[0]
+	name := dnswire.CanonicalName(q.Name)
+	if q.Class != dnswire.ClassINET && q.Class != dnswire.ClassANY {
+		resp.RCode = dnswire.RCodeNotImplemented
+		return resp
+	}
+	if name != server.zone && !strings.HasSuffix(name, "."+server.zone) {
+		resp.RCode = dnswire.RCodeRefused
+		return resp
+	}
+
+	resp.Authoritative = true
+	if name == server.ns && name != server.zone {
+		resp.Answers = server.nsAddresses(q.Type)
+		if len(resp.Answers) == 0 {
+			resp.Authorities = []dnswire.Resource{server.soa()}
+		}
+		return resp
+	}
+	service, ok := server.parseService(name)
+	if !ok {
+		resp.RCode = dnswire.RCodeNameError
+		resp.Authorities = []dnswire.Resource{server.soa()}
+		return resp
+	}
+	switch q.Type {
+	case dnswire.TypeA:
+		resp.Answers = server.addresses(name, false, service)
+	case dnswire.TypeAAAA:
+		resp.Answers = server.addresses(name, true, service)
+	case dnswire.TypeANY:
+		resp.Answers = append(server.addresses(name, false, service), server.addresses(name, true, service)...)
+	case dnswire.TypeNS:
+		if name == server.zone {
+			resp.Answers = []dnswire.Resource{server.nsRecord()}
+		}
+	case dnswire.TypeSOA:
+		if name == server.zone {
+			resp.Answers = []dnswire.Resource{server.soa()}
+		}
+	}
+	if len(resp.Answers) == 0 {
+		resp.Authorities = []dnswire.Resource{server.soa()}
+	}
+	return resp
+}
+
+// parse the service filter of the name in zone, the service is nil for the zone apex. return false if the name
+// doesn't exist.
+func (server *Server) parseService(name string) (*config.ServiceFlag, bool) {
+	if name == server.zone {
+		return nil, true
+	}
+	label := strings.TrimSuffix(name, "."+server.zone)
+	if len(label) < 2 || label[0] != 'x' || strings.Contains(label, ".") {
+		return nil, false
+	}
+	value, err := strconv.ParseUint(label[1:], 16, 64)
+	if err != nil {
+		return nil, false
+	}
+	service := config.ServiceFlag(value)
+	return &service, true
+}
+
+// pack the response, answers are dropped until it fits in the size limit, and the response is marked as
+// truncated if so, to let the resolvers retry over TCP.
+func (server *Server) pack(resp *dnswire.Message, maxSize int) []byte {
+	for {
+		buf, err := resp.Pack()
+		if err != nil {
+			log.Error("failed To pack dns response, as: %v", err)
+			return nil
+		}
+		if len(buf) <= maxSize || len(resp.Answers) == 0 {
+			return buf
+		}
+		resp.Answers = resp.Answers[:len(resp.Answers)-1]
+		resp.Truncated = true
+	}
+}
+
+// the address records of the zone
+func (server *Server) addresses(name string, ipv6 bool, service *config.ServiceFlag) []dnswire.Resource {
+	recordType := dnswire.TypeA
+	if ipv6 {
+		recordType = dnswire.TypeAAAA
+	}
+	records := make([]dnswire.Resource, 0, server.maxAnswers)
+	for _, ip := range server.source(server.maxAnswers, ipv6, service) {
+		if (ip.To4() == nil) != ipv6 {
+			continue
+		}
+		records = append(records, dnswire.Resource{
+			Name:  name,
+			Type:  recordType,
+			Class: dnswire.ClassINET,
+			TTL:   server.ttl,
+			IP:    ip,
+		})
+		if len(records) >= server.maxAnswers {
+			break
+		}
+	}
+	return records
+}
+
+// the address records of the name server in the zone
+func (server *Server) nsAddresses(qtype dnswire.Type) []dnswire.Resource {
+	records := make([]dnswire.Resource, 0, len(server.nsAddrs))
+	for _, ip := range server.nsAddrs {
+		recordType := dnswire.TypeA
+		if ip.To4() == nil {
+			recordType = dnswire.TypeAAAA
+		}
+		if qtype != recordType && qtype != dnswire.TypeANY {
+			continue
+		}
+		records = append(records, dnswire.Resource{
+			Name:  server.ns,
+			Type:  recordType,
+			Class: dnswire.ClassINET,
+			TTL:   server.ttl,
+			IP:    ip,
+		})
+	}
+	return records
+}
+
+// the NS record of the zone
+func (server *Server) nsRecord() dnswire.Resource {
+	return dnswire.Resource{
+		Name:  server.zone,
+		Type:  dnswire.TypeNS,
+		Class: dnswire.ClassINET,
+		TTL:   server.ttl,
+		NS:    server.ns,
+	}
+}
+
+// the SOA record of the zone, the serial changes over time as the answers do.
+func (server *Server) soa() dnswire.Resource {
+	return dnswire.Resource{
+		Name:  server.zone,
+		Type:  dnswire.TypeSOA,
+		Class: dnswire.ClassINET,
+		TTL:   server.ttl,
+		SOA: &dnswire.SOA{
+			MName:   server.ns,
+			RName:   "hostmaster." + server.zone,
+			Serial:  uint32(time.Now().Unix()),
+			Refresh: 3600,
+			Retry:   600,
+			Expire:  86400,
+			MinTTL:  server.ttl,
+		},
+	}
+}
@@ -0,0 +1,297 @@
+package dnsserver
+
+import (
+	"encoding/binary"
+	"github.com/DSiSc/p2p/config"
+	"github.com/DSiSc/p2p/dnswire"
+	"github.com/stretchr/testify/assert"
+	"io"
+	"net"
+	"strconv"
+	"testing"
+	"time"
+)
+
+// mock an address source with num ipv4 and ipv6 ips, the ips supporting service n start with n+1 ips.
+func mockSource(num int) AddressSource {
+	return func(max int, ipv6 bool, service *config.ServiceFlag) []net.IP {
+		ips := make([]net.IP, 0, num)
+		start := 0
+		if service != nil {
+			start = int(*service) + 1
+		}
+		for i := start; i < num && i < max+start; i++ {
+			if ipv6 {
+				ips = append(ips, net.ParseIP("2001:db8::"+strconv.Itoa(i+1)))
+			} else {
+				ips = append(ips, net.ParseIP("8.8.8."+strconv.Itoa(i+1)))
+			}
+		}
+		return ips
+	}
+}
+
+// mock addresses of the name server
+var mockNSAddrs = []net.IP{net.ParseIP("1.2.3.4"), net.ParseIP("2001:4860::1")}
+
+// mock a packed query
+func mockQuery(name string, qtype dnswire.Type) []byte {
+	query := &dnswire.Message{
+		Header:    dnswire.Header{ID: 0x1234, RecursionDesired: true},
+		Questions: []dnswire.Question{{Name: name, Type: qtype, Class: dnswire.ClassINET}},
+	}
+	buf, _ := query.Pack()
+	return buf
+}
+
+func TestNewServer(t *testing.T) {
+	assert := assert.New(t)
+	_, err := NewServer(Config{}, mockSource(1))
+	assert.NotNil(err)
+	_, err = NewServer(Config{Zone: "seed.example.com"}, nil)
+	assert.NotNil(err)
+	// name server in zone without address
+	_, err = NewServer(Config{Zone: "seed.example.com"}, mockSource(1))
+	assert.NotNil(err)
+	_, err = NewServer(Config{Zone: "seed.example.com", NS: "dns.seed.example.com"}, mockSource(1))
+	assert.NotNil(err)
+	server, err := NewServer(Config{Zone: "seed.example.com", NS: "ns.example.com"}, mockSource(1))
+	assert.Nil(err)
+	assert.Equal("ns.example.com.", server.ns)
+	server, err = NewServer(Config{Zone: "Seed.Example.com", NSAddrs: mockNSAddrs}, mockSource(1))
+	assert.Nil(err)
+	assert.Equal("seed.example.com.", server.zone)
+	assert.Equal("ns.seed.example.com.", server.ns)
+	assert.Equal(uint32(defaultTTL), server.ttl)
+	assert.Equal(defaultMaxAnswers, server.maxAnswers)
+}
+
+func TestServer_Handle(t *testing.T) {
+	assert := assert.New(t)
+	server, _ := NewServer(Config{Zone: "seed.example.com", NSAddrs: mockNSAddrs, TTL: 30, MaxAnswers: 3}, mockSource(5))
+
+	resp, err := dnswire.Unpack(server.handle(mockQuery("seed.example.com.", dnswire.TypeA), dnswire.MaxUDPMsgSize))
+	assert.Nil(err)
+	assert.Equal(uint16(0x1234), resp.ID)
+	assert.True(resp.Response)
+	assert.True(resp.Authoritative)
+	assert.True(resp.RecursionDesired)
+	assert.Equal(dnswire.RCodeSuccess, resp.RCode)
+	assert.Equal(3, len(resp.Answers))
+	for _, answer := range resp.Answers {
+		assert.Equal(dnswire.TypeA, answer.Type)
+		assert.Equal(uint32(30), answer.TTL)
+		assert.NotNil(answer.IP.To4())
+	}
+
+	resp, _ = dnswire.Unpack(server.handle(mockQuery("SEED.example.com", dnswire.TypeAAAA), dnswire.MaxUDPMsgSize))
+	assert.Equal(3, len(resp.Answers))
+	assert.Equal(dnswire.TypeAAAA, resp.Answers[0].Type)
+	assert.Nil(resp.Answers[0].IP.To4())
+
+	resp, _ = dnswire.Unpack(server.handle(mockQuery("seed.example.com.", dnswire.TypeNS), dnswire.MaxUDPMsgSize))
+	assert.Equal("ns.seed.example.com.", resp.Answers[0].NS)
+
+	// address of the name server
+	resp, _ = dnswire.Unpack(server.handle(mockQuery("ns.seed.example.com.", dnswire.TypeA), dnswire.MaxUDPMsgSize))
+	assert.Equal(dnswire.RCodeSuccess, resp.RCode)
+	assert.Equal(1, len(resp.Answers))
+	assert.Equal("1.2.3.4", resp.Answers[0].IP.String())
+	resp, _ = dnswire.Unpack(server.handle(mockQuery("ns.seed.example.com.", dnswire.TypeAAAA), dnswire.MaxUDPMsgSize))
+	assert.Equal(1, len(resp.Answers))
+	assert.Equal("2001:4860::1", resp.Answers[0].IP.String())
+	resp, _ = dnswire.Unpack(server.handle(mockQuery("ns.seed.example.com.", dnswire.TypeNS), dnswire.MaxUDPMsgSize))
+	assert.Equal(dnswire.RCodeSuccess, resp.RCode)
+	assert.Equal(0, len(resp.Answers))
+	assert.Equal(dnswire.TypeSOA, resp.Authorities[0].Type)
+
+	// no data
+	resp, _ = dnswire.Unpack(server.handle(mockQuery("seed.example.com.", dnswire.Type(16)), dnswire.MaxUDPMsgSize))
+	assert.Equal(dnswire.RCodeSuccess, resp.RCode)
+	assert.Equal(0, len(resp.Answers))
+	assert.Equal(dnswire.TypeSOA, resp.Authorities[0].Type)
+
+	// name in zone doesn't exist
+	resp, _ = dnswire.Unpack(server.handle(mockQuery("x.seed.example.com.", dnswire.TypeA), dnswire.MaxUDPMsgSize))
+	assert.Equal(dnswire.RCodeNameError, resp.RCode)
+	resp, _ = dnswire.Unpack(server.handle(mockQuery("xz.seed.example.com.", dnswire.TypeA), dnswire.MaxUDPMsgSize))
+	assert.Equal(dnswire.RCodeNameError, resp.RCode)
+	resp, _ = dnswire.Unpack(server.handle(mockQuery("a.x1.seed.example.com.", dnswire.TypeA), dnswire.MaxUDPMsgSize))
+	assert.Equal(dnswire.RCodeNameError, resp.RCode)
+
+	// filtered by service
+	resp, _ = dnswire.Unpack(server.handle(mockQuery("x3.seed.example.com.", dnswire.TypeA), dnswire.MaxUDPMsgSize))
+	assert.Equal(dnswire.RCodeSuccess, resp.RCode)
+	assert.Equal(1, len(resp.Answers))
+	assert.Equal("x3.seed.example.com.", resp.Answers[0].Name)
+	assert.Equal("8.8.8.5", resp.Answers[0].IP.String())
+	resp, _ = dnswire.Unpack(server.handle(mockQuery("x3.seed.example.com.", dnswire.TypeNS), dnswire.MaxUDPMsgSize))
+	assert.Equal(0, len(resp.Answers))
+	assert.Equal(dnswire.TypeSOA, resp.Authorities[0].Type)
+
+	// name out of zone
+	resp, _ = dnswire.Unpack(server.handle(mockQuery("example.com.", dnswire.TypeA), dnswire.MaxUDPMsgSize))
+	assert.Equal(dnswire.RCodeRefused, resp.RCode)
+	assert.False(resp.Authoritative)
+
+	// malformed query
+	resp, _ = dnswire.Unpack(server.handle([]byte{0x12, 0x34, 0x01, 0x00, 0x00, 0x01, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0xff}, dnswire.MaxUDPMsgSize))
+	assert.Equal(uint16(0x1234), resp.ID)
+	assert.Equal(dnswire.RCodeFormatError, resp.RCode)
+
+	// garbage shorter than a header is dropped
+	assert.Nil(server.handle([]byte{0x12, 0x34}, dnswire.MaxUDPMsgSize))
+	assert.Nil(server.handle([]byte{0x12, 0x34, 0x01, 0x00, 0x00, 0x01}, dnswire.MaxUDPMsgSize))
+
+	// malformed response is dropped
+	assert.Nil(server.handle([]byte{0x12, 0x34, 0x81, 0x00, 0x00, 0x01, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0xff}, dnswire.MaxUDPMsgSize))
+
+	// response is ignored
+	buf := mockQuery("seed.example.com.", dnswire.TypeA)
+	buf[2] |= 0x80
+	assert.Nil(server.handle(buf, dnswire.MaxUDPMsgSize))
+}
+
+func TestServer_HandleSizeLimit(t *testing.T) {
+	assert := assert.New(t)
+	server, _ := NewServer(Config{Zone: "seed.example.com", NSAddrs: mockNSAddrs, MaxAnswers: 100}, mockSource(100))
+	buf := server.handle(mockQuery("seed.example.com.", dnswire.TypeAAAA), dnswire.MaxUDPMsgSize)
+	assert.True(len(buf) <= dnswire.MaxUDPMsgSize)
+	resp, err := dnswire.Unpack(buf)
+	assert.Nil(err)
+	assert.True(len(resp.Answers) > 0)
+	assert.True(len(resp.Answers) < 100)
+	assert.True(resp.Truncated)
+
+	buf = server.handle(mockQuery("seed.example.com.", dnswire.TypeAAAA), maxTCPMsgSize)
+	resp, _ = dnswire.Unpack(buf)
+	assert.Equal(100, len(resp.Answers))
+	assert.False(resp.Truncated)
+}
+
+func TestServer_Serve(t *testing.T) {
+	assert := assert.New(t)
+	server, _ := NewServer(Config{Zone: "seed.example.com", NSAddrs: mockNSAddrs}, mockSource(2))
+	assert.Nil(server.Start("127.0.0.1:0"))
+	defer server.Stop()
+
+	// over UDP
+	conn, err := net.Dial("udp", server.UDPAddr().String())
+	assert.Nil(err)
+	defer conn.Close()
+	conn.SetDeadline(time.Now().Add(5 * time.Second))
+	_, err = conn.Write(mockQuery("seed.example.com.", dnswire.TypeA))
+	assert.Nil(err)
+	buf := make([]byte, dnswire.MaxUDPMsgSize)
+	n, err := conn.Read(buf)
+	assert.Nil(err)
+	resp, err := dnswire.Unpack(buf[:n])
+	assert.Nil(err)
+	assert.Equal(2, len(resp.Answers))
+
+	// over TCP
+	tcpConn, err := net.Dial("tcp", server.TCPAddr().String())
+	assert.Nil(err)
+	defer tcpConn.Close()
+	tcpConn.SetDeadline(time.Now().Add(5 * time.Second))
+	query := mockQuery("seed.example.com.", dnswire.TypeAAAA)
+	lenBuf := make([]byte, 2)
+	binary.BigEndian.PutUint16(lenBuf, uint16(len(query)))
+	_, err = tcpConn.Write(append(lenBuf, query...))
+	assert.Nil(err)
+	_, err = io.ReadFull(tcpConn, lenBuf)
+	assert.Nil(err)
+	buf = make([]byte, binary.BigEndian.Uint16(lenBuf))
+	_, err = io.ReadFull(tcpConn, buf)
+	assert.Nil(err)
+	resp, err = dnswire.Unpack(buf)
+	assert.Nil(err)
+	assert.Equal(2, len(resp.Answers))
+	assert.Equal(dnswire.TypeAAAA, resp.Answers[0].Type)
+}
+
+// send the query over the TCP connection and read the response
+func queryTCP(conn net.Conn, query []byte) (*dnswire.Message, error) {
+	conn.SetDeadline(time.Now().Add(5 * time.Second))
+	lenBuf := make([]byte, 2)
+	binary.BigEndian.PutUint16(lenBuf, uint16(len(query)))
+	if _, err := conn.Write(append(lenBuf, query...)); err != nil {
+		return nil, err
+	}
+	if _, err := io.ReadFull(conn, lenBuf); err != nil {
+		return nil, err
+	}
+	buf := make([]byte, binary.BigEndian.Uint16(lenBuf))
+	if _, err := io.ReadFull(conn, buf); err != nil {
+		return nil, err
+	}
+	return dnswire.Unpack(buf)
+}
+
+func TestServer_TCPConnLimit(t *testing.T) {
+	assert := assert.New(t)
+	server, err := NewServer(Config{Zone: "seed.example.com", NSAddrs: mockNSAddrs}, mockSource(2))
+	assert.Nil(err)
+	server.tcpSlots = make(chan struct{}, 1)
+	assert.Nil(server.Start("127.0.0.1:0"))
+
+	conn1, err := net.Dial("tcp", server.TCPAddr().String())
+	assert.Nil(err)
+	defer conn1.Close()
+	_, err = queryTCP(conn1, mockQuery("seed.example.com.", dnswire.TypeA))
+	assert.Nil(err)
+
+	// the connection exceeding the limit is closed
+	conn2, err := net.Dial("tcp", server.TCPAddr().String())
+	assert.Nil(err)
+	defer conn2.Close()
+	_, err = queryTCP(conn2, mockQuery("seed.example.com.", dnswire.TypeA))
+	assert.NotNil(err)
+
+	// idle connections are closed on stop
+	start := time.Now()
+	server.Stop()
+	assert.True(time.Since(start) < tcpIdleTimeout/2)
+	_, err = queryTCP(conn1, mockQuery("seed.example.com.", dnswire.TypeA))
+	assert.NotNil(err)
+}
+
+// temporary network error
+type tempError struct{}
+
+func (tempError) Error() string   { return "too many open files" }
+func (tempError) Timeout() bool   { return false }
+func (tempError) Temporary() bool { return true }
+
+// listener failing with temporary errors before accepting
+type flakyListener struct {
+	net.Listener
+	errs int
+}
+
+func (listener *flakyListener) Accept() (net.Conn, error) {
+	if listener.errs > 0 {
+		listener.errs--
+		return nil, tempError{}
+	}
+	return listener.Listener.Accept()
+}
+
+func TestServer_AcceptTemporaryError(t *testing.T) {
+	assert := assert.New(t)
+	server, err := NewServer(Config{Zone: "seed.example.com", NSAddrs: mockNSAddrs}, mockSource(2))
+	assert.Nil(err)
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	assert.Nil(err)
+	server.tcpListener = &flakyListener{Listener: listener, errs: 3}
+	server.wg.Add(1)
+	go server.serveTCP()
+	defer server.Stop()
+
+	conn, err := net.Dial("tcp", listener.Addr().String())
+	assert.Nil(err)
+	defer conn.Close()
+	resp, err := queryTCP(conn, mockQuery("seed.example.com.", dnswire.TypeA))
+	assert.Nil(err)
+	assert.Equal(2, len(resp.Answers))
+}
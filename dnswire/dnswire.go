@@ -0,0 +1,475 @@
+// Package dnswire implements a minimal DNS message codec(RFC 1035), which supports the records needed by a
+// DNS seed and DNS-SD: A, AAAA, NS, SOA, PTR, TXT and SRV. Unsupported records are kept as raw data.
+package dnswire
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"net"
+	"strings"
+)
+
+// Type is the type of a resource record
+type Type uint16
+
+// supported record types
+const (
+	TypeA    Type = 1
+	TypeNS   Type = 2
+	TypeSOA  Type = 6
+	TypePTR  Type = 12
+	TypeTXT  Type = 16
+	TypeAAAA Type = 28
+	TypeSRV  Type = 33
+	TypeANY  Type = 255
+)
+
+// Class is the class of a resource record
+type Class uint16
+
+// supported record classes
+const (
+	ClassINET Class = 1
+	ClassANY  Class = 255
+)
+
+// RCode is the response code
+type RCode uint8
+
+// response codes
+const (
+	RCodeSuccess        RCode = 0
+	RCodeFormatError    RCode = 1
+	RCodeServerFailure  RCode = 2
+	RCodeNameError      RCode = 3
+	RCodeNotImplemented RCode = 4
+	RCodeRefused        RCode = 5
+)
+
+const (
+	HeaderLen      = 12 // length of the fixed header of a DNS message
+	minQuestionLen = 5  // root name, type and class
+	minResourceLen = 11 // root name, type, class, ttl and rdata length
+	maxNameLen     = 255
+	maxLabelLen    = 63
+	maxPointers    = 16  // max num of compression pointers followed in a name, to avoid loop
+	MaxUDPMsgSize  = 512 // max size of a DNS message over UDP without EDNS
+)
+
+var (
+	errShortBuffer = errors.New("dns message is too short")
+	errInvalidName = errors.New("invalid domain name")
+	errBadCount    = errors.New("record count exceeds message size")
+)
+
+// Header is the header of a DNS message
+type Header struct {
+	ID                 uint16
+	Response           bool
+	Opcode             uint8
+	Authoritative      bool
+	Truncated          bool
+	RecursionDesired   bool
+	RecursionAvailable bool
+	RCode              RCode
+}
+
+// pack the header flags
+func (h *Header) flags() uint16 {
+	flags := uint16(h.Opcode&0xf)<<11 | uint16(h.RCode&0xf)
+	if h.Response {
+		flags |= 1 << 15
+	}
+	if h.Authoritative {
+		flags |= 1 << 10
+	}
+	if h.Truncated {
+		flags |= 1 << 9
+	}
+	if h.RecursionDesired {
+		flags |= 1 << 8
+	}
+	if h.RecursionAvailable {
+		flags |= 1 << 7
+	}
+	return flags
+}
+
+// unpack the header flags
+func (h *Header) setFlags(flags uint16) {
+	h.Response = flags&(1<<15) != 0
+	h.Opcode = uint8(flags>>11) & 0xf
+	h.Authoritative = flags&(1<<10) != 0
+	h.Truncated = flags&(1<<9) != 0
+	h.RecursionDesired = flags&(1<<8) != 0
+	h.RecursionAvailable = flags&(1<<7) != 0
+	h.RCode = RCode(flags & 0xf)
+}
+
+// Question is a question of a DNS query
+type Question struct {
+	Name  string // fully qualified domain name, e.g. "seed.example.com."
+	Type  Type
+	Class Class
+}
+
+// SOA is the data of a SOA record
+type SOA struct {
+	MName   string
+	RName   string
+	Serial  uint32
+	Refresh uint32
+	Retry   uint32
+	Expire  uint32
+	MinTTL  uint32
+}
+
+// SRV is the data of a SRV record(RFC 2782)
+type SRV struct {
+	Priority uint16
+	Weight   uint16
+	Port     uint16
+	Target   string
+}
+
+// Resource is a resource record, only the field corresponding To its type is valid.
+type Resource struct {
+	Name  string
+	Type  Type
+	Class Class
+	TTL   uint32
+	IP    net.IP   // A or AAAA record
+	NS    string   // NS record
+	SOA   *SOA     // SOA record
+	PTR   string   // PTR record
+	TXT   []string // TXT record
+	SRV   *SRV     // SRV record
+	Data  []byte   // raw data of unsupported record
+}
+
+// Message is a DNS message
+type Message struct {
+	Header
+	Questions   []Question
+	Answers     []Resource
+	Authorities []Resource
+	Additionals []Resource
+}
+
+// CanonicalName convert the name To lower case fully qualified form
+func CanonicalName(name string) string {
+	name = strings.ToLower(name)
+	if !strings.HasSuffix(name, ".") {
+		name += "."
+	}
+	return name
+}
+
+// Pack encode the message, names are not compressed.
+func (m *Message) Pack() ([]byte, error) {
+	buf := make([]byte, HeaderLen, MaxUDPMsgSize)
+	binary.BigEndian.PutUint16(buf[0:], m.ID)
+	binary.BigEndian.PutUint16(buf[2:], m.flags())
+	binary.BigEndian.PutUint16(buf[4:], uint16(len(m.Questions)))
+	binary.BigEndian.PutUint16(buf[6:], uint16(len(m.Answers)))
+	binary.BigEndian.PutUint16(buf[8:], uint16(len(m.Authorities)))
+	binary.BigEndian.PutUint16(buf[10:], uint16(len(m.Additionals)))
+
+	var err error
+	for _, q := range m.Questions {
+		if buf, err = packName(buf, q.Name); err != nil {
+			return nil, err
+		}
+		buf = packUint16(buf, uint16(q.Type))
+		buf = packUint16(buf, uint16(q.Class))
+	}
+	for _, section := range [][]Resource{m.Answers, m.Authorities, m.Additionals} {
+		for i := range section {
+			if buf, err = packResource(buf, &section[i]); err != nil {
+				return nil, err
+			}
+		}
+	}
+	return buf, nil
+}
+
+// Unpack decode a DNS message
+func Unpack(buf []byte) (*Message, error) {
+	if len(buf) < HeaderLen {
+		return nil, errShortBuffer
+	}
+	m := &Message{}
+	m.ID = binary.BigEndian.Uint16(buf[0:])
+	m.setFlags(binary.BigEndian.Uint16(buf[2:]))
+	qdCount := int(binary.BigEndian.Uint16(buf[4:]))
+	counts := []int{
+		int(binary.BigEndian.Uint16(buf[6:])),
+		int(binary.BigEndian.Uint16(buf[8:])),
+		int(binary.BigEndian.Uint16(buf[10:])),
+	}
+
+	// the counts come from the network, check them against the message size before allocating.
+	rrCount := counts[0] + counts[1] + counts[2]
+	if qdCount*minQuestionLen+rrCount*minResourceLen > len(buf)-HeaderLen {
+		return nil, errBadCount
+	}
+
+	off := HeaderLen
+	m.Questions = make([]Question, 0, qdCount)
+	for i := 0; i < qdCount; i++ {
+		name, next, err := unpackName(buf, off)
+		if err != nil {
+			return nil, err
+		}
+		if next+4 > len(buf) {
+			return nil, errShortBuffer
+		}
+		m.Questions = append(m.Questions, Question{
+			Name:  name,
+			Type:  Type(binary.BigEndian.Uint16(buf[next:])),
+			Class: Class(binary.BigEndian.Uint16(buf[next+2:])),
+		})
+		off = next + 4
+	}
+
+	sections := make([][]Resource, len(counts))
+	for i, count := range counts {
+		sections[i] = make([]Resource, 0, count)
+		for j := 0; j < count; j++ {
+			r, next, err := unpackResource(buf, off)
+			if err != nil {
+				return nil, err
+			}
+			sections[i] = append(sections[i], *r)
+			off = next
+		}
+	}
+	m.Answers, m.Authorities, m.Additionals = sections[0], sections[1], sections[2]
+	return m, nil
+}
+
+func packUint16(buf []byte, v uint16) []byte {
+	return append(buf, byte(v>>8), byte(v))
+}
+
+func packUint32(buf []byte, v uint32) []byte {
+	return append(buf, byte(v>>24), byte(v>>16), byte(v>>8), byte(v))
+}
+
+// encode a domain name as a sequence of labels
+func packName(buf []byte, name string) ([]byte, error) {
+	name = CanonicalName(name)
+	if len(name) > maxNameLen {
+		return nil, errInvalidName
+	}
+	if name != "." {
+		for _, label := range strings.Split(strings.TrimSuffix(name, "."), ".") {
+			if len(label) == 0 || len(label) > maxLabelLen {
+				return nil, errInvalidName
+			}
+			buf = append(buf, byte(len(label)))
+			buf = append(buf, label...)
+		}
+	}
+	return append(buf, 0), nil
+}
+
+// decode a domain name at the offset, return the name and the offset after it.
+func unpackName(buf []byte, off int) (string, int, error) {
+	labels := make([]string, 0)
+	next := -1 // offset after the name, decided by the first pointer
+	pointers := 0
+	nameLen := 0
+	for {
+		if off >= len(buf) {
+			return "", 0, errShortBuffer
+		}
+		l := int(buf[off])
+		switch l & 0xc0 {
+		case 0x00:
+			if l == 0 {
+				if next < 0 {
+					next = off + 1
+				}
+				return CanonicalName(strings.Join(labels, ".")), next, nil
+			}
+			if off+1+l > len(buf) {
+				return "", 0, errShortBuffer
+			}
+			nameLen += l + 1
+			if nameLen > maxNameLen {
+				return "", 0, errInvalidName
+			}
+			labels = append(labels, string(buf[off+1:off+1+l]))
+			off += 1 + l
+		case 0xc0:
+			if off+2 > len(buf) {
+				return "", 0, errShortBuffer
+			}
+			if pointers++; pointers > maxPointers {
+				return "", 0, errInvalidName
+			}
+			if next < 0 {
+				next = off + 2
+			}
+			off = int(binary.BigEndian.Uint16(buf[off:]) & 0x3fff)
+		default:
+			return "", 0, errInvalidName
+		}
+	}
+}
+
+// encode a resource record
+func packResource(buf []byte, r *Resource) ([]byte, error) {
+	var err error
+	if buf, err = packName(buf, r.Name); err != nil {
+		return nil, err
+	}
+	buf = packUint16(buf, uint16(r.Type))
+	buf = packUint16(buf, uint16(r.Class))
+	buf = packUint32(buf, r.TTL)
+	lenOff := len(buf)
+	buf = packUint16(buf, 0)
+
+	switch r.Type {
+	case TypeA:
+		ip := r.IP.To4()
+		if ip == nil {
+			return nil, fmt.Errorf("invalid ipv4 address %v", r.IP)
+		}
+		buf = append(buf, ip...)
+	case TypeAAAA:
+		ip := r.IP.To16()
+		if ip == nil || r.IP.To4() != nil {
+			return nil, fmt.Errorf("invalid ipv6 address %v", r.IP)
+		}
+		buf = append(buf, ip...)
+	case TypeNS:
+		if buf, err = packName(buf, r.NS); err != nil {
+			return nil, err
+		}
+	case TypeSOA:
+		if r.SOA == nil {
+			return nil, errors.New("missing SOA data")
+		}
+		if buf, err = packName(buf, r.SOA.MName); err != nil {
+			return nil, err
+		}
+		if buf, err = packName(buf, r.SOA.RName); err != nil {
+			return nil, err
+		}
+		for _, v := range []uint32{r.SOA.Serial, r.SOA.Refresh, r.SOA.Retry, r.SOA.Expire, r.SOA.MinTTL} {
+			buf = packUint32(buf, v)
+		}
+	case TypePTR:
+		if buf, err = packName(buf, r.PTR); err != nil {
+			return nil, err
+		}
+	case TypeTXT:
+		for _, txt := range r.TXT {
+			if len(txt) > 255 {
+				return nil, fmt.Errorf("TXT string too long: %d", len(txt))
+			}
+			buf = append(buf, byte(len(txt)))
+			buf = append(buf, txt...)
+		}
+	case TypeSRV:
+		if r.SRV == nil {
+			return nil, errors.New("missing SRV data")
+		}
+		buf = packUint16(buf, r.SRV.Priority)
+		buf = packUint16(buf, r.SRV.Weight)
+		buf = packUint16(buf, r.SRV.Port)
+		if buf, err = packName(buf, r.SRV.Target); err != nil {
+			return nil, err
+		}
+	default:
+		buf = append(buf, r.Data...)
+	}
+	binary.BigEndian.PutUint16(buf[lenOff:], uint16(len(buf)-lenOff-2))
+	return buf, nil
+}
+
+// decode a resource record at the offset, return the record and the offset after it.
+func unpackResource(buf []byte, off int) (*Resource, int, error) {
+	name, off, err := unpackName(buf, off)
+	if err != nil {
+		return nil, 0, err
+	}
+	if off+10 > len(buf) {
+		return nil, 0, errShortBuffer
+	}
+	r := &Resource{
+		Name:  name,
+		Type:  Type(binary.BigEndian.Uint16(buf[off:])),
+		Class: Class(binary.BigEndian.Uint16(buf[off+2:])),
+		TTL:   binary.BigEndian.Uint32(buf[off+4:]),
+	}
+	rdLen := int(binary.BigEndian.Uint16(buf[off+8:]))
+	off += 10
+	end := off + rdLen
+	if end > len(buf) {
+		return nil, 0, errShortBuffer
+	}
+
+	switch r.Type {
+	case TypeA, TypeAAAA:
+		if (r.Type == TypeA && rdLen != net.IPv4len) || (r.Type == TypeAAAA && rdLen != net.IPv6len) {
+			return nil, 0, fmt.Errorf("invalid length %d of %d record", rdLen, r.Type)
+		}
+		r.IP = net.IP(append([]byte(nil), buf[off:end]...))
+	case TypeNS:
+		if r.NS, _, err = unpackName(buf, off); err != nil {
+			return nil, 0, err
+		}
+	case TypeSOA:
+		soa := &SOA{}
+		next := off
+		if soa.MName, next, err = unpackName(buf, next); err != nil {
+			return nil, 0, err
+		}
+		if soa.RName, next, err = unpackName(buf, next); err != nil {
+			return nil, 0, err
+		}
+		if next+20 > end {
+			return nil, 0, errShortBuffer
+		}
+		soa.Serial = binary.BigEndian.Uint32(buf[next:])
+		soa.Refresh = binary.BigEndian.Uint32(buf[next+4:])
+		soa.Retry = binary.BigEndian.Uint32(buf[next+8:])
+		soa.Expire = binary.BigEndian.Uint32(buf[next+12:])
+		soa.MinTTL = binary.BigEndian.Uint32(buf[next+16:])
+		r.SOA = soa
+	case TypePTR:
+		if r.PTR, _, err = unpackName(buf, off); err != nil {
+			return nil, 0, err
+		}
+	case TypeTXT:
+		r.TXT = make([]string, 0)
+		for next := off; next < end; {
+			l := int(buf[next])
+			if next+1+l > end {
+				return nil, 0, errShortBuffer
+			}
+			r.TXT = append(r.TXT, string(buf[next+1:next+1+l]))
+			next += 1 + l
+		}
+	case TypeSRV:
+		if rdLen < 7 {
+			return nil, 0, errShortBuffer
+		}
+		srv := &SRV{
+			Priority: binary.BigEndian.Uint16(buf[off:]),
+			Weight:   binary.BigEndian.Uint16(buf[off+2:]),
+			Port:     binary.BigEndian.Uint16(buf[off+4:]),
+		}
+		if srv.Target, _, err = unpackName(buf, off+6); err != nil {
+			return nil, 0, err
+		}
+		r.SRV = srv
+	default:
+		r.Data = append([]byte(nil), buf[off:end]...)
+	}
+	return r, end, nil
+}
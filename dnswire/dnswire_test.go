@@ -0,0 +1,118 @@
+package dnswire
+
+import (
+	"github.com/stretchr/testify/assert"
+	"net"
+	"testing"
+)
+
+func TestCanonicalName(t *testing.T) {
+	assert := assert.New(t)
+	assert.Equal("seed.example.com.", CanonicalName("Seed.Example.COM"))
+	assert.Equal("seed.example.com.", CanonicalName("seed.example.com."))
+}
+
+func TestMessage_PackUnpack(t *testing.T) {
+	assert := assert.New(t)
+	msg := &Message{
+		Header: Header{
+			ID:               0x1234,
+			Response:         true,
+			Authoritative:    true,
+			RecursionDesired: true,
+			RCode:            RCodeSuccess,
+		},
+		Questions: []Question{{Name: "seed.example.com.", Type: TypeA, Class: ClassINET}},
+		Answers: []Resource{
+			{Name: "seed.example.com.", Type: TypeA, Class: ClassINET, TTL: 60, IP: net.ParseIP("192.168.1.1").To4()},
+			{Name: "seed.example.com.", Type: TypeAAAA, Class: ClassINET, TTL: 60, IP: net.ParseIP("2001:db8::1")},
+		},
+		Authorities: []Resource{
+			{Name: "example.com.", Type: TypeNS, Class: ClassINET, TTL: 3600, NS: "ns.example.com."},
+			{Name: "example.com.", Type: TypeSOA, Class: ClassINET, TTL: 3600, SOA: &SOA{
+				MName: "ns.example.com.", RName: "admin.example.com.", Serial: 1, Refresh: 2, Retry: 3, Expire: 4, MinTTL: 5,
+			}},
+		},
+		Additionals: []Resource{
+			{Name: "_svc._tcp.local.", Type: TypePTR, Class: ClassINET, TTL: 120, PTR: "node._svc._tcp.local."},
+			{Name: "node._svc._tcp.local.", Type: TypeSRV, Class: ClassINET, TTL: 120, SRV: &SRV{Port: 8080, Target: "node.local."}},
+			{Name: "node._svc._tcp.local.", Type: TypeTXT, Class: ClassINET, TTL: 120, TXT: []string{"service=0", ""}},
+			{Name: ".", Type: Type(41), Class: Class(4096), Data: []byte{0x00, 0x0a, 0x00, 0x02, 0x01, 0x02}},
+		},
+	}
+	buf, err := msg.Pack()
+	assert.Nil(err)
+	msg1, err := Unpack(buf)
+	assert.Nil(err)
+	assert.Equal(msg, msg1)
+}
+
+func TestUnpack_Compressed(t *testing.T) {
+	assert := assert.New(t)
+	buf := []byte{
+		0x00, 0x01, 0x81, 0x80, 0x00, 0x01, 0x00, 0x01, 0x00, 0x00, 0x00, 0x00,
+		// question: seed.example.com A IN
+		4, 's', 'e', 'e', 'd', 7, 'e', 'x', 'a', 'm', 'p', 'l', 'e', 3, 'c', 'o', 'm', 0, 0x00, 0x01, 0x00, 0x01,
+		// answer: pointer To offset 12
+		0xc0, 12, 0x00, 0x01, 0x00, 0x01, 0x00, 0x00, 0x00, 0x3c, 0x00, 0x04, 8, 8, 8, 8,
+	}
+	msg, err := Unpack(buf)
+	assert.Nil(err)
+	assert.True(msg.Response)
+	assert.True(msg.RecursionAvailable)
+	assert.Equal("seed.example.com.", msg.Questions[0].Name)
+	assert.Equal(1, len(msg.Answers))
+	assert.Equal("seed.example.com.", msg.Answers[0].Name)
+	assert.Equal(uint32(60), msg.Answers[0].TTL)
+	assert.Equal("8.8.8.8", msg.Answers[0].IP.String())
+}
+
+func TestUnpack_Malformed(t *testing.T) {
+	assert := assert.New(t)
+	_, err := Unpack([]byte{0x00, 0x01})
+	assert.NotNil(err)
+
+	// truncated question
+	_, err = Unpack([]byte{0x00, 0x01, 0x01, 0x00, 0x00, 0x01, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 4, 's', 'e'})
+	assert.NotNil(err)
+
+	// pointer loop
+	_, err = Unpack([]byte{0x00, 0x01, 0x01, 0x00, 0x00, 0x01, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0xc0, 12, 0x00, 0x01, 0x00, 0x01})
+	assert.NotNil(err)
+
+	// invalid A record length
+	_, err = Unpack([]byte{
+		0x00, 0x01, 0x81, 0x80, 0x00, 0x00, 0x00, 0x01, 0x00, 0x00, 0x00, 0x00,
+		0, 0x00, 0x01, 0x00, 0x01, 0x00, 0x00, 0x00, 0x3c, 0x00, 0x03, 8, 8, 8,
+	})
+	assert.NotNil(err)
+}
+
+func TestPack_InvalidRecord(t *testing.T) {
+	assert := assert.New(t)
+	msg := &Message{
+		Answers: []Resource{{Name: "seed.example.com.", Type: TypeA, Class: ClassINET, IP: net.ParseIP("2001:db8::1")}},
+	}
+	_, err := msg.Pack()
+	assert.NotNil(err)
+
+	msg = &Message{
+		Questions: []Question{{Name: "a..example.com.", Type: TypeA, Class: ClassINET}},
+	}
+	_, err = msg.Pack()
+	assert.NotNil(err)
+}
+
+func TestUnpack_OversizedCounts(t *testing.T) {
+	assert := assert.New(t)
+	// header only, every count set to the maximum
+	_, err := Unpack([]byte{0x00, 0x01, 0x01, 0x00, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff})
+	assert.Equal(errBadCount, err)
+
+	// one question claimed, but the answers don't fit in the remaining bytes
+	_, err = Unpack([]byte{
+		0x00, 0x01, 0x01, 0x00, 0x00, 0x01, 0x00, 0x02, 0x00, 0x00, 0x00, 0x00,
+		0, 0x00, 0x01, 0x00, 0x01,
+	})
+	assert.Equal(errBadCount, err)
+}
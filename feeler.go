@@ -0,0 +1,66 @@
+package p2p
+
+import (
+	"errors"
+	"github.com/DSiSc/craft/log"
+	"github.com/DSiSc/p2p/message"
+	"time"
+)
+
+const defaultFeelerInterval = 2 * time.Minute
+
+// A feeler connection handshakes with a random untried address and disconnects immediately. A reachable
+// address is promoted To tried table, and the failure of a dead one is counted, so it will be removed as a
+// terrible address. An address is reachable if remote answered, even if the handshake failed, e.g. remote is
+// full of peers, only failing To dial or timeout makes an address dead. Feeler peers are not put in peer table, so they never consume outbound slots.
+
+// feelerHandler make a feeler connection periodically
+func (service *P2P) feelerHandler() {
+	interval := service.config.FeelerInterval
+	if interval <= 0 {
+		interval = defaultFeelerInterval
+	}
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			service.feel()
+		case <-service.quitChan:
+			return
+		}
+	}
+}
+
+// make a feeler connection To a random untried address
+func (service *P2P) feel() {
+	addr, err := service.addrManager.GetUntriedAddress()
+	if err != nil {
+		log.Debug("no address for feeler connection, as: %v", err)
+		return
+	}
+	if service.addrManager.IsOurAddress(addr) || service.containsPeer(addr) || service.checkOutbound(addr) != nil {
+		return
+	}
+
+	log.Debug("start feeler connection To %s", addr.ToString())
+	service.addrManager.UpdateAddressAttemptInfo(addr)
+	peer := NewOutboundPeer(&service.PeerCom, addr, false, service.internalChan)
+	err = peer.Start()
+	if err != nil && peer.GetVersion() == "" && !isRemoteDisconnect(err) {
+		log.Debug("feeler connection To %s failed, as: %v", addr.ToString(), err)
+		service.addrManager.RecordDisconnect(addr, disconnectReason(err))
+		return
+	}
+	if err == nil {
+		peer.sayGoodbye(newDisconnectError(message.ReasonNone, errors.New("feeler connection finished")))
+		peer.Stop()
+	}
+
+	log.Debug("address %s is reachable", addr.ToString())
+	service.addrManager.ResetAddressAttemptInfo(addr)
+	service.addrManager.Good(addr)
+	if peer.GetVersion() != "" {
+		service.addrManager.Connected(addr, peer.GetService())
+	}
+}
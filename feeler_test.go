@@ -0,0 +1,120 @@
+package p2p
+
+import (
+	"errors"
+	"github.com/DSiSc/p2p/common"
+	"github.com/DSiSc/p2p/config"
+	"github.com/DSiSc/p2p/message"
+	"github.com/stretchr/testify/assert"
+	"net"
+	"os"
+	"testing"
+	"time"
+)
+
+func TestAddressManager_GetUntriedAddress(t *testing.T) {
+	assert := assert.New(t)
+	os.RemoveAll(addressFile)
+	addrManger := NewAddressManager(addressFile)
+	_, err := addrManger.GetUntriedAddress()
+	assert.NotNil(err)
+
+	addrs := mockNetAddresses(2)
+	addrManger.AddAddresses(addrs)
+	addrManger.Good(addrs[0])
+	for i := 0; i < 10; i++ {
+		addr, err := addrManger.GetUntriedAddress()
+		assert.Nil(err)
+		assert.Equal(addrs[1], addr)
+	}
+}
+
+func TestP2P_FeelUnreachable(t *testing.T) {
+	assert := assert.New(t)
+	p2p, err := NewP2P(mockConfig(), nil)
+	assert.Nil(err)
+
+	// a closed port
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	assert.Nil(err)
+	addr, _ := common.ParseNetAddress(listener.Addr().String())
+	listener.Close()
+
+	p2p.addrManager.AddAddress(addr)
+	p2p.feel()
+	attemptNum, _ := p2p.addrManager.GetAddressAttemptInfo(addr)
+	assert.Equal(uint32(1), attemptNum)
+	assert.False(p2p.addrManager.IsTried(addr))
+	assert.Equal(0, p2p.peers.count(nil))
+}
+
+func TestP2P_FeelReachable(t *testing.T) {
+	assert := assert.New(t)
+	p2p, err := NewP2P(mockConfig(), nil)
+	assert.Nil(err)
+
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	assert.Nil(err)
+	defer listener.Close()
+	go func() {
+		conn, err := listener.Accept()
+		if err != nil {
+			return
+		}
+		connAddr, _ := common.ParseNetAddress(conn.RemoteAddr().String())
+		peer := NewInboundPeer(mockServerInfo(), connAddr, make(chan *InternalMsg, 10), conn)
+		peer.Start()
+	}()
+	addr, _ := common.ParseNetAddress(listener.Addr().String())
+
+	p2p.addrManager.AddAddress(addr)
+	p2p.feel()
+	assert.True(p2p.addrManager.IsTried(addr))
+	assert.False(p2p.addrManager.LastSuccessTime(addr).IsZero())
+	assert.Equal(config.SFNodeTX, p2p.addrManager.book.get(addr).services)
+	assert.Equal(0, p2p.peers.count(nil))
+}
+
+func TestP2P_FeelFull(t *testing.T) {
+	assert := assert.New(t)
+	p2p, err := NewP2P(mockConfig(), nil)
+	assert.Nil(err)
+
+	// remote is reachable but full of peers
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	assert.Nil(err)
+	defer listener.Close()
+	go func() {
+		conn, err := listener.Accept()
+		if err != nil {
+			return
+		}
+		sayGoodbye(conn, newDisconnectError(message.ReasonTooManyPeers, errors.New("too many inbound peers")))
+	}()
+	addr, _ := common.ParseNetAddress(listener.Addr().String())
+
+	p2p.addrManager.AddAddress(addr)
+	p2p.feel()
+	attemptNum, _ := p2p.addrManager.GetAddressAttemptInfo(addr)
+	assert.Equal(uint32(0), attemptNum)
+	assert.True(p2p.addrManager.IsTried(addr))
+	assert.Equal(0, p2p.peers.count(nil))
+}
+
+func TestP2P_FeelBanned(t *testing.T) {
+	assert := assert.New(t)
+	p2p, err := NewP2P(mockConfig(), nil)
+	assert.Nil(err)
+
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	assert.Nil(err)
+	defer listener.Close()
+	addr, _ := common.ParseNetAddress(listener.Addr().String())
+	p2p.addrManager.AddAddress(addr)
+	p2p.bans.ban(addr.ParsedIP(), time.Hour, "spam")
+
+	p2p.feel()
+	attemptNum, _ := p2p.addrManager.GetAddressAttemptInfo(addr)
+	assert.Equal(uint32(0), attemptNum)
+	assert.False(p2p.addrManager.IsTried(addr))
+}
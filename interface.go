@@ -3,6 +3,7 @@ package p2p
 import (
 	"github.com/DSiSc/p2p/common"
 	"github.com/DSiSc/p2p/message"
+	"time"
 )
 
 type P2PAPI interface {
@@ -23,4 +24,31 @@ type P2PAPI interface {
 
 	// MessageChan get p2p's message channel, (Messages sent To the server will eventually be placed in the message channel)
 	MessageChan() <-chan *InternalMsg
+
+	// AddPersistentPeer add a persistent peer and connect To it, the change is saved
+	AddPersistentPeer(addr *common.NetAddress) error
+
+	// RemovePersistentPeer remove a persistent peer, the change is saved
+	RemovePersistentPeer(addr *common.NetAddress) error
+
+	// PersistentPeers get the persistent peers
+	PersistentPeers() []*common.NetAddress
+
+	// DialPeer connect To a peer once
+	DialPeer(addr *common.NetAddress) error
+
+	// DisconnectPeer disconnect a peer
+	DisconnectPeer(addr *common.NetAddress) error
+
+	// BanPeer ban the ip of the address for the duration, the peers with the ip are disconnected
+	BanPeer(addr *common.NetAddress, duration time.Duration, reason string) error
+
+	// UnbanPeer lift the ban of the ip of the address
+	UnbanPeer(addr *common.NetAddress) error
+
+	// BannedPeers get the banned ips
+	BannedPeers() []*BanInfo
+
+	// GetPeerInfo get the snapshot of the peers and their statistics
+	GetPeerInfo() []*PeerInfo
 }
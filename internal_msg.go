@@ -20,7 +20,8 @@ type InternalMsg struct {
 
 // peer disconect message ping message
 type peerDisconnecMsg struct {
-	err error
+	err    error
+	reason message.DisconnectReason // reason code of the disconnection
 }
 
 func (this *peerDisconnecMsg) MsgId() types.Hash {
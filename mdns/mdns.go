@@ -0,0 +1,328 @@
+// Package mdns implements a minimal mDNS(RFC 6762)/DNS-SD(RFC 6763) responder and browser, so the nodes in the
+// same LAN can find each other without seeds. A node advertises its listen address, service flag and version as
+// an instance of service type "_justitia._tcp.local.", and browses the instances of the others periodically.
+package mdns
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"github.com/DSiSc/craft/log"
+	"github.com/DSiSc/p2p/config"
+	"github.com/DSiSc/p2p/dnswire"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	// ServiceType is the DNS-SD service type of p2p nodes
+	ServiceType = "_justitia._tcp.local."
+	// DefaultGroup is the mDNS ipv4 multicast group
+	DefaultGroup = "224.0.0.251:5353"
+
+	defaultBrowseInterval = time.Minute
+	recordTTL             = 120
+	cacheFlush            = 0x8000 // top bit of class, cache-flush in response and unicast-response in question
+	maxPacketSize         = 9000
+	txtService            = "service"
+	txtVersion            = "version"
+)
+
+// Entry is a node discovered in LAN
+type Entry struct {
+	Instance string
+	IP       net.IP
+	Port     uint16
+	Service  config.ServiceFlag
+	Version  string
+}
+
+// Config is the config of mDNS service
+type Config struct {
+	Port      uint16             // our p2p listen port
+	IPs       []net.IP           // our ips advertised, all non-loopback interface ips if empty
+	Service   config.ServiceFlag // our service flag
+	Version   string             // our version
+	Interval  time.Duration      // browse interval(default 1m)
+	Group     string             // multicast group address(default 224.0.0.251:5353)
+	Interface string             // name of the interface To join the group, system default if empty
+	OnEntry   func(entry *Entry) // called when a node is discovered
+}
+
+// MDNS is the mDNS responder and browser
+type MDNS struct {
+	config   Config
+	instance string // our instance name
+	group    *net.UDPAddr
+	conn     *net.UDPConn
+	quitChan chan struct{}
+	wg       sync.WaitGroup
+}
+
+// New create a mDNS service instance with a random instance name
+func New(config Config) (*MDNS, error) {
+	if config.Port == 0 {
+		return nil, errors.New("listen port is not specified")
+	}
+	if config.Group == "" {
+		config.Group = DefaultGroup
+	}
+	if config.Interval <= 0 {
+		config.Interval = defaultBrowseInterval
+	}
+	group, err := net.ResolveUDPAddr("udp4", config.Group)
+	if err != nil {
+		return nil, err
+	}
+	id := make([]byte, 8)
+	rand.Read(id)
+	return &MDNS{
+		config:   config,
+		instance: "node-" + hex.EncodeToString(id),
+		group:    group,
+		quitChan: make(chan struct{}),
+	}, nil
+}
+
+// Start join the multicast group, announce ourselves and start browsing.
+func (m *MDNS) Start() error {
+	var ifi *net.Interface
+	if m.config.Interface != "" {
+		var err error
+		if ifi, err = net.InterfaceByName(m.config.Interface); err != nil {
+			return err
+		}
+	}
+	conn, err := net.ListenMulticastUDP("udp4", ifi, m.group)
+	if err != nil {
+		return err
+	}
+	m.conn = conn
+	log.Info("mdns service started, instance %s", m.instance)
+	m.wg.Add(2)
+	go m.readLoop()
+	go m.browseLoop()
+	return nil
+}
+
+// Stop stop the service
+func (m *MDNS) Stop() {
+	close(m.quitChan)
+	if m.conn != nil {
+		m.conn.Close()
+	}
+	m.wg.Wait()
+}
+
+// Instance get our instance name
+func (m *MDNS) Instance() string {
+	return m.instance
+}
+
+// read and handle the packets From the group
+func (m *MDNS) readLoop() {
+	defer m.wg.Done()
+	buf := make([]byte, maxPacketSize)
+	for {
+		n, from, err := m.conn.ReadFromUDP(buf)
+		if err != nil {
+			select {
+			case <-m.quitChan:
+			default:
+				log.Error("failed To read mdns packet, as: %v", err)
+			}
+			return
+		}
+		if resp := m.handlePacket(buf[:n], from); resp != nil {
+			m.send(resp)
+		}
+	}
+}
+
+// announce ourselves and query the others periodically
+func (m *MDNS) browseLoop() {
+	defer m.wg.Done()
+	if announce, err := m.response(); err == nil {
+		m.send(announce)
+	}
+	ticker := time.NewTicker(m.config.Interval)
+	defer ticker.Stop()
+	for {
+		if query, err := m.query(); err == nil {
+			m.send(query)
+		}
+		select {
+		case <-ticker.C:
+		case <-m.quitChan:
+			return
+		}
+	}
+}
+
+// send the packet To the group
+func (m *MDNS) send(buf []byte) {
+	if _, err := m.conn.WriteToUDP(buf, m.group); err != nil {
+		log.Debug("failed To send mdns packet, as: %v", err)
+	}
+}
+
+// handle a packet, return the response if it's a query we should answer.
+func (m *MDNS) handlePacket(buf []byte, from *net.UDPAddr) []byte {
+	msg, err := dnswire.Unpack(buf)
+	if err != nil {
+		log.Debug("bad mdns packet From %s, as: %v", from.String(), err)
+		return nil
+	}
+	// only standard query and response are supported
+	if msg.Opcode != 0 || msg.RCode != dnswire.RCodeSuccess {
+		return nil
+	}
+	if msg.Response {
+		m.handleResponse(msg, from)
+		return nil
+	}
+	for _, q := range msg.Questions {
+		name := dnswire.CanonicalName(q.Name)
+		if (q.Type == dnswire.TypePTR || q.Type == dnswire.TypeANY) && name == ServiceType {
+			resp, err := m.response()
+			if err != nil {
+				log.Error("failed To pack mdns response, as: %v", err)
+				return nil
+			}
+			return resp
+		}
+	}
+	return nil
+}
+
+// handle a response, the instances of our service type are reported.
+func (m *MDNS) handleResponse(msg *dnswire.Message, from *net.UDPAddr) {
+	records := append(msg.Answers, msg.Additionals...)
+	instances := make([]string, 0)
+	srvs := make(map[string]*dnswire.SRV)
+	txts := make(map[string][]string)
+	ips := make(map[string][]net.IP)
+	for _, r := range records {
+		name := dnswire.CanonicalName(r.Name)
+		switch r.Type {
+		case dnswire.TypePTR:
+			if name == ServiceType {
+				instances = append(instances, dnswire.CanonicalName(r.PTR))
+			}
+		case dnswire.TypeSRV:
+			srvs[name] = r.SRV
+		case dnswire.TypeTXT:
+			txts[name] = r.TXT
+		case dnswire.TypeA, dnswire.TypeAAAA:
+			ips[name] = append(ips[name], r.IP)
+		}
+	}
+
+	for _, instance := range instances {
+		srv := srvs[instance]
+		label := strings.TrimSuffix(instance, "."+ServiceType)
+		if srv == nil || label == instance || label == m.instance || srv.Port == 0 {
+			continue
+		}
+		entry := &Entry{Instance: label, Port: srv.Port}
+		parseTXT(entry, txts[instance])
+		// the ips of the target host, or the source ip of the packet if not given
+		hostIPs := ips[dnswire.CanonicalName(srv.Target)]
+		if len(hostIPs) == 0 {
+			hostIPs = []net.IP{from.IP}
+		}
+		for _, ip := range hostIPs {
+			e := *entry
+			e.IP = ip
+			if m.config.OnEntry != nil {
+				m.config.OnEntry(&e)
+			}
+		}
+	}
+}
+
+// parse the TXT strings of the instance
+func parseTXT(entry *Entry, txt []string) {
+	for _, kv := range txt {
+		pair := strings.SplitN(kv, "=", 2)
+		if len(pair) != 2 {
+			continue
+		}
+		switch pair[0] {
+		case txtService:
+			if service, err := strconv.ParseUint(pair[1], 10, 64); err == nil {
+				entry.Service = config.ServiceFlag(service)
+			}
+		case txtVersion:
+			entry.Version = pair[1]
+		}
+	}
+}
+
+// the query of our service type
+func (m *MDNS) query() ([]byte, error) {
+	msg := &dnswire.Message{
+		Questions: []dnswire.Question{{Name: ServiceType, Type: dnswire.TypePTR, Class: dnswire.ClassINET}},
+	}
+	return msg.Pack()
+}
+
+// the response advertising our instance
+func (m *MDNS) response() ([]byte, error) {
+	instance := m.instance + "." + ServiceType
+	host := m.instance + ".local."
+	msg := &dnswire.Message{
+		Header: dnswire.Header{Response: true, Authoritative: true},
+		Answers: []dnswire.Resource{
+			{Name: ServiceType, Type: dnswire.TypePTR, Class: dnswire.ClassINET, TTL: recordTTL, PTR: instance},
+		},
+		Additionals: []dnswire.Resource{
+			{
+				Name:  instance,
+				Type:  dnswire.TypeSRV,
+				Class: dnswire.ClassINET | cacheFlush,
+				TTL:   recordTTL,
+				SRV:   &dnswire.SRV{Port: m.config.Port, Target: host},
+			},
+			{
+				Name:  instance,
+				Type:  dnswire.TypeTXT,
+				Class: dnswire.ClassINET | cacheFlush,
+				TTL:   recordTTL,
+				TXT: []string{
+					txtService + "=" + strconv.FormatUint(uint64(m.config.Service), 10),
+					txtVersion + "=" + m.config.Version,
+				},
+			},
+		},
+	}
+	for _, ip := range m.ips() {
+		r := dnswire.Resource{Name: host, Type: dnswire.TypeA, Class: dnswire.ClassINET | cacheFlush, TTL: recordTTL, IP: ip}
+		if ip.To4() == nil {
+			r.Type = dnswire.TypeAAAA
+		}
+		msg.Additionals = append(msg.Additionals, r)
+	}
+	return msg.Pack()
+}
+
+// our ips advertised
+func (m *MDNS) ips() []net.IP {
+	if len(m.config.IPs) > 0 {
+		return m.config.IPs
+	}
+	ips := make([]net.IP, 0)
+	addrs, err := net.InterfaceAddrs()
+	if err != nil {
+		return ips
+	}
+	for _, addr := range addrs {
+		if ipNet, ok := addr.(*net.IPNet); ok && !ipNet.IP.IsLoopback() && !ipNet.IP.IsLinkLocalUnicast() {
+			ips = append(ips, ipNet.IP)
+		}
+	}
+	return ips
+}
@@ -0,0 +1,123 @@
+package mdns
+
+import (
+	"github.com/DSiSc/p2p/config"
+	"github.com/DSiSc/p2p/dnswire"
+	"github.com/stretchr/testify/assert"
+	"net"
+	"strconv"
+	"testing"
+	"time"
+)
+
+// mock a mDNS service, the entries discovered are sent To the channel
+func mockMDNS(t *testing.T, port uint16, ips ...net.IP) (*MDNS, chan *Entry) {
+	entries := make(chan *Entry, 10)
+	m, err := New(Config{
+		Port:    port,
+		IPs:     ips,
+		Service: config.SFNodeBlockSyncer,
+		Version: "1.0",
+		Group:   "224.0.0.251:" + strconv.Itoa(20000+int(port)),
+		OnEntry: func(entry *Entry) {
+			entries <- entry
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return m, entries
+}
+
+func TestNew(t *testing.T) {
+	assert := assert.New(t)
+	_, err := New(Config{})
+	assert.NotNil(err)
+	m, err := New(Config{Port: 8080})
+	assert.Nil(err)
+	assert.Equal(DefaultGroup, m.group.String())
+	assert.Equal(defaultBrowseInterval, m.config.Interval)
+	m1, _ := New(Config{Port: 8080})
+	assert.NotEqual(m.Instance(), m1.Instance())
+}
+
+func TestMDNS_QueryResponse(t *testing.T) {
+	assert := assert.New(t)
+	a, entriesA := mockMDNS(t, 8080, net.ParseIP("192.168.1.1"))
+	b, _ := mockMDNS(t, 8081, net.ParseIP("192.168.1.2"), net.ParseIP("fd00::2"))
+	fromA := &net.UDPAddr{IP: net.ParseIP("192.168.1.1"), Port: 5353}
+	fromB := &net.UDPAddr{IP: net.ParseIP("192.168.1.2"), Port: 5353}
+
+	query, err := a.query()
+	assert.Nil(err)
+	resp := b.handlePacket(query, fromA)
+	assert.NotNil(resp)
+	assert.Nil(a.handlePacket(resp, fromB))
+	assert.Equal(2, len(entriesA))
+	entry := <-entriesA
+	assert.Equal(b.Instance(), entry.Instance)
+	assert.Equal("192.168.1.2", entry.IP.String())
+	assert.Equal(uint16(8081), entry.Port)
+	assert.Equal(config.SFNodeBlockSyncer, entry.Service)
+	assert.Equal("1.0", entry.Version)
+	entry = <-entriesA
+	assert.Equal("fd00::2", entry.IP.String())
+
+	// our own response is ignored
+	resp, _ = a.response()
+	a.handlePacket(resp, fromA)
+	assert.Equal(0, len(entriesA))
+
+	// other queries are not answered
+	msg := &dnswire.Message{Questions: []dnswire.Question{{Name: "_http._tcp.local.", Type: dnswire.TypePTR, Class: dnswire.ClassINET}}}
+	query, _ = msg.Pack()
+	assert.Nil(b.handlePacket(query, fromA))
+	assert.Nil(b.handlePacket([]byte{0x00}, fromA))
+}
+
+func TestMDNS_ResponseWithoutAddress(t *testing.T) {
+	assert := assert.New(t)
+	a, entries := mockMDNS(t, 8080, net.ParseIP("192.168.1.1"))
+	instance := "other." + ServiceType
+	msg := &dnswire.Message{
+		Header: dnswire.Header{Response: true, Authoritative: true},
+		Answers: []dnswire.Resource{
+			{Name: ServiceType, Type: dnswire.TypePTR, Class: dnswire.ClassINET, TTL: recordTTL, PTR: instance},
+			{Name: instance, Type: dnswire.TypeSRV, Class: dnswire.ClassINET | cacheFlush, TTL: recordTTL, SRV: &dnswire.SRV{Port: 9090, Target: "other.local."}},
+		},
+	}
+	resp, _ := msg.Pack()
+	a.handlePacket(resp, &net.UDPAddr{IP: net.ParseIP("192.168.1.3"), Port: 5353})
+	assert.Equal(1, len(entries))
+	entry := <-entries
+	assert.Equal("other", entry.Instance)
+	assert.Equal("192.168.1.3", entry.IP.String())
+	assert.Equal(uint16(9090), entry.Port)
+	assert.Equal(config.SFNodeTX, entry.Service)
+}
+
+func TestMDNS_Multicast(t *testing.T) {
+	assert := assert.New(t)
+	a, entriesA := mockMDNS(t, 8080, net.ParseIP("192.168.1.1"))
+	b, _ := mockMDNS(t, 8080, net.ParseIP("192.168.1.2"))
+	if err := a.Start(); err != nil {
+		t.Skipf("multicast is not available, as: %v", err)
+	}
+	defer a.Stop()
+	assert.Nil(b.Start())
+	defer b.Stop()
+
+	timer := time.NewTimer(2 * time.Second)
+	defer timer.Stop()
+	for {
+		select {
+		case entry := <-entriesA:
+			if entry.Instance == b.Instance() {
+				assert.Equal("192.168.1.2", entry.IP.String())
+				return
+			}
+		case <-timer.C:
+			t.Skip("multicast packets are not delivered on this host")
+		}
+	}
+}
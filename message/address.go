@@ -3,9 +3,15 @@ package message
 import (
 	"github.com/DSiSc/craft/types"
 	"github.com/DSiSc/p2p/common"
+	"github.com/DSiSc/p2p/config"
+	"time"
 )
 
-type AddrReq struct{}
+// AddrReq request the known addresses of the peer, only the addresses supporting Services are requested if it's
+// not nil.
+type AddrReq struct {
+	Services *config.ServiceFlag `json:"services,omitempty"`
+}
 
 func (this *AddrReq) MsgId() types.Hash {
 	return EmptyHash
@@ -19,8 +25,24 @@ func (this *AddrReq) ResponseMsgType() MessageType {
 	return ADDR_TYPE
 }
 
+// TimedAddress is a net address with its last seen time and services, it's encoded compatible with
+// common.NetAddress, so the peers only know the plain address can still read it.
+type TimedAddress struct {
+	*common.NetAddress
+	Timestamp int64               `json:"timestamp,omitempty"` // unix time when the address was last seen active, 0 if unknown
+	Services  *config.ServiceFlag `json:"services,omitempty"`  // services supported by the address, nil if unknown
+}
+
+// NewTimedAddress create a timed address instance
+func NewTimedAddress(addr *common.NetAddress, lastSeen time.Time) *TimedAddress {
+	return &TimedAddress{
+		NetAddress: addr,
+		Timestamp:  lastSeen.Unix(),
+	}
+}
+
 type Addr struct {
-	NetAddresses []*common.NetAddress `json:"net_addresses"`
+	NetAddresses []*TimedAddress `json:"net_addresses"`
 }
 
 func (this *Addr) MsgId() types.Hash {
@@ -0,0 +1,65 @@
+package message
+
+import (
+	"fmt"
+	"github.com/DSiSc/craft/types"
+)
+
+// DisconnectReason is the reason code of a disconnection
+type DisconnectReason uint8
+
+const (
+	ReasonNone                DisconnectReason = iota // normal disconnection
+	ReasonTooManyPeers                                // peer have reached its connection limit
+	ReasonBanned                                      // remote have been banned
+	ReasonIncompatibleVersion                         // version or service is not compatible
+	ReasonProtocolViolation                           // remote violated the protocol
+	ReasonShuttingDown                                // peer is shutting down
+	ReasonDuplicate                                   // already connected To the remote
+	ReasonTimeout                                     // remote didn't respond in time
+	ReasonAddrExchangeDone                            // seed node have finished exchanging addresses
+)
+
+// String return the reason name
+func (reason DisconnectReason) String() string {
+	switch reason {
+	case ReasonNone:
+		return "none"
+	case ReasonTooManyPeers:
+		return "too many peers"
+	case ReasonBanned:
+		return "banned"
+	case ReasonIncompatibleVersion:
+		return "incompatible version"
+	case ReasonProtocolViolation:
+		return "protocol violation"
+	case ReasonShuttingDown:
+		return "shutting down"
+	case ReasonDuplicate:
+		return "duplicate connection"
+	case ReasonTimeout:
+		return "timeout"
+	case ReasonAddrExchangeDone:
+		return "address exchange done"
+	default:
+		return fmt.Sprintf("unknown(%d)", reason)
+	}
+}
+
+// GoodbyeMsg is sent To remote before closing the connection
+type GoodbyeMsg struct {
+	Reason  DisconnectReason `json:"reason"`
+	Message string           `json:"message,omitempty"`
+}
+
+func (this *GoodbyeMsg) MsgId() types.Hash {
+	return EmptyHash
+}
+
+func (this *GoodbyeMsg) MsgType() MessageType {
+	return GOODBYE_TYPE
+}
+
+func (this *GoodbyeMsg) ResponseMsgType() MessageType {
+	return NIL
+}
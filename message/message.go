@@ -1,6 +1,7 @@
 package message
 
 import (
+	"bytes"
 	"encoding/binary"
 	"encoding/json"
 	"errors"
@@ -11,6 +12,9 @@ import (
 
 var EmptyHash = types.Hash{}
 
+// MaxMessageLength is the maximum length of a message body, messages declaring a larger length are rejected.
+const MaxMessageLength = 32 * 1024 * 1024
+
 type Message interface {
 	MsgId() types.Hash
 	MsgType() MessageType
@@ -37,8 +41,38 @@ const (
 	REJECT_TYPE
 	DISCONNECT_TYPE //peer disconnect info raise by link
 	TRACE_TYPE      //trace message
+	GOODBYE_TYPE    //disconnect reason sent before closing connection
 )
 
+// messageTypeNames is the names of message types
+var messageTypeNames = map[MessageType]string{
+	NIL:              "nil",
+	VERSION_TYPE:     "version",
+	VERACK_TYPE:      "verack",
+	GETADDR_TYPE:     "getaddr",
+	ADDR_TYPE:        "addr",
+	PING_TYPE:        "ping",
+	PONG_TYPE:        "pong",
+	GET_HEADERS_TYPE: "getheaders",
+	HEADERS_TYPE:     "headers",
+	BLOCK_TYPE:       "block",
+	TX_TYPE:          "tx",
+	GET_BLOCK_TYPE:   "getblock",
+	NOT_FOUND_TYPE:   "notfound",
+	REJECT_TYPE:      "reject",
+	DISCONNECT_TYPE:  "disconnect",
+	TRACE_TYPE:       "trace",
+	GOODBYE_TYPE:     "goodbye",
+}
+
+// String return the message type name
+func (msgType MessageType) String() string {
+	if name, ok := messageTypeNames[msgType]; ok {
+		return name
+	}
+	return fmt.Sprintf("unknown(%d)", uint32(msgType))
+}
+
 // message's header
 type messageHeader struct {
 	Magic   uint32
@@ -85,19 +119,27 @@ func ReadMessage(reader io.Reader) (Message, error) {
 	if err != nil {
 		return nil, err
 	}
+	if header.Length > MaxMessageLength {
+		return nil, fmt.Errorf("message length %d exceeds the limit %d", header.Length, MaxMessageLength)
+	}
 
-	body := make([]byte, header.Length)
-	_, err = io.ReadFull(reader, body)
+	msg, err := makeEmptyMessage(header.MsgType)
 	if err != nil {
 		return nil, err
 	}
 
-	msg, err := makeEmptyMessage(header.MsgType)
+	// the body buffer grows with the bytes actually received, so a declared length costs nothing until the
+	// remote really sends that much.
+	var body bytes.Buffer
+	n, err := body.ReadFrom(io.LimitReader(reader, int64(header.Length)))
 	if err != nil {
 		return nil, err
 	}
+	if n < int64(header.Length) {
+		return nil, io.ErrUnexpectedEOF
+	}
 
-	err = json.Unmarshal(body, msg)
+	err = json.Unmarshal(body.Bytes(), msg)
 	if err != nil {
 		return nil, err
 	}
@@ -151,6 +193,8 @@ func makeEmptyMessage(msgType MessageType) (Message, error) {
 		return &Transaction{}, nil
 	case TRACE_TYPE:
 		return &TraceMsg{}, nil
+	case GOODBYE_TYPE:
+		return &GoodbyeMsg{}, nil
 	default:
 		return nil, fmt.Errorf("unknown message type %v", msgType)
 	}
@@ -7,9 +7,12 @@ import (
 
 // Version version message
 type Version struct {
-	Version string             `json:"version"`
-	PortMe  int32              `json:"port_me"`
-	Service config.ServiceFlag `json:"service"`
+	Version      string               `json:"version"`
+	NodeID       string               `json:"node_id,omitempty"` // random id of the sender, stable during its run
+	PortMe       int32                `json:"port_me"`
+	Service      config.ServiceFlag   `json:"service"`
+	ObservedAddr string               `json:"observed_addr,omitempty"` // the receiver's address observed by the sender
+	Accepts      []config.ServiceFlag `json:"accepts,omitempty"`       // services the sender needs besides its own
 }
 
 func (this *Version) MsgId() types.Hash {
@@ -0,0 +1,47 @@
+package p2p
+
+import (
+	"github.com/DSiSc/p2p/common"
+	"github.com/DSiSc/p2p/config"
+)
+
+// NodeInfo is a snapshot of our node
+type NodeInfo struct {
+	Version      string               `json:"version"`
+	Service      config.ServiceFlag   `json:"service"`
+	ListenAddr   string               `json:"listen_addr"`
+	ExternalAddr string               `json:"external_addr,omitempty"` // our address observed by peers
+	OurAddrs     []*common.NetAddress `json:"our_addrs"`
+	Node         string               `json:"node,omitempty"` // our discovery node URL, empty if discovery is disabled or our ip is unknown
+	Running      bool                 `json:"running"`
+	InBound      int                  `json:"in_bound"`  // num of active inbound peers
+	OutBound     int                  `json:"out_bound"` // num of active outbound peers
+	Addresses    int                  `json:"addresses"` // num of addresses in address book
+	Persistent   int                  `json:"persistent"`
+	Banned       int                  `json:"banned"`
+}
+
+// GetNodeInfo get the snapshot of our node
+func (service *P2P) GetNodeInfo() *NodeInfo {
+	info := &NodeInfo{
+		Version:    service.version,
+		Service:    service.service,
+		ListenAddr: service.addr.ToString(),
+		OurAddrs:   service.addrManager.OurAddresses(),
+		Running:    service.running(),
+		InBound:    service.GetInBountPeersCount(),
+		OutBound:   service.GetOutBountPeersCount(),
+		Addresses:  service.addrManager.GetAddressCount(),
+		Persistent: len(service.PersistentPeers()),
+		Banned:     len(service.BannedPeers()),
+	}
+	if external := service.ExternalAddress(); external != nil {
+		info.ExternalAddr = external.ToString()
+	}
+	service.lock.RLock()
+	if service.discovery != nil && service.discovery.Self().IP != nil {
+		info.Node = service.discovery.Self().String()
+	}
+	service.lock.RUnlock()
+	return info
+}
@@ -0,0 +1,32 @@
+package p2p
+
+import (
+	"github.com/DSiSc/p2p/common"
+	"github.com/DSiSc/p2p/version"
+	"github.com/stretchr/testify/assert"
+	"testing"
+	"time"
+)
+
+func TestP2P_GetNodeInfo(t *testing.T) {
+	assert := assert.New(t)
+	conf := mockConfig()
+	conf.PersistentPeers = "192.168.1.1:8080"
+	p2p, err := NewP2P(conf, nil)
+	assert.Nil(err)
+	p2p.addrManager.AddAddresses(mockNetAddresses(3))
+	p2p.BanPeer(common.NewNetAddress("tcp", "192.168.1.2", 8080), time.Hour, "")
+
+	info := p2p.GetNodeInfo()
+	assert.Equal(version.Version, info.Version)
+	assert.Equal(conf.Service, info.Service)
+	assert.Equal("tcp://0.0.0.0:8080", info.ListenAddr)
+	assert.Equal("", info.ExternalAddr)
+	assert.Equal("", info.Node)
+	assert.False(info.Running)
+	assert.Equal(0, info.InBound)
+	assert.Equal(0, info.OutBound)
+	assert.Equal(3, info.Addresses)
+	assert.Equal(1, info.Persistent)
+	assert.Equal(1, info.Banned)
+}
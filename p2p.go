@@ -7,25 +7,28 @@ import (
 	"github.com/DSiSc/craft/types"
 	"github.com/DSiSc/p2p/common"
 	"github.com/DSiSc/p2p/config"
+	"github.com/DSiSc/p2p/discover"
+	"github.com/DSiSc/p2p/mdns"
 	"github.com/DSiSc/p2p/message"
 	"github.com/DSiSc/p2p/nat"
 	"github.com/DSiSc/p2p/version"
 	"math/rand"
 	"net"
 	"strconv"
-	"strings"
 	"sync"
 	"sync/atomic"
 	"time"
 )
 
 const (
-	persistentPeerRetryInterval = time.Minute
-	stallTickInterval           = 15 * time.Second
-	stallResponseTimeout        = 60 * time.Second
-	heartBeatInterval           = 10 * time.Second
+	stallTickInterval    = 15 * time.Second
+	stallResponseTimeout = 60 * time.Second
+	heartBeatInterval    = 10 * time.Second
 )
 
+// errPeerPending is returned when dialing a peer which is already connecting or connected
+var errPeerPending = errors.New("peer is already connecting or connected")
+
 // PeerFilter used To filter the peer satisfy the request
 type PeerFilter func(peerState uint64) bool
 
@@ -33,19 +36,28 @@ type PeerFilter func(peerState uint64) bool
 type P2P struct {
 	PeerCom
 	config        *config.P2PConfig
-	listener      net.Listener // net listener
+	listeners     []net.Listener // net listeners
 	internalChan  chan *InternalMsg
 	msgChan       chan *InternalMsg
 	stallChan     chan *InternalMsg
 	quitChan      chan struct{}
 	isRunning     int32
 	addrManager   *AddressManager
-	pendingPeers  sync.Map
-	outbountPeers sync.Map
-	inboundPeers  sync.Map
+	peers         *peerTable
 	center        types.EventCenter
 	lock          sync.RWMutex
 	debugHandler  *DebugHandler
+	connLimiter   *connLimiter
+	externalTally *externalAddrTally
+	externalAddr  atomic.Value // our external address inferred From peers
+	anchorsPath   string       // file path of the anchors
+	crawler       *crawler     // crawler of the known addresses, nil if crawling is disabled
+	discovery     *discover.Discovery
+	mdns          *mdns.MDNS
+	persistent    *persistentPeers // persistent peers, can be changed at runtime
+	bans          *banList         // banned ips
+	admin         *AdminServer     // admin http server, nil if disabled
+	traffic       *trafficTap      // live traffic of messages
 }
 
 // NewP2P create a p2p service instance
@@ -56,20 +68,40 @@ func NewP2P(config *config.P2PConfig, center types.EventCenter) (*P2P, error) {
 		return nil, err
 	}
 	addrManger := NewAddressManager(config.AddrBookFilePath)
+	addrManger.backoff = newBackoff(config.ReconnectBase, config.ReconnectMax, config.ReconnectJitter)
+	addrManger.SetMaxSize(config.MaxAddrBookSize)
+	if err := addrManger.SetAddrPolicy(config.AddrPolicy); err != nil {
+		log.Error("invalid address policy")
+		return nil, err
+	}
+	var addrCrawler *crawler
+	if config.Crawl {
+		addrCrawler = newCrawler(config.CrawlInterval)
+	}
 	return &P2P{
 		PeerCom: PeerCom{
-			version: version.Version,
-			addr:    netAddr,
-			service: config.Service,
+			version:  version.Version,
+			id:       newNodeID(),
+			addr:     netAddr,
+			service:  config.Service,
+			accepted: acceptedServices(config.ServiceQuotas),
 		},
-		config:       config,
-		addrManager:  addrManger,
-		msgChan:      make(chan *InternalMsg),
-		internalChan: make(chan *InternalMsg),
-		stallChan:    make(chan *InternalMsg),
-		quitChan:     make(chan struct{}),
-		isRunning:    0,
-		center:       center,
+		config:        config,
+		addrManager:   addrManger,
+		peers:         newPeerTable(),
+		msgChan:       make(chan *InternalMsg),
+		internalChan:  make(chan *InternalMsg),
+		stallChan:     make(chan *InternalMsg),
+		quitChan:      make(chan struct{}),
+		isRunning:     0,
+		center:        center,
+		connLimiter:   newConnLimiter(config.MaxPendingInBound, config.InBoundRatePerIP),
+		externalTally: newExternalAddrTally(),
+		anchorsPath:   anchorsFilePath(config.AddrBookFilePath),
+		crawler:       addrCrawler,
+		persistent:    newPersistentPeers(persistentPeersFilePath(config.AddrBookFilePath), config.PersistentPeers),
+		bans:          newBanList(),
+		traffic:       newTrafficTap(),
 	}, nil
 }
 
@@ -91,24 +123,49 @@ func (service *P2P) Start() error {
 		return err
 	}
 
-	listener, err := net.Listen(service.addr.Protocol, service.addr.IP+":"+strconv.Itoa(int(service.addr.Port)))
+	listeners, err := service.listen()
 	if err != nil {
 		log.Error("failed To create listener with address: %s, as: %v", service.addr.ToString(), err)
 		return err
 	}
-	service.listener = listener
-	go service.startListen(listener) // listen To accept new connection
+	service.listeners = listeners
+	for _, listener := range listeners {
+		go service.startListen(listener) // listen To accept new connection
+	}
+	if err := service.startDiscovery(); err != nil {
+		log.Error("failed To start node discovery, as: %v", err)
+		for _, listener := range listeners {
+			listener.Close()
+		}
+		return err
+	}
+	if err := service.startMDNS(); err != nil {
+		// LAN discovery is optional, failing To join the multicast group shouldn't stop us
+		log.Warn("failed To start mdns, as: %v", err)
+	}
 	if "" != service.config.NAT {
 		go service.addPortMapping(int(service.addr.Port)) // add nat port mapping
 	}
-	go service.recvHandler()      // message receive handler
-	go service.stallHandler()     // message response timeout handler
-	go service.connectPeers()     // connect To network peers
-	go service.addressHandler()   // request address From neighbor peers
-	go service.heartBeatHandler() // start heartbeat handler
+	go service.recvHandler()          // message receive handler
+	go service.stallHandler()         // message response timeout handler
+	go service.connectPeers()         // connect To network peers
+	go service.addressHandler()       // request address From neighbor peers
+	go service.heartBeatHandler()     // start heartbeat handler
+	go service.selfAdvertiseHandler() // advertise our address To neighbors
+	go service.feelerHandler()        // test the liveness of untried addresses
+	if service.crawler != nil {
+		go service.crawlHandler() // crawl the known addresses
+	}
 
 	service.isRunning = 1
 
+	if service.config.AdminAddr != "" {
+		if err := service.startAdmin(); err != nil {
+			// admin server is only for operators, the node can still serve without it
+			log.Error("failed To start admin server, as: %v", err)
+		}
+	}
+
 	// debug p2p
 	if service.config.DebugP2P {
 		service.debugHandler = NewDebugHandler(service, service.center, service.config.DebugServer)
@@ -119,28 +176,27 @@ func (service *P2P) Start() error {
 
 // Stop stop p2p service
 func (service *P2P) Stop() {
+	// stop admin server first, so no more runtime actions are taken during stopping
+	if service.admin != nil {
+		service.admin.Stop()
+		service.admin = nil
+	}
+
+	// remember the healthy outbound peers before stopping them
+	service.persistAnchors()
+
 	// stop all peer.
-	service.pendingPeers.Range(
-		func(key, value interface{}) bool {
-			peer := value.(*Peer)
-			peer.Stop()
-			return true
-		},
-	)
-	service.outbountPeers.Range(
-		func(key, value interface{}) bool {
-			peer := value.(*Peer)
-			peer.Stop()
-			return true
-		},
-	)
-	service.inboundPeers.Range(
-		func(key, value interface{}) bool {
-			peer := value.(*Peer)
-			peer.Stop()
-			return true
-		},
-	)
+	reason := newDisconnectError(message.ReasonShuttingDown, errors.New("p2p service stopped"))
+	for _, peer := range service.peers.list(nil) {
+		status, _ := service.peers.remove(peer)
+		if status == PeerActive {
+			peer.sayGoodbye(reason)
+		}
+		peer.Stop()
+		if status == PeerActive {
+			service.notifyPeerEvent(EventPeerDisconnected, peer, reason)
+		}
+	}
 
 	service.lock.Lock()
 	if service.isRunning != 1 {
@@ -148,8 +204,17 @@ func (service *P2P) Stop() {
 	}
 	close(service.quitChan)
 	service.addrManager.Stop()
-	if service.listener != nil {
-		service.listener.Close()
+	for _, listener := range service.listeners {
+		listener.Close()
+	}
+	service.listeners = nil
+	if service.discovery != nil {
+		service.discovery.Stop()
+		service.discovery = nil
+	}
+	if service.mdns != nil {
+		service.mdns.Stop()
+		service.mdns = nil
 	}
 
 	service.isRunning = 0
@@ -162,6 +227,31 @@ func (service *P2P) Stop() {
 	}
 }
 
+// create the listeners of local address. If the local address is a wildcard address, we listen on both ipv4
+// and ipv6 wildcard address, and failing To listen on ipv6 is tolerated, as ipv6 may be disabled on the host.
+func (service *P2P) listen() ([]net.Listener, error) {
+	port := strconv.Itoa(int(service.addr.Port))
+	ip := service.addr.ParsedIP()
+	if service.addr.IP != "" && (ip == nil || !ip.IsUnspecified()) {
+		listener, err := net.Listen(service.addr.Protocol, service.addr.HostPort())
+		if err != nil {
+			return nil, err
+		}
+		return []net.Listener{listener}, nil
+	}
+
+	listener4, err := net.Listen("tcp4", net.JoinHostPort(net.IPv4zero.String(), port))
+	if err != nil {
+		return nil, err
+	}
+	listener6, err := net.Listen("tcp6", net.JoinHostPort(net.IPv6unspecified.String(), port))
+	if err != nil {
+		log.Warn("failed To listen on ipv6 address, as: %v", err)
+		return []net.Listener{listener4}, nil
+	}
+	return []net.Listener{listener4, listener6}, nil
+}
+
 // listen To accept connection From inbound peer.
 func (service *P2P) startListen(listener net.Listener) {
 	for {
@@ -181,18 +271,46 @@ func (service *P2P) startListen(listener net.Listener) {
 			continue
 		}
 
+		// drop the connections From banned ips
+		if service.bans.isBanned(addr.ParsedIP()) {
+			log.Debug("peer %s is banned, drop it", addr.ToString())
+			conn.Close()
+			continue
+		}
+
+		// limit the accept rate of a single ip
+		if !service.connLimiter.allow(addr.IP) {
+			log.Debug("too many connections From %s, drop it", addr.IP)
+			conn.Close()
+			continue
+		}
+
+		// limit the num of concurrent pending handshakes, the goodbye To a rejected connection takes a slot too
+		if !service.connLimiter.acquire() {
+			log.Debug("too many pending inbound handshakes, drop connection From %s", addr.ToString())
+			conn.Close()
+			continue
+		}
+
 		// check num of the inbound peer
 		if service.GetInBountPeersCount() > service.config.MaxConnInBound {
-			conn.Close()
+			go func(conn net.Conn) {
+				sayGoodbye(conn, newDisconnectError(message.ReasonTooManyPeers, errors.New("too many inbound peers")))
+				service.connLimiter.release()
+			}(conn)
 			continue
 		}
 
+		// drop the connection if it doesn't send a valid message in time
+		conn.SetReadDeadline(time.Now().Add(inboundHeaderTimeout))
+
 		// init an inbound peer
 		peer := NewInboundPeer(&service.PeerCom, addr, service.internalChan, conn)
 		//peer := NewInboundPeer(service.addrManager.OurAddresses(), addr, service.internalChan, conn)
 		err = service.addPendingPeer(peer)
 		if err != nil {
 			conn.Close()
+			service.connLimiter.release()
 			log.Debug("failed To add peer %s To pending queue, as:%v", peer.GetAddr().ToString(), err)
 			continue
 		}
@@ -241,10 +359,12 @@ func (service *P2P) addPortMapping(localPort int) {
 
 // init inbound peer
 func (service *P2P) initInboundPeer(peer *Peer) {
-	defer service.removePendingPeer(peer)
+	defer service.connLimiter.release()
 	err := peer.Start()
 	if err != nil {
 		log.Info("failed to start inbound peer as: %v", err)
+		service.removePendingPeer(peer)
+		service.notifyPeerEvent(EventPeerHandshakeFailed, peer, err)
 		return
 	}
 	service.addrManager.AddAddress(peer.GetAddr())
@@ -258,7 +378,9 @@ func (service *P2P) initInboundPeer(peer *Peer) {
 		if err := service.sendMsgSync(peer, addrMsg); err != nil {
 			log.Error("failed to send address message to peer %s, as: %v", peer.GetAddr().ToString(), err)
 		}
+		peer.sayGoodbye(newDisconnectError(message.ReasonAddrExchangeDone, errors.New("address exchange finished")))
 		peer.Stop()
+		service.removePendingPeer(peer)
 	} else {
 		service.addInBoundPeer(peer)
 	}
@@ -266,43 +388,50 @@ func (service *P2P) initInboundPeer(peer *Peer) {
 
 // add pending peer
 func (service *P2P) addPendingPeer(peer *Peer) error {
-	log.Info("add peer %s To pending queue", peer.GetAddr().IP)
-	if _, ok := service.pendingPeers.LoadOrStore(peer.GetAddr().IP, peer); ok {
-		return fmt.Errorf("peer %s already in our pending peer list", peer.GetAddr().IP)
-	}
-	return nil
+	log.Info("add peer %s To pending queue", peer.GetAddr().ToString())
+	return service.peers.add(peer.GetAddr(), peer)
 }
 
 // remove pending peer
 func (service *P2P) removePendingPeer(peer *Peer) {
-	log.Info("remove peer %s From pending queue", peer.GetAddr().IP)
-	service.pendingPeers.Delete(peer.GetAddr().IP)
+	log.Info("remove peer %s From pending queue", peer.GetAddr().ToString())
+	service.peers.remove(peer)
 }
 
 // add inbound peer
 func (service *P2P) addInBoundPeer(peer *Peer) error {
 	log.Info("add a new inbound peer %s", peer.GetAddr().ToString())
-	return service.addPeer(true, peer)
+	return service.addPeer(peer)
 }
 
 // add outbound peer
 func (service *P2P) addOutBoundPeer(peer *Peer) error {
 	log.Info("add a new outbound peer %s", peer.GetAddr().ToString())
-	return service.addPeer(false, peer)
+	return service.addPeer(peer)
 }
 
-// add peer
-func (service *P2P) addPeer(inbound bool, peer *Peer) error {
-	if inbound {
-		if _, ok := service.inboundPeers.LoadOrStore(peer.GetAddr().ToString(), peer); ok {
-			return fmt.Errorf("peer %s already in our inbound peer list", peer.GetAddr().ToString())
-		}
-	} else {
-		if _, ok := service.outbountPeers.LoadOrStore(peer.GetAddr().ToString(), peer); ok {
-			return fmt.Errorf("peer %s already in our outbound peer list", peer.GetAddr().ToString())
-		}
+// add peer, the pending peer will be activated if its service is admitted and there is no other peer with the
+// same identity.
+func (service *P2P) addPeer(peer *Peer) error {
+	err := service.admitService(peer)
+	if err == nil {
+		err = service.peers.activate(peer)
 	}
-	service.center.Notify(types.EventAddPeer, peer.GetAddr())
+	if err != nil {
+		log.Info("failed To activate peer %s, as: %v", peer.GetAddr().ToString(), err)
+		service.peers.remove(peer)
+		peer.sayGoodbye(err)
+		peer.Stop()
+		service.notifyPeerEvent(EventPeerHandshakeFailed, peer, err)
+		return err
+	}
+	service.addrManager.Connected(peer.GetAddr(), peer.GetService())
+	service.recordObservedAddr(peer)
+	if peer.IsOutBound() && !service.config.SeedMode {
+		service.advertiseSelf(peer)
+	}
+	service.notify(types.EventAddPeer, peer.GetAddr())
+	service.notifyPeerEvent(EventPeerConnected, peer, nil)
 	return nil
 }
 
@@ -348,7 +477,7 @@ func (service *P2P) stallHandler() {
 					}
 					log.Error("receive %v type message's From Peer %s timeout", msgType, addr.ToString())
 					timeOutAddrs = append(timeOutAddrs, addr)
-					service.stopPeer(addr)
+					service.disconnectPeer(addr, newDisconnectError(message.ReasonTimeout, fmt.Errorf("receive %v type message timeout", msgType)))
 					break
 				}
 			}
@@ -395,8 +524,9 @@ func removePendingRespMsg(pendingQueue map[*common.NetAddress]map[message.Messag
 
 // connectPeers connect To peers in p2p network
 func (service *P2P) connectPeers() {
+	service.connectAnchors()
 	service.connectPersistentPeers()
-	if "" == service.config.PersistentPeers && !service.config.DisableDNSSeed {
+	if len(service.persistent.list()) == 0 && !service.config.DisableDNSSeed {
 		service.connectDnsSeeds()
 	}
 	service.connectNormalPeers()
@@ -404,45 +534,127 @@ func (service *P2P) connectPeers() {
 
 // connect To persistent peers
 func (service *P2P) connectPersistentPeers() {
-	if service.config.PersistentPeers != "" {
-		peerAddres := strings.Split(service.config.PersistentPeers, ",")
-		for _, peerAddr := range peerAddres {
-			netAddr, err := common.ParseNetAddress(peerAddr)
-			if err != nil {
-				log.Warn("invalid persistent peer address")
-				continue
-			}
-			if service.addrManager.IsOurAddress(netAddr) {
-				continue
-			}
+	for _, netAddr := range service.persistent.list() {
+		service.connectPersistentPeer(netAddr)
+	}
+}
 
-			service.addrManager.AddAddress(netAddr) //record address
-			peer := NewOutboundPeer(&service.PeerCom, netAddr, true, service.internalChan)
-			go service.connectPeer(peer)
-		}
+// connect To a persistent peer in background
+func (service *P2P) connectPersistentPeer(netAddr *common.NetAddress) {
+	if netAddr.IsHostname() {
+		go service.connectPersistentHost(netAddr)
+		return
+	}
+	if service.addrManager.IsOurAddress(netAddr) {
+		return
+	}
+	if peer := service.peers.get(netAddr); peer != nil {
+		// already connecting or connected, it's reconnected once disconnected
+		peer.setPersistent(true, nil)
+		return
+	}
+
+	service.addrManager.AddAddress(netAddr) //record address
+	peer := NewOutboundPeer(&service.PeerCom, netAddr, true, service.internalChan)
+	go service.connectPeer(peer)
+}
+
+// reconnect To a disconnected persistent peer after backing off, it's given up if removed From persistent peers.
+func (service *P2P) reconnectPersistentPeer(addr *common.NetAddress) {
+	service.addrManager.UpdateAddressAttemptInfo(addr)
+	timer := time.NewTimer(time.Until(service.addrManager.NextAttemptTime(addr)))
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+	case <-service.quitChan:
+		return
+	}
+	if service.persistent.contains(addr) {
+		service.connectPeer(NewOutboundPeer(&service.PeerCom, addr, true, service.internalChan))
 	}
 }
 
 // connect To dns seeds
 func (service *P2P) connectDnsSeeds() {
 	if "" != service.config.DNSSeeds {
+		dnsSeeds := service.parseDnsSeeds()
+		if service.config.DNSBootstrap {
+			if service.bootstrapFromDNS(dnsSeeds) > 0 {
+				return
+			}
+			log.Warn("no address got From dns seeds, fall back To connect To seed nodes")
+		}
 		log.Info("connect to dns seeds")
-		dnsSeeds := strings.Split(service.config.DNSSeeds, ",")
-		for _, dnsSeed := range dnsSeeds {
-			netAddr, err := common.ParseNetAddress(dnsSeed)
+		for _, netAddr := range dnsSeeds {
+			// a seed host name may have multiple records, connect To all of them To get more addresses.
+			seedAddrs, err := netAddr.Resolve()
 			if err != nil {
-				log.Warn("invalid dns seed address")
+				log.Warn("failed To resolve dns seed %s, as: %v", netAddr.ToString(), err)
 				continue
 			}
-			if service.addrManager.IsOurAddress(netAddr) {
+			for _, seedAddr := range seedAddrs {
+				if service.addrManager.IsOurAddress(seedAddr) || service.containsPeer(seedAddr) {
+					continue
+				}
+				peer := NewOutboundPeer(&service.PeerCom, seedAddr, false, service.internalChan)
+				go service.connectPeer(peer)
+			}
+		}
+	}
+}
+
+// connect To a persistent peer specified by host name. The host name is resolved before every attempt, so
+// the change of its DNS records takes effect, and the resolved addresses are tried in turn until one of them
+// is connected. The host name itself never goes into address book, and the connected peer remembers it, so
+// the host name is resolved again when the peer is disconnected.
+func (service *P2P) connectPersistentHost(host *common.NetAddress) {
+	for attempts := uint32(1); service.persistent.contains(host); attempts++ {
+		addrs, err := host.Resolve()
+		if err != nil {
+			log.Warn("failed To resolve persistent peer %s, as: %v", host.ToString(), err)
+		}
+		for _, addr := range addrs {
+			if service.addrManager.IsOurAddress(addr) {
 				continue
 			}
-			peer := NewOutboundPeer(&service.PeerCom, netAddr, false, service.internalChan)
-			go service.connectPeer(peer)
+			if peer := service.peers.get(addr); peer != nil {
+				if peer.Status() != PeerActive {
+					// try again later, as the pending connection may fail
+					continue
+				}
+				peer.setPersistent(true, host)
+				return
+			}
+			service.addrManager.AddAddress(addr)
+			peer := NewOutboundPeer(&service.PeerCom, addr, true, service.internalChan)
+			peer.host = host
+			if service.dialPeer(peer) == nil {
+				return
+			}
+		}
+
+		timer := time.NewTimer(service.addrManager.backoff.delay(attempts))
+		select {
+		case <-timer.C:
+		case <-service.quitChan:
+			timer.Stop()
+			return
 		}
 	}
 }
 
+// reconnect To a disconnected persistent peer specified by host name after backing off
+func (service *P2P) reconnectPersistentHost(host *common.NetAddress) {
+	timer := time.NewTimer(service.addrManager.backoff.delay(1))
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+	case <-service.quitChan:
+		return
+	}
+	service.connectPersistentHost(host)
+}
+
 // connect To dns seeds
 func (service *P2P) connectNormalPeers() {
 	log.Info("start connection To normal peers")
@@ -460,12 +672,16 @@ func (service *P2P) connectNormalPeers() {
 		}
 
 		log.Info("start to connect to normal peers, current peer num: inbound-%d, outbound-%d.", service.GetInBountPeersCount(), service.GetOutBountPeersCount())
+		service.connectQuotaPeers()
 		if service.addrManager.GetAddressCount()-len(service.GetPeers()) < service.config.MaxConnOutBound {
 			for _, addr := range service.addrManager.GetAllAddress() {
 				if service.containsPeer(addr) {
 					log.Debug("peer with addr %s already in our neighbor list", addr.ToString())
 					continue
 				}
+				if !service.compatibleAddr(addr, service.addrManager.GetServices(addr)) {
+					continue
+				}
 				log.Info("start connecting To peer %s", addr.ToString())
 				service.addrManager.UpdateAddressAttemptInfo(addr)
 				peer := NewOutboundPeer(&service.PeerCom, addr, false, service.internalChan)
@@ -477,7 +693,7 @@ func (service *P2P) connectNormalPeers() {
 				if service.GetOutBountPeersCount() >= service.config.MaxConnOutBound || service.addrManager.GetAddressCount() <= service.GetOutBountPeersCount() {
 					break
 				}
-				addr, err := service.addrManager.GetAddress()
+				addr, err := service.addrManager.GetAddressWithFilter(service.compatibleAddr)
 				if err != nil {
 					break
 				}
@@ -498,72 +714,92 @@ func (service *P2P) connectNormalPeers() {
 
 // check whether peer with this address have existed in the neighbor list
 func (service *P2P) containsPeer(addr *common.NetAddress) bool {
-	if _, ok := service.pendingPeers.Load(addr.IP); ok {
-		return true
-	}
-	if _, ok := service.outbountPeers.Load(addr.ToString()); ok {
-		return true
-	}
-	if _, ok := service.inboundPeers.Load(addr.ToString()); ok {
-		return true
-	}
-	return false
+	return service.peers.contains(addr)
 }
 
 // connect To a peer
 func (service *P2P) connectPeer(peer *Peer) {
 RETRY:
+	err := service.dialPeer(peer)
+	if err != nil && err != errPeerPending && peer.IsPersistent() && service.persistent.contains(peer.GetAddr()) {
+		service.addrManager.UpdateAddressAttemptInfo(peer.GetAddr())
+		timer := time.NewTimer(time.Until(service.addrManager.NextAttemptTime(peer.GetAddr())))
+		select {
+		case <-timer.C:
+			timer.Stop()
+			goto RETRY
+		case <-service.quitChan:
+			timer.Stop()
+			return
+		}
+	}
+}
+
+// make an attempt To connect To the peer, return errPeerPending if the peer is already connecting or connected.
+func (service *P2P) dialPeer(peer *Peer) error {
+	if err := service.checkOutbound(peer.GetAddr()); err != nil {
+		return err
+	}
 	err := service.addPendingPeer(peer)
 	if err != nil {
 		log.Debug("failed To add peer %s To pending list, as: %v", peer.GetAddr().ToString(), err)
-		return
-	} else {
-		err = peer.Start()
+		return errPeerPending
 	}
+	service.notifyPeerEvent(EventPeerDialStarted, peer, nil)
+	err = peer.Start()
 	if err != nil {
+		status := peer.Status()
 		service.removePendingPeer(peer)
+		service.addrManager.RecordDisconnect(peer.GetAddr(), disconnectReason(err))
+		service.notifyStartFailed(peer, status, err)
 		log.Info("failed To connect To peer %s, as: %v", peer.GetAddr().ToString(), err)
-		if peer.IsPersistent() {
-			timer := time.NewTimer(persistentPeerRetryInterval)
-			select {
-			case <-timer.C:
-				timer.Stop()
-				goto RETRY
-			case <-service.quitChan:
-				timer.Stop()
-				return
-			}
-		}
-	} else {
-		if service.config.SeedMode {
-			addReq := &message.AddrReq{}
-			service.sendMsgAsync(peer, addReq)
-		}
-		service.addOutBoundPeer(peer)
-		service.removePendingPeer(peer)
+		return err
+	}
+	if service.config.SeedMode {
+		addReq := &message.AddrReq{}
+		service.sendMsgAsync(peer, addReq)
 	}
+	service.addrManager.ResetAddressAttemptInfo(peer.GetAddr())
+	service.addrManager.Good(peer.GetAddr())
+	service.addOutBoundPeer(peer)
+	return nil
 }
 
 // stop the peer with specified address
-func (service *P2P) stopPeer(addr *common.NetAddress) {
-	if value, ok := service.pendingPeers.Load(addr.IP); ok {
-		peer := value.(*Peer)
-		peer.Stop()
-		service.pendingPeers.Delete(addr.IP)
-		service.center.Notify(types.EventRemovePeer, addr)
+func (service *P2P) stopPeer(addr *common.NetAddress, reason error) {
+	peer := service.peers.lookup(addr)
+	if peer == nil {
+		return
 	}
-	if value, ok := service.inboundPeers.Load(addr.ToString()); ok {
-		peer := value.(*Peer)
-		peer.Stop()
-		service.inboundPeers.Delete(addr.ToString())
-		service.center.Notify(types.EventRemovePeer, addr)
+	status, ok := service.peers.remove(peer)
+	if !ok {
+		return
 	}
-	if value, ok := service.outbountPeers.Load(addr.ToString()); ok {
-		peer := value.(*Peer)
-		peer.Stop()
-		service.outbountPeers.Delete(addr.ToString())
-		service.center.Notify(types.EventRemovePeer, addr)
+	peer.Stop()
+	if status == PeerActive || peer.IsOutBound() {
+		service.addrManager.RecordDisconnect(addr, disconnectReason(reason))
+	}
+	if status == PeerActive {
+		log.Info("peer %s disconnected, as: %v", addr.ToString(), reason)
+		service.notify(types.EventRemovePeer, addr)
+		service.notifyPeerEvent(EventPeerDisconnected, peer, reason)
+	}
+	if !service.running() {
+		return
+	}
+	if host := peer.persistentHost(); host != nil && service.persistent.contains(host) {
+		go service.reconnectPersistentHost(host)
+	} else if service.persistent.contains(addr) {
+		go service.reconnectPersistentPeer(addr)
+	}
+}
+
+// disconnectPeer tell the peer with specified address the disconnect reason, then stop it.
+func (service *P2P) disconnectPeer(addr *common.NetAddress, reason error) {
+	if peer := service.peers.lookup(addr); peer != nil {
+		peer.sayGoodbye(reason)
 	}
+	service.stopPeer(addr, reason)
 }
 
 // clear all pending response from this peer.
@@ -595,6 +831,8 @@ func (service *P2P) addressHandler() {
 				service.sendMsgAsync(peers[rand.Intn(len(peers))], addReq)
 			}
 		}
+		// get more address supporting the services short of peers
+		service.requestServiceAddrs()
 		timer.Reset(retryInterval)
 	}
 }
@@ -606,9 +844,12 @@ func (service *P2P) recvHandler() {
 		case msg := <-service.internalChan:
 			log.Debug("Server receive a message From %s", msg.From.ToString())
 			service.stallChan <- msg
+			if _, ok := msg.Payload.(*peerDisconnecMsg); !ok {
+				service.traffic.publish(msg.From, TrafficIn, msg.Payload)
+			}
 			switch msg.Payload.(type) {
 			case *peerDisconnecMsg:
-				service.stopPeer(msg.From)
+				service.stopPeer(msg.From, msg.Payload.(*peerDisconnecMsg).err)
 			case *message.PingMsg:
 				pingMsg := &message.PongMsg{
 					State: LocalState(),
@@ -621,9 +862,13 @@ func (service *P2P) recvHandler() {
 				peer := service.GetPeerByAddress(msg.From)
 				if peer != nil {
 					peer.SetState(msg.Payload.(*message.PongMsg).State)
+					service.addrManager.Seen(peer.GetAddr())
 				}
 			case *message.AddrReq:
 				addrs := service.addrManager.GetAddresses()
+				if services := msg.Payload.(*message.AddrReq).Services; services != nil {
+					addrs = service.addrManager.GetServiceAddresses(*services)
+				}
 				addrMsg := &message.Addr{
 					NetAddresses: addrs,
 				}
@@ -633,14 +878,16 @@ func (service *P2P) recvHandler() {
 				}
 			case *message.Addr:
 				addrMsg := msg.Payload.(*message.Addr)
-				service.addrManager.AddAddresses(addrMsg.NetAddresses)
-				if service.config.SeedMode {
-					service.stopPeer(msg.From)
+				junk := service.addrManager.AddTimedAddresses(addrMsg.NetAddresses, msg.From)
+				if peer := service.GetPeerByAddress(msg.From); junk > 0 && peer != nil && peer.recordJunkRelay() {
+					service.disconnectPeer(msg.From, newDisconnectError(message.ReasonProtocolViolation, errors.New("relay too many junk addresses")))
+				} else if service.config.SeedMode {
+					service.disconnectPeer(msg.From, newDisconnectError(message.ReasonAddrExchangeDone, errors.New("address exchange finished")))
 				}
 			default:
 				service.msgChan <- msg
 				if service.config.DebugP2P {
-					service.center.Notify(types.EventRecvNewMsg, msg)
+					service.notify(types.EventRecvNewMsg, msg)
 				}
 			}
 		case <-service.quitChan:
@@ -670,30 +917,17 @@ func (service *P2P) heartBeatHandler() {
 // BroadCast broad cast message To all neighbor peers
 func (service *P2P) BroadCast(msg message.Message) {
 	log.Debug("broadcas message (type: %v, id: %x) to neighbors", msg.MsgType(), msg.MsgId())
-	service.outbountPeers.Range(
-		func(key, value interface{}) bool {
-			peer := value.(*Peer)
-			if !peer.KnownMsg(msg) {
-				go service.sendMsgAsync(peer, msg)
-			}
-			return true
-		},
-	)
-	service.inboundPeers.Range(
-		func(key, value interface{}) bool {
-			peer := value.(*Peer)
-			if !peer.KnownMsg(msg) {
-				go service.sendMsgAsync(peer, msg)
-			}
-			return true
-		},
-	)
+	for _, peer := range service.GetPeers() {
+		if !peer.KnownMsg(msg) {
+			go service.sendMsgAsync(peer, msg)
+		}
+	}
 	if service.config.DebugP2P {
 		imsg := &InternalMsg{
 			From:    service.addrManager.OurAddresses()[0],
 			Payload: msg,
 		}
-		service.center.Notify(types.EventBroadCastMsg, imsg)
+		service.notify(types.EventBroadCastMsg, imsg)
 	}
 }
 
@@ -762,6 +996,7 @@ func (service *P2P) sendMsg(peer *Peer, msg message.Message, sync bool) error {
 		message.RespTo = make(chan interface{})
 	}
 	peer.SendMsg(message)
+	service.traffic.publish(peer.GetAddr(), TrafficOut, msg)
 	service.registerPendingResp(message)
 
 	if message.RespTo != nil {
@@ -789,57 +1024,52 @@ func (service *P2P) registerPendingResp(msg *InternalMsg) {
 
 // GetOutBountPeersCount get out bount peer count
 func (service *P2P) GetOutBountPeersCount() int {
-	count := 0
-	service.outbountPeers.Range(
-		func(key, value interface{}) bool {
-			count++
-			return true
-		},
-	)
-	return count
+	return service.peers.count(func(peer *Peer) bool {
+		return activeFilter(peer) && peer.IsOutBound()
+	})
 }
 
 // GetOutBountPeersCount get out bount peer count
 func (service *P2P) GetInBountPeersCount() int {
-	count := 0
-	service.inboundPeers.Range(
-		func(key, value interface{}) bool {
-			count++
-			return true
-		},
-	)
-	return count
+	return service.peers.count(func(peer *Peer) bool {
+		return activeFilter(peer) && !peer.IsOutBound()
+	})
 }
 
 // GetPeers get service's inbound peers and outbound peers
 func (service *P2P) GetPeers() []*Peer {
-	peers := make([]*Peer, 0)
-	service.outbountPeers.Range(
-		func(key, value interface{}) bool {
-			peer := value.(*Peer)
-			peers = append(peers, peer)
-			return true
-		},
-	)
-	service.inboundPeers.Range(
-		func(key, value interface{}) bool {
-			peer := value.(*Peer)
-			peers = append(peers, peer)
-			return true
-		},
-	)
-	return peers
+	return service.peers.list(activeFilter)
 }
 
 // GetPeerByAddress get a peer by net address
 func (service *P2P) GetPeerByAddress(addr *common.NetAddress) *Peer {
-	if value, ok := service.inboundPeers.Load(addr.ToString()); ok {
-		return value.(*Peer)
+	return service.peers.getActive(addr)
+}
+
+// SeedIPs get the ips of at most num healthy addresses supporting the service(any service if nil), used To answer
+// the queries To DNS seed. As DNS answers carry no port, only the addresses listening on the same port as us are
+// selected. If crawler is enabled, only the addresses verified by crawler are selected.
+func (service *P2P) SeedIPs(num int, ipv6 bool, serviceFlag *config.ServiceFlag) []net.IP {
+	filter := func(addr *common.NetAddress, services *config.ServiceFlag) bool {
+		if addr.Port != service.addr.Port || addr.IsIPv6() != ipv6 {
+			return false
+		}
+		if serviceFlag != nil && (services == nil || *services != *serviceFlag) {
+			return false
+		}
+		return addr.IsRoutable() || service.config.AddrPolicy == AddrPolicyPrivate
 	}
-	if value, ok := service.outbountPeers.Load(addr.ToString()); ok {
-		return value.(*Peer)
+	var addrs []*common.NetAddress
+	if service.crawler != nil {
+		addrs = service.crawler.goodAddresses(num, filter)
+	} else {
+		addrs = service.addrManager.GetHealthyAddresses(num, filter)
 	}
-	return nil
+	ips := make([]net.IP, 0, len(addrs))
+	for _, addr := range addrs {
+		ips = append(ips, addr.ParsedIP())
+	}
+	return ips
 }
 
 //	used to verify peer compatibility
@@ -847,7 +1077,7 @@ func (service *P2P) onVersion(versionMsg *message.Version) error {
 	if !version.Accept(versionMsg.Version) {
 		return errors.New("Version not compatible with the server ")
 	}
-	if versionMsg.Service != service.service {
+	if !service.compatibleVersion(versionMsg) {
 		return errors.New("Service type not compatible with the server ")
 	}
 	return nil
@@ -37,6 +37,9 @@ func mockPeer(serverAddr, addr *common.NetAddress, outBound, persistent bool, ms
 	}
 	peer := newPeer(serverInfo, addr, outBound, persistent, msgChan, conn)
 	monkey.PatchInstanceMethod(reflect.TypeOf(peer), "Start", func(peer *Peer) error {
+		if peer.IsOutBound() {
+			peer.transit(PeerHandshaking)
+		}
 		return nil
 	})
 	monkey.PatchInstanceMethod(reflect.TypeOf(peer), "Stop", func(peer *Peer) {
@@ -444,9 +447,9 @@ func TestP2P_DNSSeed(t *testing.T) {
 	// Waiting to connect to normal peer
 	timeoutTricker := time.NewTicker(time.Second)
 	<-timeoutTricker.C
-	if peer, ok := p2p.pendingPeers.Load(mockPeer.GetAddr().IP); ok {
+	if peer := p2p.peers.get(mockPeer.GetAddr()); peer != nil {
 		select {
-		case pmsg := <-peer.(*Peer).sendChan:
+		case pmsg := <-peer.sendChan:
 			switch pmsg.Payload.(type) {
 			case *message.AddrReq:
 			default:
@@ -512,7 +515,8 @@ func TestP2P_DNSSeed1(t *testing.T) {
 }
 
 type testListener struct {
-	connChan chan net.Conn
+	connChan  chan net.Conn
+	closeOnce sync.Once
 }
 
 func newTestListener() *testListener {
@@ -532,8 +536,10 @@ func (this *testListener) Accept() (conn net.Conn, err error) {
 	return
 }
 
+// Close close the listener, it may be called more than once as the mocked net.Listen return the same
+// listener for both ipv4 and ipv6.
 func (this *testListener) Close() error {
-	close(this.connChan)
+	this.closeOnce.Do(func() { close(this.connChan) })
 	return nil
 }
 
@@ -1,33 +1,78 @@
 package p2p
 
 import (
+	"crypto/rand"
+	"encoding/hex"
 	"errors"
 	"fmt"
 	"github.com/DSiSc/craft/log"
 	"github.com/DSiSc/p2p/common"
 	"github.com/DSiSc/p2p/config"
 	"github.com/DSiSc/p2p/message"
+	"github.com/DSiSc/p2p/version"
 	"net"
-	"strconv"
+	"sort"
 	"sync"
 	"sync/atomic"
 	"time"
 )
 
 const (
-	MAX_BUF_LEN    = 1024 * 256 //the maximum buffer To receive message
-	WRITE_DEADLINE = 60         //deadline of conn write
+	MAX_BUF_LEN       = 1024 * 256 //the maximum buffer To receive message
+	WRITE_DEADLINE    = 60         //deadline of conn write
+	HANDSHAKE_TIMEOUT = 5          //timeout of dialing To peer and waiting for each handshake message
 
 )
 
+var errIncompatibleService = errors.New("Incompatible service ")
+
 // PeerCom provides the basic information of a peer
 type PeerCom struct {
-	version    string             // version info
-	addr       *common.NetAddress // peer address
-	state      uint64             //current state of this peer
-	outBound   atomic.Value       // whether peer is out bound peer
-	persistent bool               // whether peer is persistent peer
-	service    config.ServiceFlag // service peer supported
+	version    string                      // version info
+	id         string                      // node id, random on every start, identifies the node whatever its address is
+	addr       *common.NetAddress          // peer address
+	state      uint64                      //current state of this peer
+	outBound   atomic.Value                // whether peer is out bound peer
+	persistent bool                        // whether peer is persistent peer
+	service    config.ServiceFlag          // service peer supported
+	accepted   map[config.ServiceFlag]bool // services of remote peers accepted besides ours
+}
+
+// create a random node id
+func newNodeID() string {
+	id := make([]byte, 16)
+	rand.Read(id)
+	return hex.EncodeToString(id)
+}
+
+// check whether a remote peer supporting the service is compatible with us
+func (com *PeerCom) compatible(service config.ServiceFlag) bool {
+	return service == com.service || com.accepted[service]
+}
+
+// check whether the remote peer sending the version is compatible with us. A remote peer needing our service is
+// accepted even if its own service is not, so that its service quota can be met by us. As the services needed
+// by remote are only its claim, the inbound peers accepted this way are limited on activation.
+func (com *PeerCom) compatibleVersion(vmsg *message.Version) bool {
+	if com.compatible(vmsg.Service) {
+		return true
+	}
+	for _, service := range vmsg.Accepts {
+		if service == com.service {
+			return true
+		}
+	}
+	return false
+}
+
+// get the services accepted besides ours, sorted
+func (com *PeerCom) acceptedList() []config.ServiceFlag {
+	services := make([]config.ServiceFlag, 0, len(com.accepted))
+	for service := range com.accepted {
+		services = append(services, service)
+	}
+	sort.Slice(services, func(i, j int) bool { return services[i] < services[j] })
+	return services
 }
 
 // Peer represent the peer
@@ -39,9 +84,18 @@ type Peer struct {
 	sendChan     chan *InternalMsg
 	recvChan     chan<- *InternalMsg
 	quitChan     chan interface{}
-	lock         sync.RWMutex
+	lock         sync.RWMutex // guard the peer info, never held while waiting on the network
+	runLock      sync.Mutex   // serialize Start and Stop, held through dialing and handshake
 	isRunning    int32
+	status       int32        // connection status
+	activeTime   atomic.Value // time when peer became active
+	observedAddr atomic.Value // our address observed by remote peer, string
 	knownMsgs    *common.RingBuffer
+	stats        *peerStats
+	host         *common.NetAddress // host name of the persistent peer the address is resolved From
+	crawling     bool               // handshake only To learn the service of remote, which is recorded instead of checked
+	junkRelays   int32              // num of the address messages containing junk addresses relayed by remote
+	listenPort   int32              // listen port reported by inbound remote, the address is re-keyed with it on activation
 }
 
 // NewInboundPeer new inbound peer instance
@@ -67,49 +121,70 @@ func newPeer(serverInfo *PeerCom, addr *common.NetAddress, outBound, persistent
 		recvChan:     msgChan,
 		quitChan:     make(chan interface{}),
 		knownMsgs:    common.NewRingBuffer(1024),
+		stats:        newPeerStats(),
 		isRunning:    0,
 	}
 	peer.outBound.Store(outBound)
+	if outBound {
+		peer.status = int32(PeerDialing)
+	} else {
+		peer.status = int32(PeerHandshaking)
+	}
 	if !outBound && conn != nil {
 		peer.conn = NewPeerConn(conn, peer.internalChan)
+		peer.conn.stats = peer.stats
 	}
 	return peer
 }
 
 // Start connect To peer and send message To each other
 func (peer *Peer) Start() error {
-	peer.lock.Lock()
-	defer peer.lock.Unlock()
+	peer.runLock.Lock()
+	defer peer.runLock.Unlock()
+	addr := peer.GetAddr()
 	if peer.isRunning != 0 {
-		log.Error("peer %s has been started", peer.addr.ToString())
-		return fmt.Errorf("peer %s has been started", peer.addr.ToString())
+		log.Error("peer %s has been started", addr.ToString())
+		return fmt.Errorf("peer %s has been started", addr.ToString())
 	}
 
 	if peer.outBound.Load().(bool) {
-		log.Info("Start outbound peer %s", peer.addr.ToString())
+		log.Info("Start outbound peer %s", addr.ToString())
+		if _, err := peer.transit(PeerDialing); err != nil {
+			return err
+		}
 		err := peer.initConn()
 		if err != nil {
 			return err
 		}
+		if _, err := peer.transit(PeerHandshaking); err != nil {
+			peer.conn.Stop()
+			return err
+		}
 		peer.conn.Start()
+		handshakeStart := time.Now()
 		err = peer.handShakeWithOutBoundPeer()
 		if err != nil {
-			log.Info("failed to hand shake with outbound peer %s, as: %v", peer.addr.ToString(), err)
+			log.Info("failed to hand shake with outbound peer %s, as: %v", addr.ToString(), err)
+			peer.sayGoodbye(err)
 			peer.conn.Stop()
 			return err
 		}
+		peer.stats.onHandshake(time.Since(handshakeStart))
 	} else {
-		log.Info("Start inbound peer %s", peer.addr.ToString())
+		log.Info("Start inbound peer %s", addr.ToString())
 		if peer.conn == nil {
 			return errors.New("have no established connection")
 		}
 		peer.conn.Start()
+		handshakeStart := time.Now()
 		err := peer.handShakeWithInBoundPeer()
 		if err != nil {
-			log.Info("failed to hand shake with inbound peer %s, as: %v", peer.addr.ToString(), err)
+			log.Info("failed to hand shake with inbound peer %s, as: %v", addr.ToString(), err)
+			peer.sayGoodbye(err)
 			peer.conn.Stop()
 			return err
 		}
+		peer.stats.onHandshake(time.Since(handshakeStart))
 	}
 
 	go peer.recvHandler()
@@ -147,6 +222,10 @@ func (peer *Peer) handShakeWithInBoundPeer() error {
 	// read version message
 	err := peer.readVersionMessage()
 	if err != nil {
+		// still tell remote our version, so it learns our service even if we don't accept it
+		if derr, ok := err.(*disconnectError); ok && derr.err == errIncompatibleService {
+			peer.sendVersionMessage()
+		}
 		return err
 	}
 
@@ -169,9 +248,12 @@ func (peer *Peer) handShakeWithInBoundPeer() error {
 // send version message To this peer.
 func (peer *Peer) sendVersionMessage() error {
 	vmsg := &message.Version{
-		Version: peer.serverInfo.version,
-		PortMe:  peer.serverInfo.addr.Port,
-		Service: peer.serverInfo.service,
+		Version:      peer.serverInfo.version,
+		NodeID:       peer.serverInfo.id,
+		PortMe:       peer.serverInfo.addr.Port,
+		Service:      peer.serverInfo.service,
+		ObservedAddr: peer.conn.RemoteAddr(),
+		Accepts:      peer.serverInfo.acceptedList(),
 	}
 	return peer.conn.SendMessage(vmsg)
 }
@@ -189,11 +271,23 @@ func (peer *Peer) readVersionMessage() error {
 		return err
 	}
 	vmsg := msg.(*message.Version)
-	if vmsg.Service != peer.serverInfo.service {
-		return errors.New("Incompatible service ")
+	if !version.Accept(vmsg.Version) {
+		return newDisconnectError(message.ReasonIncompatibleVersion, fmt.Errorf("incompatible version %s", vmsg.Version))
 	}
+	if vmsg.NodeID != "" && vmsg.NodeID == peer.serverInfo.id {
+		return newDisconnectError(message.ReasonDuplicate, errors.New("connected To ourself"))
+	}
+	peer.lock.Lock()
 	if !peer.outBound.Load().(bool) {
-		peer.addr.Port = vmsg.PortMe
+		peer.listenPort = vmsg.PortMe
+	}
+	peer.id = vmsg.NodeID
+	peer.version = vmsg.Version
+	peer.service = vmsg.Service
+	peer.lock.Unlock()
+	peer.observedAddr.Store(vmsg.ObservedAddr)
+	if !peer.crawling && !peer.serverInfo.compatibleVersion(vmsg) {
+		return newDisconnectError(message.ReasonIncompatibleVersion, errIncompatibleService)
 	}
 	return nil
 }
@@ -209,19 +303,26 @@ func (peer *Peer) readVersionAckMessage() error {
 
 // read specified type message From peer.
 func (peer *Peer) readMessageWithType(msgType message.MessageType) (message.Message, error) {
-	timer := time.NewTimer(5 * time.Second)
+	timer := time.NewTimer(time.Duration(HANDSHAKE_TIMEOUT) * time.Second)
 	defer timer.Stop()
 	select {
 	case msg := <-peer.internalChan:
+		switch m := msg.(type) {
+		case *message.GoodbyeMsg:
+			log.Info("peer %s said goodbye during hand shake, reason: %v", peer.GetAddr().ToString(), m.Reason)
+			return nil, newRemoteDisconnectError(m)
+		case *peerDisconnecMsg:
+			return nil, m.err
+		}
 		if msg.MsgType() == msgType {
 			return msg, nil
 		} else {
-			log.Warn("error type message received From peer %s, expected: %v, actual: %v", peer.addr.ToString(), msgType, msg.MsgType())
-			return nil, fmt.Errorf("error type message received From peer %s, expected: %v, actual: %v", peer.addr.ToString(), msgType, msg.MsgType())
+			log.Warn("error type message received From peer %s, expected: %v, actual: %v", peer.GetAddr().ToString(), msgType, msg.MsgType())
+			return nil, newDisconnectError(message.ReasonProtocolViolation, fmt.Errorf("error type message received From peer %s, expected: %v, actual: %v", peer.GetAddr().ToString(), msgType, msg.MsgType()))
 		}
 	case <-timer.C:
-		log.Warn("read %v type message From peer %s time out", msgType, peer.addr.ToString())
-		return nil, fmt.Errorf("read %v type message From peer %s time out", msgType, peer.addr.ToString())
+		log.Warn("read %v type message From peer %s time out", msgType, peer.GetAddr().ToString())
+		return nil, newDisconnectError(message.ReasonTimeout, fmt.Errorf("read %v type message From peer %s time out", msgType, peer.GetAddr().ToString()))
 	}
 }
 
@@ -229,8 +330,9 @@ func (peer *Peer) readMessageWithType(msgType message.MessageType) (message.Mess
 func (peer *Peer) Stop() {
 	log.Info("Stop peer %s", peer.GetAddr().ToString())
 
-	peer.lock.Lock()
-	defer peer.lock.Unlock()
+	peer.runLock.Lock()
+	defer peer.runLock.Unlock()
+	peer.transit(PeerClosing)
 	if peer.isRunning == 0 {
 		return
 	}
@@ -243,14 +345,15 @@ func (peer *Peer) Stop() {
 
 // initConnection init the connection To peer.
 func (peer *Peer) initConn() error {
-	log.Debug("start init the connection To peer %s", peer.addr.ToString())
-	dialAddr := peer.addr.IP + ":" + strconv.Itoa(int(peer.addr.Port))
-	conn, err := net.Dial("tcp", dialAddr)
+	log.Debug("start init the connection To peer %s", peer.GetAddr().ToString())
+	dialAddr := peer.GetAddr().HostPort()
+	conn, err := net.DialTimeout("tcp", dialAddr, time.Duration(HANDSHAKE_TIMEOUT)*time.Second)
 	if err != nil {
-		log.Info("failed To dial To peer %s, as : %v", peer.addr.ToString(), err)
-		return fmt.Errorf("failed To dial To peer %s, as : %v", peer.addr.ToString(), err)
+		log.Info("failed To dial To peer %s, as : %v", peer.GetAddr().ToString(), err)
+		return fmt.Errorf("failed To dial To peer %s, as : %v", peer.GetAddr().ToString(), err)
 	}
 	peer.conn = NewPeerConn(conn, peer.internalChan)
+	peer.conn.stats = peer.stats
 	return nil
 }
 
@@ -262,6 +365,9 @@ func (peer *Peer) recvHandler() {
 		case msg = <-peer.internalChan:
 			log.Debug("receive %v type message From peer %s", msg.MsgType(), peer.GetAddr().ToString())
 			if msg.MsgId() != message.EmptyHash {
+				if peer.knownMsgs.Exist(msg.MsgId()) {
+					peer.stats.onDuplicate()
+				}
 				peer.knownMsgs.AddElement(msg.MsgId(), struct{}{})
 			}
 		case <-peer.quitChan:
@@ -270,18 +376,19 @@ func (peer *Peer) recvHandler() {
 
 		switch msg.(type) {
 		case *message.Version:
-			reject := &message.RejectMsg{
-				Reason: "invalid message, as version messages can only be sent once ",
-			}
-			peer.conn.SendMessage(reject)
-			peer.disconnectNotify(errors.New("receive an invalid message From remote"))
+			err := newDisconnectError(message.ReasonProtocolViolation, errors.New("version messages can only be sent once"))
+			peer.sayGoodbye(err)
+			peer.disconnectNotify(err)
 			return
 		case *message.VersionAck:
-			reject := &message.RejectMsg{
-				Reason: "invalid message, as version ack messages can only be sent once ",
-			}
-			peer.conn.SendMessage(reject)
-			peer.disconnectNotify(errors.New("receive an invalid message From remote"))
+			err := newDisconnectError(message.ReasonProtocolViolation, errors.New("version ack messages can only be sent once"))
+			peer.sayGoodbye(err)
+			peer.disconnectNotify(err)
+			return
+		case *message.GoodbyeMsg:
+			goodbye := msg.(*message.GoodbyeMsg)
+			log.Info("receive a goodbye message From remote, reason: %v", goodbye.Reason)
+			peer.disconnectNotify(newRemoteDisconnectError(goodbye))
 			return
 		case *message.RejectMsg:
 			rejectMsg := msg.(*message.RejectMsg)
@@ -290,7 +397,7 @@ func (peer *Peer) recvHandler() {
 			return
 		default:
 			imsg := &InternalMsg{
-				From:    peer.addr,
+				From:    peer.GetAddr(),
 				To:      peer.serverInfo.addr,
 				Payload: msg,
 			}
@@ -329,6 +436,22 @@ func (peer *Peer) IsPersistent() bool {
 	return peer.persistent
 }
 
+// setPersistent mark whether the peer is a persistent peer, host is the host name it's resolved From if it's
+// specified by host name.
+func (peer *Peer) setPersistent(persistent bool, host *common.NetAddress) {
+	peer.lock.Lock()
+	defer peer.lock.Unlock()
+	peer.persistent = persistent
+	peer.host = host
+}
+
+// get the host name of the persistent peer, nil if it's not specified by host name.
+func (peer *Peer) persistentHost() *common.NetAddress {
+	peer.lock.RLock()
+	defer peer.lock.RUnlock()
+	return peer.host
+}
+
 // GetAddr get peer's address
 func (peer *Peer) GetAddr() *common.NetAddress {
 	peer.lock.RLock()
@@ -336,6 +459,20 @@ func (peer *Peer) GetAddr() *common.NetAddress {
 	return peer.addr
 }
 
+// set the address of the peer, when it's re-keyed in peer table.
+func (peer *Peer) setAddr(addr *common.NetAddress) {
+	peer.lock.Lock()
+	defer peer.lock.Unlock()
+	peer.addr = addr
+}
+
+// get the node id and the listen port reported by remote in handshake.
+func (peer *Peer) identity() (string, int32) {
+	peer.lock.RLock()
+	defer peer.lock.RUnlock()
+	return peer.id, peer.listenPort
+}
+
 // CurrentState get current state of this peer.
 func (peer *Peer) CurrentState() uint64 {
 	peer.lock.RLock()
@@ -372,6 +509,39 @@ func (peer *Peer) KnownMsg(msg message.Message) bool {
 	return peer.knownMsgs.Exist(msg.MsgId())
 }
 
+// sayGoodbye tell remote the reason before closing the connection, nothing will be sent if the
+// disconnection is decided by remote.
+func (peer *Peer) sayGoodbye(err error) {
+	if peer.conn == nil || isRemoteDisconnect(err) {
+		return
+	}
+	if serr := peer.conn.SendMessage(newGoodbyeMsg(err)); serr != nil {
+		log.Debug("failed To send goodbye message To peer %s, as: %v", peer.conn.RemoteAddr(), serr)
+	}
+}
+
+// GetService get the service supported by remote peer
+func (peer *Peer) GetService() config.ServiceFlag {
+	peer.lock.RLock()
+	defer peer.lock.RUnlock()
+	return peer.service
+}
+
+// GetVersion get the version of remote peer
+func (peer *Peer) GetVersion() string {
+	peer.lock.RLock()
+	defer peer.lock.RUnlock()
+	return peer.version
+}
+
+// ObservedAddr get our address observed by remote peer, return empty string if remote didn't tell us.
+func (peer *Peer) ObservedAddr() string {
+	if addr, ok := peer.observedAddr.Load().(string); ok {
+		return addr
+	}
+	return ""
+}
+
 // IsOutBound check whether the peer is outbound peer.
 func (peer *Peer) IsOutBound() bool {
 	return peer.outBound.Load().(bool)
@@ -381,10 +551,11 @@ func (peer *Peer) IsOutBound() bool {
 func (peer *Peer) disconnectNotify(err error) {
 	log.Debug("[p2p]call disconnectNotify for %s, as: %v", peer.GetAddr().ToString(), err)
 	disconnectMsg := &peerDisconnecMsg{
-		err,
+		err:    err,
+		reason: disconnectReason(err),
 	}
 	msg := &InternalMsg{
-		From:    peer.addr,
+		From:    peer.GetAddr(),
 		To:      peer.serverInfo.addr,
 		Payload: disconnectMsg,
 	}
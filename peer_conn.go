@@ -16,6 +16,7 @@ type PeerConn struct {
 	quitChan  chan interface{}
 	lock      sync.RWMutex
 	isRunning int32
+	stats     *peerStats // statistics of the peer, nil if not recorded
 }
 
 // NewPeerConn create a PeerConn instance
@@ -50,15 +51,25 @@ func (peerConn *PeerConn) Stop() {
 
 // message receive handler
 func (peerConn *PeerConn) recvHandler() {
-	reader := bufio.NewReaderSize(peerConn.conn, MAX_BUF_LEN)
+	reader := &countReader{reader: bufio.NewReaderSize(peerConn.conn, MAX_BUF_LEN)}
+	firstMsg := true
 	for {
 		// read new message From connection
+		reader.count = 0
 		msg, err := message.ReadMessage(reader)
 		if err != nil {
 			log.Error("failed To read message From remote %s, as: %v", peerConn.conn.RemoteAddr().String(), err)
 			peerConn.disconnectNotify(err)
 			return
 		}
+		if firstMsg {
+			// remote have sent a valid message, clear the handshake read deadline.
+			peerConn.conn.SetReadDeadline(time.Time{})
+			firstMsg = false
+		}
+		if peerConn.stats != nil {
+			peerConn.stats.onRecv(msg, reader.count)
+		}
 		peerConn.receivedMsg(msg)
 	}
 }
@@ -78,14 +89,26 @@ func (peerConn *PeerConn) SendMessage(msg message.Message) error {
 		log.Error("failed To send raw message To remote %s, as: %v", peerConn.conn.RemoteAddr().String(), err)
 		return err
 	}
+	if peerConn.stats != nil {
+		peerConn.stats.onSend(msg, len(buf))
+	}
 	return nil
 }
 
+// RemoteAddr get the remote address of the connection, return empty string if unknown.
+func (peerConn *PeerConn) RemoteAddr() string {
+	if peerConn.conn == nil || peerConn.conn.RemoteAddr() == nil {
+		return ""
+	}
+	return peerConn.conn.RemoteAddr().String()
+}
+
 //disconnectNotify push disconnect msg To channel
 func (peerConn *PeerConn) disconnectNotify(err error) {
 	log.Debug("call disconnectNotify for %s, as: %v", peerConn.conn.RemoteAddr().String(), err)
 	disconnectMsg := &peerDisconnecMsg{
-		err,
+		err:    err,
+		reason: disconnectReason(err),
 	}
 	peerConn.receivedMsg(disconnectMsg)
 }
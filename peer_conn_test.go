@@ -1,10 +1,13 @@
 package p2p
 
 import (
+	"bytes"
+	"encoding/binary"
 	"errors"
 	"github.com/DSiSc/monkey"
 	"github.com/DSiSc/p2p/message"
 	"github.com/stretchr/testify/assert"
+	"io"
 	"net"
 	"reflect"
 	"testing"
@@ -109,3 +112,18 @@ func TestPeerConn_SendMessage(t *testing.T) {
 
 	peerConn.Stop()
 }
+
+func TestReadMessage_DeclaredLength(t *testing.T) {
+	assert := assert.New(t)
+	buf, err := message.EncodeMessage(&message.PingMsg{State: 1})
+	assert.Nil(err)
+
+	// a message declaring a large body but sending only a few bytes
+	binary.LittleEndian.PutUint32(buf[8:], message.MaxMessageLength)
+	_, err = message.ReadMessage(bytes.NewReader(buf))
+	assert.Equal(io.ErrUnexpectedEOF, err)
+
+	binary.LittleEndian.PutUint32(buf[8:], message.MaxMessageLength+1)
+	_, err = message.ReadMessage(bytes.NewReader(buf))
+	assert.NotNil(err)
+}
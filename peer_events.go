@@ -0,0 +1,87 @@
+package p2p
+
+import (
+	"github.com/DSiSc/craft/types"
+	"github.com/DSiSc/p2p/common"
+	"github.com/DSiSc/p2p/message"
+	"time"
+)
+
+// peerEventBase is the first event type of the p2p peer lifecycle events, it leaves room for
+// the event types defined in craft.
+const peerEventBase types.EventType = 100
+
+// peer lifecycle event types, the event value is a *PeerEvent.
+const (
+	EventPeerDialStarted       = peerEventBase + iota // start dialing To an outbound peer
+	EventPeerDialFailed                               // failed To dial To an outbound peer
+	EventPeerHandshakeFailed                          // failed To hand shake with a peer
+	EventPeerConnected                                // peer became active
+	EventPeerDisconnected                             // active peer have been disconnected
+	EventPeerBanned                                   // ip of the peer have been banned
+	EventPeerUnbanned                                 // ban of the peer ip have been lifted
+	EventPersistentPeerAdded                          // persistent peer have been added at runtime
+	EventPersistentPeerRemoved                        // persistent peer have been removed at runtime
+)
+
+// PeerEvent is the value of the peer lifecycle events.
+type PeerEvent struct {
+	Addr       *common.NetAddress       `json:"addr"`
+	OutBound   bool                     `json:"out_bound"`
+	Persistent bool                     `json:"persistent"`
+	Reason     string                   `json:"reason,omitempty"`   // failure or disconnection reason
+	Code       message.DisconnectReason `json:"code,omitempty"`     // disconnect reason code
+	Remote     bool                     `json:"remote,omitempty"`   // whether the disconnection is decided by remote
+	Duration   time.Duration            `json:"duration,omitempty"` // how long the peer have been active, only set in disconnected event
+	Time       time.Time                `json:"time"`
+}
+
+// create a lifecycle event of the peer
+func newPeerEvent(peer *Peer, reason error) *PeerEvent {
+	event := &PeerEvent{
+		Addr:       peer.GetAddr(),
+		OutBound:   peer.IsOutBound(),
+		Persistent: peer.IsPersistent(),
+		Time:       time.Now(),
+	}
+	if reason != nil {
+		event.Reason = reason.Error()
+		event.Code = disconnectReason(reason)
+		event.Remote = isRemoteDisconnect(reason)
+	}
+	return event
+}
+
+// notify event To subscribers
+func (service *P2P) notify(eventType types.EventType, value interface{}) {
+	if service.center != nil {
+		service.center.Notify(eventType, value)
+	}
+}
+
+// notify peer lifecycle event To subscribers
+func (service *P2P) notifyPeerEvent(eventType types.EventType, peer *Peer, reason error) {
+	event := newPeerEvent(peer, reason)
+	if eventType == EventPeerDisconnected && !peer.ActiveTime().IsZero() {
+		event.Duration = event.Time.Sub(peer.ActiveTime())
+	}
+	service.notify(eventType, event)
+}
+
+// notify the event of an address which may have no peer, e.g. ban and persistent peer changes
+func (service *P2P) notifyAddrEvent(eventType types.EventType, addr *common.NetAddress, reason string) {
+	service.notify(eventType, &PeerEvent{
+		Addr:   addr,
+		Reason: reason,
+		Time:   time.Now(),
+	})
+}
+
+// notify the failure of starting a peer, which is a dial failure if peer have not established the connection.
+func (service *P2P) notifyStartFailed(peer *Peer, status PeerStatus, reason error) {
+	if status == PeerDialing {
+		service.notifyPeerEvent(EventPeerDialFailed, peer, reason)
+	} else {
+		service.notifyPeerEvent(EventPeerHandshakeFailed, peer, reason)
+	}
+}
@@ -0,0 +1,76 @@
+package p2p
+
+import (
+	"errors"
+	"github.com/DSiSc/craft/types"
+	"github.com/DSiSc/p2p/message"
+	"github.com/stretchr/testify/assert"
+	"testing"
+	"time"
+)
+
+// event center which records the notified events
+type recordEventCenter struct {
+	eventCenter
+	events chan interface{}
+	types  chan types.EventType
+}
+
+func newRecordEventCenter() *recordEventCenter {
+	return &recordEventCenter{
+		events: make(chan interface{}, 16),
+		types:  make(chan types.EventType, 16),
+	}
+}
+
+func (center *recordEventCenter) Notify(eventType types.EventType, value interface{}) (err error) {
+	center.types <- eventType
+	center.events <- value
+	return nil
+}
+
+func TestP2P_NotifyPeerEvent(t *testing.T) {
+	assert := assert.New(t)
+	center := newRecordEventCenter()
+	p2p, err := NewP2P(mockConfig(), center)
+	assert.Nil(err)
+
+	peer := NewOutboundPeer(mockServerInfo(), mockAddress(), true, make(chan *InternalMsg))
+	peer.transit(PeerHandshaking)
+	peer.transit(PeerActive)
+	peer.activeTime.Store(time.Now().Add(-time.Minute))
+	p2p.notifyPeerEvent(EventPeerDisconnected, peer, errors.New("mock reason"))
+
+	assert.Equal(EventPeerDisconnected, <-center.types)
+	event := (<-center.events).(*PeerEvent)
+	assert.Equal(peer.GetAddr(), event.Addr)
+	assert.True(event.OutBound)
+	assert.True(event.Persistent)
+	assert.Equal("mock reason", event.Reason)
+	assert.True(event.Duration >= time.Minute)
+}
+
+func TestP2P_NotifyStartFailed(t *testing.T) {
+	assert := assert.New(t)
+	center := newRecordEventCenter()
+	p2p, err := NewP2P(mockConfig(), center)
+	assert.Nil(err)
+
+	peer := NewOutboundPeer(mockServerInfo(), mockAddress(), false, make(chan *InternalMsg))
+	p2p.notifyStartFailed(peer, PeerDialing, errors.New("connection refused"))
+	assert.Equal(EventPeerDialFailed, <-center.types)
+	assert.Equal("connection refused", (<-center.events).(*PeerEvent).Reason)
+
+	p2p.notifyStartFailed(peer, PeerHandshaking, newRemoteDisconnectError(&message.GoodbyeMsg{Reason: message.ReasonIncompatibleVersion}))
+	assert.Equal(EventPeerHandshakeFailed, <-center.types)
+	event := (<-center.events).(*PeerEvent)
+	assert.Equal(time.Duration(0), event.Duration)
+	assert.Equal(message.ReasonIncompatibleVersion, event.Code)
+	assert.True(event.Remote)
+}
+
+func TestP2P_NotifyNilCenter(t *testing.T) {
+	p2p, err := NewP2P(mockConfig(), nil)
+	assert.Nil(t, err)
+	p2p.notify(types.EventAddPeer, mockAddress())
+}
@@ -0,0 +1,143 @@
+package p2p
+
+import (
+	"errors"
+	"fmt"
+	"github.com/DSiSc/craft/log"
+	"github.com/DSiSc/p2p/common"
+	"github.com/DSiSc/p2p/message"
+	"sync/atomic"
+	"time"
+)
+
+// errPeerBanned is returned when dialing a peer whose ip is banned
+var errPeerBanned = errors.New("peer is banned")
+
+// check whether outbound connection To the address is allowed, return errPeerBanned if its ip is banned. All the
+// outbound connections(dialing, feeler and crawler) must be checked before connecting.
+func (service *P2P) checkOutbound(addr *common.NetAddress) error {
+	if service.bans.isBanned(addr.ParsedIP()) {
+		log.Debug("peer %s is banned, don't connect To it", addr.ToString())
+		return errPeerBanned
+	}
+	return nil
+}
+
+// check whether the service is running
+func (service *P2P) running() bool {
+	return atomic.LoadInt32(&service.isRunning) == 1
+}
+
+// AddPersistentPeer add a persistent peer and connect To it immediately if the service is running, a connected
+// peer is kept and becomes persistent. The peer is reconnected whenever it's disconnected, and the change is
+// saved, so it survives restart. Failing To save only loses the change on restart, so it's logged but not
+// returned as the change have taken effect.
+func (service *P2P) AddPersistentPeer(addr *common.NetAddress) error {
+	if service.addrManager.IsOurAddress(addr) {
+		return fmt.Errorf("%s is our own address", addr.ToString())
+	}
+	added, err := service.persistent.add(addr)
+	if err != nil {
+		log.Warn("failed To save persistent peers, as: %v", err)
+	}
+	if !added {
+		return fmt.Errorf("%s is already a persistent peer", addr.ToString())
+	}
+	log.Info("add persistent peer %s", addr.ToString())
+	service.notifyAddrEvent(EventPersistentPeerAdded, addr, "")
+	if service.running() {
+		service.connectPersistentPeer(addr)
+	}
+	return nil
+}
+
+// RemovePersistentPeer remove a persistent peer, it's not reconnected any more but the current connection is
+// kept. The change is saved, so it survives restart, failing To save is logged as in AddPersistentPeer.
+func (service *P2P) RemovePersistentPeer(addr *common.NetAddress) error {
+	removed, err := service.persistent.remove(addr)
+	if err != nil {
+		log.Warn("failed To save persistent peers, as: %v", err)
+	}
+	if !removed {
+		return fmt.Errorf("%s is not a persistent peer", addr.ToString())
+	}
+	log.Info("remove persistent peer %s", addr.ToString())
+	for _, peer := range service.peers.list(nil) {
+		host := peer.persistentHost()
+		if peer.GetAddr().Equal(addr) || (host != nil && host.Equal(addr)) {
+			peer.setPersistent(false, nil)
+		}
+	}
+	service.notifyAddrEvent(EventPersistentPeerRemoved, addr, "")
+	return nil
+}
+
+// PersistentPeers get the persistent peers
+func (service *P2P) PersistentPeers() []*common.NetAddress {
+	return service.persistent.list()
+}
+
+// DialPeer make an attempt To connect To the peer, it's not reconnected after disconnection.
+func (service *P2P) DialPeer(addr *common.NetAddress) error {
+	if !service.running() {
+		return errors.New("P2P have not been started yet")
+	}
+	if addr.IsHostname() {
+		addrs, err := addr.Resolve()
+		if err != nil {
+			return err
+		}
+		addr = addrs[0]
+	}
+	if service.addrManager.IsOurAddress(addr) {
+		return fmt.Errorf("%s is our own address", addr.ToString())
+	}
+	service.addrManager.AddAddress(addr)
+	return service.dialPeer(NewOutboundPeer(&service.PeerCom, addr, false, service.internalChan))
+}
+
+// DisconnectPeer disconnect the peer, a persistent peer will be reconnected.
+func (service *P2P) DisconnectPeer(addr *common.NetAddress) error {
+	peer := service.peers.get(addr)
+	if peer == nil {
+		return fmt.Errorf("peer %s is not in our neighbor list", addr.ToString())
+	}
+	service.disconnectPeer(peer.GetAddr(), errors.New("disconnected by operator"))
+	return nil
+}
+
+// BanPeer ban the ip of the address for the duration(default 24h), the peers with the ip are disconnected and
+// no more connections with it are allowed until the ban expires.
+func (service *P2P) BanPeer(addr *common.NetAddress, duration time.Duration, reason string) error {
+	ip := addr.ParsedIP()
+	if ip == nil {
+		return fmt.Errorf("can't ban %s, only ip address can be banned", addr.ToString())
+	}
+	info := service.bans.ban(ip, duration, reason)
+	log.Info("ban %s until %v, as: %s", info.IP, info.Until, reason)
+	service.notifyAddrEvent(EventPeerBanned, addr, reason)
+
+	banErr := newDisconnectError(message.ReasonBanned, fmt.Errorf("banned: %s", reason))
+	for _, peer := range service.peers.list(nil) {
+		if peer.GetAddr().ParsedIP().Equal(ip) {
+			service.disconnectPeer(peer.GetAddr(), banErr)
+		}
+	}
+	return nil
+}
+
+// UnbanPeer lift the ban of the ip of the address
+func (service *P2P) UnbanPeer(addr *common.NetAddress) error {
+	ip := addr.ParsedIP()
+	if ip == nil || !service.bans.unban(ip) {
+		return fmt.Errorf("%s is not banned", addr.IP)
+	}
+	log.Info("unban %s", ip.String())
+	service.notifyAddrEvent(EventPeerUnbanned, addr, "")
+	return nil
+}
+
+// BannedPeers get the ips banned
+func (service *P2P) BannedPeers() []*BanInfo {
+	return service.bans.list()
+}
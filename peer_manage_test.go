@@ -0,0 +1,158 @@
+package p2p
+
+import (
+	"errors"
+	"github.com/DSiSc/monkey"
+	"github.com/DSiSc/p2p/common"
+	"github.com/stretchr/testify/assert"
+	"io/ioutil"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+var _ P2PAPI = (*P2P)(nil)
+
+func TestP2P_PersistentPeers(t *testing.T) {
+	assert := assert.New(t)
+	dir, err := ioutil.TempDir("", "persistent")
+	assert.Nil(err)
+	defer os.RemoveAll(dir)
+	conf := mockConfig()
+	conf.AddrBookFilePath = filepath.Join(dir, "address.json")
+	conf.PersistentPeers = "192.168.1.1:8080"
+	center := newRecordEventCenter()
+	p2p, err := NewP2P(conf, center)
+	assert.Nil(err)
+
+	addr := common.NewNetAddress("tcp", "192.168.1.2", 8080)
+	assert.Nil(p2p.AddPersistentPeer(addr))
+	assert.Equal(EventPersistentPeerAdded, <-center.types)
+	assert.Equal(addr, (<-center.events).(*PeerEvent).Addr)
+	assert.NotNil(p2p.AddPersistentPeer(addr))
+	assert.Equal(2, len(p2p.PersistentPeers()))
+
+	assert.Nil(p2p.RemovePersistentPeer(common.NewNetAddress("tcp", "192.168.1.1", 8080)))
+	assert.Equal(EventPersistentPeerRemoved, <-center.types)
+	<-center.events
+	assert.NotNil(p2p.RemovePersistentPeer(common.NewNetAddress("tcp", "192.168.1.1", 8080)))
+
+	// the changes survive restart
+	p2p, err = NewP2P(conf, nil)
+	assert.Nil(err)
+	assert.Equal([]*common.NetAddress{addr}, p2p.PersistentPeers())
+}
+
+func TestP2P_PersistentPeersSaveFailed(t *testing.T) {
+	defer monkey.UnpatchAll()
+	assert := assert.New(t)
+	p2p, err := NewP2P(mockConfig(), nil)
+	assert.Nil(err)
+	monkey.Patch(common.WriteFileAtomic, func(path string, data []byte, perm os.FileMode) error {
+		return errors.New("disk full")
+	})
+
+	// the changes take effect even if they are not saved
+	addr := common.NewNetAddress("tcp", "192.168.1.2", 8080)
+	assert.Nil(p2p.AddPersistentPeer(addr))
+	assert.Equal([]*common.NetAddress{addr}, p2p.PersistentPeers())
+	assert.Nil(p2p.RemovePersistentPeer(addr))
+	assert.Equal(0, len(p2p.PersistentPeers()))
+}
+
+func TestP2P_DialPeer(t *testing.T) {
+	assert := assert.New(t)
+	p2p, err := NewP2P(mockConfig(), nil)
+	assert.Nil(err)
+	addr := common.NewNetAddress("tcp", "192.168.1.1", 8080)
+	assert.NotNil(p2p.DialPeer(addr))
+
+	p2p.isRunning = 1
+	assert.Nil(p2p.BanPeer(addr, time.Hour, "spam"))
+	assert.Equal(errPeerBanned, p2p.DialPeer(addr))
+}
+
+func TestP2P_DisconnectPeer(t *testing.T) {
+	assert := assert.New(t)
+	p2p, err := NewP2P(mockConfig(), nil)
+	assert.Nil(err)
+	addr := common.NewNetAddress("tcp", "192.168.1.1", 8080)
+	assert.NotNil(p2p.DisconnectPeer(addr))
+
+	peer := NewOutboundPeer(mockServerInfo(), addr, false, make(chan *InternalMsg))
+	assert.Nil(p2p.peers.add(addr, peer))
+	assert.Nil(p2p.DisconnectPeer(common.NewNetAddress("tcp", "192.168.1.1", 8080)))
+	assert.False(p2p.containsPeer(addr))
+}
+
+func TestP2P_BanPeer(t *testing.T) {
+	assert := assert.New(t)
+	center := newRecordEventCenter()
+	p2p, err := NewP2P(mockConfig(), center)
+	assert.Nil(err)
+	assert.NotNil(p2p.BanPeer(common.NewNetAddress("tcp", "example.com", 8080), time.Hour, ""))
+
+	// peers with the ip are disconnected
+	addr1 := common.NewNetAddress("tcp", "192.168.1.1", 8080)
+	addr2 := common.NewNetAddress("tcp", "192.168.1.1", 8081)
+	addr3 := common.NewNetAddress("tcp", "192.168.1.2", 8080)
+	for _, addr := range []*common.NetAddress{addr1, addr2, addr3} {
+		assert.Nil(p2p.peers.add(addr, NewOutboundPeer(mockServerInfo(), addr, false, make(chan *InternalMsg))))
+	}
+	assert.Nil(p2p.BanPeer(addr1, time.Hour, "spam"))
+	assert.Equal(EventPeerBanned, <-center.types)
+	assert.Equal("spam", (<-center.events).(*PeerEvent).Reason)
+	assert.False(p2p.containsPeer(addr1))
+	assert.False(p2p.containsPeer(addr2))
+	assert.True(p2p.containsPeer(addr3))
+	bans := p2p.BannedPeers()
+	assert.Equal(1, len(bans))
+	assert.Equal("192.168.1.1", bans[0].IP)
+
+	assert.Nil(p2p.UnbanPeer(addr2))
+	assert.Equal(EventPeerUnbanned, <-center.types)
+	<-center.events
+	assert.NotNil(p2p.UnbanPeer(addr2))
+	assert.Equal(0, len(p2p.BannedPeers()))
+}
+
+func TestP2P_AddPersistentPeer_Connected(t *testing.T) {
+	assert := assert.New(t)
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	assert.Nil(err)
+	defer listener.Close()
+	port := int32(listener.Addr().(*net.TCPAddr).Port)
+
+	p2p, err := NewP2P(mockConfig(), nil)
+	assert.Nil(err)
+	p2p.addrManager.backoff = newBackoff(time.Millisecond, 10*time.Millisecond, 0)
+	p2p.isRunning = 1
+	defer close(p2p.quitChan)
+
+	// promote a connected peer To persistent peer
+	addr := common.NewNetAddress("tcp", "127.0.0.1", port)
+	peer := NewOutboundPeer(&p2p.PeerCom, addr, false, p2p.internalChan)
+	assert.Nil(p2p.peers.add(addr, peer))
+	assert.Nil(p2p.AddPersistentPeer(common.NewNetAddress("tcp", "127.0.0.1", port)))
+	assert.True(peer.IsPersistent())
+
+	// it's reconnected after disconnection
+	p2p.stopPeer(addr, errors.New("connection reset"))
+	select {
+	case conn := <-acceptConn(listener):
+		conn.Close()
+	case <-time.After(5 * time.Second):
+		assert.Fail("persistent peer is not reconnected")
+	}
+
+	// not persistent any more after removed
+	addr = common.NewNetAddress("tcp", "192.168.1.1", 8080)
+	peer = NewOutboundPeer(&p2p.PeerCom, addr, false, p2p.internalChan)
+	assert.Nil(p2p.peers.add(addr, peer))
+	assert.Nil(p2p.AddPersistentPeer(addr))
+	assert.True(peer.IsPersistent())
+	assert.Nil(p2p.RemovePersistentPeer(addr))
+	assert.False(peer.IsPersistent())
+}
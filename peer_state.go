@@ -0,0 +1,81 @@
+package p2p
+
+import (
+	"fmt"
+	"sync/atomic"
+	"time"
+)
+
+// PeerStatus is the connection status of a peer
+type PeerStatus int32
+
+const (
+	PeerDialing     PeerStatus = iota // dialing To the outbound peer
+	PeerHandshaking                   // connection established, exchanging version messages
+	PeerActive                        // handshake finished, peer is serving
+	PeerClosing                       // peer is being stopped
+)
+
+// peerTransitions defines the valid transitions of the peer state machine. A stopped persistent peer
+// can be redialed, so closing peer is allowed To go back To dialing.
+var peerTransitions = map[PeerStatus][]PeerStatus{
+	PeerDialing:     {PeerDialing, PeerHandshaking, PeerClosing},
+	PeerHandshaking: {PeerActive, PeerClosing},
+	PeerActive:      {PeerClosing},
+	PeerClosing:     {PeerClosing, PeerDialing},
+}
+
+// String return the status name
+func (status PeerStatus) String() string {
+	switch status {
+	case PeerDialing:
+		return "dialing"
+	case PeerHandshaking:
+		return "handshaking"
+	case PeerActive:
+		return "active"
+	case PeerClosing:
+		return "closing"
+	default:
+		return fmt.Sprintf("unknown(%d)", status)
+	}
+}
+
+// check whether the peer can transit From one status To another.
+func canTransit(from, to PeerStatus) bool {
+	for _, status := range peerTransitions[from] {
+		if status == to {
+			return true
+		}
+	}
+	return false
+}
+
+// Status get peer's connection status
+func (peer *Peer) Status() PeerStatus {
+	return PeerStatus(atomic.LoadInt32(&peer.status))
+}
+
+// transit peer To the new status, return the status before transition, and error if the transition is invalid.
+func (peer *Peer) transit(to PeerStatus) (PeerStatus, error) {
+	for {
+		from := peer.Status()
+		if !canTransit(from, to) {
+			return from, fmt.Errorf("peer %s can't transit From %v To %v", peer.addr.ToString(), from, to)
+		}
+		if atomic.CompareAndSwapInt32(&peer.status, int32(from), int32(to)) {
+			if to == PeerActive {
+				peer.activeTime.Store(time.Now())
+			}
+			return from, nil
+		}
+	}
+}
+
+// ActiveTime get the time when peer became active, return zero time if peer have never been active.
+func (peer *Peer) ActiveTime() time.Time {
+	if t, ok := peer.activeTime.Load().(time.Time); ok {
+		return t
+	}
+	return time.Time{}
+}
@@ -0,0 +1,54 @@
+package p2p
+
+import (
+	"github.com/stretchr/testify/assert"
+	"testing"
+)
+
+func TestPeerStatus_String(t *testing.T) {
+	assert := assert.New(t)
+	assert.Equal("dialing", PeerDialing.String())
+	assert.Equal("handshaking", PeerHandshaking.String())
+	assert.Equal("active", PeerActive.String())
+	assert.Equal("closing", PeerClosing.String())
+	assert.Equal("unknown(10)", PeerStatus(10).String())
+}
+
+func TestPeer_Transit(t *testing.T) {
+	assert := assert.New(t)
+	peer := NewOutboundPeer(mockServerInfo(), mockAddress(), true, make(chan *InternalMsg))
+	assert.Equal(PeerDialing, peer.Status())
+
+	_, err := peer.transit(PeerActive)
+	assert.NotNil(err)
+
+	from, err := peer.transit(PeerHandshaking)
+	assert.Nil(err)
+	assert.Equal(PeerDialing, from)
+	assert.True(peer.ActiveTime().IsZero())
+
+	from, err = peer.transit(PeerActive)
+	assert.Nil(err)
+	assert.Equal(PeerHandshaking, from)
+	assert.False(peer.ActiveTime().IsZero())
+
+	_, err = peer.transit(PeerDialing)
+	assert.NotNil(err)
+
+	from, err = peer.transit(PeerClosing)
+	assert.Nil(err)
+	assert.Equal(PeerActive, from)
+
+	// redial
+	from, err = peer.transit(PeerDialing)
+	assert.Nil(err)
+	assert.Equal(PeerClosing, from)
+}
+
+func TestNewInboundPeer_Status(t *testing.T) {
+	assert := assert.New(t)
+	peer := NewInboundPeer(mockServerInfo(), mockAddress(), make(chan *InternalMsg), newTestConn())
+	assert.Equal(PeerHandshaking, peer.Status())
+	_, err := peer.transit(PeerDialing)
+	assert.NotNil(err)
+}
@@ -0,0 +1,174 @@
+package p2p
+
+import (
+	"github.com/DSiSc/p2p/config"
+	"github.com/DSiSc/p2p/message"
+	"io"
+	"sort"
+	"sync"
+	"time"
+)
+
+// MessageStats is the num and bytes of a type of messages
+type MessageStats struct {
+	Messages uint64 `json:"messages"`
+	Bytes    uint64 `json:"bytes"`
+}
+
+// PeerInfo is the snapshot of a peer and its statistics
+type PeerInfo struct {
+	Addr              string                   `json:"addr"`
+	Status            string                   `json:"status"`
+	OutBound          bool                     `json:"out_bound"`
+	Persistent        bool                     `json:"persistent"`
+	Version           string                   `json:"version,omitempty"`
+	Service           config.ServiceFlag       `json:"service"`
+	State             uint64                   `json:"state"`
+	ConnectedSince    time.Time                `json:"connected_since"`
+	HandshakeDuration time.Duration            `json:"handshake_duration"`
+	PingRTT           time.Duration            `json:"ping_rtt"` // zero if no pong received yet
+	LastSend          time.Time                `json:"last_send"`
+	LastRecv          time.Time                `json:"last_recv"`
+	MsgsSent          uint64                   `json:"msgs_sent"`
+	MsgsRecv          uint64                   `json:"msgs_recv"`
+	BytesSent         uint64                   `json:"bytes_sent"`
+	BytesRecv         uint64                   `json:"bytes_recv"`
+	Duplicates        uint64                   `json:"duplicates"` // received messages already known by peer
+	Sent              map[string]*MessageStats `json:"sent"`       // keyed by message type name
+	Recv              map[string]*MessageStats `json:"recv"`       // keyed by message type name
+}
+
+// peerStats records the traffic statistics of a peer
+type peerStats struct {
+	sent       map[message.MessageType]*MessageStats
+	recv       map[message.MessageType]*MessageStats
+	lastSend   time.Time
+	lastRecv   time.Time
+	pingSent   time.Time // time when the last unanswered ping was sent
+	pingRTT    time.Duration
+	handshake  time.Duration
+	duplicates uint64
+	lock       sync.RWMutex
+}
+
+// create an empty peer statistics
+func newPeerStats() *peerStats {
+	return &peerStats{
+		sent: make(map[message.MessageType]*MessageStats),
+		recv: make(map[message.MessageType]*MessageStats),
+	}
+}
+
+// record a message sent To peer
+func (stats *peerStats) onSend(msg message.Message, size int) {
+	stats.lock.Lock()
+	defer stats.lock.Unlock()
+	stats.lastSend = time.Now()
+	addMessageStats(stats.sent, msg.MsgType(), size)
+	if msg.MsgType() == message.PING_TYPE {
+		stats.pingSent = stats.lastSend
+	}
+}
+
+// record a message received From peer
+func (stats *peerStats) onRecv(msg message.Message, size int) {
+	stats.lock.Lock()
+	defer stats.lock.Unlock()
+	stats.lastRecv = time.Now()
+	addMessageStats(stats.recv, msg.MsgType(), size)
+	if msg.MsgType() == message.PONG_TYPE && !stats.pingSent.IsZero() {
+		stats.pingRTT = stats.lastRecv.Sub(stats.pingSent)
+		stats.pingSent = time.Time{}
+	}
+}
+
+// record a received message already known by peer
+func (stats *peerStats) onDuplicate() {
+	stats.lock.Lock()
+	defer stats.lock.Unlock()
+	stats.duplicates++
+}
+
+// record the duration of the handshake
+func (stats *peerStats) onHandshake(duration time.Duration) {
+	stats.lock.Lock()
+	defer stats.lock.Unlock()
+	stats.handshake = duration
+}
+
+// fill the statistics into peer info
+func (stats *peerStats) fill(info *PeerInfo) {
+	stats.lock.RLock()
+	defer stats.lock.RUnlock()
+	info.HandshakeDuration = stats.handshake
+	info.PingRTT = stats.pingRTT
+	info.LastSend = stats.lastSend
+	info.LastRecv = stats.lastRecv
+	info.Duplicates = stats.duplicates
+	info.Sent, info.MsgsSent, info.BytesSent = copyMessageStats(stats.sent)
+	info.Recv, info.MsgsRecv, info.BytesRecv = copyMessageStats(stats.recv)
+}
+
+// add a message To the statistics of its type
+func addMessageStats(statsMap map[message.MessageType]*MessageStats, msgType message.MessageType, size int) {
+	stats, ok := statsMap[msgType]
+	if !ok {
+		stats = &MessageStats{}
+		statsMap[msgType] = stats
+	}
+	stats.Messages++
+	stats.Bytes += uint64(size)
+}
+
+// copy the statistics keyed by type name, and sum up the messages and bytes
+func copyMessageStats(statsMap map[message.MessageType]*MessageStats) (map[string]*MessageStats, uint64, uint64) {
+	named := make(map[string]*MessageStats, len(statsMap))
+	var msgs, bytes uint64
+	for msgType, stats := range statsMap {
+		named[msgType.String()] = &MessageStats{Messages: stats.Messages, Bytes: stats.Bytes}
+		msgs += stats.Messages
+		bytes += stats.Bytes
+	}
+	return named, msgs, bytes
+}
+
+// countReader counts the bytes read From the underlying reader
+type countReader struct {
+	reader io.Reader
+	count  int
+}
+
+// Read read From the underlying reader and count the bytes
+func (r *countReader) Read(p []byte) (int, error) {
+	n, err := r.reader.Read(p)
+	r.count += n
+	return n, err
+}
+
+// Info get the snapshot of the peer and its statistics
+func (peer *Peer) Info() *PeerInfo {
+	info := &PeerInfo{
+		Addr:           peer.GetAddr().ToString(),
+		Status:         peer.Status().String(),
+		OutBound:       peer.IsOutBound(),
+		Persistent:     peer.IsPersistent(),
+		Version:        peer.GetVersion(),
+		Service:        peer.GetService(),
+		State:          peer.GetState(),
+		ConnectedSince: peer.ActiveTime(),
+	}
+	peer.stats.fill(info)
+	return info
+}
+
+// GetPeerInfo get the snapshot of all the peers(pending and active) and their statistics, sorted by address.
+func (service *P2P) GetPeerInfo() []*PeerInfo {
+	infos := make([]*PeerInfo, 0)
+	for _, peer := range service.peers.list(nil) {
+		infos = append(infos, peer.Info())
+	}
+	sort.Slice(infos, func(i, j int) bool {
+		return infos[i].Addr < infos[j].Addr
+	})
+	return infos
+}
@@ -0,0 +1,111 @@
+package p2p
+
+import (
+	"bytes"
+	"github.com/DSiSc/p2p/common"
+	"github.com/DSiSc/p2p/message"
+	"github.com/stretchr/testify/assert"
+	"net"
+	"testing"
+	"time"
+)
+
+func TestPeerStats(t *testing.T) {
+	assert := assert.New(t)
+	stats := newPeerStats()
+	stats.onHandshake(time.Second)
+	stats.onSend(&message.PingMsg{}, 20)
+	time.Sleep(time.Millisecond)
+	stats.onRecv(&message.PongMsg{}, 30)
+	stats.onRecv(&message.PongMsg{}, 30) // no pending ping, rtt not changed
+	stats.onRecv(&message.Transaction{}, 100)
+	stats.onDuplicate()
+
+	info := &PeerInfo{}
+	stats.fill(info)
+	assert.Equal(time.Second, info.HandshakeDuration)
+	assert.True(info.PingRTT >= time.Millisecond)
+	assert.True(info.PingRTT < time.Second)
+	assert.False(info.LastSend.IsZero())
+	assert.False(info.LastRecv.IsZero())
+	assert.Equal(uint64(1), info.MsgsSent)
+	assert.Equal(uint64(20), info.BytesSent)
+	assert.Equal(uint64(3), info.MsgsRecv)
+	assert.Equal(uint64(160), info.BytesRecv)
+	assert.Equal(uint64(1), info.Duplicates)
+	assert.Equal(&MessageStats{Messages: 1, Bytes: 20}, info.Sent["ping"])
+	assert.Equal(&MessageStats{Messages: 2, Bytes: 60}, info.Recv["pong"])
+	assert.Equal(&MessageStats{Messages: 1, Bytes: 100}, info.Recv["tx"])
+
+	// snapshot is not changed by later records
+	stats.onRecv(&message.Transaction{}, 100)
+	assert.Equal(uint64(1), info.Recv["tx"].Messages)
+}
+
+func TestCountReader(t *testing.T) {
+	assert := assert.New(t)
+	buf, err := message.EncodeMessage(&message.PingMsg{State: 1})
+	assert.Nil(err)
+	reader := &countReader{reader: bytes.NewReader(buf)}
+	msg, err := message.ReadMessage(reader)
+	assert.Nil(err)
+	assert.Equal(message.PING_TYPE, msg.MsgType())
+	assert.Equal(len(buf), reader.count)
+}
+
+func TestPeerConn_Stats(t *testing.T) {
+	assert := assert.New(t)
+	local, remote := net.Pipe()
+	defer remote.Close()
+	stats := newPeerStats()
+	peerConn := NewPeerConn(local, make(chan message.Message))
+	peerConn.stats = stats
+	peerConn.Start()
+	defer peerConn.Stop()
+
+	msg := &message.PingMsg{State: 1}
+	buf, err := message.EncodeMessage(msg)
+	assert.Nil(err)
+	sent := make(chan error)
+	go func() {
+		sent <- peerConn.SendMessage(msg)
+	}()
+	recv, err := message.ReadMessage(remote)
+	assert.Nil(err)
+	assert.Equal(msg, recv)
+	assert.Nil(<-sent)
+
+	go remote.Write(buf)
+	assert.Equal(msg, <-peerConn.recvChan)
+
+	info := &PeerInfo{}
+	stats.fill(info)
+	assert.Equal(&MessageStats{Messages: 1, Bytes: uint64(len(buf))}, info.Sent["ping"])
+	assert.Equal(&MessageStats{Messages: 1, Bytes: uint64(len(buf))}, info.Recv["ping"])
+}
+
+func TestP2P_GetPeerInfo(t *testing.T) {
+	assert := assert.New(t)
+	p2p, err := NewP2P(mockConfig(), nil)
+	assert.Nil(err)
+	assert.Equal(0, len(p2p.GetPeerInfo()))
+
+	addr1 := common.NewNetAddress("tcp", "192.168.1.2", 8080)
+	addr2 := common.NewNetAddress("tcp", "192.168.1.1", 8080)
+	peer1 := NewOutboundPeer(mockServerInfo(), addr1, true, make(chan *InternalMsg))
+	peer2 := NewOutboundPeer(mockServerInfo(), addr2, false, make(chan *InternalMsg))
+	peer1.stats.onSend(&message.Transaction{}, 100)
+	assert.Nil(p2p.peers.add(addr1, peer1))
+	assert.Nil(p2p.peers.add(addr2, peer2))
+
+	infos := p2p.GetPeerInfo()
+	assert.Equal(2, len(infos))
+	assert.Equal(addr2.ToString(), infos[0].Addr)
+	assert.False(infos[0].Persistent)
+	assert.Equal(addr1.ToString(), infos[1].Addr)
+	assert.Equal(PeerDialing.String(), infos[1].Status)
+	assert.True(infos[1].OutBound)
+	assert.True(infos[1].Persistent)
+	assert.Equal(uint64(100), infos[1].BytesSent)
+	assert.True(infos[1].ConnectedSince.IsZero())
+}
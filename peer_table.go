@@ -0,0 +1,194 @@
+package p2p
+
+import (
+	"fmt"
+	"github.com/DSiSc/p2p/common"
+	"github.com/DSiSc/p2p/message"
+	"sync"
+)
+
+// peerTable records all the peers(pending and active) of the p2p service. Peers are keyed by their address,
+// and active peers are also keyed by their identity, which is the node id the remote told us in handshake. As
+// the listen address of an inbound peer is unknown until the handshake finished, pending inbound peer is keyed
+// by the address of its connection, and re-keyed by its listen address when it's activated, unless another
+// node(e.g. behind the same NAT) have taken the address.
+type peerTable struct {
+	peers   map[string]*peerEntry // keyed by address
+	nodes   map[string]*peerEntry // active peers keyed by node id
+	entries map[*Peer]*peerEntry
+	lock    sync.RWMutex
+}
+
+// peerEntry is a record in peer table
+type peerEntry struct {
+	key  string
+	id   string // node id, empty until activated or if remote didn't tell us
+	addr *common.NetAddress
+	peer *Peer
+}
+
+// newPeerTable create a peer table instance
+func newPeerTable() *peerTable {
+	return &peerTable{
+		peers:   make(map[string]*peerEntry),
+		nodes:   make(map[string]*peerEntry),
+		entries: make(map[*Peer]*peerEntry),
+	}
+}
+
+// peer's address key
+func peerKey(addr *common.NetAddress) string {
+	return addr.ToString()
+}
+
+// add a pending peer To table, keyed by the address.
+func (table *peerTable) add(addr *common.NetAddress, peer *Peer) error {
+	key := peerKey(addr)
+	table.lock.Lock()
+	defer table.lock.Unlock()
+	if exist, ok := table.peers[key]; ok {
+		return fmt.Errorf("peer %s already in our peer list(status: %v)", key, exist.peer.Status())
+	}
+	if _, ok := table.entries[peer]; ok {
+		return fmt.Errorf("peer %s already in our peer list with another key", key)
+	}
+	entry := &peerEntry{
+		key:  key,
+		addr: addr,
+		peer: peer,
+	}
+	table.peers[key] = entry
+	table.entries[peer] = entry
+	return nil
+}
+
+// activate key the peer by its identity, re-key an inbound peer by its listen address, and mark it active.
+// return error if another peer with the same identity exist, or the peer have been removed From table. Remote
+// without node id is identified by its listen address.
+func (table *peerTable) activate(peer *Peer) error {
+	table.lock.Lock()
+	defer table.lock.Unlock()
+	entry, ok := table.entries[peer]
+	if !ok {
+		return fmt.Errorf("peer %s is not in our peer list", peer.GetAddr().ToString())
+	}
+	id, listenPort := peer.identity()
+	if exist, ok := table.nodes[id]; ok && id != "" && exist != entry {
+		return newDisconnectError(message.ReasonDuplicate, fmt.Errorf("node %s already in our peer list(address: %s, status: %v)", id, exist.addr.ToString(), exist.peer.Status()))
+	}
+	addr := entry.addr
+	if !peer.IsOutBound() && listenPort != 0 && listenPort != addr.Port {
+		listenAddr := *addr
+		listenAddr.Port = listenPort
+		addr = &listenAddr
+	}
+	key := peerKey(addr)
+	if exist, ok := table.peers[key]; ok && exist != entry {
+		if id == "" {
+			return newDisconnectError(message.ReasonDuplicate, fmt.Errorf("peer %s already in our peer list(status: %v)", key, exist.peer.Status()))
+		}
+		// a different node listen on the same address, keep the peer keyed by its connection address
+		addr, key = entry.addr, entry.key
+	}
+	if _, err := peer.transit(PeerActive); err != nil {
+		return err
+	}
+	delete(table.peers, entry.key)
+	entry.key, entry.id, entry.addr = key, id, addr
+	table.peers[key] = entry
+	if id != "" {
+		table.nodes[id] = entry
+	}
+	if addr != peer.GetAddr() {
+		peer.setAddr(addr)
+	}
+	return nil
+}
+
+// remove the peer From table, return the status the peer had before removing, and false if the peer is
+// not in table.
+func (table *peerTable) remove(peer *Peer) (PeerStatus, bool) {
+	table.lock.Lock()
+	defer table.lock.Unlock()
+	entry, ok := table.entries[peer]
+	if !ok {
+		return PeerClosing, false
+	}
+	delete(table.entries, peer)
+	delete(table.peers, entry.key)
+	if table.nodes[entry.id] == entry {
+		delete(table.nodes, entry.id)
+	}
+	status, _ := peer.transit(PeerClosing)
+	return status, true
+}
+
+// contains check whether a peer with the address exist.
+func (table *peerTable) contains(addr *common.NetAddress) bool {
+	table.lock.RLock()
+	defer table.lock.RUnlock()
+	_, ok := table.peers[peerKey(addr)]
+	return ok
+}
+
+// get the peer with the address, return nil if not exist.
+func (table *peerTable) get(addr *common.NetAddress) *Peer {
+	table.lock.RLock()
+	defer table.lock.RUnlock()
+	if entry, ok := table.peers[peerKey(addr)]; ok {
+		return entry.peer
+	}
+	return nil
+}
+
+// getActive get the active peer with the address, return nil if not exist.
+func (table *peerTable) getActive(addr *common.NetAddress) *Peer {
+	table.lock.RLock()
+	defer table.lock.RUnlock()
+	if entry, ok := table.peers[peerKey(addr)]; ok && entry.peer.Status() == PeerActive {
+		return entry.peer
+	}
+	return nil
+}
+
+// lookup the peer who own the address instance. Different From get, it will never return a peer which
+// replaced the owner in table.
+func (table *peerTable) lookup(addr *common.NetAddress) *Peer {
+	table.lock.RLock()
+	defer table.lock.RUnlock()
+	if entry, ok := table.peers[peerKey(addr)]; ok && entry.addr == addr {
+		return entry.peer
+	}
+	return nil
+}
+
+// list all peers satisfy the filter
+func (table *peerTable) list(filter func(peer *Peer) bool) []*Peer {
+	table.lock.RLock()
+	defer table.lock.RUnlock()
+	peers := make([]*Peer, 0)
+	for peer := range table.entries {
+		if filter == nil || filter(peer) {
+			peers = append(peers, peer)
+		}
+	}
+	return peers
+}
+
+// count the peers satisfy the filter
+func (table *peerTable) count(filter func(peer *Peer) bool) int {
+	table.lock.RLock()
+	defer table.lock.RUnlock()
+	count := 0
+	for peer := range table.entries {
+		if filter == nil || filter(peer) {
+			count++
+		}
+	}
+	return count
+}
+
+// activeFilter filter the active peers
+func activeFilter(peer *Peer) bool {
+	return peer.Status() == PeerActive
+}
@@ -0,0 +1,160 @@
+package p2p
+
+import (
+	"github.com/DSiSc/p2p/common"
+	"github.com/DSiSc/p2p/message"
+	"github.com/stretchr/testify/assert"
+	"testing"
+)
+
+func TestNewPeerTable(t *testing.T) {
+	assert := assert.New(t)
+	table := newPeerTable()
+	assert.NotNil(table)
+	assert.Equal(0, table.count(nil))
+}
+
+func TestPeerTable_Add(t *testing.T) {
+	assert := assert.New(t)
+	table := newPeerTable()
+	addr := mockAddress()
+	peer := NewOutboundPeer(mockServerInfo(), addr, false, make(chan *InternalMsg))
+	assert.Nil(table.add(addr, peer))
+	assert.True(table.contains(addr))
+	assert.Equal(PeerDialing, peer.Status())
+
+	// duplicate peer
+	peer1 := NewOutboundPeer(mockServerInfo(), mockAddress(), false, make(chan *InternalMsg))
+	assert.NotNil(table.add(peer1.GetAddr(), peer1))
+	assert.Nil(table.getActive(addr))
+	assert.Equal(peer, table.get(addr))
+}
+
+func TestPeerTable_Activate(t *testing.T) {
+	assert := assert.New(t)
+	table := newPeerTable()
+
+	// inbound peers From the same ip with different connection port
+	connAddr := common.NewNetAddress("tcp", "192.168.1.101", 50001)
+	connAddr1 := common.NewNetAddress("tcp", "192.168.1.101", 50002)
+	peer := NewInboundPeer(mockServerInfo(), connAddr, make(chan *InternalMsg), newTestConn())
+	peer1 := NewInboundPeer(mockServerInfo(), connAddr1, make(chan *InternalMsg), newTestConn())
+	assert.Nil(table.add(connAddr, peer))
+	assert.Nil(table.add(connAddr1, peer1))
+	assert.Equal(PeerHandshaking, peer.Status())
+
+	// different listen port after handshake
+	peer.id, peer.listenPort = "node", 8080
+	peer1.id, peer1.listenPort = "node1", 8081
+	assert.Nil(table.activate(peer))
+	assert.Nil(table.activate(peer1))
+	assert.Equal(PeerActive, peer.Status())
+	assert.Equal(peer, table.getActive(common.NewNetAddress("tcp", "192.168.1.101", 8080)))
+	assert.Equal(peer1, table.getActive(common.NewNetAddress("tcp", "192.168.1.101", 8081)))
+	assert.Equal(2, table.count(activeFilter))
+	assert.False(table.contains(common.NewNetAddress("tcp", "192.168.1.101", 50001)))
+
+	// the address the pending peer was added with is not changed
+	assert.Equal(int32(50001), connAddr.Port)
+	assert.Equal(int32(8080), peer.GetAddr().Port)
+	assert.Equal(peer, table.lookup(peer.GetAddr()))
+}
+
+func TestPeerTable_ActivateSameListenAddr(t *testing.T) {
+	assert := assert.New(t)
+	table := newPeerTable()
+
+	// different nodes behind the same NAT listen on the same port
+	connAddr := common.NewNetAddress("tcp", "192.168.1.101", 50001)
+	connAddr1 := common.NewNetAddress("tcp", "192.168.1.101", 50002)
+	peer := NewInboundPeer(mockServerInfo(), connAddr, make(chan *InternalMsg), newTestConn())
+	peer1 := NewInboundPeer(mockServerInfo(), connAddr1, make(chan *InternalMsg), newTestConn())
+	assert.Nil(table.add(connAddr, peer))
+	assert.Nil(table.add(connAddr1, peer1))
+	peer.id, peer.listenPort = "node", 8080
+	peer1.id, peer1.listenPort = "node1", 8080
+	assert.Nil(table.activate(peer))
+	assert.Nil(table.activate(peer1))
+	assert.Equal(2, table.count(activeFilter))
+	assert.Equal(peer, table.getActive(common.NewNetAddress("tcp", "192.168.1.101", 8080)))
+	assert.Equal(peer1, table.getActive(connAddr1))
+
+	// the same node connect again From another port
+	connAddr2 := common.NewNetAddress("tcp", "192.168.1.101", 50003)
+	peer2 := NewInboundPeer(mockServerInfo(), connAddr2, make(chan *InternalMsg), newTestConn())
+	assert.Nil(table.add(connAddr2, peer2))
+	peer2.id, peer2.listenPort = "node1", 8080
+	err := table.activate(peer2)
+	assert.Equal(message.ReasonDuplicate, disconnectReason(err))
+
+	// the identity is released once the peer is removed
+	table.remove(peer1)
+	assert.Nil(table.activate(peer2))
+	assert.Equal(peer2, table.getActive(connAddr2))
+}
+
+func TestPeerTable_ActivateDuplicate(t *testing.T) {
+	assert := assert.New(t)
+	table := newPeerTable()
+	addr := mockAddress()
+	outPeer := NewOutboundPeer(mockServerInfo(), addr, false, make(chan *InternalMsg))
+	assert.Nil(table.add(addr, outPeer))
+	outPeer.transit(PeerHandshaking)
+	outPeer.id = "node"
+	assert.Nil(table.activate(outPeer))
+
+	// the same node connect us From another address
+	connAddr := common.NewNetAddress("tcp", "192.168.1.102", 50001)
+	inPeer := NewInboundPeer(mockServerInfo(), connAddr, make(chan *InternalMsg), newTestConn())
+	assert.Nil(table.add(connAddr, inPeer))
+	inPeer.id, inPeer.listenPort = "node", 8080
+	err := table.activate(inPeer)
+	assert.NotNil(err)
+	assert.Equal(message.ReasonDuplicate, disconnectReason(err))
+	assert.Equal(outPeer, table.getActive(addr))
+
+	// remote without node id is identified by its listen address
+	connAddr1 := common.NewNetAddress("tcp", addr.IP, 50002)
+	inPeer1 := NewInboundPeer(mockServerInfo(), connAddr1, make(chan *InternalMsg), newTestConn())
+	assert.Nil(table.add(connAddr1, inPeer1))
+	inPeer1.listenPort = addr.Port
+	err = table.activate(inPeer1)
+	assert.Equal(message.ReasonDuplicate, disconnectReason(err))
+}
+
+func TestPeerTable_Remove(t *testing.T) {
+	assert := assert.New(t)
+	table := newPeerTable()
+	addr := mockAddress()
+	peer := NewOutboundPeer(mockServerInfo(), addr, false, make(chan *InternalMsg))
+	assert.Nil(table.add(addr, peer))
+	assert.NotNil(table.activate(peer))
+	peer.transit(PeerHandshaking)
+	assert.Nil(table.activate(peer))
+	status, ok := table.remove(peer)
+	assert.True(ok)
+	assert.Equal(PeerActive, status)
+	assert.Equal(PeerClosing, peer.Status())
+	_, ok = table.remove(peer)
+	assert.False(ok)
+	assert.False(table.contains(addr))
+
+	// removed peer can't be activated
+	assert.NotNil(table.activate(peer))
+}
+
+func TestPeerTable_Lookup(t *testing.T) {
+	assert := assert.New(t)
+	table := newPeerTable()
+	addr := mockAddress()
+	peer := NewOutboundPeer(mockServerInfo(), addr, false, make(chan *InternalMsg))
+	assert.Nil(table.add(addr, peer))
+	table.remove(peer)
+
+	// a new peer with the same identity replace the old one
+	addr1 := mockAddress()
+	peer1 := NewOutboundPeer(mockServerInfo(), addr1, false, make(chan *InternalMsg))
+	assert.Nil(table.add(addr1, peer1))
+	assert.Nil(table.lookup(addr))
+	assert.Equal(peer1, table.lookup(addr1))
+}
@@ -70,12 +70,13 @@ func TestPeer_Start(t *testing.T) {
 	msgs := []message.Message{
 		&message.Version{
 			Version: version.Version,
+			NodeID:  "node",
 			PortMe:  mockAddress().Port,
 			Service: config.SFNodeTX,
 		},
 		&message.VersionAck{},
 		&message.Addr{
-			NetAddresses: make([]*common.NetAddress, 0),
+			NetAddresses: make([]*message.TimedAddress, 0),
 		},
 	}
 
@@ -83,7 +84,8 @@ func TestPeer_Start(t *testing.T) {
 	monkey.Patch(NewPeerConn, func(conn net.Conn, recvChan chan message.Message) *PeerConn { return peerConn })
 	// start inbound peer
 	msgChan := make(chan *InternalMsg)
-	peer := NewInboundPeer(mockServerInfo(), mockAddress(), msgChan, newTestConn())
+	connAddr := common.NewNetAddress("tcp", "192.168.1.101", 50001)
+	peer := NewInboundPeer(mockServerInfo(), connAddr, msgChan, newTestConn())
 	assert.NotNil(peer)
 	// mock receive message From peerConn
 	go func(msgs []message.Message) {
@@ -93,6 +95,10 @@ func TestPeer_Start(t *testing.T) {
 	}(msgs)
 	err := peer.Start()
 	assert.Nil(err)
+	id, listenPort := peer.identity()
+	assert.Equal("node", id)
+	assert.Equal(mockAddress().Port, listenPort)
+	assert.Equal(int32(50001), connAddr.Port)
 
 	timer := time.NewTicker(2 * time.Second)
 	select {
@@ -124,10 +130,10 @@ func TestPeer_Start1(t *testing.T) {
 		},
 		&message.VersionAck{},
 		&message.Addr{
-			NetAddresses: make([]*common.NetAddress, 0),
+			NetAddresses: make([]*message.TimedAddress, 0),
 		},
 	}
-	monkey.Patch(net.Dial, func(network, address string) (net.Conn, error) { return newTestConn(), nil })
+	monkey.Patch(net.DialTimeout, func(network, address string, timeout time.Duration) (net.Conn, error) { return newTestConn(), nil })
 	peerConn := mockPeerConn()
 	monkey.Patch(NewPeerConn, func(conn net.Conn, recvChan chan message.Message) *PeerConn { return peerConn })
 	// start outbound peer
@@ -161,6 +167,65 @@ func TestPeer_Start1(t *testing.T) {
 	}
 }
 
+func TestPeer_StartSelf(t *testing.T) {
+	defer monkey.UnpatchAll()
+
+	assert := assert.New(t)
+
+	serverInfo := mockServerInfo()
+	serverInfo.id = "node"
+	monkey.Patch(net.DialTimeout, func(network, address string, timeout time.Duration) (net.Conn, error) { return newTestConn(), nil })
+	peerConn := mockPeerConn()
+	monkey.Patch(NewPeerConn, func(conn net.Conn, recvChan chan message.Message) *PeerConn { return peerConn })
+	peer := NewOutboundPeer(serverInfo, mockAddress(), false, make(chan *InternalMsg))
+	go func() {
+		peer.internalChan <- &message.Version{
+			Version: version.Version,
+			NodeID:  "node",
+			PortMe:  mockAddress().Port,
+			Service: config.SFNodeTX,
+		}
+	}()
+	err := peer.Start()
+	assert.NotNil(err)
+	assert.Equal(message.ReasonDuplicate, disconnectReason(err))
+}
+
+func TestPeer_InfoDuringHandshake(t *testing.T) {
+	defer monkey.UnpatchAll()
+
+	assert := assert.New(t)
+	monkey.Patch(net.DialTimeout, func(network, address string, timeout time.Duration) (net.Conn, error) { return newTestConn(), nil })
+	peerConn := mockPeerConn()
+	monkey.Patch(NewPeerConn, func(conn net.Conn, recvChan chan message.Message) *PeerConn { return peerConn })
+	peer := NewOutboundPeer(mockServerInfo(), mockAddress(), true, make(chan *InternalMsg))
+	errChan := make(chan error)
+	go func() {
+		errChan <- peer.Start()
+	}()
+	for peer.Status() != PeerHandshaking {
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	// the peer info is readable while waiting for remote's version
+	done := make(chan struct{})
+	go func() {
+		peer.GetAddr()
+		peer.IsPersistent()
+		peer.GetVersion()
+		peer.GetService()
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		assert.Nil(errors.New("peer info is blocked by handshake"))
+	}
+
+	peer.internalChan <- &message.GoodbyeMsg{Reason: message.ReasonTooManyPeers}
+	assert.NotNil(<-errChan)
+}
+
 func TestPeer_IsPersistent(t *testing.T) {
 	assert := assert.New(t)
 	msgChan := make(chan *InternalMsg)
@@ -197,7 +262,7 @@ func TestPeer_Channel(t *testing.T) {
 		},
 		&message.VersionAck{},
 		&message.Addr{
-			NetAddresses: make([]*common.NetAddress, 0),
+			NetAddresses: make([]*message.TimedAddress, 0),
 		},
 	}
 
@@ -0,0 +1,158 @@
+package p2p
+
+import (
+	"encoding/json"
+	"github.com/DSiSc/craft/log"
+	"github.com/DSiSc/p2p/common"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+)
+
+const persistentPeersFileName = "persistent_peers.json" // persistent peers file is in the same directory as address book file
+
+// get the persistent peers file path, return empty string if address book is not persisted.
+func persistentPeersFilePath(addrBookPath string) string {
+	if addrBookPath == "" {
+		return ""
+	}
+	return filepath.Join(filepath.Dir(addrBookPath), persistentPeersFileName)
+}
+
+// changes of the persistent peers made at runtime, relative To the config
+type persistentChanges struct {
+	Added   []string `json:"added,omitempty"`
+	Removed []string `json:"removed,omitempty"`
+}
+
+// persistentPeers is the set of persistent peers. The peers in config are always loaded, and the changes made
+// at runtime are saved as the peers added and removed relative To config, so the changes survive restart while
+// the config can still be edited.
+type persistentPeers struct {
+	filePath string
+	config   map[string]bool               // peers in config
+	peers    map[string]*common.NetAddress // current persistent peers
+	lock     sync.RWMutex
+}
+
+// create the persistent peer set From the comma separated peers in config and the changes in file
+func newPersistentPeers(filePath, configPeers string) *persistentPeers {
+	set := &persistentPeers{
+		filePath: filePath,
+		config:   make(map[string]bool),
+		peers:    make(map[string]*common.NetAddress),
+	}
+	for _, addrStr := range strings.Split(configPeers, ",") {
+		if strings.TrimSpace(addrStr) == "" {
+			continue
+		}
+		addr, err := common.ParseNetAddress(strings.TrimSpace(addrStr))
+		if err != nil {
+			log.Warn("invalid persistent peer address %s", addrStr)
+			continue
+		}
+		set.config[addr.ToString()] = true
+		set.peers[addr.ToString()] = addr
+	}
+	set.load()
+	return set
+}
+
+// apply the changes saved in file
+func (set *persistentPeers) load() {
+	if set.filePath == "" {
+		return
+	}
+	buf, err := ioutil.ReadFile(set.filePath)
+	if err != nil {
+		if !os.IsNotExist(err) {
+			log.Warn("failed To read persistent peers file, as: %v", err)
+		}
+		return
+	}
+	changes := &persistentChanges{}
+	if err := json.Unmarshal(buf, changes); err != nil {
+		log.Warn("persistent peers file is corrupted, as: %v", err)
+		return
+	}
+	for _, addrStr := range changes.Added {
+		if addr, err := common.ParseNetAddress(addrStr); err == nil {
+			set.peers[addr.ToString()] = addr
+		}
+	}
+	for _, addrStr := range changes.Removed {
+		delete(set.peers, addrStr)
+	}
+}
+
+// save the changes relative To config To file, must be called with lock held.
+func (set *persistentPeers) save() error {
+	if set.filePath == "" {
+		return nil
+	}
+	changes := &persistentChanges{}
+	for key := range set.peers {
+		if !set.config[key] {
+			changes.Added = append(changes.Added, key)
+		}
+	}
+	for key := range set.config {
+		if _, ok := set.peers[key]; !ok {
+			changes.Removed = append(changes.Removed, key)
+		}
+	}
+	sort.Strings(changes.Added)
+	sort.Strings(changes.Removed)
+	buf, err := json.Marshal(changes)
+	if err != nil {
+		return err
+	}
+	return common.WriteFileAtomic(set.filePath, buf, addrBookFilePerm)
+}
+
+// add a persistent peer and save the change, return false if it's already persistent.
+func (set *persistentPeers) add(addr *common.NetAddress) (bool, error) {
+	set.lock.Lock()
+	defer set.lock.Unlock()
+	if _, ok := set.peers[addr.ToString()]; ok {
+		return false, nil
+	}
+	set.peers[addr.ToString()] = addr
+	return true, set.save()
+}
+
+// remove a persistent peer and save the change, return false if it's not persistent.
+func (set *persistentPeers) remove(addr *common.NetAddress) (bool, error) {
+	set.lock.Lock()
+	defer set.lock.Unlock()
+	if _, ok := set.peers[addr.ToString()]; !ok {
+		return false, nil
+	}
+	delete(set.peers, addr.ToString())
+	return true, set.save()
+}
+
+// check whether the address is a persistent peer
+func (set *persistentPeers) contains(addr *common.NetAddress) bool {
+	set.lock.RLock()
+	defer set.lock.RUnlock()
+	_, ok := set.peers[addr.ToString()]
+	return ok
+}
+
+// get all persistent peers, sorted by address
+func (set *persistentPeers) list() []*common.NetAddress {
+	set.lock.RLock()
+	defer set.lock.RUnlock()
+	addrs := make([]*common.NetAddress, 0, len(set.peers))
+	for _, addr := range set.peers {
+		addrs = append(addrs, addr)
+	}
+	sort.Slice(addrs, func(i, j int) bool {
+		return addrs[i].ToString() < addrs[j].ToString()
+	})
+	return addrs
+}
@@ -0,0 +1,102 @@
+package p2p
+
+import (
+	"errors"
+	"github.com/DSiSc/p2p/common"
+	"github.com/stretchr/testify/assert"
+	"io/ioutil"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestPersistentPeersFilePath(t *testing.T) {
+	assert := assert.New(t)
+	assert.Equal("", persistentPeersFilePath(""))
+	assert.Equal(filepath.Join("data", persistentPeersFileName), persistentPeersFilePath(filepath.Join("data", "address.json")))
+}
+
+func TestPersistentPeers(t *testing.T) {
+	assert := assert.New(t)
+	dir, err := ioutil.TempDir("", "persistent")
+	assert.Nil(err)
+	defer os.RemoveAll(dir)
+	filePath := filepath.Join(dir, persistentPeersFileName)
+
+	addr1 := common.NewNetAddress("tcp", "192.168.1.1", 8080)
+	addr2 := common.NewNetAddress("tcp", "192.168.1.2", 8080)
+	addr3 := common.NewNetAddress("tcp", "192.168.1.3", 8080)
+	set := newPersistentPeers(filePath, "192.168.1.1:8080, tcp://192.168.1.2:8080,invalid")
+	assert.Equal([]*common.NetAddress{addr1, addr2}, set.list())
+	assert.True(set.contains(addr1))
+	assert.False(set.contains(addr3))
+
+	added, err := set.add(addr3)
+	assert.True(added)
+	assert.Nil(err)
+	added, _ = set.add(addr3)
+	assert.False(added)
+	removed, err := set.remove(addr1)
+	assert.True(removed)
+	assert.Nil(err)
+	removed, _ = set.remove(addr1)
+	assert.False(removed)
+	assert.Equal([]*common.NetAddress{addr2, addr3}, set.list())
+
+	// the changes are applied To config on restart
+	buf, err := ioutil.ReadFile(filePath)
+	assert.Nil(err)
+	assert.Equal(`{"added":["tcp://192.168.1.3:8080"],"removed":["tcp://192.168.1.1:8080"]}`, string(buf))
+	set = newPersistentPeers(filePath, "192.168.1.1:8080,192.168.1.2:8080,192.168.1.4:8080")
+	assert.Equal([]*common.NetAddress{addr2, addr3, common.NewNetAddress("tcp", "192.168.1.4", 8080)}, set.list())
+
+	// not persisted
+	set = newPersistentPeers("", "192.168.1.1:8080")
+	added, err = set.add(addr2)
+	assert.True(added)
+	assert.Nil(err)
+}
+
+// accept a connection in background
+func acceptConn(listener net.Listener) <-chan net.Conn {
+	conns := make(chan net.Conn, 1)
+	go func() {
+		if conn, err := listener.Accept(); err == nil {
+			conns <- conn
+		}
+	}()
+	return conns
+}
+
+func TestP2P_ReconnectPersistentHost(t *testing.T) {
+	assert := assert.New(t)
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	assert.Nil(err)
+	defer listener.Close()
+	port := int32(listener.Addr().(*net.TCPAddr).Port)
+
+	p2p, err := NewP2P(mockConfig(), nil)
+	assert.Nil(err)
+	p2p.addrManager.backoff = newBackoff(time.Millisecond, 10*time.Millisecond, 0)
+	p2p.isRunning = 1
+	defer close(p2p.quitChan)
+	host := common.NewNetAddress("tcp", "localhost", port)
+	_, err = p2p.persistent.add(host)
+	assert.Nil(err)
+
+	addr := common.NewNetAddress("tcp", "127.0.0.1", port)
+	peer := NewOutboundPeer(&p2p.PeerCom, addr, true, p2p.internalChan)
+	peer.host = host
+	assert.Nil(p2p.peers.add(addr, peer))
+	p2p.stopPeer(addr, errors.New("connection reset"))
+
+	// the host name is resolved and dialed again
+	select {
+	case conn := <-acceptConn(listener):
+		conn.Close()
+	case <-time.After(5 * time.Second):
+		assert.Fail("persistent host is not reconnected")
+	}
+}
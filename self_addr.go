@@ -0,0 +1,159 @@
+package p2p
+
+import (
+	"github.com/DSiSc/craft/log"
+	"github.com/DSiSc/p2p/common"
+	"github.com/DSiSc/p2p/message"
+	"net"
+	"sort"
+	"sync"
+	"time"
+)
+
+const (
+	minExternalAddrVotes      = 2                // min num of peers observed the same ip before we trust it
+	maxExternalAddrCandidates = 32               // max num of observed ips we keep
+	selfAdvertiseInterval     = 30 * time.Minute // interval of advertising our address To neighbors
+)
+
+// externalAddrTally tally our ips observed by remote peers To infer our external address. Each network group
+// of the observers has only one vote for an ip, so a few peers can't mislead us.
+type externalAddrTally struct {
+	votes map[string]map[string]struct{} // observed ip -> network groups of the observers
+	lock  sync.Mutex
+}
+
+// newExternalAddrTally create an empty tally
+func newExternalAddrTally() *externalAddrTally {
+	return &externalAddrTally{
+		votes: make(map[string]map[string]struct{}),
+	}
+}
+
+// vote record the ip observed by the observer
+func (tally *externalAddrTally) vote(ip string, observer *common.NetAddress) {
+	tally.lock.Lock()
+	defer tally.lock.Unlock()
+	if _, ok := tally.votes[ip]; !ok {
+		if len(tally.votes) >= maxExternalAddrCandidates {
+			tally.evict()
+		}
+		tally.votes[ip] = make(map[string]struct{})
+	}
+	tally.votes[ip][observer.GroupKey()] = struct{}{}
+}
+
+// remove the candidate with least votes
+func (tally *externalAddrTally) evict() {
+	least, leastVotes := "", 0
+	for ip, observers := range tally.votes {
+		if least == "" || len(observers) < leastVotes {
+			least, leastVotes = ip, len(observers)
+		}
+	}
+	delete(tally.votes, least)
+}
+
+// best get the ip observed by the most peers, return empty string if no ip have enough votes.
+func (tally *externalAddrTally) best() string {
+	tally.lock.Lock()
+	defer tally.lock.Unlock()
+	ips := make([]string, 0, len(tally.votes))
+	for ip, observers := range tally.votes {
+		if len(observers) >= minExternalAddrVotes {
+			ips = append(ips, ip)
+		}
+	}
+	if len(ips) == 0 {
+		return ""
+	}
+	sort.Slice(ips, func(i, j int) bool {
+		if len(tally.votes[ips[i]]) != len(tally.votes[ips[j]]) {
+			return len(tally.votes[ips[i]]) > len(tally.votes[ips[j]])
+		}
+		return ips[i] < ips[j]
+	})
+	return ips[0]
+}
+
+// parse the ip in the observed address, return nil if it can't be our external ip. Only the routable ip is
+// accepted, as the private ips observed by LAN peers are junk To public peers.
+func parseObservedIP(observedAddr string) net.IP {
+	host, _, err := net.SplitHostPort(observedAddr)
+	if err != nil {
+		host = observedAddr
+	}
+	ip := net.ParseIP(host)
+	if ip == nil || !common.NewNetAddress("tcp", ip.String(), 1).IsRoutable() {
+		return nil
+	}
+	return ip
+}
+
+// record our address observed by the peer, and update our external address if the tally changed.
+func (service *P2P) recordObservedAddr(peer *Peer) {
+	ip := parseObservedIP(peer.ObservedAddr())
+	if ip == nil {
+		return
+	}
+	service.externalTally.vote(ip.String(), peer.GetAddr())
+	best := service.externalTally.best()
+	if best == "" {
+		return
+	}
+	old := service.ExternalAddress()
+	if old != nil && old.IP == best {
+		return
+	}
+	external := common.NewNetAddress(service.addr.Protocol, best, service.addr.Port)
+	log.Info("our external address is %s", external.ToString())
+	service.externalAddr.Store(external)
+	service.addrManager.AddOurAddress(external)
+	if old != nil {
+		service.addrManager.RemoveOurAddress(old)
+	}
+	service.lock.RLock()
+	if service.discovery != nil {
+		service.discovery.SetExternalIP(external.ParsedIP())
+	}
+	service.lock.RUnlock()
+}
+
+// ExternalAddress get our external address inferred From peers' observations, return nil if unknown.
+func (service *P2P) ExternalAddress() *common.NetAddress {
+	if external, ok := service.externalAddr.Load().(*common.NetAddress); ok {
+		return external
+	}
+	return nil
+}
+
+// advertise our external address To the peer
+func (service *P2P) advertiseSelf(peer *Peer) {
+	external := service.ExternalAddress()
+	if external == nil {
+		return
+	}
+	taddr := message.NewTimedAddress(external, time.Now())
+	svc := service.service
+	taddr.Services = &svc
+	addrMsg := &message.Addr{
+		NetAddresses: []*message.TimedAddress{taddr},
+	}
+	service.sendMsgAsync(peer, addrMsg)
+}
+
+// advertise our address To neighbors periodically, so the new nodes can discover us.
+func (service *P2P) selfAdvertiseHandler() {
+	ticker := time.NewTicker(selfAdvertiseInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			for _, peer := range service.GetPeers() {
+				service.advertiseSelf(peer)
+			}
+		case <-service.quitChan:
+			return
+		}
+	}
+}
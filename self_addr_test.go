@@ -0,0 +1,89 @@
+package p2p
+
+import (
+	"github.com/DSiSc/p2p/common"
+	"github.com/stretchr/testify/assert"
+	"strconv"
+	"testing"
+)
+
+func TestExternalAddrTally(t *testing.T) {
+	assert := assert.New(t)
+	tally := newExternalAddrTally()
+	assert.Equal("", tally.best())
+
+	// observers in the same network group have only one vote
+	tally.vote("1.2.3.4", common.NewNetAddress("tcp", "10.0.0.1", 8080))
+	tally.vote("1.2.3.4", common.NewNetAddress("tcp", "10.0.0.2", 8080))
+	assert.Equal("", tally.best())
+
+	tally.vote("1.2.3.4", common.NewNetAddress("tcp", "20.0.0.1", 8080))
+	assert.Equal("1.2.3.4", tally.best())
+
+	tally.vote("5.6.7.8", common.NewNetAddress("tcp", "30.0.0.1", 8080))
+	tally.vote("5.6.7.8", common.NewNetAddress("tcp", "40.0.0.1", 8080))
+	tally.vote("5.6.7.8", common.NewNetAddress("tcp", "50.0.0.1", 8080))
+	assert.Equal("5.6.7.8", tally.best())
+}
+
+func TestExternalAddrTally_Evict(t *testing.T) {
+	assert := assert.New(t)
+	tally := newExternalAddrTally()
+	tally.vote("1.2.3.4", common.NewNetAddress("tcp", "10.0.0.1", 8080))
+	tally.vote("1.2.3.4", common.NewNetAddress("tcp", "20.0.0.1", 8080))
+	for i := 0; i < maxExternalAddrCandidates; i++ {
+		tally.vote("9.9.9."+strconv.Itoa(i), common.NewNetAddress("tcp", "10.0.0.1", 8080))
+	}
+	assert.Equal(maxExternalAddrCandidates, len(tally.votes))
+	assert.Equal("1.2.3.4", tally.best())
+}
+
+func TestParseObservedIP(t *testing.T) {
+	assert := assert.New(t)
+	assert.Equal("1.2.3.4", parseObservedIP("1.2.3.4:50001").String())
+	assert.Equal("1.2.3.4", parseObservedIP("1.2.3.4").String())
+	assert.Equal("2001:4860::8888", parseObservedIP("[2001:4860::8888]:50001").String())
+	assert.Nil(parseObservedIP("[2001:db8::1]:50001"))
+	assert.Nil(parseObservedIP(""))
+	assert.Nil(parseObservedIP("127.0.0.1:50001"))
+	assert.Nil(parseObservedIP("0.0.0.0:50001"))
+	assert.Nil(parseObservedIP("192.168.1.1:50001"))
+	assert.Nil(parseObservedIP("100.64.1.1:50001"))
+	assert.Nil(parseObservedIP("[fe80::1]:50001"))
+}
+
+func TestP2P_RecordObservedAddr(t *testing.T) {
+	assert := assert.New(t)
+	p2p, err := NewP2P(mockConfig(), nil)
+	assert.Nil(err)
+	assert.Nil(p2p.ExternalAddress())
+
+	for _, ip := range []string{"10.0.0.1", "20.0.0.1"} {
+		peer := NewOutboundPeer(mockServerInfo(), common.NewNetAddress("tcp", ip, 8080), false, make(chan *InternalMsg))
+		peer.observedAddr.Store("1.2.3.4:50001")
+		p2p.recordObservedAddr(peer)
+	}
+	external := p2p.ExternalAddress()
+	assert.NotNil(external)
+	assert.Equal("1.2.3.4", external.IP)
+	assert.Equal(p2p.addr.Port, external.Port)
+	assert.True(p2p.addrManager.IsOurAddress(external))
+
+	// the private ip observed by LAN peers is ignored
+	for _, ip := range []string{"30.0.0.1", "40.0.0.1", "50.0.0.1"} {
+		peer := NewOutboundPeer(mockServerInfo(), common.NewNetAddress("tcp", ip, 8080), false, make(chan *InternalMsg))
+		peer.observedAddr.Store("192.168.1.100:50001")
+		p2p.recordObservedAddr(peer)
+	}
+	assert.Equal("1.2.3.4", p2p.ExternalAddress().IP)
+
+	// the outdated external address is removed
+	for _, ip := range []string{"60.0.0.1", "70.0.0.1", "80.0.0.1"} {
+		peer := NewOutboundPeer(mockServerInfo(), common.NewNetAddress("tcp", ip, 8080), false, make(chan *InternalMsg))
+		peer.observedAddr.Store("5.6.7.8:50001")
+		p2p.recordObservedAddr(peer)
+	}
+	assert.Equal("5.6.7.8", p2p.ExternalAddress().IP)
+	assert.True(p2p.addrManager.IsOurAddress(p2p.ExternalAddress()))
+	assert.False(p2p.addrManager.IsOurAddress(external))
+}
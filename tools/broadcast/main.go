@@ -15,6 +15,7 @@ import (
 	"github.com/DSiSc/repository"
 	"github.com/DSiSc/repository/config"
 	"math/rand"
+	"net"
 	"os"
 	"os/signal"
 	"strconv"
@@ -35,8 +36,9 @@ func main() {
 	}
 	repository.InitRepository(chainConf, &tools.P2PTestEventCenter{})
 	var addrBookPath, listenAddress, persistentPeers, localAddrStr, displayServer, dnsSeeds string
+	var discoveryAddr, bootnodes, adminAddr, adminToken string
 	var maxConnOutBound, maxConnInBound int
-	var traceMaster, disableDNSSeed, seedMode bool
+	var traceMaster, disableDNSSeed, seedMode, dnsBootstrap, lanDiscovery bool
 	flagSet := flag.NewFlagSet("broadcast", flag.ExitOnError)
 	flagSet.StringVar(&addrBookPath, "path", "./address_book.json", "Address book file path")
 	flagSet.StringVar(&listenAddress, "listen", "tcp://0.0.0.0:8888", "Listen address")
@@ -49,6 +51,12 @@ func main() {
 	flagSet.BoolVar(&disableDNSSeed, "disable_dns", true, "disable DNS seeding for peers(default true)")
 	flagSet.BoolVar(&seedMode, "seed_mode", false, "whether run as dns seed(default false)")
 	flagSet.StringVar(&dnsSeeds, "dns_seeds", "", "list of DNS seeds ")
+	flagSet.BoolVar(&dnsBootstrap, "dns_bootstrap", false, "resolve DNS seeds as DNS names To get peer addresses(default false)")
+	flagSet.StringVar(&discoveryAddr, "discovery", "", "udp listen address of node discovery, empty means disabled")
+	flagSet.StringVar(&bootnodes, "bootnodes", "", "boot nodes of node discovery")
+	flagSet.BoolVar(&lanDiscovery, "mdns", false, "discover the peers in LAN with mDNS(default false)")
+	flagSet.StringVar(&adminAddr, "admin", "", "listen address of admin server, empty means disabled")
+	flagSet.StringVar(&adminToken, "admin_token", "", "bearer token of admin server, required if admin server is not on loopback")
 
 	flagSet.Usage = func() {
 		fmt.Println(`Justitia blockchain p2p test tool.
@@ -76,6 +84,12 @@ Examples:
 		SeedMode:         seedMode,
 		DisableDNSSeed:   disableDNSSeed,
 		DNSSeeds:         dnsSeeds,
+		DNSBootstrap:     dnsBootstrap,
+		DiscoveryAddr:    discoveryAddr,
+		Bootnodes:        bootnodes,
+		MDNS:             lanDiscovery,
+		AdminAddr:        adminAddr,
+		AdminToken:       adminToken,
 		Service:          p2pconf.SFNodeBroadCastTest,
 	}
 
@@ -85,7 +99,7 @@ Examples:
 		fmt.Printf("invalid listen address, as: %v", err)
 		os.Exit(1)
 	}
-	localAddr, err := common.ParseNetAddress(localAddrStr + ":" + strconv.Itoa(int(listenAddr.Port)))
+	localAddr, err := common.ParseNetAddress(net.JoinHostPort(localAddrStr, strconv.Itoa(int(listenAddr.Port))))
 	if err != nil {
 		fmt.Printf("invalid local_addr, as: %v", err)
 		os.Exit(1)
@@ -8,12 +8,14 @@ import (
 	"github.com/DSiSc/p2p/config"
 	"github.com/spf13/viper"
 	"math"
+	"net"
 	"os"
 	"os/exec"
 	"os/user"
 	"path/filepath"
 	"runtime"
 	"strings"
+	"time"
 )
 
 const (
@@ -25,6 +27,16 @@ const (
 	MaxConnOutBound  = "general.maxConnOutBound"
 	MaxConnInBound   = "general.maxConnInBound"
 	Service          = "general.Service"
+	Crawl            = "general.crawl"
+	CrawlInterval    = "general.crawlInterval"
+
+	// DNS server setting
+	DNSEnabled         = "dns.enabled"
+	DNSListenAddr      = "dns.listenAddr"
+	DNSZone            = "dns.zone"
+	DNSNameServer      = "dns.nameServer"
+	DNSNameServerAddrs = "dns.nameServerAddrs"
+	DNSTTL             = "dns.ttl"
 
 	// Log Setting
 	LogTimeFieldFormat = "logging.timeFieldFormat"
@@ -55,7 +67,19 @@ type NodeConfig struct {
 	MaxConnOutBound  int                // max connection out bound
 	MaxConnInBound   int                // max connection in bound
 	Service          config.ServiceFlag // service tag
+	Crawl            bool               // whether crawl the known addresses
+	CrawlInterval    time.Duration      // interval of crawling an address
 	Logger           log.Config         // log setting
+	DNS              DNSConfig          // dns server setting
+}
+
+type DNSConfig struct {
+	Enabled    bool     // whether serve the addresses over DNS
+	ListenAddr string   // dns server listen address, both UDP and TCP
+	Zone       string   // the zone served by dns server
+	NameServer string   // name server of the zone
+	NSAddrs    []net.IP // addresses of the name server
+	TTL        uint32   // ttl of the answers in seconds
 }
 
 type Config struct {
@@ -101,8 +125,33 @@ func NewNodeConfig() NodeConfig {
 		MaxConnOutBound:  maxConnOutBound,
 		MaxConnInBound:   maxConnInBound,
 		Service:          config.ServiceFlag(service),
+		Crawl:            vp.GetBool(Crawl),
+		CrawlInterval:    vp.GetDuration(CrawlInterval),
 		Logger:           logConf,
+		DNS:              GetDNSSetting(vp),
+	}
+}
+
+func GetDNSSetting(vp *viper.Viper) DNSConfig {
+	return DNSConfig{
+		Enabled:    vp.GetBool(DNSEnabled),
+		ListenAddr: vp.GetString(DNSListenAddr),
+		Zone:       vp.GetString(DNSZone),
+		NameServer: vp.GetString(DNSNameServer),
+		NSAddrs:    parseIPs(vp.GetStringSlice(DNSNameServerAddrs)),
+		TTL:        uint32(vp.GetInt(DNSTTL)),
+	}
+}
+
+// parse the ip addresses, invalid ones are ignored.
+func parseIPs(addrs []string) []net.IP {
+	ips := make([]net.IP, 0, len(addrs))
+	for _, addr := range addrs {
+		if ip := net.ParseIP(strings.TrimSpace(addr)); ip != nil {
+			ips = append(ips, ip)
+		}
 	}
+	return ips
 }
 
 func GetLogSetting(vp *viper.Viper) log.Config {
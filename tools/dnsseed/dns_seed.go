@@ -10,6 +10,7 @@ import (
 	"github.com/DSiSc/p2p"
 	"github.com/DSiSc/p2p/common"
 	p2pconf "github.com/DSiSc/p2p/config"
+	"github.com/DSiSc/p2p/dnsserver"
 	"github.com/DSiSc/p2p/message"
 	"github.com/DSiSc/p2p/tools"
 	"math/rand"
@@ -55,6 +56,8 @@ func main() {
 		MaxConnOutBound:  node.MaxConnOutBound,
 		MaxConnInBound:   node.MaxConnInBound,
 		SeedMode:         true,
+		Crawl:            node.Crawl,
+		CrawlInterval:    node.CrawlInterval,
 	}
 
 	// create p2p
@@ -70,6 +73,26 @@ func main() {
 		fmt.Printf("failed to start p2p node, as: %v", err)
 		os.Exit(1)
 	}
+
+	// serve the addresses over DNS
+	if node.DNS.Enabled {
+		dnsConf := dnsserver.Config{
+			Zone:    node.DNS.Zone,
+			NS:      node.DNS.NameServer,
+			NSAddrs: node.DNS.NSAddrs,
+			TTL:     node.DNS.TTL,
+		}
+		dnsServer, err := dnsserver.NewServer(dnsConf, p2p.SeedIPs)
+		if err != nil {
+			fmt.Printf("failed to create dns server, as: %v", err)
+			os.Exit(1)
+		}
+		err = dnsServer.Start(node.DNS.ListenAddr)
+		if err != nil {
+			fmt.Printf("failed to start dns server, as: %v", err)
+			os.Exit(1)
+		}
+	}
 	// catch system exit signal
 	sysSignalProcess()
 }
@@ -0,0 +1,178 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/DSiSc/p2p"
+	"github.com/DSiSc/p2p/tools/common"
+	"io"
+	"net/http"
+	"net/url"
+	"time"
+)
+
+const requestTimeout = 30 * time.Second
+
+// AdminClient is the client of a node's admin server
+type AdminClient struct {
+	baseURL string
+	token   string
+	client  *http.Client
+}
+
+// NewAdminClient create an admin client of the server "host:port" or "http://host:port"
+func NewAdminClient(server, token string) *AdminClient {
+	if u, err := url.Parse(server); err != nil || u.Scheme == "" || u.Host == "" {
+		server = "http://" + server
+	}
+	return &AdminClient{
+		baseURL: server,
+		token:   token,
+		client:  &http.Client{},
+	}
+}
+
+// Node get the node info
+func (c *AdminClient) Node() (*p2p.NodeInfo, error) {
+	info := &p2p.NodeInfo{}
+	return info, c.do("GET", "/node", nil, nil, info)
+}
+
+// Peers get the peer list
+func (c *AdminClient) Peers() ([]*p2p.PeerInfo, error) {
+	peers := make([]*p2p.PeerInfo, 0)
+	return peers, c.do("GET", "/peers", nil, nil, &peers)
+}
+
+// Connect connect To the peer once, or add it as persistent peer
+func (c *AdminClient) Connect(addr string, persistent bool) error {
+	body := map[string]interface{}{"addr": addr, "persistent": persistent}
+	return c.do("POST", "/peers", nil, body, nil)
+}
+
+// Disconnect disconnect the peer
+func (c *AdminClient) Disconnect(addr string) error {
+	return c.do("DELETE", "/peers", url.Values{"addr": {addr}}, nil, nil)
+}
+
+// PersistentPeers get the persistent peers
+func (c *AdminClient) PersistentPeers() ([]string, error) {
+	addrs := make([]string, 0)
+	return addrs, c.do("GET", "/persistent", nil, nil, &addrs)
+}
+
+// RemovePersistentPeer remove the persistent peer
+func (c *AdminClient) RemovePersistentPeer(addr string) error {
+	return c.do("DELETE", "/persistent", url.Values{"addr": {addr}}, nil, nil)
+}
+
+// Addresses query the address book
+func (c *AdminClient) Addresses(query url.Values) ([]*p2p.AddressInfo, error) {
+	infos := make([]*p2p.AddressInfo, 0)
+	return infos, c.do("GET", "/addresses", query, nil, &infos)
+}
+
+// Bans get the ban list
+func (c *AdminClient) Bans() ([]*p2p.BanInfo, error) {
+	bans := make([]*p2p.BanInfo, 0)
+	return bans, c.do("GET", "/bans", nil, nil, &bans)
+}
+
+// Ban ban the ip for the duration, the server default is used if duration is empty.
+func (c *AdminClient) Ban(ip, duration, reason string) error {
+	body := map[string]string{"addr": ip, "duration": duration, "reason": reason}
+	return c.do("POST", "/bans", nil, body, nil)
+}
+
+// Unban lift the ban of the ip
+func (c *AdminClient) Unban(ip string) error {
+	return c.do("DELETE", "/bans", url.Values{"addr": {ip}}, nil, nil)
+}
+
+// Traffic stream the live messages To handler until the stream ends or handler returns false.
+func (c *AdminClient) Traffic(query url.Values, handler func(record *p2p.TrafficRecord) bool) error {
+	req, err := c.newRequest("GET", "/traffic", query, nil)
+	if err != nil {
+		return err
+	}
+	resp, err := c.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return responseError(resp)
+	}
+	scanner := bufio.NewScanner(resp.Body)
+	for scanner.Scan() {
+		record := &p2p.TrafficRecord{}
+		if err := json.Unmarshal(scanner.Bytes(), record); err != nil {
+			return err
+		}
+		if !handler(record) {
+			return nil
+		}
+	}
+	return scanner.Err()
+}
+
+// create a request To the server
+func (c *AdminClient) newRequest(method, path string, query url.Values, body interface{}) (*http.Request, error) {
+	var reader io.Reader
+	if body != nil {
+		buf, err := json.Marshal(body)
+		if err != nil {
+			return nil, err
+		}
+		reader = bytes.NewReader(buf)
+	}
+	u := c.baseURL + path
+	if len(query) > 0 {
+		u += "?" + query.Encode()
+	}
+	req, err := http.NewRequest(method, u, reader)
+	if err != nil {
+		return nil, err
+	}
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	if c.token != "" {
+		req.Header.Set("Authorization", "Bearer "+c.token)
+	}
+	return req, nil
+}
+
+// send the request and decode the response into result if it's not nil
+func (c *AdminClient) do(method, path string, query url.Values, body, result interface{}) error {
+	req, err := c.newRequest(method, path, query, body)
+	if err != nil {
+		return err
+	}
+	client := *c.client
+	client.Timeout = requestTimeout
+	resp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return responseError(resp)
+	}
+	if result == nil {
+		return nil
+	}
+	return json.NewDecoder(resp.Body).Decode(result)
+}
+
+// get the error in response
+func responseError(resp *http.Response) error {
+	errResp := &common.ErrorResponse{}
+	if err := json.NewDecoder(resp.Body).Decode(errResp); err != nil || errResp.Error == "" {
+		return fmt.Errorf("admin server responded %s", resp.Status)
+	}
+	return errors.New(errResp.Error)
+}
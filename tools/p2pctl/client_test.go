@@ -0,0 +1,140 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"github.com/DSiSc/p2p"
+	"github.com/DSiSc/p2p/tools/common"
+	"github.com/stretchr/testify/assert"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestNewAdminClient(t *testing.T) {
+	assert := assert.New(t)
+	assert.Equal("http://127.0.0.1:8081", NewAdminClient("127.0.0.1:8081", "").baseURL)
+	assert.Equal("https://127.0.0.1:8081", NewAdminClient("https://127.0.0.1:8081", "").baseURL)
+}
+
+func TestAdminClient(t *testing.T) {
+	assert := assert.New(t)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Authorization") != "Bearer secret" {
+			w.WriteHeader(http.StatusUnauthorized)
+			json.NewEncoder(w).Encode(&common.ErrorResponse{Error: "invalid admin token"})
+			return
+		}
+		switch r.URL.Path {
+		case "/peers":
+			if r.Method == "DELETE" {
+				w.WriteHeader(http.StatusBadRequest)
+				json.NewEncoder(w).Encode(&common.ErrorResponse{Error: "peer " + r.URL.Query().Get("addr") + " is not in our neighbor list"})
+				return
+			}
+			json.NewEncoder(w).Encode([]*p2p.PeerInfo{{Addr: "tcp://192.168.1.1:8080", OutBound: true}})
+		case "/traffic":
+			json.NewEncoder(w).Encode(&p2p.TrafficRecord{Peer: "tcp://192.168.1.1:8080", TypeName: "ping"})
+			json.NewEncoder(w).Encode(&p2p.TrafficRecord{Peer: "tcp://192.168.1.1:8080", TypeName: "pong"})
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	defer server.Close()
+
+	_, err := NewAdminClient(server.URL, "").Peers()
+	assert.EqualError(err, "invalid admin token")
+
+	client := NewAdminClient(server.URL, "secret")
+	peers, err := client.Peers()
+	assert.Nil(err)
+	assert.Equal(1, len(peers))
+	assert.True(peers[0].OutBound)
+	assert.EqualError(client.Disconnect("tcp://192.168.1.2:8080"), "peer tcp://192.168.1.2:8080 is not in our neighbor list")
+	_, err = client.Bans()
+	assert.EqualError(err, "admin server responded 404 Not Found")
+
+	names := make([]string, 0)
+	err = client.Traffic(url.Values{}, func(record *p2p.TrafficRecord) bool {
+		names = append(names, record.TypeName)
+		return true
+	})
+	assert.Nil(err)
+	assert.Equal([]string{"ping", "pong"}, names)
+}
+
+func TestPrinter(t *testing.T) {
+	assert := assert.New(t)
+	_, err := NewPrinter(&bytes.Buffer{}, "yaml")
+	assert.NotNil(err)
+
+	buf := &bytes.Buffer{}
+	printer, err := NewPrinter(buf, formatTable)
+	assert.Nil(err)
+	assert.Nil(printer.PrintPersistentPeers([]string{"tcp://192.168.1.1:8080"}))
+	lines := strings.Split(strings.TrimSpace(buf.String()), "\n")
+	assert.Equal([]string{"ADDR", "tcp://192.168.1.1:8080"}, lines)
+
+	buf.Reset()
+	printer, err = NewPrinter(buf, formatJSON)
+	assert.Nil(err)
+	assert.Nil(printer.PrintPersistentPeers([]string{"tcp://192.168.1.1:8080"}))
+	addrs := make([]string, 0)
+	assert.Nil(json.Unmarshal(buf.Bytes(), &addrs))
+	assert.Equal([]string{"tcp://192.168.1.1:8080"}, addrs)
+}
+
+func TestRun_Peer(t *testing.T) {
+	assert := assert.New(t)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode([]*p2p.PeerInfo{{Addr: "tcp://192.168.1.1:8080"}, {Addr: "tcp://[::1]:8080"}})
+	}))
+	defer server.Close()
+	client := NewAdminClient(server.URL, "")
+	buf := &bytes.Buffer{}
+	printer, err := NewPrinter(buf, formatJSON)
+	assert.Nil(err)
+
+	// the address is compared in canonical form
+	assert.Nil(run(client, printer, "peer", []string{"192.168.1.1:8080"}))
+	assert.Contains(buf.String(), "tcp://192.168.1.1:8080")
+	buf.Reset()
+	assert.Nil(run(client, printer, "peer", []string{"tcp://[0:0:0:0:0:0:0:1]:8080"}))
+	assert.Contains(buf.String(), "tcp://[::1]:8080")
+	assert.EqualError(run(client, printer, "peer", []string{"tcp://192.168.1.2:8080"}), "peer tcp://192.168.1.2:8080 not found")
+	assert.NotNil(run(client, printer, "peer", []string{"192.168.1.1"}))
+}
+
+func TestPrinter_PrintPeer(t *testing.T) {
+	assert := assert.New(t)
+	buf := &bytes.Buffer{}
+	printer, err := NewPrinter(buf, formatTable)
+	assert.Nil(err)
+	peer := &p2p.PeerInfo{
+		Addr:      "tcp://192.168.1.1:8080",
+		PingRTT:   1500 * time.Microsecond,
+		MsgsSent:  1,
+		BytesSent: 20,
+		MsgsRecv:  3,
+		BytesRecv: 2048,
+		Sent:      map[string]*p2p.MessageStats{"ping": {Messages: 1, Bytes: 20}},
+		Recv:      map[string]*p2p.MessageStats{"tx": {Messages: 3, Bytes: 2048}},
+	}
+	assert.Nil(printer.PrintPeer(peer))
+	output := buf.String()
+	assert.Contains(output, "1.5ms")
+	assert.Regexp(`ping\s+1\s+20B\s+0\s+0B`, output)
+	assert.Regexp(`tx\s+0\s+0B\s+3\s+2.0KB`, output)
+	assert.Regexp(`total\s+1\s+20B\s+3\s+2.0KB`, output)
+}
+
+func TestByteSize(t *testing.T) {
+	assert := assert.New(t)
+	assert.Equal("0B", byteSize(0))
+	assert.Equal("1023B", byteSize(1023))
+	assert.Equal("1.5KB", byteSize(1536))
+	assert.Equal("3.0MB", byteSize(3*1024*1024))
+}
@@ -0,0 +1,207 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"github.com/DSiSc/p2p"
+	"github.com/DSiSc/p2p/common"
+	"net/url"
+	"os"
+	"strconv"
+)
+
+const usage = `Justitia blockchain p2p control tool, talking to the admin server of a running node.
+
+Usage:
+	p2pctl [-server 127.0.0.1:8081] [-token TOKEN] [-o table|json] <command> [arguments]
+
+Commands:
+	node                                                 show node info
+	peers                                                list peers with their statistics
+	peer <addr>                                          show the details of a peer
+	connect [-persistent] <addr>                         connect to a peer once, or add it as persistent peer
+	disconnect <addr>                                    disconnect a peer
+	persistent                                           list persistent peers
+	unpersist <addr>                                     remove a persistent peer
+	addrs [-tried true|false] [-service N] [-limit N]    show address book entries
+	bans                                                 list bans
+	ban [-duration 24h] [-reason REASON] <ip>            ban an ip
+	unban <ip>                                           lift a ban
+	traffic [-peer addr] [-type name] [-n N]             dump live message traffic
+
+Examples:
+	p2pctl peers
+	p2pctl peer tcp://192.168.1.100:8888
+	p2pctl -o json addrs -tried true -limit 20
+	p2pctl connect -persistent tcp://192.168.1.100:8888
+	p2pctl traffic -type block`
+
+func main() {
+	var server, token, format string
+	flagSet := flag.NewFlagSet("p2pctl", flag.ExitOnError)
+	flagSet.StringVar(&server, "server", "127.0.0.1:8081", "admin server address of the node")
+	flagSet.StringVar(&token, "token", os.Getenv("P2PCTL_TOKEN"), "admin token, default $P2PCTL_TOKEN")
+	flagSet.StringVar(&format, "o", formatTable, "output format(table|json)")
+	flagSet.Usage = func() {
+		fmt.Println(usage)
+		fmt.Println("Flags:")
+		flagSet.PrintDefaults()
+	}
+	flagSet.Parse(os.Args[1:])
+	if flagSet.NArg() == 0 {
+		flagSet.Usage()
+		os.Exit(2)
+	}
+
+	printer, err := NewPrinter(os.Stdout, format)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(2)
+	}
+	client := NewAdminClient(server, token)
+	if err := run(client, printer, flagSet.Arg(0), flagSet.Args()[1:]); err != nil {
+		fmt.Fprintf(os.Stderr, "p2pctl %s: %v\n", flagSet.Arg(0), err)
+		os.Exit(1)
+	}
+}
+
+// run the command
+func run(client *AdminClient, printer *Printer, command string, args []string) error {
+	flagSet := flag.NewFlagSet(command, flag.ExitOnError)
+	switch command {
+	case "node":
+		info, err := client.Node()
+		if err != nil {
+			return err
+		}
+		return printer.PrintNode(info)
+	case "peers":
+		peers, err := client.Peers()
+		if err != nil {
+			return err
+		}
+		return printer.PrintPeers(peers)
+	case "peer":
+		addr, err := parseOneArg(flagSet, args, "addr")
+		if err != nil {
+			return err
+		}
+		// the admin server reports the canonical form of address
+		netAddr, err := common.ParseNetAddress(addr)
+		if err != nil {
+			return err
+		}
+		peers, err := client.Peers()
+		if err != nil {
+			return err
+		}
+		for _, peer := range peers {
+			if peer.Addr == netAddr.ToString() {
+				return printer.PrintPeer(peer)
+			}
+		}
+		return fmt.Errorf("peer %s not found", addr)
+	case "connect":
+		persistent := flagSet.Bool("persistent", false, "add the peer as persistent peer")
+		addr, err := parseOneArg(flagSet, args, "addr")
+		if err != nil {
+			return err
+		}
+		return client.Connect(addr, *persistent)
+	case "disconnect":
+		addr, err := parseOneArg(flagSet, args, "addr")
+		if err != nil {
+			return err
+		}
+		return client.Disconnect(addr)
+	case "persistent":
+		addrs, err := client.PersistentPeers()
+		if err != nil {
+			return err
+		}
+		return printer.PrintPersistentPeers(addrs)
+	case "unpersist":
+		addr, err := parseOneArg(flagSet, args, "addr")
+		if err != nil {
+			return err
+		}
+		return client.RemovePersistentPeer(addr)
+	case "addrs":
+		tried := flagSet.String("tried", "", "only the tried(true) or untried(false) addresses")
+		service := flagSet.String("service", "", "only the addresses supporting the service")
+		limit := flagSet.Int("limit", 0, "max num of addresses, server default if 0")
+		flagSet.Parse(args)
+		query := url.Values{}
+		if *tried != "" {
+			query.Set("tried", *tried)
+		}
+		if *service != "" {
+			query.Set("service", *service)
+		}
+		if *limit > 0 {
+			query.Set("limit", strconv.Itoa(*limit))
+		}
+		infos, err := client.Addresses(query)
+		if err != nil {
+			return err
+		}
+		return printer.PrintAddresses(infos)
+	case "bans":
+		bans, err := client.Bans()
+		if err != nil {
+			return err
+		}
+		return printer.PrintBans(bans)
+	case "ban":
+		duration := flagSet.String("duration", "", "ban duration, server default(24h) if empty")
+		reason := flagSet.String("reason", "", "ban reason")
+		ip, err := parseOneArg(flagSet, args, "ip")
+		if err != nil {
+			return err
+		}
+		return client.Ban(ip, *duration, *reason)
+	case "unban":
+		ip, err := parseOneArg(flagSet, args, "ip")
+		if err != nil {
+			return err
+		}
+		return client.Unban(ip)
+	case "traffic":
+		peer := flagSet.String("peer", "", "only the messages of the peer")
+		msgType := flagSet.String("type", "", "only the messages of the type, e.g. block, tx, ping")
+		num := flagSet.Int("n", 0, "exit after dumping so many messages, never if 0")
+		flagSet.Parse(args)
+		query := url.Values{}
+		if *peer != "" {
+			query.Set("peer", *peer)
+		}
+		if *msgType != "" {
+			query.Set("type", *msgType)
+		}
+		count := 0
+		var printErr error
+		err := client.Traffic(query, func(record *p2p.TrafficRecord) bool {
+			if printErr = printer.PrintTraffic(record); printErr != nil {
+				return false
+			}
+			count++
+			return *num <= 0 || count < *num
+		})
+		if err != nil {
+			return err
+		}
+		return printErr
+	default:
+		return fmt.Errorf("unknown command, run p2pctl -h for usage")
+	}
+}
+
+// parse the flags and the only positional argument
+func parseOneArg(flagSet *flag.FlagSet, args []string, name string) (string, error) {
+	flagSet.Parse(args)
+	if flagSet.NArg() != 1 {
+		return "", errors.New(name + " is required")
+	}
+	return flagSet.Arg(0), nil
+}
@@ -0,0 +1,267 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/DSiSc/p2p"
+	"io"
+	"sort"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+	"time"
+)
+
+// output formats
+const (
+	formatTable = "table"
+	formatJSON  = "json"
+)
+
+// Printer print the results in table or JSON
+type Printer struct {
+	w      io.Writer
+	format string
+}
+
+// NewPrinter create a printer of the format
+func NewPrinter(w io.Writer, format string) (*Printer, error) {
+	if format != formatTable && format != formatJSON {
+		return nil, fmt.Errorf("unknown output format %s, expected %s or %s", format, formatTable, formatJSON)
+	}
+	return &Printer{w: w, format: format}, nil
+}
+
+// print the value as indented JSON
+func (p *Printer) printJSON(value interface{}) error {
+	encoder := json.NewEncoder(p.w)
+	encoder.SetIndent("", "  ")
+	return encoder.Encode(value)
+}
+
+// print the rows as a table with header
+func (p *Printer) printTable(header []string, rows [][]string) error {
+	tw := tabwriter.NewWriter(p.w, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(tw, strings.Join(header, "\t"))
+	for _, row := range rows {
+		fmt.Fprintln(tw, strings.Join(row, "\t"))
+	}
+	return tw.Flush()
+}
+
+// PrintNode print the node info
+func (p *Printer) PrintNode(info *p2p.NodeInfo) error {
+	if p.format == formatJSON {
+		return p.printJSON(info)
+	}
+	ourAddrs := make([]string, 0, len(info.OurAddrs))
+	for _, addr := range info.OurAddrs {
+		ourAddrs = append(ourAddrs, addr.ToString())
+	}
+	rows := [][]string{
+		{"version", info.Version},
+		{"service", strconv.FormatUint(uint64(info.Service), 10)},
+		{"listen", info.ListenAddr},
+		{"external", orDash(info.ExternalAddr)},
+		{"our addresses", orDash(strings.Join(ourAddrs, ","))},
+		{"node", orDash(info.Node)},
+		{"running", strconv.FormatBool(info.Running)},
+		{"peers", fmt.Sprintf("%d in, %d out", info.InBound, info.OutBound)},
+		{"addresses", strconv.Itoa(info.Addresses)},
+		{"persistent", strconv.Itoa(info.Persistent)},
+		{"banned", strconv.Itoa(info.Banned)},
+	}
+	return p.printTable([]string{"FIELD", "VALUE"}, rows)
+}
+
+// PrintPeers print the peer list
+func (p *Printer) PrintPeers(peers []*p2p.PeerInfo) error {
+	if p.format == formatJSON {
+		return p.printJSON(peers)
+	}
+	rows := make([][]string, 0, len(peers))
+	for _, peer := range peers {
+		rows = append(rows, []string{
+			peer.Addr,
+			peer.Status,
+			direction(peer.OutBound),
+			strconv.FormatBool(peer.Persistent),
+			strconv.FormatUint(uint64(peer.Service), 10),
+			orDash(peer.Version),
+			rtt(peer.PingRTT),
+			fmt.Sprintf("%d/%s", peer.MsgsSent, byteSize(peer.BytesSent)),
+			fmt.Sprintf("%d/%s", peer.MsgsRecv, byteSize(peer.BytesRecv)),
+			strconv.FormatUint(peer.Duplicates, 10),
+			since(peer.ConnectedSince),
+		})
+	}
+	return p.printTable([]string{"ADDR", "STATUS", "DIR", "PERSISTENT", "SERVICE", "VERSION", "RTT", "SENT", "RECV", "DUP", "CONNECTED"}, rows)
+}
+
+// PrintPeer print the details of a peer, including the statistics of each message type
+func (p *Printer) PrintPeer(peer *p2p.PeerInfo) error {
+	if p.format == formatJSON {
+		return p.printJSON(peer)
+	}
+	rows := [][]string{
+		{"addr", peer.Addr},
+		{"status", peer.Status},
+		{"direction", direction(peer.OutBound)},
+		{"persistent", strconv.FormatBool(peer.Persistent)},
+		{"version", orDash(peer.Version)},
+		{"service", strconv.FormatUint(uint64(peer.Service), 10)},
+		{"state", strconv.FormatUint(peer.State, 10)},
+		{"connected", since(peer.ConnectedSince)},
+		{"handshake", peer.HandshakeDuration.String()},
+		{"ping rtt", rtt(peer.PingRTT)},
+		{"last send", since(peer.LastSend)},
+		{"last recv", since(peer.LastRecv)},
+		{"duplicates", strconv.FormatUint(peer.Duplicates, 10)},
+	}
+	if err := p.printTable([]string{"FIELD", "VALUE"}, rows); err != nil {
+		return err
+	}
+
+	types := make([]string, 0)
+	for name := range peer.Sent {
+		types = append(types, name)
+	}
+	for name := range peer.Recv {
+		if _, ok := peer.Sent[name]; !ok {
+			types = append(types, name)
+		}
+	}
+	sort.Strings(types)
+	rows = make([][]string, 0, len(types)+1)
+	for _, name := range types {
+		sent, recv := messageStats(peer.Sent, name), messageStats(peer.Recv, name)
+		rows = append(rows, []string{
+			name,
+			strconv.FormatUint(sent.Messages, 10),
+			byteSize(sent.Bytes),
+			strconv.FormatUint(recv.Messages, 10),
+			byteSize(recv.Bytes),
+		})
+	}
+	rows = append(rows, []string{"total", strconv.FormatUint(peer.MsgsSent, 10), byteSize(peer.BytesSent),
+		strconv.FormatUint(peer.MsgsRecv, 10), byteSize(peer.BytesRecv)})
+	fmt.Fprintln(p.w)
+	return p.printTable([]string{"TYPE", "SENT", "SENT BYTES", "RECV", "RECV BYTES"}, rows)
+}
+
+// PrintPersistentPeers print the persistent peers
+func (p *Printer) PrintPersistentPeers(addrs []string) error {
+	if p.format == formatJSON {
+		return p.printJSON(addrs)
+	}
+	rows := make([][]string, 0, len(addrs))
+	for _, addr := range addrs {
+		rows = append(rows, []string{addr})
+	}
+	return p.printTable([]string{"ADDR"}, rows)
+}
+
+// PrintAddresses print the address book entries
+func (p *Printer) PrintAddresses(infos []*p2p.AddressInfo) error {
+	if p.format == formatJSON {
+		return p.printJSON(infos)
+	}
+	rows := make([][]string, 0, len(infos))
+	for _, info := range infos {
+		services := "-"
+		if info.Services != nil {
+			services = strconv.FormatUint(uint64(*info.Services), 10)
+		}
+		rows = append(rows, []string{
+			info.Addr,
+			strconv.FormatBool(info.Tried),
+			services,
+			strconv.FormatUint(uint64(info.Attempts), 10),
+			since(info.LastSeen),
+			since(info.LastSuccess),
+			orDash(info.Src),
+		})
+	}
+	return p.printTable([]string{"ADDR", "TRIED", "SERVICES", "ATTEMPTS", "LAST SEEN", "LAST SUCCESS", "SOURCE"}, rows)
+}
+
+// PrintBans print the ban list
+func (p *Printer) PrintBans(bans []*p2p.BanInfo) error {
+	if p.format == formatJSON {
+		return p.printJSON(bans)
+	}
+	rows := make([][]string, 0, len(bans))
+	for _, ban := range bans {
+		rows = append(rows, []string{
+			ban.IP,
+			ban.Created.Format(time.RFC3339),
+			ban.Until.Format(time.RFC3339),
+			orDash(ban.Reason),
+		})
+	}
+	return p.printTable([]string{"IP", "CREATED", "UNTIL", "REASON"}, rows)
+}
+
+// PrintTraffic print a traffic record in a line, so it can be used in stream
+func (p *Printer) PrintTraffic(record *p2p.TrafficRecord) error {
+	if p.format == formatJSON {
+		return json.NewEncoder(p.w).Encode(record)
+	}
+	_, err := fmt.Fprintf(p.w, "%s %-3s %-24s %-10s %s\n", record.Time.Format("15:04:05.000"), record.Direction,
+		record.Peer, record.TypeName, orDash(record.ID))
+	return err
+}
+
+// the direction of peer
+func direction(outBound bool) string {
+	if outBound {
+		return "out"
+	}
+	return "in"
+}
+
+// the duration since the time, "-" if zero
+func since(t time.Time) string {
+	if t.IsZero() {
+		return "-"
+	}
+	return time.Since(t).Truncate(time.Second).String()
+}
+
+// the statistics of the message type, zero if absent
+func messageStats(statsMap map[string]*p2p.MessageStats, name string) *p2p.MessageStats {
+	if stats, ok := statsMap[name]; ok {
+		return stats
+	}
+	return &p2p.MessageStats{}
+}
+
+// the ping round trip time, "-" if unknown
+func rtt(d time.Duration) string {
+	if d == 0 {
+		return "-"
+	}
+	return d.Round(time.Microsecond).String()
+}
+
+// human readable byte size
+func byteSize(n uint64) string {
+	const unit = 1024
+	if n < unit {
+		return fmt.Sprintf("%dB", n)
+	}
+	div, exp := uint64(unit), 0
+	for m := n / unit; m >= unit; m /= unit {
+		div *= unit
+		exp++
+	}
+	return fmt.Sprintf("%.1f%cB", float64(n)/float64(div), "KMGTPE"[exp])
+}
+
+// "-" for empty string
+func orDash(s string) string {
+	if s == "" {
+		return "-"
+	}
+	return s
+}
@@ -0,0 +1,87 @@
+package p2p
+
+import (
+	"encoding/hex"
+	"github.com/DSiSc/p2p/common"
+	"github.com/DSiSc/p2p/message"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// traffic directions
+const (
+	TrafficIn  = "in"  // message received From peer
+	TrafficOut = "out" // message sent To peer
+)
+
+// TrafficRecord is a message sent To or received From a peer
+type TrafficRecord struct {
+	Time      time.Time           `json:"time"`
+	Peer      string              `json:"peer"`
+	Direction string              `json:"direction"`
+	Type      message.MessageType `json:"type"`
+	TypeName  string              `json:"type_name"`
+	ID        string              `json:"id,omitempty"` // hex message id, empty if the message has no id
+}
+
+// trafficTap copies the messages sent and received To the subscribers, so operators can watch live traffic.
+// A slow subscriber misses records instead of blocking the message flow.
+type trafficTap struct {
+	subs  sync.Map // chan *TrafficRecord -> struct{}
+	count int32    // num of subscribers, checked before building records
+}
+
+// create a traffic tap without subscribers
+func newTrafficTap() *trafficTap {
+	return &trafficTap{}
+}
+
+// subscribe the traffic records with the buffer size
+func (tap *trafficTap) subscribe(size int) chan *TrafficRecord {
+	ch := make(chan *TrafficRecord, size)
+	tap.subs.Store(ch, struct{}{})
+	atomic.AddInt32(&tap.count, 1)
+	return ch
+}
+
+// unsubscribe the traffic records
+func (tap *trafficTap) unsubscribe(ch chan *TrafficRecord) {
+	if _, ok := tap.subs.Load(ch); ok {
+		tap.subs.Delete(ch)
+		atomic.AddInt32(&tap.count, -1)
+	}
+}
+
+// publish the message To subscribers
+func (tap *trafficTap) publish(peer *common.NetAddress, direction string, msg message.Message) {
+	if atomic.LoadInt32(&tap.count) == 0 || msg == nil {
+		return
+	}
+	record := &TrafficRecord{
+		Time:      time.Now(),
+		Peer:      peer.ToString(),
+		Direction: direction,
+		Type:      msg.MsgType(),
+		TypeName:  msg.MsgType().String(),
+	}
+	if id := msg.MsgId(); id != message.EmptyHash {
+		record.ID = hex.EncodeToString(id[:])
+	}
+	tap.subs.Range(func(key, value interface{}) bool {
+		select {
+		case key.(chan *TrafficRecord) <- record:
+		default:
+		}
+		return true
+	})
+}
+
+// SubscribeTraffic subscribe the messages sent To and received From peers, records are dropped if the channel
+// is full. The returned function cancels the subscription.
+func (service *P2P) SubscribeTraffic(size int) (<-chan *TrafficRecord, func()) {
+	ch := service.traffic.subscribe(size)
+	return ch, func() {
+		service.traffic.unsubscribe(ch)
+	}
+}
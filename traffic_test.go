@@ -0,0 +1,45 @@
+package p2p
+
+import (
+	"github.com/DSiSc/p2p/common"
+	"github.com/DSiSc/p2p/message"
+	"github.com/stretchr/testify/assert"
+	"testing"
+)
+
+func TestTrafficTap(t *testing.T) {
+	assert := assert.New(t)
+	tap := newTrafficTap()
+	addr := common.NewNetAddress("tcp", "192.168.1.1", 8080)
+	// no subscriber
+	tap.publish(addr, TrafficIn, &message.PingMsg{})
+
+	ch := tap.subscribe(1)
+	tap.publish(addr, TrafficOut, &message.PingMsg{State: 1})
+	tap.publish(addr, TrafficOut, &message.PingMsg{State: 2}) // dropped as channel is full
+	record := <-ch
+	assert.Equal(addr.ToString(), record.Peer)
+	assert.Equal(TrafficOut, record.Direction)
+	assert.Equal(message.PING_TYPE, record.Type)
+	assert.Equal("ping", record.TypeName)
+	assert.Equal("", record.ID)
+	assert.Equal(0, len(ch))
+
+	tap.unsubscribe(ch)
+	tap.unsubscribe(ch)
+	tap.publish(addr, TrafficIn, &message.PingMsg{})
+	assert.Equal(0, len(ch))
+	assert.Equal(int32(0), tap.count)
+}
+
+func TestP2P_SubscribeTraffic(t *testing.T) {
+	assert := assert.New(t)
+	p2p, err := NewP2P(mockConfig(), nil)
+	assert.Nil(err)
+	records, cancel := p2p.SubscribeTraffic(1)
+	p2p.traffic.publish(mockAddress(), TrafficIn, &message.PongMsg{})
+	assert.Equal("pong", (<-records).TypeName)
+	cancel()
+	p2p.traffic.publish(mockAddress(), TrafficIn, &message.PongMsg{})
+	assert.Equal(0, len(records))
+}